@@ -6,10 +6,8 @@ require (
 	github.com/aws/aws-lambda-go v1.36.1
 	github.com/aws/aws-sdk-go v1.42.27
 	github.com/google/uuid v1.2.0
+	github.com/opensearch-project/opensearch-go v1.1.0
 	golang.org/x/exp v0.0.0-20221217163422-3c43f8badb15
 )
 
-require (
-	github.com/jmespath/go-jmespath v0.4.0 // indirect
-	github.com/opensearch-project/opensearch-go v1.1.0 // indirect
-)
+require github.com/jmespath/go-jmespath v0.4.0 // indirect
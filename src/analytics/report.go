@@ -0,0 +1,269 @@
+package analytics
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
+)
+
+// Report formats
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+// Stage of the report rows measuring whole documents rather than the runs of a stage
+const EndToEnd = "END_TO_END"
+
+// Stats summarise durations, in seconds
+type Stats struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min"`
+	Mean  float64 `json:"mean"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P95   float64 `json:"p95"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+// Summarise durations, taking percentiles by the nearest rank
+func Summarise(durations []time.Duration) Stats {
+	if len(durations) == 0 {
+		return Stats{}
+	}
+	sorted := make([]float64, len(durations))
+	sum := 0.0
+	for i, d := range durations {
+		sorted[i] = d.Seconds()
+		sum += sorted[i]
+	}
+	sort.Float64s(sorted)
+
+	percentile := func(p float64) float64 {
+		rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+		if rank < 1 {
+			rank = 1
+		}
+		return sorted[rank-1]
+	}
+	return Stats{
+		Count: len(sorted),
+		Min:   sorted[0],
+		Mean:  sum / float64(len(sorted)),
+		P50:   percentile(50),
+		P90:   percentile(90),
+		P95:   percentile(95),
+		P99:   percentile(99),
+		Max:   sorted[len(sorted)-1],
+	}
+}
+
+// Row aggregates the runs of a stage, or the documents for EndToEnd, of a document class or of every class
+type Row struct {
+	Stage string `json:"stage"`
+	// Empty for the documents of every class
+	Class     string `json:"class"`
+	Documents int    `json:"documents"`
+	// Finished runs, or settled documents for EndToEnd
+	Runs     int `json:"runs"`
+	Retries  int `json:"retries"`
+	Failures int `json:"failures"`
+	// Durations of the runs, or of the documents' runs added up for EndToEnd
+	QueueWait  Stats `json:"queueWait"`
+	Processing Stats `json:"processing"`
+	// Queue wait and processing of a run, or the time from the first to the last event of a document
+	Latency Stats `json:"latency"`
+	// Latency target in seconds and the runs or documents that missed it, when a target is set
+	SLA      float64 `json:"sla,omitempty"`
+	Breaches int     `json:"breaches,omitempty"`
+}
+
+// Options of a report
+type Options struct {
+	// Window the reported documents started in. Zero times leave that end open.
+	From time.Time
+	To   time.Time
+	// Latency targets by stage, or EndToEnd for whole documents
+	SLA map[string]time.Duration
+}
+
+// Report summarises the latency of the pipeline stages over a time window
+type Report struct {
+	From        string `json:"from,omitempty"`
+	To          string `json:"to,omitempty"`
+	GeneratedAt string `json:"generatedAt"`
+	Documents   int    `json:"documents"`
+	// Documents with a run still in progress, left out of the EndToEnd rows
+	InProgress int   `json:"inProgress"`
+	Rows       []Row `json:"rows"`
+}
+
+// Samples of a row before they are summarised
+type rowSamples struct {
+	row                            Row
+	documents                      map[string]bool
+	queueWait, processing, latency []time.Duration
+}
+
+func (s *rowSamples) add(documentId string, queueWait time.Duration, processing time.Duration, latency time.Duration, sla time.Duration) {
+	s.documents[documentId] = true
+	s.row.Runs++
+	s.queueWait = append(s.queueWait, queueWait)
+	s.processing = append(s.processing, processing)
+	s.latency = append(s.latency, latency)
+	if sla > 0 && latency > sla {
+		s.row.Breaches++
+	}
+}
+
+// Aggregate the timings of the documents that started in the window, by stage and by document class
+func NewReport(timings []*DocumentTimings, opts Options) *Report {
+	report := &Report{GeneratedAt: metadata.FormatTimestamp(time.Now()), Rows: []Row{}}
+	if !opts.From.IsZero() {
+		report.From = metadata.FormatTimestamp(opts.From)
+	}
+	if !opts.To.IsZero() {
+		report.To = metadata.FormatTimestamp(opts.To)
+	}
+
+	rows := map[[2]string]*rowSamples{}
+	// Rows of a stage for every class and for the document's class. Documents without a class are only counted
+	// in the rows of every class.
+	rowsOf := func(stage string, class string) []*rowSamples {
+		keys := [][2]string{{stage, ""}}
+		if class != "" {
+			keys = append(keys, [2]string{stage, class})
+		}
+		selected := []*rowSamples{}
+		for _, key := range keys {
+			r, ok := rows[key]
+			if !ok {
+				r = &rowSamples{row: Row{Stage: stage, Class: key[1]}, documents: map[string]bool{}}
+				if sla := opts.SLA[stage]; sla > 0 {
+					r.row.SLA = sla.Seconds()
+				}
+				rows[key] = r
+			}
+			selected = append(selected, r)
+		}
+		return selected
+	}
+
+	for _, d := range timings {
+		if !opts.From.IsZero() && d.Started.Before(opts.From) {
+			continue
+		}
+		if !opts.To.IsZero() && d.Started.After(opts.To) {
+			continue
+		}
+		report.Documents++
+
+		for _, run := range d.Runs {
+			if !run.Done() {
+				continue
+			}
+			for _, r := range rowsOf(run.Stage, d.Class) {
+				r.add(d.DocumentId, run.QueueWait, run.Processing, run.Latency(), opts.SLA[run.Stage])
+				if run.Attempt > 1 {
+					r.row.Retries++
+				}
+				if run.Status == metadata.StatusFailed {
+					r.row.Failures++
+				}
+			}
+		}
+
+		if !d.Settled() {
+			report.InProgress++
+			continue
+		}
+		queueWait, processing := d.Totals()
+		for _, r := range rowsOf(EndToEnd, d.Class) {
+			r.add(d.DocumentId, queueWait, processing, d.EndToEnd(), opts.SLA[EndToEnd])
+			r.row.Retries += d.Retries()
+			if d.Status == metadata.StatusFailed {
+				r.row.Failures++
+			}
+		}
+	}
+
+	for _, r := range rows {
+		r.row.Documents = len(r.documents)
+		r.row.QueueWait = Summarise(r.queueWait)
+		r.row.Processing = Summarise(r.processing)
+		r.row.Latency = Summarise(r.latency)
+		report.Rows = append(report.Rows, r.row)
+	}
+	// End to end rows first, then the stages by name, each with every class before the classes by name
+	sort.Slice(report.Rows, func(i, j int) bool {
+		a, b := report.Rows[i], report.Rows[j]
+		if (a.Stage == EndToEnd) != (b.Stage == EndToEnd) {
+			return a.Stage == EndToEnd
+		}
+		if a.Stage != b.Stage {
+			return a.Stage < b.Stage
+		}
+		return a.Class < b.Class
+	})
+	return report
+}
+
+// Write the report in a format
+func (r *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatJSON:
+		return r.WriteJSON(w)
+	case FormatCSV:
+		return r.WriteCSV(w)
+	}
+	return fmt.Errorf("unknown report format %q, expected %s or %s", format, FormatJSON, FormatCSV)
+}
+
+// Write the report as indented JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// Write the report as CSV, one line per row and duration measured
+func (r *Report) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	header := []string{"stage", "class", "documents", "runs", "retries", "failures", "sla", "breaches",
+		"measure", "count", "min", "mean", "p50", "p90", "p95", "p99", "max"}
+	err := writer.Write(header)
+	if err != nil {
+		return err
+	}
+
+	seconds := func(s float64) string { return strconv.FormatFloat(s, 'f', 3, 64) }
+	for _, row := range r.Rows {
+		sla := ""
+		if row.SLA > 0 {
+			sla = seconds(row.SLA)
+		}
+		for _, measure := range []struct {
+			name  string
+			stats Stats
+		}{{"queueWait", row.QueueWait}, {"processing", row.Processing}, {"latency", row.Latency}} {
+			s := measure.stats
+			err = writer.Write([]string{row.Stage, row.Class, strconv.Itoa(row.Documents), strconv.Itoa(row.Runs),
+				strconv.Itoa(row.Retries), strconv.Itoa(row.Failures), sla, strconv.Itoa(row.Breaches),
+				measure.name, strconv.Itoa(s.Count), seconds(s.Min), seconds(s.Mean), seconds(s.P50), seconds(s.P90),
+				seconds(s.P95), seconds(s.P99), seconds(s.Max)})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
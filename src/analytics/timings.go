@@ -0,0 +1,170 @@
+package analytics
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dreamspider42/document-processing-pipeline/src/datastores"
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
+)
+
+// StageRun is one attempt of a pipeline stage at a document
+type StageRun struct {
+	Stage string
+	// 1 for the first run of the stage, 2 for its first retry, ...
+	Attempt int
+	// SUCCEEDED or FAILED, or IN_PROGRESS while the run is unfinished
+	Status  string
+	Started time.Time
+	// Zero while the run is unfinished
+	Finished time.Time
+	// Time from the end of the previous run, or the document's first event, to the start of this run
+	QueueWait time.Duration
+	// Time from the start to the end of the run
+	Processing time.Duration
+}
+
+// Latency of a run: the time it waited and the time it took
+func (r StageRun) Latency() time.Duration {
+	return r.QueueWait + r.Processing
+}
+
+// Whether the run has finished
+func (r StageRun) Done() bool {
+	return r.Status != metadata.StatusInProgress
+}
+
+// DocumentTimings are the stage runs of a document, derived from its pipeline operations timeline
+type DocumentTimings struct {
+	DocumentId string
+	BucketName string
+	ObjectName string
+	// Class of the document in the registry, empty when unknown
+	Class string
+	// Stage and status the document is in
+	Stage  string
+	Status string
+	// Times of the first and last timeline entries
+	Started    time.Time
+	LastUpdate time.Time
+	Runs       []StageRun
+}
+
+// Time from the document's first timeline entry to its last
+func (d *DocumentTimings) EndToEnd() time.Duration {
+	return d.LastUpdate.Sub(d.Started)
+}
+
+// Whether the document left the pipeline, having failed or reached its terminal state, with every run finished
+func (d *DocumentTimings) Settled() bool {
+	for _, run := range d.Runs {
+		if !run.Done() {
+			return false
+		}
+	}
+	return d.Status == metadata.StatusFailed || metadata.Pipeline.Terminal(metadata.StageState{Stage: d.Stage, Status: d.Status})
+}
+
+// Number of runs that retried a stage
+func (d *DocumentTimings) Retries() int {
+	retries := 0
+	for _, run := range d.Runs {
+		if run.Attempt > 1 {
+			retries++
+		}
+	}
+	return retries
+}
+
+// Total queue wait and processing time of the document's runs
+func (d *DocumentTimings) Totals() (queueWait time.Duration, processing time.Duration) {
+	for _, run := range d.Runs {
+		queueWait += run.QueueWait
+		processing += run.Processing
+	}
+	return queueWait, processing
+}
+
+// Derive the stage runs of a pipeline operations record. A run starts with an IN_PROGRESS entry of a stage and ends
+// with its next SUCCEEDED or FAILED entry; a stage reporting its outcome without reporting its start ran for no
+// measurable time. Queue wait is measured from the end of the previous run, as stages are chained.
+func Timings(item datastores.PipelineOperationsItem) (*DocumentTimings, error) {
+	type entry struct {
+		datastores.TimelineItem
+		time time.Time
+	}
+	entries := make([]entry, 0, len(item.Timeline))
+	for _, timelineItem := range item.Timeline {
+		t, err := metadata.ParseTimestamp(timelineItem.Timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("timeline of document %s: %v", item.DocumentId, err)
+		}
+		entries = append(entries, entry{TimelineItem: timelineItem, time: t})
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("document %s has an empty timeline", item.DocumentId)
+	}
+	// Events can be delivered out of order; equal times keep their timeline order
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].time.Before(entries[j].time) })
+
+	d := &DocumentTimings{
+		DocumentId: item.DocumentId,
+		BucketName: item.BucketName,
+		ObjectName: item.ObjectName,
+		Stage:      item.DocumentStage,
+		Status:     item.DocumentStatus,
+		Started:    entries[0].time,
+		LastUpdate: entries[len(entries)-1].time,
+		Runs:       []StageRun{},
+	}
+	attempts := map[string]int{}
+	open := map[string]int{}
+	lastDone := d.Started
+
+	for _, e := range entries {
+		i, running := open[e.Stage]
+		if e.Status == metadata.StatusInProgress {
+			// A stage reporting progress again is still on the same run
+			if running {
+				continue
+			}
+			attempts[e.Stage]++
+			open[e.Stage] = len(d.Runs)
+			d.Runs = append(d.Runs, StageRun{
+				Stage:     e.Stage,
+				Attempt:   attempts[e.Stage],
+				Status:    metadata.StatusInProgress,
+				Started:   e.time,
+				QueueWait: positive(e.time.Sub(lastDone)),
+			})
+			continue
+		}
+
+		if !running {
+			attempts[e.Stage]++
+			i = len(d.Runs)
+			d.Runs = append(d.Runs, StageRun{
+				Stage:     e.Stage,
+				Attempt:   attempts[e.Stage],
+				Started:   e.time,
+				QueueWait: positive(e.time.Sub(lastDone)),
+			})
+		}
+		run := &d.Runs[i]
+		run.Status = e.Status
+		run.Finished = e.time
+		run.Processing = e.time.Sub(run.Started)
+		delete(open, e.Stage)
+		lastDone = e.time
+	}
+	return d, nil
+}
+
+// Stages started in parallel can finish before the previous run did
+func positive(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	return d
+}
@@ -0,0 +1,197 @@
+package analytics
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dreamspider42/document-processing-pipeline/src/datastores"
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
+)
+
+var start = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// Timeline item at a number of seconds after start
+func at(seconds int, stage string, status string) datastores.TimelineItem {
+	return datastores.TimelineItem{
+		Timestamp: metadata.FormatTimestamp(start.Add(time.Duration(seconds) * time.Second)),
+		Stage:     stage,
+		Status:    status,
+	}
+}
+
+// Summary of the runs of a document: stage, attempt, status, queue wait and processing seconds
+type runSummary struct {
+	stage      string
+	attempt    int
+	status     string
+	queueWait  int
+	processing int
+}
+
+func TestTimings(t *testing.T) {
+	const (
+		classifier = metadata.StageDocumentClassifier
+		processor  = metadata.StageDocumentProcessor
+		embeddings = metadata.StageSyncProcessEmbeddings
+		inProgress = metadata.StatusInProgress
+		succeeded  = metadata.StatusSucceeded
+		failed     = metadata.StatusFailed
+	)
+	tests := []struct {
+		name        string
+		stage       string
+		status      string
+		timeline    []datastores.TimelineItem
+		wantRuns    []runSummary
+		wantSettled bool
+		wantEnd     int
+	}{
+		{
+			name:   "chained stages",
+			stage:  processor,
+			status: succeeded,
+			timeline: []datastores.TimelineItem{
+				at(0, classifier, inProgress), at(2, classifier, succeeded),
+				at(5, processor, inProgress), at(9, processor, succeeded),
+			},
+			wantRuns: []runSummary{{classifier, 1, succeeded, 0, 2}, {processor, 1, succeeded, 3, 4}},
+			wantEnd:  9,
+		},
+		{
+			name:   "retried stage",
+			stage:  classifier,
+			status: succeeded,
+			timeline: []datastores.TimelineItem{
+				at(0, classifier, inProgress), at(1, classifier, failed),
+				at(4, classifier, inProgress), at(6, classifier, inProgress), at(7, classifier, succeeded),
+			},
+			wantRuns: []runSummary{{classifier, 1, failed, 0, 1}, {classifier, 2, succeeded, 3, 3}},
+			wantEnd:  7,
+		},
+		{
+			name:   "out of order timeline",
+			stage:  processor,
+			status: inProgress,
+			timeline: []datastores.TimelineItem{
+				at(5, processor, inProgress), at(0, classifier, inProgress), at(2, classifier, succeeded),
+			},
+			wantRuns: []runSummary{{classifier, 1, succeeded, 0, 2}, {processor, 1, inProgress, 3, 0}},
+			wantEnd:  5,
+		},
+		{
+			name:   "outcome without start",
+			stage:  embeddings,
+			status: succeeded,
+			timeline: []datastores.TimelineItem{
+				at(0, classifier, inProgress), at(3, embeddings, succeeded),
+			},
+			wantRuns: []runSummary{{classifier, 1, inProgress, 0, 0}, {embeddings, 1, succeeded, 3, 0}},
+			wantEnd:  3,
+		},
+		{
+			name:   "terminal state",
+			stage:  embeddings,
+			status: succeeded,
+			timeline: []datastores.TimelineItem{
+				at(0, embeddings, inProgress), at(3, embeddings, succeeded),
+			},
+			wantRuns:    []runSummary{{embeddings, 1, succeeded, 0, 3}},
+			wantSettled: true,
+			wantEnd:     3,
+		},
+		{
+			name:   "failed",
+			stage:  classifier,
+			status: failed,
+			timeline: []datastores.TimelineItem{
+				at(0, classifier, inProgress), at(3, classifier, failed),
+			},
+			wantRuns:    []runSummary{{classifier, 1, failed, 0, 3}},
+			wantSettled: true,
+			wantEnd:     3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := Timings(datastores.PipelineOperationsItem{
+				DocumentId:     "doc",
+				DocumentStage:  tt.stage,
+				DocumentStatus: tt.status,
+				Timeline:       tt.timeline,
+			})
+			if err != nil {
+				t.Fatalf("Timings() error = %v", err)
+			}
+			runs := []runSummary{}
+			for _, run := range d.Runs {
+				runs = append(runs, runSummary{run.Stage, run.Attempt, run.Status, int(run.QueueWait.Seconds()), int(run.Processing.Seconds())})
+			}
+			if !reflect.DeepEqual(runs, tt.wantRuns) {
+				t.Errorf("runs %v, want %v", runs, tt.wantRuns)
+			}
+			if d.Settled() != tt.wantSettled {
+				t.Errorf("Settled() = %v, want %v", d.Settled(), tt.wantSettled)
+			}
+			if d.EndToEnd() != time.Duration(tt.wantEnd)*time.Second {
+				t.Errorf("EndToEnd() = %v, want %ds", d.EndToEnd(), tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestTimingsInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeline []datastores.TimelineItem
+	}{
+		{name: "empty timeline"},
+		{name: "unparseable timestamp", timeline: []datastores.TimelineItem{{Timestamp: "yesterday"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Timings(datastores.PipelineOperationsItem{DocumentId: "doc", Timeline: tt.timeline})
+			if err == nil {
+				t.Error("Timings() error = nil")
+			}
+		})
+	}
+}
+
+func TestSummarise(t *testing.T) {
+	seconds := func(values ...int) []time.Duration {
+		durations := []time.Duration{}
+		for _, v := range values {
+			durations = append(durations, time.Duration(v)*time.Second)
+		}
+		return durations
+	}
+	tests := []struct {
+		name      string
+		durations []time.Duration
+		want      Stats
+	}{
+		{name: "none", want: Stats{}},
+		{name: "one", durations: seconds(4), want: Stats{Count: 1, Min: 4, Mean: 4, P50: 4, P90: 4, P95: 4, P99: 4, Max: 4}},
+		{
+			name:      "unsorted",
+			durations: seconds(3, 1, 2, 4),
+			want:      Stats{Count: 4, Min: 1, Mean: 2.5, P50: 2, P90: 4, P95: 4, P99: 4, Max: 4},
+		},
+		{
+			name:      "nearest rank",
+			durations: seconds(10, 9, 8, 7, 6, 5, 4, 3, 2, 1),
+			want:      Stats{Count: 10, Min: 1, Mean: 5.5, P50: 5, P90: 9, P95: 10, P99: 10, Max: 10},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Summarise(tt.durations); got != tt.want {
+				t.Errorf("Summarise() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package awshelper
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/opensearch-project/opensearch-go"
+	"github.com/opensearch-project/opensearch-go/opensearchapi"
+	"github.com/opensearch-project/opensearch-go/signer/aws"
+)
+
+// Ways of authenticating to the cluster
+const (
+	ESAuthSigV4 = "sigv4" // Amazon OpenSearch Service domain, signed with the default AWS credentials
+	ESAuthBasic = "basic" // Self-managed cluster with the security plugin's internal users
+	ESAuthMTLS  = "mtls"  // Self-managed cluster authenticating client certificates
+	ESAuthNone  = "none"  // Local container or cluster without the security plugin
+)
+
+const defaultESTimeout = 30 * time.Second
+
+// ESConfig describes how to reach a cluster. An endpoint without a scheme is reached over https.
+type ESConfig struct {
+	Endpoint string
+	Index    string
+	AuthMode string
+
+	// Basic auth credentials
+	Username string
+	Password string
+
+	// PEM bundle trusted in addition to the system roots, e.g. the CA of a self-signed cluster
+	CABundleFile string
+	// Client certificate and key presented in mtls mode
+	ClientCertFile string
+	ClientKeyFile  string
+	// Skip server certificate verification. Only meant for local containers.
+	InsecureSkipVerify bool
+
+	// Timeout of each request, including the health check
+	Timeout time.Duration
+}
+
+// ESHealth reports the cluster behind a connection
+type ESHealth struct {
+	Endpoint     string `json:"endpoint"`
+	Distribution string `json:"distribution"`
+	Version      string `json:"version"`
+	ClusterName  string `json:"clusterName"`
+	Status       string `json:"status"`
+}
+
+// Read the connection settings from the environment:
+// TARGET_ES_CLUSTER, ES_CLUSTER_INDEX, ES_AUTH_MODE (sigv4 by default), ES_USERNAME, ES_PASSWORD,
+// ES_CA_BUNDLE, ES_CLIENT_CERT, ES_CLIENT_KEY, ES_INSECURE_SKIP_VERIFY and ES_TIMEOUT (a Go duration, e.g. 10s).
+func ESConfigFromEnv() (ESConfig, error) {
+	cfg := ESConfig{
+		Endpoint:           os.Getenv("TARGET_ES_CLUSTER"),
+		Index:              os.Getenv("ES_CLUSTER_INDEX"),
+		AuthMode:           os.Getenv("ES_AUTH_MODE"),
+		Username:           os.Getenv("ES_USERNAME"),
+		Password:           os.Getenv("ES_PASSWORD"),
+		CABundleFile:       os.Getenv("ES_CA_BUNDLE"),
+		ClientCertFile:     os.Getenv("ES_CLIENT_CERT"),
+		ClientKeyFile:      os.Getenv("ES_CLIENT_KEY"),
+		InsecureSkipVerify: os.Getenv("ES_INSECURE_SKIP_VERIFY") == "true",
+	}
+	if timeout := os.Getenv("ES_TIMEOUT"); timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid ES_TIMEOUT %q: %v", timeout, err)
+		}
+		cfg.Timeout = d
+	}
+	return cfg, nil
+}
+
+// Register the connection flags of the operator commands, defaulting to the current values.
+// The basic auth password is only read from ES_PASSWORD so it stays out of shell history.
+func (cfg *ESConfig) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.Endpoint, "endpoint", cfg.Endpoint, "Search cluster endpoint, https is assumed without a scheme")
+	fs.StringVar(&cfg.Index, "index", cfg.Index, "Base name of the index, also the read alias")
+	fs.StringVar(&cfg.AuthMode, "auth", cfg.AuthMode, "Auth mode: sigv4 (default), basic, mtls or none")
+	fs.StringVar(&cfg.Username, "username", cfg.Username, "Username of basic auth")
+	fs.StringVar(&cfg.CABundleFile, "ca-bundle", cfg.CABundleFile, "PEM bundle of additional CAs to trust")
+	fs.StringVar(&cfg.ClientCertFile, "client-cert", cfg.ClientCertFile, "Client certificate of mtls auth")
+	fs.StringVar(&cfg.ClientKeyFile, "client-key", cfg.ClientKeyFile, "Client key of mtls auth")
+	fs.BoolVar(&cfg.InsecureSkipVerify, "insecure-skip-verify", cfg.InsecureSkipVerify, "Skip server certificate verification (local clusters only)")
+	fs.DurationVar(&cfg.Timeout, "timeout", cfg.Timeout, "Timeout of each request")
+}
+
+// Check the settings of the auth mode are complete, filling in defaults
+func (cfg *ESConfig) Validate() error {
+	if cfg.Endpoint == "" {
+		return fmt.Errorf("missing search cluster endpoint")
+	}
+	if !strings.Contains(cfg.Endpoint, "://") {
+		cfg.Endpoint = "https://" + cfg.Endpoint
+	}
+	if cfg.AuthMode == "" {
+		cfg.AuthMode = ESAuthSigV4
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultESTimeout
+	}
+
+	switch cfg.AuthMode {
+	case ESAuthSigV4, ESAuthNone:
+	case ESAuthBasic:
+		if cfg.Username == "" || cfg.Password == "" {
+			return fmt.Errorf("%s auth needs a username and password", cfg.AuthMode)
+		}
+	case ESAuthMTLS:
+		if cfg.ClientCertFile == "" || cfg.ClientKeyFile == "" {
+			return fmt.Errorf("%s auth needs a client certificate and key", cfg.AuthMode)
+		}
+	default:
+		return fmt.Errorf("unknown search cluster auth mode %q", cfg.AuthMode)
+	}
+	return nil
+}
+
+// Build the HTTP transport carrying the TLS settings and timeouts
+func (cfg ESConfig) transport() (*http.Transport, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+
+	if cfg.CABundleFile != "" {
+		pem, err := os.ReadFile(cfg.CABundleFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA bundle: %v", err)
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil || roots == nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", cfg.CABundleFile)
+		}
+		tlsConfig.RootCAs = roots
+	}
+
+	if cfg.AuthMode == ESAuthMTLS {
+		certificate, err := tls.LoadX509KeyPair(cfg.ClientCertFile, cfg.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.DialContext = (&net.Dialer{Timeout: cfg.Timeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = cfg.Timeout
+	transport.ResponseHeaderTimeout = cfg.Timeout
+	return transport, nil
+}
+
+// Create a new instance of the search helper from a connection config. The cluster is not contacted; see HealthCheck.
+func NewESHelperWithConfig(cfg ESConfig) (*ESHelper, error) {
+	err := cfg.Validate()
+	if err != nil {
+		return nil, &ESError{Op: "configure client", Err: err}
+	}
+
+	transport, err := cfg.transport()
+	if err != nil {
+		return nil, &ESError{Op: "configure transport", Err: err}
+	}
+	clientConfig := opensearch.Config{
+		Addresses: []string{cfg.Endpoint},
+		Transport: transport,
+	}
+
+	switch cfg.AuthMode {
+	case ESAuthSigV4:
+		// Get credentials from the default chain and create the Signature Version 4 signer
+		sess, err := session.NewSession()
+		if err != nil {
+			return nil, &ESError{Op: "create session", Err: err}
+		}
+		signer, err := aws.NewSigner(session.Options{Config: *sess.Config})
+		if err != nil {
+			return nil, &ESError{Op: "create signer", Err: err}
+		}
+		clientConfig.Signer = signer
+	case ESAuthBasic:
+		clientConfig.Username = cfg.Username
+		clientConfig.Password = cfg.Password
+	}
+
+	client, err := opensearch.NewClient(clientConfig)
+	if err != nil {
+		return nil, &ESError{Op: "create client", Err: err}
+	}
+
+	return &ESHelper{
+		ESClient:     client,
+		endpoint:     cfg.Endpoint,
+		timeout:      cfg.Timeout,
+		index:        cfg.Index,
+		readIndex:    cfg.Index,
+		MaxRetries:   defaultESMaxRetries,
+		RetryBackoff: defaultESRetryBackoff,
+	}, nil
+}
+
+// Create a new instance of the search helper and check the cluster is healthy before returning it
+func ConnectESHelper(cfg ESConfig) (*ESHelper, error) {
+	es, err := NewESHelperWithConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	health, err := es.HealthCheck()
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Connected to %s %s cluster %s at %s, status %s \n", health.Distribution, health.Version, health.ClusterName, health.Endpoint, health.Status)
+	return es, nil
+}
+
+// Check the cluster is reachable, authenticates us and is not red.
+// Connection failures wrap ErrESConnection.
+func (es *ESHelper) HealthCheck() (*ESHealth, error) {
+	timeout := es.timeout
+	if timeout <= 0 {
+		timeout = defaultESTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	health := &ESHealth{Endpoint: es.endpoint}
+	res, err := opensearchapi.InfoRequest{}.Do(ctx, es.ESClient)
+	if err = esResponseError("info", res, err); err != nil {
+		return nil, &ESError{Op: "connect to " + es.endpoint, Err: fmt.Errorf("%w: %v", ErrESConnection, err)}
+	}
+	info := struct {
+		Version struct {
+			Distribution string `json:"distribution"`
+			Number       string `json:"number"`
+		} `json:"version"`
+	}{}
+	err = json.NewDecoder(res.Body).Decode(&info)
+	res.Body.Close()
+	if err != nil {
+		return nil, &ESError{Op: "read cluster info", Err: err}
+	}
+	health.Distribution = info.Version.Distribution
+	health.Version = info.Version.Number
+
+	res, err = opensearchapi.ClusterHealthRequest{}.Do(ctx, es.ESClient)
+	if err = esResponseError("cluster health", res, err); err != nil {
+		return health, &ESError{Op: "check health of " + es.endpoint, Err: err}
+	}
+	status := struct {
+		ClusterName string `json:"cluster_name"`
+		Status      string `json:"status"`
+	}{}
+	err = json.NewDecoder(res.Body).Decode(&status)
+	res.Body.Close()
+	if err != nil {
+		return health, &ESError{Op: "read cluster health", Err: err}
+	}
+	health.ClusterName = status.ClusterName
+	health.Status = status.Status
+
+	if health.Status == "red" {
+		return health, &ESError{Op: "check health of " + es.endpoint, Err: fmt.Errorf("cluster %s is red", health.ClusterName)}
+	}
+	return health, nil
+}
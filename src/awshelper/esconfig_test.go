@@ -0,0 +1,58 @@
+package awshelper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestESConfigValidate(t *testing.T) {
+	tests := []struct {
+		name         string
+		cfg          ESConfig
+		wantErr      bool
+		wantEndpoint string
+		wantAuthMode string
+	}{
+		{name: "sigv4 by default", cfg: ESConfig{Endpoint: "search.example.com"}, wantEndpoint: "https://search.example.com", wantAuthMode: ESAuthSigV4},
+		{name: "scheme kept", cfg: ESConfig{Endpoint: "http://localhost:9200", AuthMode: ESAuthNone}, wantEndpoint: "http://localhost:9200", wantAuthMode: ESAuthNone},
+		{name: "basic", cfg: ESConfig{Endpoint: "https://search", AuthMode: ESAuthBasic, Username: "admin", Password: "secret"}, wantEndpoint: "https://search", wantAuthMode: ESAuthBasic},
+		{name: "basic without a password", cfg: ESConfig{Endpoint: "https://search", AuthMode: ESAuthBasic, Username: "admin"}, wantErr: true},
+		{name: "basic without a username", cfg: ESConfig{Endpoint: "https://search", AuthMode: ESAuthBasic, Password: "secret"}, wantErr: true},
+		{name: "mtls", cfg: ESConfig{Endpoint: "https://search", AuthMode: ESAuthMTLS, ClientCertFile: "client.pem", ClientKeyFile: "client.key"}, wantEndpoint: "https://search", wantAuthMode: ESAuthMTLS},
+		{name: "mtls without a key", cfg: ESConfig{Endpoint: "https://search", AuthMode: ESAuthMTLS, ClientCertFile: "client.pem"}, wantErr: true},
+		{name: "mtls without a certificate", cfg: ESConfig{Endpoint: "https://search", AuthMode: ESAuthMTLS, ClientKeyFile: "client.key"}, wantErr: true},
+		{name: "unknown auth mode", cfg: ESConfig{Endpoint: "https://search", AuthMode: "kerberos"}, wantErr: true},
+		{name: "no endpoint", cfg: ESConfig{AuthMode: ESAuthNone}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			err := cfg.Validate()
+			if tt.wantErr {
+				if err == nil {
+					t.Error("Validate() error = nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			if cfg.Endpoint != tt.wantEndpoint || cfg.AuthMode != tt.wantAuthMode || cfg.Timeout != defaultESTimeout {
+				t.Errorf("validated %s with %s auth and a %s timeout, want %s with %s auth", cfg.Endpoint, cfg.AuthMode, cfg.Timeout, tt.wantEndpoint, tt.wantAuthMode)
+			}
+		})
+	}
+
+	cfg := ESConfig{Endpoint: "https://search", AuthMode: ESAuthNone, Timeout: time.Second}
+	if err := cfg.Validate(); err != nil || cfg.Timeout != time.Second {
+		t.Errorf("Validate() = %v with a %s timeout, want the configured 1s", err, cfg.Timeout)
+	}
+}
+
+func TestNewESHelperWithConfigInvalid(t *testing.T) {
+	_, err := NewESHelperWithConfig(ESConfig{Endpoint: "https://search", AuthMode: ESAuthBasic})
+	if _, ok := err.(*ESError); !ok {
+		t.Errorf("NewESHelperWithConfig() error = %v, want an *ESError", err)
+	}
+}
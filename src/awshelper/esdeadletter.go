@@ -0,0 +1,118 @@
+package awshelper
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ESDeadLetter parks bulk items that could not be indexed under an S3 prefix so they can be replayed later
+type ESDeadLetter struct {
+	S3         *S3Helper
+	BucketName string
+	Prefix     string
+}
+
+// Represents a parked bulk item, with everything needed to replay it
+type ESDeadLetterRecord struct {
+	Index      string          `json:"index"`
+	Action     string          `json:"action"`
+	DocumentID string          `json:"documentId"`
+	Body       json.RawMessage `json:"body"`
+	Status     int             `json:"status"`
+	ErrorType  string          `json:"errorType"`
+	Reason     string          `json:"reason"`
+	Attempts   int             `json:"attempts"`
+	FailedAt   string          `json:"failedAt"`
+}
+
+// Park a failed item and return the S3 key it was written to
+func (d *ESDeadLetter) Park(index string, item BulkItem, failure BulkItemFailure) (string, error) {
+	now := time.Now().UTC()
+	record := ESDeadLetterRecord{
+		Index:      index,
+		Action:     "index",
+		DocumentID: item.DocumentID,
+		Body:       item.Body,
+		Status:     failure.Status,
+		ErrorType:  failure.ErrorType,
+		Reason:     failure.Reason,
+		Attempts:   failure.Attempts,
+		FailedAt:   now.Format(time.RFC3339Nano),
+	}
+
+	content, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+
+	key := fmt.Sprintf("%s/%s/%s/%s-%d.json", strings.TrimSuffix(d.Prefix, "/"), index, now.Format("2006/01/02"), url.PathEscape(item.DocumentID), now.UnixNano())
+	err = d.S3.WriteToS3(string(content), d.BucketName, key, nil)
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("Parked %s in s3://%s/%s \n", item.DocumentID, d.BucketName, key)
+	return key, nil
+}
+
+// Replay parked items under the given prefix (relative to the dead letter prefix) into the helper's current index.
+// Records that index successfully are removed; records that fail again are parked anew.
+func (es *ESHelper) ReplayDeadLetters(prefix string) (*BulkResult, error) {
+	if es.DeadLetter == nil {
+		return nil, fmt.Errorf("no dead letter location configured")
+	}
+	d := es.DeadLetter
+	listPrefix := strings.TrimSuffix(d.Prefix, "/") + "/" + strings.TrimPrefix(prefix, "/")
+
+	keys, err := d.S3.ListObjectsInS3(d.BucketName, listPrefix, 1000)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []BulkItem{}
+	// A document can be parked several times, each time under its own key
+	keysById := map[string][]string{}
+	for _, key := range keys {
+		content, err := d.S3.ReadFromS3(d.BucketName, *key)
+		if err != nil {
+			return nil, err
+		}
+		record := ESDeadLetterRecord{}
+		err = json.Unmarshal(content, &record)
+		if err != nil {
+			log.Printf("Skipping unreadable dead letter record %s: %v \n", *key, err)
+			continue
+		}
+		items = append(items, BulkItem{DocumentID: record.DocumentID, Body: record.Body})
+		keysById[record.DocumentID] = append(keysById[record.DocumentID], *key)
+	}
+	if len(items) == 0 {
+		return &BulkResult{}, nil
+	}
+
+	result, err := es.PostBulk(items)
+	if result == nil {
+		return nil, err
+	}
+
+	// Remove replayed records, and failed ones that were parked again under a new key
+	keep := map[string]bool{}
+	for _, failure := range result.Failures {
+		keep[failure.DocumentID] = failure.DeadLetterKey == ""
+	}
+	for documentId, documentKeys := range keysById {
+		if keep[documentId] {
+			continue
+		}
+		for _, key := range documentKeys {
+			if delErr := d.S3.DeleteFromS3(d.BucketName, key); delErr != nil {
+				log.Printf("Could not remove dead letter record %s for %s: %v \n", key, documentId, delErr)
+			}
+		}
+	}
+	return result, err
+}
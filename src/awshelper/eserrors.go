@@ -0,0 +1,45 @@
+package awshelper
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrESConnection is wrapped by errors raised when the cluster cannot be reached
+var ErrESConnection = errors.New("unable to connect to OpenSearch")
+
+// ESError is returned when an OpenSearch operation fails as a whole
+type ESError struct {
+	Op  string
+	Err error
+}
+
+func (e *ESError) Error() string {
+	return fmt.Sprintf("opensearch %s: %v", e.Op, e.Err)
+}
+
+func (e *ESError) Unwrap() error {
+	return e.Err
+}
+
+// BulkIndexError is returned when items of a bulk session could not be indexed after retries
+type BulkIndexError struct {
+	Index    string
+	Failures []BulkItemFailure
+}
+
+func (e *BulkIndexError) Error() string {
+	first := e.Failures[0]
+	return fmt.Sprintf("%d bulk items failed to index into %s, first failure on %s: [%d] %s %s", len(e.Failures), e.Index, first.DocumentID, first.Status, first.ErrorType, first.Reason)
+}
+
+// Number of failed items parked in the dead letter location
+func (e *BulkIndexError) DeadLettered() int {
+	parked := 0
+	for _, failure := range e.Failures {
+		if failure.DeadLetterKey != "" {
+			parked++
+		}
+	}
+	return parked
+}
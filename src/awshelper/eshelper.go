@@ -4,127 +4,278 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"fmt"
+	"io"
 	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/opensearch-project/opensearch-go"
+	"github.com/opensearch-project/opensearch-go/opensearchapi"
 	"github.com/opensearch-project/opensearch-go/opensearchutil"
-	"github.com/opensearch-project/opensearch-go/signer/aws"
+)
+
+const (
+	defaultESMaxRetries   = 3
+	defaultESRetryBackoff = 250 * time.Millisecond
+	maxESRetryBackoff     = 5 * time.Second
 )
 
 // ESHelper is a helper for AWS Elasticsearch Service
 type ESHelper struct {
-	ESClient 	*opensearch.Client
-	index 		string
+	ESClient  *opensearch.Client
+	endpoint  string
+	timeout   time.Duration
+	index     string
+	readIndex string
+
+	// Number of times retryable item failures are resubmitted, and the initial backoff between attempts
+	MaxRetries   int
+	RetryBackoff time.Duration
+
+	// Optional location where permanently failed items are parked for replay
+	DeadLetter *ESDeadLetter
 }
 
-// NewESHelper creates a new ESHelper
-func NewESHelper(endpoint string, esIndex string) *ESHelper {
-	// Get credentials from environment variables and create the Signature Version 4 signer
-	sess := session.Must(session.NewSession())
+// BulkItem is a single record to index within a bulk session
+type BulkItem struct {
+	DocumentID string
+	Body       []byte
+}
 
-	sessConfig := sess.Config
-	signer, err := aws.NewSigner(session.Options{
-		Config: *sessConfig,
-	})
-	if err != nil {
-		panic(fmt.Sprintf("Could not create signer properly: %v" , err))
+// BulkItemFailure describes a record OpenSearch did not index
+type BulkItemFailure struct {
+	DocumentID    string `json:"documentId"`
+	Status        int    `json:"status"`
+	ErrorType     string `json:"errorType"`
+	Reason        string `json:"reason"`
+	Attempts      int    `json:"attempts"`
+	DeadLetterKey string `json:"deadLetterKey,omitempty"`
+}
+
+// BulkResult reports the outcome of a bulk session
+type BulkResult struct {
+	Indexed  int
+	Failures []BulkItemFailure
+}
+
+// NewESHelper creates a new ESHelper for an Amazon OpenSearch Service domain, signing requests with SigV4,
+// and checks the cluster is healthy. Use NewESHelperWithConfig for the other connection modes.
+func NewESHelper(endpoint string, esIndex string) (*ESHelper, error) {
+	return ConnectESHelper(ESConfig{Endpoint: endpoint, Index: esIndex, AuthMode: ESAuthSigV4})
+}
+
+// PostBulk indexes all items in a single bulk session. Retryable item failures are resubmitted with backoff,
+// and items that still fail are parked in the dead letter location when one is configured.
+// A *BulkIndexError is returned when any item could not be indexed.
+func (es *ESHelper) PostBulk(items []BulkItem) (*BulkResult, error) {
+	result := &BulkResult{}
+	pending := items
+	byId := make(map[string]BulkItem, len(items))
+	for _, item := range items {
+		byId[item.DocumentID] = item
 	}
 
-	client, _ := opensearch.NewClient(opensearch.Config{
-		Addresses: []string{endpoint},
-		Signer:    signer,
-		
-	})
+	for attempt := 1; len(pending) > 0; attempt++ {
+		indexed, failures, err := es.postBulkSession(pending)
+		if err != nil {
+			return nil, err
+		}
+		result.Indexed += indexed
+
+		pending = []BulkItem{}
+		for _, failure := range failures {
+			failure.Attempts = attempt
+			if isRetryableStatus(failure.Status) && attempt <= es.MaxRetries {
+				pending = append(pending, byId[failure.DocumentID])
+			} else {
+				result.Failures = append(result.Failures, failure)
+			}
+		}
+
+		if len(pending) > 0 {
+			backoff := es.retryBackoff(attempt)
+			log.Printf("Retrying %d bulk items in %s (attempt %d of %d) \n", len(pending), backoff, attempt+1, es.MaxRetries+1)
+			time.Sleep(backoff)
+		}
+	}
 
-	if info, err := client.Info(); err != nil {
-		log.Fatal("info", err)
-	} else {
-		var r map[string]interface{}
-		json.NewDecoder(info.Body).Decode(&r)
-		version := r["version"].(map[string]interface{})
-		fmt.Printf("%s: %s\n", version["distribution"], version["number"])
-		fmt.Println("Successfully established connection to ", endpoint)
+	if len(result.Failures) == 0 {
+		log.Printf("Successfully indexed [%d] documents", result.Indexed)
+		return result, nil
 	}
 
-	return &ESHelper{
-		ESClient: client,
-		index: esIndex,
+	log.Printf("Indexed [%d] documents with [%d] errors", result.Indexed, len(result.Failures))
+	if es.DeadLetter != nil {
+		for i, failure := range result.Failures {
+			key, err := es.DeadLetter.Park(es.index, byId[failure.DocumentID], failure)
+			if err != nil {
+				log.Printf("Unable to park %s in the dead letter location: %v \n", failure.DocumentID, err)
+				continue
+			}
+			result.Failures[i].DeadLetterKey = key
+		}
 	}
+	return result, &BulkIndexError{Index: es.index, Failures: result.Failures}
 }
 
-func (es *ESHelper) PostBulk(documentId string, payload []byte) error {
+// Run one bulk session and report which items failed. Items lost to a failed flush are reported with status 0.
+func (es *ESHelper) postBulkSession(items []BulkItem) (int, []BulkItemFailure, error) {
+	var mu sync.Mutex
+	failures := []BulkItemFailure{}
+	settled := map[string]bool{}
+	var flushErr error
+
 	// Create the indexer
 	//
 	indexer, err := opensearchutil.NewBulkIndexer(opensearchutil.BulkIndexerConfig{
-		Client:     es.ESClient, // The OpenSearch client
-		Index:      es.index, // The default index name
+		Client: es.ESClient, // The OpenSearch client
+		Index:  es.index,    // The default index name
+		OnError: func(ctx context.Context, err error) {
+			log.Printf("Bulk indexer error: %s", err)
+			mu.Lock()
+			flushErr = err
+			mu.Unlock()
+		},
 	})
 	if err != nil {
-		log.Fatalf("Error creating the indexer: %s", err)
-		return err
+		return 0, nil, &ESError{Op: "create bulk indexer", Err: err}
 	}
 
-	// Add an item to the indexer
+	// Add the items to the indexer
 	//
-	err = indexer.Add(
-		context.Background(),
-		opensearchutil.BulkIndexerItem{
-			// Action field configures the operation to perform (index, create, delete, update)
-			Action: "index",
-
-			// DocumentID is the optional document ID
-			DocumentID: documentId,
-
-			// Body is an `io.Reader` with the payload
-			Body: bytes.NewReader(payload),
-
-			// OnSuccess is the optional callback for each successful operation
-			OnSuccess: func(
-				ctx context.Context,
-				item opensearchutil.BulkIndexerItem,
-				res opensearchutil.BulkIndexerResponseItem,
-			) {
-				fmt.Printf("[%d] %s test/%s", res.Status, res.Result, item.DocumentID)
-			},
+	for _, item := range items {
+		err = indexer.Add(
+			context.Background(),
+			opensearchutil.BulkIndexerItem{
+				Action:     "index",
+				DocumentID: item.DocumentID,
+				Body:       bytes.NewReader(item.Body),
+
+				// OnSuccess is the optional callback for each successful operation
+				OnSuccess: func(
+					ctx context.Context,
+					item opensearchutil.BulkIndexerItem,
+					res opensearchutil.BulkIndexerResponseItem,
+				) {
+					log.Printf("[%d] %s %s/%s", res.Status, res.Result, res.Index, item.DocumentID)
+					mu.Lock()
+					settled[item.DocumentID] = true
+					mu.Unlock()
+				},
 
-			// OnFailure is the optional callback for each failed operation
-			OnFailure: func(
-				ctx context.Context,
-				item opensearchutil.BulkIndexerItem,
-				res opensearchutil.BulkIndexerResponseItem, err error,
-			) {
-				if err != nil {
-					log.Printf("ERROR: %s for payload %s \n", err, string(payload))
-					log.Printf("ERROR: %s: %s", res.Error.Type, res.Error.Reason)
-				} else {
-					log.Printf("ERROR for payload %s %s: %s \n", string(payload), res.Error.Type, res.Error.Reason)
-				}
+				// OnFailure is the optional callback for each failed operation
+				OnFailure: func(
+					ctx context.Context,
+					item opensearchutil.BulkIndexerItem,
+					res opensearchutil.BulkIndexerResponseItem, err error,
+				) {
+					failure := BulkItemFailure{
+						DocumentID: item.DocumentID,
+						Status:     res.Status,
+						ErrorType:  res.Error.Type,
+						Reason:     res.Error.Reason,
+					}
+					if err != nil {
+						failure.Reason = err.Error()
+					}
+					log.Printf("ERROR indexing %s: [%d] %s: %s", failure.DocumentID, failure.Status, failure.ErrorType, failure.Reason)
+
+					mu.Lock()
+					settled[item.DocumentID] = true
+					failures = append(failures, failure)
+					mu.Unlock()
+				},
 			},
-		},
-	)
-	if err != nil {
-		log.Fatalf("Unexpected error: %s", err)
-		return err
+		)
+		if err != nil {
+			indexer.Close(context.Background())
+			return 0, nil, &ESError{Op: "add " + item.DocumentID + " to the bulk indexer", Err: err}
+		}
 	}
 
 	// Close the indexer channel and flush remaining items
 	//
 	if err := indexer.Close(context.Background()); err != nil {
-		log.Fatalf("Unexpected error closing the channel: %s", err)
+		return 0, nil, &ESError{Op: "close bulk indexer", Err: err}
 	}
 
-	// Report the indexer statistics
-	//
-	stats := indexer.Stats()
-	if stats.NumFailed > 0 {
-		log.Fatalf("Indexed [%d] documents with [%d] errors", stats.NumFlushed, stats.NumFailed)
-	} else {
-		log.Printf("Successfully indexed [%d] documents", stats.NumFlushed)
+	// Items of a flush that never reached OpenSearch get no callback
+	for _, item := range items {
+		if !settled[item.DocumentID] {
+			reason := "bulk flush failed"
+			if flushErr != nil {
+				reason = flushErr.Error()
+			}
+			failures = append(failures, BulkItemFailure{DocumentID: item.DocumentID, ErrorType: "flush_error", Reason: reason})
+		}
 	}
 
-	return nil
+	return int(indexer.Stats().NumIndexed), failures, nil
 }
 
+// Run a search request body against the read index and return the raw response
+func (es *ESHelper) Search(body []byte) ([]byte, error) {
+	res, err := opensearchapi.SearchRequest{
+		Index: []string{es.readIndex},
+		Body:  bytes.NewReader(body),
+	}.Do(context.Background(), es.ESClient)
+	if err = esResponseError("search "+es.readIndex, res, err); err != nil {
+		return nil, &ESError{Op: "search", Err: err}
+	}
+	defer res.Body.Close()
+
+	return io.ReadAll(res.Body)
+}
+
+// Delete the documents matching a query from the write and read indices, returning how many were deleted
+func (es *ESHelper) DeleteByQuery(body []byte) (int, error) {
+	indices := []string{es.index}
+	if es.readIndex != es.index {
+		indices = append(indices, es.readIndex)
+	}
+
+	refresh := true
+	res, err := opensearchapi.DeleteByQueryRequest{
+		Index:     indices,
+		Body:      bytes.NewReader(body),
+		Conflicts: "proceed",
+		Refresh:   &refresh,
+	}.Do(context.Background(), es.ESClient)
+	if err = esResponseError("delete by query", res, err); err != nil {
+		return 0, &ESError{Op: "delete", Err: err}
+	}
+	defer res.Body.Close()
 
+	result := struct {
+		Deleted int `json:"deleted"`
+	}{}
+	err = json.NewDecoder(res.Body).Decode(&result)
+	if err != nil {
+		return 0, &ESError{Op: "delete", Err: err}
+	}
+	return result.Deleted, nil
+}
+
+// Exponential backoff with jitter for the given attempt
+func (es *ESHelper) retryBackoff(attempt int) time.Duration {
+	backoff := es.RetryBackoff
+	if backoff <= 0 {
+		backoff = defaultESRetryBackoff
+	}
+	backoff = backoff << (attempt - 1)
+	if backoff > maxESRetryBackoff {
+		backoff = maxESRetryBackoff
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// Throttling, unavailability and failed flushes (status 0) are worth retrying; mapping and validation errors are not
+func isRetryableStatus(status int) bool {
+	switch status {
+	case 0, http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,175 @@
+package awshelper
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+func TestIsRetryableStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		want   bool
+	}{
+		{status: 0, want: true},
+		{status: http.StatusTooManyRequests, want: true},
+		{status: http.StatusBadGateway, want: true},
+		{status: http.StatusServiceUnavailable, want: true},
+		{status: http.StatusGatewayTimeout, want: true},
+		{status: http.StatusBadRequest},
+		{status: http.StatusConflict},
+		{status: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		if got := isRetryableStatus(tt.status); got != tt.want {
+			t.Errorf("isRetryableStatus(%d) = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
+
+// Cluster answering each bulk item with the next status scripted for its document, then with 201
+type bulkCluster struct {
+	mu       sync.Mutex
+	statuses map[string][]int
+	requests int
+}
+
+func (c *bulkCluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if !strings.HasSuffix(r.URL.Path, "/_bulk") {
+		// The client checks the cluster before its first request
+		json.NewEncoder(w).Encode(map[string]interface{}{"version": map[string]interface{}{"number": "2.11.0", "distribution": "opensearch"}})
+		return
+	}
+	c.requests++
+
+	items := []interface{}{}
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		action := map[string]struct {
+			Id string `json:"_id"`
+		}{}
+		json.Unmarshal(scanner.Bytes(), &action)
+		scanner.Scan()
+		documentId := action["index"].Id
+
+		status := http.StatusCreated
+		if statuses := c.statuses[documentId]; len(statuses) > 0 {
+			status, c.statuses[documentId] = statuses[0], statuses[1:]
+		}
+		result := map[string]interface{}{"_id": documentId, "status": status}
+		if status == http.StatusCreated {
+			result["result"] = "created"
+		} else {
+			result["error"] = map[string]string{"type": "scripted_exception", "reason": http.StatusText(status)}
+		}
+		items = append(items, map[string]interface{}{"index": result})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"errors": true, "items": items})
+}
+
+// Bucket keeping the objects written to it
+type fakeDeadLetterBucket struct {
+	s3iface.S3API
+	objects map[string]string
+}
+
+func (b *fakeDeadLetterBucket) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	content, err := io.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	b.objects[aws.StringValue(input.Key)] = string(content)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func TestPostBulk(t *testing.T) {
+	cluster := &bulkCluster{statuses: map[string][]int{
+		"throttled":   {http.StatusTooManyRequests},
+		"rejected":    {http.StatusBadRequest},
+		"unavailable": {503, 503, 503, 503, 503},
+	}}
+	server := httptest.NewServer(cluster)
+	defer server.Close()
+	es, err := NewESHelperWithConfig(ESConfig{Endpoint: server.URL, Index: "documents", AuthMode: ESAuthNone})
+	if err != nil {
+		t.Fatalf("NewESHelperWithConfig() error = %v", err)
+	}
+	es.RetryBackoff = time.Microsecond
+	bucket := &fakeDeadLetterBucket{objects: map[string]string{}}
+	es.DeadLetter = &ESDeadLetter{S3: &S3Helper{S3Client: bucket}, BucketName: "dead-letters", Prefix: "es-dead-letter/"}
+
+	items := []BulkItem{}
+	for _, documentId := range []string{"indexed", "throttled", "rejected", "unavailable"} {
+		items = append(items, BulkItem{DocumentID: documentId, Body: []byte(`{"documentId":"` + documentId + `"}`)})
+	}
+	result, err := es.PostBulk(items)
+
+	var bulkErr *BulkIndexError
+	if !errors.As(err, &bulkErr) || bulkErr.DeadLettered() != 2 {
+		t.Fatalf("PostBulk() error = %v, want a *BulkIndexError of 2 parked items", err)
+	}
+	// The first attempt and MaxRetries retries of the unavailable item
+	if result.Indexed != 2 || cluster.requests != es.MaxRetries+1 {
+		t.Errorf("indexed %d items in %d requests, want 2 in %d", result.Indexed, cluster.requests, es.MaxRetries+1)
+	}
+
+	failures := map[string]BulkItemFailure{}
+	for _, failure := range result.Failures {
+		failures[failure.DocumentID] = failure
+	}
+	wantFailures := map[string][2]int{
+		"rejected":    {http.StatusBadRequest, 1},
+		"unavailable": {http.StatusServiceUnavailable, es.MaxRetries + 1},
+	}
+	if len(failures) != len(wantFailures) {
+		t.Errorf("failures %+v, want %v", result.Failures, wantFailures)
+	}
+	for documentId, want := range wantFailures {
+		failure := failures[documentId]
+		if failure.Status != want[0] || failure.Attempts != want[1] || failure.ErrorType != "scripted_exception" {
+			t.Errorf("%s failed with %d after %d attempts, want %d after %d", documentId, failure.Status, failure.Attempts, want[0], want[1])
+		}
+
+		record := ESDeadLetterRecord{}
+		err := json.Unmarshal([]byte(bucket.objects[failure.DeadLetterKey]), &record)
+		if err != nil {
+			t.Fatalf("%s parked at %q: %v", documentId, failure.DeadLetterKey, err)
+		}
+		if !strings.HasPrefix(failure.DeadLetterKey, "es-dead-letter/documents/") || record.DocumentID != documentId || record.Attempts != want[1] || string(record.Body) != `{"documentId":"`+documentId+`"}` {
+			t.Errorf("%s parked at %s as %+v", documentId, failure.DeadLetterKey, record)
+		}
+	}
+}
+
+func TestPostBulkWithoutDeadLetter(t *testing.T) {
+	server := httptest.NewServer(&bulkCluster{statuses: map[string][]int{"rejected": {http.StatusBadRequest}}})
+	defer server.Close()
+	es, err := NewESHelperWithConfig(ESConfig{Endpoint: server.URL, Index: "documents", AuthMode: ESAuthNone})
+	if err != nil {
+		t.Fatalf("NewESHelperWithConfig() error = %v", err)
+	}
+
+	result, err := es.PostBulk([]BulkItem{{DocumentID: "rejected", Body: []byte(`{}`)}, {DocumentID: "indexed", Body: []byte(`{}`)}})
+	var bulkErr *BulkIndexError
+	if !errors.As(err, &bulkErr) || bulkErr.DeadLettered() != 0 {
+		t.Fatalf("PostBulk() error = %v, want a *BulkIndexError of no parked items", err)
+	}
+	if result.Indexed != 1 || len(result.Failures) != 1 || result.Failures[0].DocumentID != "rejected" {
+		t.Errorf("PostBulk() = %+v", result)
+	}
+}
@@ -0,0 +1,409 @@
+package awshelper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go/opensearchapi"
+)
+
+// Interval between checks of a running reindex task
+const reindexPollInterval = 5 * time.Second
+
+// IndexTemplate describes a versioned index template with explicit settings and mappings.
+// Indices created from it are named <name>-v<version>, read through the <name> alias and written through the <name>-write alias.
+type IndexTemplate struct {
+	Name     string
+	Version  int
+	Settings map[string]interface{}
+	Mappings map[string]interface{}
+}
+
+// IndexMigration reports what a migration to a new template version did
+type IndexMigration struct {
+	SourceIndices []string `json:"sourceIndices"`
+	TargetIndex   string   `json:"targetIndex"`
+	Reindexed     int      `json:"reindexed"`
+	Deleted       bool     `json:"deleted"`
+}
+
+// Name of the concrete index holding the given template version
+func (t IndexTemplate) IndexName(version int) string {
+	return fmt.Sprintf("%s-v%d", t.Name, version)
+}
+
+// Alias searches read through
+func (t IndexTemplate) ReadAlias() string {
+	return t.Name
+}
+
+// Alias the pipeline writes through
+func (t IndexTemplate) WriteAlias() string {
+	return t.Name + "-write"
+}
+
+// Put (create or replace) the composable index template in the cluster
+func (es *ESHelper) PutIndexTemplate(t IndexTemplate) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"index_patterns": []string{t.Name + "-v*"},
+		"version":        t.Version,
+		"template": map[string]interface{}{
+			"settings": t.Settings,
+			"mappings": t.Mappings,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	res, err := opensearchapi.IndicesPutIndexTemplateRequest{
+		Name: t.Name,
+		Body: bytes.NewReader(body),
+	}.Do(context.Background(), es.ESClient)
+	return esCheck("put index template "+t.Name, res, err)
+}
+
+// Make sure the template, the current versioned index and its aliases exist, then write through the write alias.
+// Fails while a legacy concrete index occupies the read alias name, as documents written to the versioned index
+// would not be searchable until MigrateIndex replaces it.
+func (es *ESHelper) ManageIndex(t IndexTemplate) error {
+	legacy, err := es.isConcreteIndex(t.ReadAlias())
+	if err != nil {
+		return err
+	}
+	if legacy {
+		return fmt.Errorf("index %s is a legacy concrete index; migrate it to %s with esIndexMigrate -delete-old before indexing", t.ReadAlias(), t.IndexName(t.Version))
+	}
+
+	err = es.PutIndexTemplate(t)
+	if err != nil {
+		return err
+	}
+
+	writeIndices, err := es.AliasIndices(t.WriteAlias())
+	if err != nil {
+		return err
+	}
+
+	if len(writeIndices) == 0 {
+		aliases := map[string]interface{}{
+			t.WriteAlias(): map[string]interface{}{"is_write_index": true},
+			t.ReadAlias():  map[string]interface{}{},
+		}
+		err = es.createIndex(t.IndexName(t.Version), aliases)
+		if err != nil {
+			return err
+		}
+		log.Printf("Created index %s for template %s version %d \n", t.IndexName(t.Version), t.Name, t.Version)
+	} else if writeIndices[0] != t.IndexName(t.Version) {
+		log.Printf("Writing to %s while template %s is at version %d. Run the index migration to upgrade. \n", writeIndices[0], t.Name, t.Version)
+	}
+
+	es.index = t.WriteAlias()
+	es.readIndex = t.ReadAlias()
+	return nil
+}
+
+// Zero-downtime migration to the template's current version: create the new versioned index,
+// move the write alias to it, reindex the documents behind the read alias, then flip the read alias atomically.
+// When the reindex or the flip fails, the write alias moves back to the indices it was on; documents written
+// to the new index meanwhile stay there, and rerunning the migration reindexes around them.
+// A legacy concrete index holding the read alias name is deleted in the flip, so migrating one requires deleteOld.
+func (es *ESHelper) MigrateIndex(t IndexTemplate, deleteOld bool) (*IndexMigration, error) {
+	migration := &IndexMigration{TargetIndex: t.IndexName(t.Version)}
+
+	legacy, err := es.isConcreteIndex(t.ReadAlias())
+	if err != nil {
+		return nil, err
+	}
+	if legacy && !deleteOld {
+		return nil, fmt.Errorf("index %s is a legacy concrete index, which is deleted when the read alias takes its name; rerun with -delete-old to migrate it", t.ReadAlias())
+	}
+
+	err = es.PutIndexTemplate(t)
+	if err != nil {
+		return nil, err
+	}
+
+	if legacy {
+		migration.SourceIndices = []string{t.ReadAlias()}
+	} else {
+		migration.SourceIndices, err = es.AliasIndices(t.ReadAlias())
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(migration.SourceIndices) == 1 && migration.SourceIndices[0] == migration.TargetIndex {
+		log.Printf("Index %s is already at version %d \n", migration.TargetIndex, t.Version)
+		return migration, nil
+	}
+
+	// Create the target and send new writes to it while the old documents are copied over
+	exists, err := es.isConcreteIndex(migration.TargetIndex)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		err = es.createIndex(migration.TargetIndex, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	writeIndices, err := es.AliasIndices(t.WriteAlias())
+	if err != nil {
+		return nil, err
+	}
+	err = es.moveWriteAlias(t.WriteAlias(), writeIndices, []string{migration.TargetIndex})
+	if err != nil {
+		return nil, err
+	}
+
+	// Copy the old documents without overwriting anything written since the flip
+	if len(migration.SourceIndices) > 0 {
+		migration.Reindexed, err = es.reindex(migration.SourceIndices, migration.TargetIndex)
+		if err != nil {
+			return nil, es.rollbackWriteAlias(t, writeIndices, migration.TargetIndex, err)
+		}
+	}
+
+	// Flip the read alias in a single atomic request
+	actions := []map[string]interface{}{}
+	for _, index := range migration.SourceIndices {
+		if legacy {
+			actions = append(actions, map[string]interface{}{"remove_index": map[string]interface{}{"index": index}})
+		} else {
+			actions = append(actions, map[string]interface{}{"remove": map[string]interface{}{"index": index, "alias": t.ReadAlias()}})
+		}
+	}
+	actions = append(actions, map[string]interface{}{"add": map[string]interface{}{"index": migration.TargetIndex, "alias": t.ReadAlias()}})
+	err = es.updateAliases(actions)
+	if err != nil {
+		return nil, es.rollbackWriteAlias(t, writeIndices, migration.TargetIndex, err)
+	}
+	migration.Deleted = legacy
+
+	if deleteOld && !legacy {
+		for _, index := range migration.SourceIndices {
+			res, err := opensearchapi.IndicesDeleteRequest{Index: []string{index}}.Do(context.Background(), es.ESClient)
+			err = esCheck("delete index "+index, res, err)
+			if err != nil {
+				return migration, err
+			}
+		}
+		migration.Deleted = true
+	}
+
+	log.Printf("Migrated %v to %s, reindexed %d documents \n", migration.SourceIndices, migration.TargetIndex, migration.Reindexed)
+	return migration, nil
+}
+
+// Move an alias from some indices to others in a single request, the first of the new ones as its write index
+func (es *ESHelper) moveWriteAlias(alias string, from []string, to []string) error {
+	actions := []map[string]interface{}{}
+	for _, index := range from {
+		actions = append(actions, map[string]interface{}{"remove": map[string]interface{}{"index": index, "alias": alias}})
+	}
+	for i, index := range to {
+		actions = append(actions, map[string]interface{}{"add": map[string]interface{}{"index": index, "alias": alias, "is_write_index": i == 0}})
+	}
+	return es.updateAliases(actions)
+}
+
+// Move the write alias of a failed migration back to the indices it was on, returning the failure
+func (es *ESHelper) rollbackWriteAlias(t IndexTemplate, writeIndices []string, targetIndex string, cause error) error {
+	err := es.moveWriteAlias(t.WriteAlias(), []string{targetIndex}, writeIndices)
+	if err != nil {
+		return fmt.Errorf("%v; moving %s back to %v also failed, it still writes to %s: %v", cause, t.WriteAlias(), writeIndices, targetIndex, err)
+	}
+	log.Printf("Moved %s back to %v; documents written to %s during the migration are kept there \n", t.WriteAlias(), writeIndices, targetIndex)
+	return cause
+}
+
+// List the concrete indices behind an alias
+func (es *ESHelper) AliasIndices(alias string) ([]string, error) {
+	res, err := opensearchapi.IndicesGetAliasRequest{Name: []string{alias}}.Do(context.Background(), es.ESClient)
+	if err == nil && res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return []string{}, nil
+	}
+	if err = esResponseError("get alias "+alias, res, err); err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	aliases := map[string]struct {
+		Aliases map[string]struct {
+			IsWriteIndex *bool `json:"is_write_index"`
+		} `json:"aliases"`
+	}{}
+	err = json.NewDecoder(res.Body).Decode(&aliases)
+	if err != nil {
+		return nil, err
+	}
+
+	// Write indices come first so callers can rely on indices[0]
+	indices := []string{}
+	for index, entry := range aliases {
+		if isWrite := entry.Aliases[alias].IsWriteIndex; isWrite != nil && *isWrite {
+			indices = append([]string{index}, indices...)
+		} else {
+			indices = append(indices, index)
+		}
+	}
+	return indices, nil
+}
+
+func (es *ESHelper) isConcreteIndex(name string) (bool, error) {
+	indices, err := es.AliasIndices(name)
+	if err != nil || len(indices) > 0 {
+		return false, err
+	}
+
+	res, err := opensearchapi.IndicesExistsRequest{Index: []string{name}}.Do(context.Background(), es.ESClient)
+	if err != nil {
+		return false, err
+	}
+	res.Body.Close()
+	return res.StatusCode == http.StatusOK, nil
+}
+
+func (es *ESHelper) createIndex(index string, aliases map[string]interface{}) error {
+	body := map[string]interface{}{}
+	if aliases != nil {
+		body["aliases"] = aliases
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	res, err := opensearchapi.IndicesCreateRequest{
+		Index: index,
+		Body:  bytes.NewReader(payload),
+	}.Do(context.Background(), es.ESClient)
+	return esCheck("create index "+index, res, err)
+}
+
+func (es *ESHelper) updateAliases(actions []map[string]interface{}) error {
+	payload, err := json.Marshal(map[string]interface{}{"actions": actions})
+	if err != nil {
+		return err
+	}
+
+	res, err := opensearchapi.IndicesUpdateAliasesRequest{Body: bytes.NewReader(payload)}.Do(context.Background(), es.ESClient)
+	return esCheck("update aliases", res, err)
+}
+
+// Reindex as a background task and poll it, as a reindex outlasts the response timeout of the client's requests
+func (es *ESHelper) reindex(sourceIndices []string, targetIndex string) (int, error) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"conflicts": "proceed",
+		"source":    map[string]interface{}{"index": sourceIndices},
+		"dest":      map[string]interface{}{"index": targetIndex, "op_type": "create"},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	waitForCompletion := false
+	res, err := opensearchapi.ReindexRequest{
+		Body:              bytes.NewReader(payload),
+		WaitForCompletion: &waitForCompletion,
+	}.Do(context.Background(), es.ESClient)
+	if err = esResponseError("reindex into "+targetIndex, res, err); err != nil {
+		return 0, err
+	}
+	started := struct {
+		Task string `json:"task"`
+	}{}
+	err = json.NewDecoder(res.Body).Decode(&started)
+	res.Body.Close()
+	if err != nil {
+		return 0, err
+	}
+	if started.Task == "" {
+		return 0, fmt.Errorf("reindex into %s did not start a task", targetIndex)
+	}
+	log.Printf("Reindexing into %s as task %s \n", targetIndex, started.Task)
+
+	for {
+		task, err := es.getReindexTask(started.Task)
+		if err != nil {
+			return 0, err
+		}
+		if !task.Completed {
+			log.Printf("Reindex task %s created %d of %d documents \n", started.Task, task.Task.Status.Created, task.Task.Status.Total)
+			time.Sleep(reindexPollInterval)
+			continue
+		}
+
+		if task.Error != nil {
+			return task.Response.Created, fmt.Errorf("reindex into %s failed: %v", targetIndex, task.Error)
+		}
+		if len(task.Response.Failures) > 0 {
+			return task.Response.Created, fmt.Errorf("reindex into %s had %d failures: %v", targetIndex, len(task.Response.Failures), task.Response.Failures[0])
+		}
+		return task.Response.Created, nil
+	}
+}
+
+// State of a reindex task
+type reindexTask struct {
+	Completed bool `json:"completed"`
+	Task      struct {
+		Status struct {
+			Total   int `json:"total"`
+			Created int `json:"created"`
+		} `json:"status"`
+	} `json:"task"`
+	Response struct {
+		Created  int           `json:"created"`
+		Failures []interface{} `json:"failures"`
+	} `json:"response"`
+	Error interface{} `json:"error"`
+}
+
+func (es *ESHelper) getReindexTask(taskId string) (*reindexTask, error) {
+	res, err := opensearchapi.TasksGetRequest{TaskID: taskId}.Do(context.Background(), es.ESClient)
+	if err = esResponseError("get task "+taskId, res, err); err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	task := &reindexTask{}
+	err = json.NewDecoder(res.Body).Decode(task)
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// Check a request whose response body is not needed
+func esCheck(op string, res *opensearchapi.Response, err error) error {
+	err = esResponseError(op, res, err)
+	if err == nil {
+		res.Body.Close()
+	}
+	return err
+}
+
+// Turn a failed request or an error response into an error, closing the body of error responses
+func esResponseError(op string, res *opensearchapi.Response, err error) error {
+	if err != nil {
+		return fmt.Errorf("%s: %v", op, err)
+	}
+	if res.IsError() {
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("%s: [%d] %s", op, res.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package awshelper
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+// Cluster keeping its indices and aliases in memory, answering the requests of the index management
+type aliasCluster struct {
+	mu      sync.Mutex
+	indices map[string]bool
+	// Indices of each alias, true for its write index
+	aliases map[string]map[string]bool
+	// Documents of the reindex task, and the error it fails with if any
+	documents   int
+	reindexErr  string
+	reindexFrom []string
+	// Indices of the write alias when the reindex started
+	writeAtReindex map[string]bool
+	// Alias requests adding this alias fail
+	failAlias string
+}
+
+func (c *aliasCluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	reply := func(status int, body interface{}) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}
+
+	switch {
+	case path == "":
+		reply(http.StatusOK, map[string]interface{}{"version": map[string]interface{}{"number": "2.11.0", "distribution": "opensearch"}})
+	case strings.HasPrefix(path, "_alias/"):
+		alias := strings.TrimPrefix(path, "_alias/")
+		if len(c.aliases[alias]) == 0 {
+			reply(http.StatusNotFound, map[string]interface{}{"error": "alias [" + alias + "] missing", "status": 404})
+			return
+		}
+		body := map[string]interface{}{}
+		for index, isWrite := range c.aliases[alias] {
+			body[index] = map[string]interface{}{"aliases": map[string]interface{}{alias: map[string]interface{}{"is_write_index": isWrite}}}
+		}
+		reply(http.StatusOK, body)
+	case strings.HasPrefix(path, "_index_template/"):
+		reply(http.StatusOK, map[string]interface{}{"acknowledged": true})
+	case path == "_aliases":
+		c.updateAliases(r, reply)
+	case path == "_reindex":
+		request := struct {
+			Source struct {
+				Index []string `json:"index"`
+			} `json:"source"`
+		}{}
+		json.NewDecoder(r.Body).Decode(&request)
+		c.reindexFrom = request.Source.Index
+		c.writeAtReindex = map[string]bool{}
+		for index, isWrite := range c.aliases["documents-write"] {
+			c.writeAtReindex[index] = isWrite
+		}
+		reply(http.StatusOK, map[string]interface{}{"task": "node:1"})
+	case strings.HasPrefix(path, "_tasks/"):
+		task := map[string]interface{}{"completed": true, "response": map[string]interface{}{"created": c.documents, "failures": []interface{}{}}}
+		if c.reindexErr != "" {
+			task["error"] = map[string]interface{}{"type": c.reindexErr}
+		}
+		reply(http.StatusOK, task)
+	case r.Method == http.MethodHead:
+		if c.indices[path] {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case r.Method == http.MethodPut:
+		c.indices[path] = true
+		reply(http.StatusOK, map[string]interface{}{"acknowledged": true, "index": path})
+	case r.Method == http.MethodDelete:
+		delete(c.indices, path)
+		reply(http.StatusOK, map[string]interface{}{"acknowledged": true})
+	default:
+		reply(http.StatusNotFound, map[string]interface{}{"error": "unexpected " + r.Method + " " + r.URL.Path})
+	}
+}
+
+// Apply the actions of an alias request together, or none of them
+func (c *aliasCluster) updateAliases(r *http.Request, reply func(int, interface{})) {
+	request := struct {
+		Actions []map[string]struct {
+			Index        string `json:"index"`
+			Alias        string `json:"alias"`
+			IsWriteIndex bool   `json:"is_write_index"`
+		} `json:"actions"`
+	}{}
+	json.NewDecoder(r.Body).Decode(&request)
+	for _, action := range request.Actions {
+		if add, ok := action["add"]; ok && add.Alias == c.failAlias {
+			reply(http.StatusInternalServerError, map[string]interface{}{"error": "scripted failure", "status": 500})
+			return
+		}
+	}
+
+	for _, action := range request.Actions {
+		for kind, target := range action {
+			switch kind {
+			case "add":
+				if c.aliases[target.Alias] == nil {
+					c.aliases[target.Alias] = map[string]bool{}
+				}
+				c.aliases[target.Alias][target.Index] = target.IsWriteIndex
+			case "remove":
+				delete(c.aliases[target.Alias], target.Index)
+			case "remove_index":
+				delete(c.indices, target.Index)
+			}
+		}
+	}
+	reply(http.StatusOK, map[string]interface{}{"acknowledged": true})
+}
+
+func TestMigrateIndex(t *testing.T) {
+	template := IndexTemplate{Name: "documents", Version: 2}
+	tests := []struct {
+		name       string
+		reindexErr string
+		failAlias  string
+		wantErr    bool
+		// Indices of the aliases after the migration, the write index first
+		wantWrite []string
+		wantRead  []string
+	}{
+		{name: "migrated", wantWrite: []string{"documents-v2"}, wantRead: []string{"documents-v2"}},
+		{name: "reindex failed", reindexErr: "search_phase_execution_exception", wantErr: true, wantWrite: []string{"documents-v1"}, wantRead: []string{"documents-v1"}},
+		{name: "read alias flip failed", failAlias: "documents", wantErr: true, wantWrite: []string{"documents-v1"}, wantRead: []string{"documents-v1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster := &aliasCluster{
+				indices: map[string]bool{"documents-v1": true},
+				aliases: map[string]map[string]bool{
+					"documents":       {"documents-v1": false},
+					"documents-write": {"documents-v1": true},
+				},
+				documents:  5,
+				reindexErr: tt.reindexErr,
+				failAlias:  tt.failAlias,
+			}
+			server := httptest.NewServer(cluster)
+			defer server.Close()
+			es, err := NewESHelperWithConfig(ESConfig{Endpoint: server.URL, Index: "documents", AuthMode: ESAuthNone})
+			if err != nil {
+				t.Fatalf("NewESHelperWithConfig() error = %v", err)
+			}
+
+			migration, err := es.MigrateIndex(template, false)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("MigrateIndex() error = %v, want an error: %v", err, tt.wantErr)
+			}
+			if err == nil && (migration.Reindexed != 5 || migration.TargetIndex != "documents-v2" || migration.Deleted) {
+				t.Errorf("MigrateIndex() = %+v", migration)
+			}
+
+			// New documents go to the target while the old ones are copied over
+			if !slices.Equal(cluster.reindexFrom, []string{"documents-v1"}) || len(cluster.writeAtReindex) != 1 || !cluster.writeAtReindex["documents-v2"] {
+				t.Errorf("reindexed %v while writing to %v", cluster.reindexFrom, cluster.writeAtReindex)
+			}
+			write, err := es.AliasIndices(template.WriteAlias())
+			if err != nil || !slices.Equal(write, tt.wantWrite) || !cluster.aliases[template.WriteAlias()][tt.wantWrite[0]] {
+				t.Errorf("write alias on %v (%v), want %v as its write index", write, err, tt.wantWrite)
+			}
+			read, err := es.AliasIndices(template.ReadAlias())
+			if err != nil || !slices.Equal(read, tt.wantRead) {
+				t.Errorf("read alias on %v (%v), want %v", read, err, tt.wantRead)
+			}
+			if !cluster.indices["documents-v1"] || !cluster.indices["documents-v2"] {
+				t.Errorf("indices %v, want documents-v1 and documents-v2 kept", cluster.indices)
+			}
+		})
+	}
+}
+
+func TestMigrateIndexLegacy(t *testing.T) {
+	cluster := &aliasCluster{indices: map[string]bool{"documents": true}, aliases: map[string]map[string]bool{}, documents: 3}
+	server := httptest.NewServer(cluster)
+	defer server.Close()
+	es, err := NewESHelperWithConfig(ESConfig{Endpoint: server.URL, Index: "documents", AuthMode: ESAuthNone})
+	if err != nil {
+		t.Fatalf("NewESHelperWithConfig() error = %v", err)
+	}
+	template := IndexTemplate{Name: "documents", Version: 1}
+
+	_, err = es.MigrateIndex(template, false)
+	if err == nil || cluster.indices["documents-v1"] {
+		t.Fatalf("MigrateIndex() error = %v of a legacy index without deleteOld, created %v", err, cluster.indices)
+	}
+
+	migration, err := es.MigrateIndex(template, true)
+	if err != nil {
+		t.Fatalf("MigrateIndex() error = %v", err)
+	}
+	if !migration.Deleted || migration.Reindexed != 3 || cluster.indices["documents"] {
+		t.Errorf("MigrateIndex() = %+v, indices %v", migration, cluster.indices)
+	}
+	if read, _ := es.AliasIndices(template.ReadAlias()); !slices.Equal(read, []string{"documents-v1"}) {
+		t.Errorf("read alias on %v, want documents-v1", read)
+	}
+
+	// Migrating again finds the index at its version
+	migration, err = es.MigrateIndex(template, true)
+	if err != nil || migration.Reindexed != 0 {
+		t.Errorf("MigrateIndex() = %+v, %v, want nothing to migrate", migration, err)
+	}
+}
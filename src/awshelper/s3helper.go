@@ -171,4 +171,16 @@ func (s *S3Helper) ReadFromS3(bucketName string, s3FileName string) ([]byte, err
 	buf.ReadFrom(res.Body)
 	
 	return buf.Bytes(), nil
-}
\ No newline at end of file
+}
+
+// Deletes an S3 object
+func (s *S3Helper) DeleteFromS3(bucketName string, s3FileName string) error {
+	_, err := s.S3Client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(s3FileName),
+	})
+	if err != nil {
+		log.Println("Got error deleting object from S3: ", err.Error())
+	}
+	return err
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/dreamspider42/document-processing-pipeline/src/awshelper"
+	"github.com/dreamspider42/document-processing-pipeline/src/search"
+)
+
+// Migrates the search index to the current index template version without downtime.
+// Usage: esIndexMigrate [-endpoint <domain endpoint>] [-index <name>] [-auth <mode>] [-delete-old]
+func main() {
+	esConfig, err := awshelper.ESConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	esConfig.RegisterFlags(flag.CommandLine)
+	deleteOld := flag.Bool("delete-old", false, "Delete the previous versioned indices once the read alias has moved. Required to migrate a legacy concrete index.")
+	flag.Parse()
+
+	if esConfig.Endpoint == "" {
+		log.Fatal("Missing -endpoint flag or TARGET_ES_CLUSTER environment variable.")
+	}
+	if esConfig.Index == "" {
+		log.Fatal("Missing -index flag or ES_CLUSTER_INDEX environment variable.")
+	}
+
+	eshelper, err := awshelper.ConnectESHelper(esConfig)
+	if err != nil {
+		log.Fatalf("Could not connect to the search index: %v", err)
+	}
+	migration, err := eshelper.MigrateIndex(search.IndexTemplate(esConfig.Index), *deleteOld)
+	if err != nil {
+		log.Fatalf("Index migration failed: %v", err)
+	}
+
+	output, _ := json.MarshalIndent(migration, "", "  ")
+	fmt.Println(string(output))
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/dreamspider42/document-processing-pipeline/src/awshelper"
+	"github.com/dreamspider42/document-processing-pipeline/src/datastores"
+	"github.com/dreamspider42/document-processing-pipeline/src/search"
+)
+
+// Represents the progress of a rebuild, saved after every document so an interrupted run can resume
+type checkpoint struct {
+	Completed map[string]bool   `json:"completed"`
+	Failed    map[string]string `json:"failed"`
+	UpdatedAt string            `json:"updatedAt"`
+}
+
+// Represents the resources used by the rebuild
+type rebuilder struct {
+	s3                    *awshelper.S3Helper
+	indexer               search.SearchIndexer
+	documentRegistryStore *datastores.DocumentRegistryStore
+	comprehendBucketName  string
+	textractBucketName    string
+	checkpointPath        string
+	dryRun                bool
+
+	mu         sync.Mutex
+	checkpoint checkpoint
+	// Documents and records rebuilt by this run
+	documents int
+	records   int
+}
+
+// Load the checkpoint file, starting fresh when it does not exist yet
+func (r *rebuilder) loadCheckpoint() error {
+	r.checkpoint = checkpoint{Completed: map[string]bool{}, Failed: map[string]string{}}
+	if r.checkpointPath == "" {
+		return nil
+	}
+
+	content, err := os.ReadFile(r.checkpointPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	err = json.Unmarshal(content, &r.checkpoint)
+	if err != nil {
+		return fmt.Errorf("unreadable checkpoint %s: %v", r.checkpointPath, err)
+	}
+	if r.checkpoint.Failed == nil {
+		r.checkpoint.Failed = map[string]string{}
+	}
+	return nil
+}
+
+// Record the outcome of a document and persist the checkpoint. Callers hold r.mu.
+func (r *rebuilder) saveCheckpoint() error {
+	if r.checkpointPath == "" || r.dryRun {
+		return nil
+	}
+
+	r.checkpoint.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	content, err := json.MarshalIndent(r.checkpoint, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write then rename so an interrupted run never leaves a truncated checkpoint
+	tmpPath := r.checkpointPath + ".tmp"
+	err = os.WriteFile(tmpPath, content, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, r.checkpointPath)
+}
+
+// Read a comprehend output and index its document and page records
+func (r *rebuilder) rebuildDocument(key string) (int, error) {
+	documentId, documentName, err := search.ParseComprehendOutputKey(key)
+	if err != nil {
+		return 0, err
+	}
+
+	content, err := r.s3.ReadFromS3(r.comprehendBucketName, key)
+	if err != nil {
+		return 0, err
+	}
+	pages, err := search.ParseComprehendOutput(content)
+	if err != nil {
+		return 0, fmt.Errorf("unreadable comprehend output: %v", err)
+	}
+
+	objectName := fmt.Sprintf("%s/%s/ocr-analysis/fullresponse.json", documentId, documentName)
+	documentRecord := search.NewDocumentRecord(documentId, documentName, r.textractBucketName, objectName, pages)
+
+	var registryItem *datastores.DocumentRegistryItem
+	if r.documentRegistryStore != nil {
+		registryItem, err = r.documentRegistryStore.GetDocument(documentId)
+		if err != nil {
+			return 0, err
+		}
+		if registryItem == nil {
+			log.Printf("Document %s is not registered, indexing without registry metadata \n", documentId)
+		}
+	}
+	documentRecord.RecordMetadata = search.NewRecordMetadata(registryItem, time.Now())
+
+	if r.dryRun {
+		log.Printf("[dry-run] Would index %d records for document %s from %s \n", len(pages)+1, documentId, key)
+		return len(pages) + 1, nil
+	}
+
+	result, err := r.indexer.IndexDocument(documentRecord, pages)
+	if err != nil {
+		return 0, err
+	}
+	return result.Indexed, nil
+}
+
+// Rebuild every pending document with the given number of workers
+func (r *rebuilder) run(keys []string, concurrency int) {
+	work := make(chan string)
+	var wg sync.WaitGroup
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range work {
+				indexed, err := r.rebuildDocument(key)
+
+				r.mu.Lock()
+				if err != nil {
+					log.Printf("Failed to rebuild %s. Error: %v \n", key, err)
+					r.checkpoint.Failed[key] = err.Error()
+				} else {
+					delete(r.checkpoint.Failed, key)
+					r.checkpoint.Completed[key] = true
+					r.documents++
+					r.records += indexed
+				}
+				if err := r.saveCheckpoint(); err != nil {
+					log.Printf("Failed to save checkpoint. Error: %v \n", err)
+				}
+				r.mu.Unlock()
+			}
+		}()
+	}
+
+	for _, key := range keys {
+		work <- key
+	}
+	close(work)
+	wg.Wait()
+}
+
+// Rebuilds the search index from the comprehend outputs stored in S3.
+// Usage: esRebuildIndex [-bucket <comprehend bucket>] [-prefix <prefix>] [-endpoint <domain endpoint>] [-index <name>] [-auth <mode>] [-backend opensearch|local] [-concurrency <n>] [-checkpoint <file>] [-dry-run]
+func main() {
+	comprehendBucketName := flag.String("bucket", os.Getenv("TARGET_COMPREHEND_BUCKET"), "Bucket holding the comprehend outputs")
+	textractBucketName := flag.String("textract-bucket", os.Getenv("TEXTRACT_RESULTS_BUCKET_NAME"), "Bucket holding the Textract results, recorded on the document records")
+	prefix := flag.String("prefix", "", "Only rebuild documents under this prefix, e.g. a documentId")
+	searchConfig, err := search.BackendConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	searchConfig.ES.RegisterFlags(flag.CommandLine)
+	flag.StringVar(&searchConfig.Backend, "backend", searchConfig.Backend, "Search backend to rebuild: opensearch or local")
+	flag.StringVar(&searchConfig.LocalDir, "local-dir", searchConfig.LocalDir, "Directory of the local search backend")
+	registryTable := flag.String("registry-table", os.Getenv("REGISTRY_TABLE"), "Document registry table to copy document metadata from, empty to skip")
+	concurrency := flag.Int("concurrency", 4, "Number of documents indexed in parallel")
+	checkpointPath := flag.String("checkpoint", "es-rebuild-checkpoint.json", "File recording completed documents so an interrupted run can resume, empty to disable")
+	dryRun := flag.Bool("dry-run", false, "Read and convert every document without writing to the index or the checkpoint")
+	flag.Parse()
+
+	if *comprehendBucketName == "" {
+		log.Fatal("Missing -bucket flag or TARGET_COMPREHEND_BUCKET environment variable.")
+	}
+	if !*dryRun && searchConfig.Backend == search.BackendOpenSearch && (searchConfig.ES.Endpoint == "" || searchConfig.ES.Index == "") {
+		log.Fatal("Missing -endpoint or -index flag (or TARGET_ES_CLUSTER, ES_CLUSTER_INDEX environment variables).")
+	}
+	if *concurrency < 1 {
+		log.Fatal("-concurrency must be at least 1.")
+	}
+
+	r := &rebuilder{
+		s3:                   &awshelper.S3Helper{S3Client: s3.New(awshelper.NewAWSSession())},
+		comprehendBucketName: *comprehendBucketName,
+		textractBucketName:   *textractBucketName,
+		checkpointPath:       *checkpointPath,
+		dryRun:               *dryRun,
+	}
+	if *registryTable != "" {
+		r.documentRegistryStore = datastores.NewDocumentRegistryStore(*registryTable)
+	}
+	if !*dryRun {
+		r.indexer, err = search.OpenIndexer(searchConfig)
+		if err != nil {
+			log.Fatalf("Could not open the %s search index: %v", searchConfig.Backend, err)
+		}
+	}
+
+	err = r.loadCheckpoint()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Walk the bucket for comprehend outputs not completed by a previous run
+	objects, err := r.s3.ListObjectsInS3(*comprehendBucketName, *prefix, 1000)
+	if err != nil {
+		log.Fatalf("Could not list s3://%s/%s: %v", *comprehendBucketName, *prefix, err)
+	}
+	keys := []string{}
+	skipped := 0
+	for _, object := range objects {
+		if !strings.HasSuffix(*object, "/"+search.ComprehendOutputFileName) {
+			continue
+		}
+		if r.checkpoint.Completed[*object] {
+			skipped++
+			continue
+		}
+		keys = append(keys, *object)
+	}
+	log.Printf("Rebuilding %d documents, %d already completed \n", len(keys), skipped)
+
+	r.run(keys, *concurrency)
+
+	summary, _ := json.MarshalIndent(map[string]interface{}{
+		"dryRun":    *dryRun,
+		"documents": r.documents,
+		"records":   r.records,
+		"skipped":   skipped,
+		"failed":    r.checkpoint.Failed,
+	}, "", "  ")
+	fmt.Println(string(summary))
+	if len(r.checkpoint.Failed) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/dreamspider42/document-processing-pipeline/src/awshelper"
+	"github.com/dreamspider42/document-processing-pipeline/src/search"
+)
+
+// Replays search records parked in the dead letter prefix by the comprehend processor.
+// Usage: esReplayDeadLetters [-endpoint <domain endpoint>] [-index <name>] [-auth <mode>] [-bucket <bucket>] [-dead-letter-prefix <prefix>] [-prefix <index>/<yyyy>/<mm>/<dd>]
+func main() {
+	esConfig, err := awshelper.ESConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	esConfig.RegisterFlags(flag.CommandLine)
+	bucket := flag.String("bucket", os.Getenv("TARGET_COMPREHEND_BUCKET"), "Bucket holding the dead letter records")
+	deadLetterPrefix := flag.String("dead-letter-prefix", "es-dead-letter", "Prefix the comprehend processor parks records under")
+	prefix := flag.String("prefix", "", "Only replay records under this prefix, relative to the dead letter prefix")
+	flag.Parse()
+
+	if esConfig.Endpoint == "" || esConfig.Index == "" || *bucket == "" {
+		log.Fatal("Missing -endpoint, -index or -bucket flag (or TARGET_ES_CLUSTER, ES_CLUSTER_INDEX, TARGET_COMPREHEND_BUCKET environment variables).")
+	}
+
+	eshelper, err := awshelper.ConnectESHelper(esConfig)
+	if err != nil {
+		log.Fatalf("Could not connect to the search index: %v", err)
+	}
+	err = eshelper.ManageIndex(search.IndexTemplate(esConfig.Index))
+	if err != nil {
+		log.Fatalf("Could not prepare the %s index: %v", esConfig.Index, err)
+	}
+	eshelper.DeadLetter = &awshelper.ESDeadLetter{
+		S3:         &awshelper.S3Helper{S3Client: s3.New(awshelper.NewAWSSession())},
+		BucketName: *bucket,
+		Prefix:     *deadLetterPrefix,
+	}
+
+	result, err := eshelper.ReplayDeadLetters(*prefix)
+	if result != nil {
+		output, _ := json.MarshalIndent(result, "", "  ")
+		fmt.Println(string(output))
+	}
+	if err != nil {
+		log.Fatalf("Replay finished with errors: %v", err)
+	}
+}
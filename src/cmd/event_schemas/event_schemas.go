@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
+)
+
+// Generates the JSON Schema documents of the metadata events from their Go types.
+// Usage: eventSchemas [-out <directory>]
+func main() {
+	out := flag.String("out", "schemas", "Directory to write the schema documents to")
+	flag.Parse()
+
+	err := os.MkdirAll(*out, 0755)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, metadataType := range metadata.EventTypes() {
+		schema, err := metadata.JSONSchema(metadataType)
+		if err != nil {
+			log.Fatal(err)
+		}
+		document, err := json.MarshalIndent(schema, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		path := filepath.Join(*out, metadata.SchemaFileName(metadataType))
+		err = os.WriteFile(path, append(document, '\n'), 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Wrote %s \n", path)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/dreamspider42/document-processing-pipeline/src/datastores"
+	"github.com/dreamspider42/document-processing-pipeline/src/lineage"
+)
+
+// Export formats
+const (
+	formatOpenLineage = "openlineage"
+	formatProv        = "prov"
+)
+
+// Parse an optional RFC 3339 time flag
+func parseTime(name string, value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		log.Fatalf("Invalid -%s time %q, expected RFC 3339: %v", name, value, err)
+	}
+	return t
+}
+
+func main() {
+	lineageTable := flag.String("table", os.Getenv("LINEAGE_TABLE"), "Document lineage table")
+	documentId := flag.String("document", "", "Document id, every document if empty")
+	from := flag.String("from", "", "Only export records from this RFC 3339 time")
+	to := flag.String("to", "", "Only export records up to this RFC 3339 time")
+	format := flag.String("format", formatOpenLineage, "Export format: openlineage (newline delimited run events) or prov (a PROV-JSON document)")
+	jobNamespace := flag.String("namespace", os.Getenv("OPENLINEAGE_NAMESPACE"), "Namespace of the OpenLineage jobs")
+	output := flag.String("out", "", "File to write the export to, stdout if empty")
+	flag.Parse()
+
+	if *lineageTable == "" {
+		log.Fatal("Missing -table flag (or LINEAGE_TABLE environment variable).")
+	}
+	if *documentId == "" && *from == "" && *to == "" {
+		log.Fatal("Missing -document flag or -from/-to time range.")
+	}
+	if *format != formatOpenLineage && *format != formatProv {
+		log.Fatalf("Unknown format %q, expected %s or %s.", *format, formatOpenLineage, formatProv)
+	}
+	if *format == formatOpenLineage && *jobNamespace == "" {
+		log.Fatal("Missing -namespace flag (or OPENLINEAGE_NAMESPACE environment variable).")
+	}
+	fromTime, toTime := parseTime("from", *from), parseTime("to", *to)
+
+	lineageStore := datastores.NewLineageStore(*lineageTable, "")
+	var items []datastores.LineageItem
+	var err error
+	if *documentId != "" {
+		items, err = lineageStore.QueryLineage(*documentId)
+	} else {
+		items, err = lineageStore.ScanLineage(fromTime, toTime)
+	}
+	if err != nil {
+		log.Fatalf("Could not read the lineage records: %v", err)
+	}
+
+	// Apply the time range to the records of a document
+	if *documentId != "" && (!fromTime.IsZero() || !toTime.IsZero()) {
+		filter := lineage.Filter{From: fromTime, To: toTime}
+		selected := []datastores.LineageItem{}
+		for _, item := range items {
+			if filter.Includes(item) {
+				selected = append(selected, item)
+			}
+		}
+		items = selected
+	}
+	log.Printf("Exporting %d lineage records as %s \n", len(items), *format)
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.Fatalf("Could not create %s: %v", *output, err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if *format == formatOpenLineage {
+		err = lineage.WriteOpenLineage(w, items, *jobNamespace)
+	} else {
+		var doc *lineage.ProvDocument
+		doc, err = lineage.ToProv(items)
+		if err == nil {
+			err = doc.Write(w)
+		}
+	}
+	if err != nil {
+		log.Fatalf("Could not export the lineage records: %v", err)
+	}
+}
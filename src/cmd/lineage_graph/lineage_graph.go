@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dreamspider42/document-processing-pipeline/src/datastores"
+	"github.com/dreamspider42/document-processing-pipeline/src/lineage"
+)
+
+// Parse an optional RFC 3339 time flag
+func parseTime(name string, value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		log.Fatalf("Invalid -%s time %q, expected RFC 3339: %v", name, value, err)
+	}
+	return t
+}
+
+func main() {
+	lineageTable := flag.String("table", os.Getenv("LINEAGE_TABLE"), "Document lineage table")
+	lineageIndex := flag.String("index", os.Getenv("LINEAGE_INDEX"), "Index of the lineage table by document signature")
+	documentId := flag.String("document", "", "Document id")
+	from := flag.String("from", "", "Only include events from this RFC 3339 time")
+	to := flag.String("to", "", "Only include events up to this RFC 3339 time")
+	actors := flag.String("actor", "", "Comma separated callerIds to include: ARNs, principal ids or Lambda function names")
+	format := flag.String("format", lineage.FormatJSON, "Output format, json or dot")
+	output := flag.String("out", "", "File to write the graph to, stdout if empty")
+	textractBucketName := flag.String("textract-bucket", os.Getenv("TEXTRACT_RESULTS_BUCKET_NAME"), "Bucket of the Textract outputs")
+	comprehendBucketName := flag.String("comprehend-bucket", os.Getenv("TARGET_COMPREHEND_BUCKET"), "Bucket of the comprehend outputs")
+	flag.Parse()
+
+	if *lineageTable == "" || *documentId == "" {
+		log.Fatal("Missing -table (or LINEAGE_TABLE environment variable) or -document flag.")
+	}
+
+	opts := lineage.Options{
+		Filter: lineage.Filter{
+			From: parseTime("from", *from),
+			To:   parseTime("to", *to),
+		},
+		BucketKinds: map[string]string{},
+	}
+	if *actors != "" {
+		opts.Filter.Actors = strings.Split(*actors, ",")
+	}
+	if *textractBucketName != "" {
+		opts.BucketKinds[*textractBucketName] = lineage.KindTextract
+	}
+	if *comprehendBucketName != "" {
+		opts.BucketKinds[*comprehendBucketName] = lineage.KindComprehend
+	}
+
+	lineageStore := datastores.NewLineageStore(*lineageTable, *lineageIndex)
+	items, err := lineageStore.QueryLineage(*documentId)
+	if err != nil {
+		log.Fatalf("Could not read the lineage of document %s: %v", *documentId, err)
+	}
+	if len(items) == 0 {
+		log.Printf("Document %s has no lineage records \n", *documentId)
+	}
+	graph, err := lineage.Build(*documentId, items, opts)
+	if err != nil {
+		log.Fatalf("Could not build the lineage graph: %v", err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.Fatalf("Could not create %s: %v", *output, err)
+		}
+		defer file.Close()
+		w = file
+	}
+	err = graph.Write(w, *format)
+	if err != nil {
+		log.Fatalf("Could not write the lineage graph: %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/rand"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dreamspider42/document-processing-pipeline/src/analytics"
+	"github.com/dreamspider42/document-processing-pipeline/src/datastores"
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
+)
+
+// Parse an optional RFC 3339 time flag
+func parseTime(name string, value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		log.Fatalf("Invalid -%s time %q, expected RFC 3339: %v", name, value, err)
+	}
+	return t
+}
+
+// Parse latency targets such as SYNC_PROCESS_COMPREHEND=30s,END_TO_END=5m
+func parseSLA(value string) map[string]time.Duration {
+	sla := map[string]time.Duration{}
+	if value == "" {
+		return sla
+	}
+	for _, target := range strings.Split(value, ",") {
+		stage, duration, ok := strings.Cut(target, "=")
+		d, err := time.ParseDuration(duration)
+		if !ok || err != nil || d <= 0 {
+			log.Fatalf("Invalid -sla target %q, expected STAGE=duration", target)
+		}
+		sla[stage] = d
+	}
+	return sla
+}
+
+// Read the documents updated since a time through the status index
+func loadDocuments(store *datastores.PipelineOperationsStore, since time.Time) []datastores.PipelineOperationsItem {
+	items := []datastores.PipelineOperationsItem{}
+	for _, status := range []string{metadata.StatusInProgress, metadata.StatusSucceeded, metadata.StatusFailed} {
+		nextToken := ""
+		for {
+			page, err := store.ListByStatus(status, datastores.OperationsFilter{Since: since}, 100, nextToken)
+			if err != nil {
+				log.Fatalf("Could not list the %s documents: %v", status, err)
+			}
+			items = append(items, page.Documents...)
+			if page.NextToken == "" {
+				break
+			}
+			nextToken = page.NextToken
+		}
+	}
+	return items
+}
+
+func main() {
+	opsTable := flag.String("table", os.Getenv("PIPELINE_OPS_TABLE"), "Pipeline operations table")
+	registryTable := flag.String("registry", os.Getenv("REGISTRY_TABLE"), "Document registry table to read the document classes from, none if empty")
+	from := flag.String("from", "", "Only report documents started from this RFC 3339 time, 24 hours ago if empty")
+	to := flag.String("to", "", "Only report documents started up to this RFC 3339 time")
+	sla := flag.String("sla", "", "Comma separated latency targets, such as SYNC_PROCESS_COMPREHEND=30s,END_TO_END=5m")
+	format := flag.String("format", analytics.FormatJSON, "Report format, json or csv")
+	output := flag.String("out", "", "File to write the report to, stdout if empty")
+	flag.Parse()
+
+	if *opsTable == "" {
+		log.Fatal("Missing -table flag (or PIPELINE_OPS_TABLE environment variable).")
+	}
+	if *format != analytics.FormatJSON && *format != analytics.FormatCSV {
+		log.Fatalf("Unknown format %q, expected %s or %s.", *format, analytics.FormatJSON, analytics.FormatCSV)
+	}
+	opts := analytics.Options{From: parseTime("from", *from), To: parseTime("to", *to), SLA: parseSLA(*sla)}
+	if opts.From.IsZero() {
+		opts.From = time.Now().Add(-24 * time.Hour)
+	}
+
+	// Page tokens never leave this process, so any key signs them
+	pageTokenKey := make([]byte, 32)
+	_, err := rand.Read(pageTokenKey)
+	if err != nil {
+		log.Fatalf("Could not generate a page token key: %v", err)
+	}
+	opsStore := datastores.NewPipelineOperationsStore(*opsTable).WithPageTokenKey(pageTokenKey)
+
+	// Documents started in the window were last updated after it opened
+	items := loadDocuments(opsStore, opts.From)
+	log.Printf("Read %d documents updated since %s \n", len(items), metadata.FormatTimestamp(opts.From))
+
+	var registryStore *datastores.DocumentRegistryStore
+	if *registryTable != "" {
+		registryStore = datastores.NewDocumentRegistryStore(*registryTable)
+	}
+	timings := []*analytics.DocumentTimings{}
+	for _, item := range items {
+		d, err := analytics.Timings(item)
+		if err != nil {
+			log.Printf("Skipping document %s: %v \n", item.DocumentId, err)
+			continue
+		}
+		if registryStore != nil {
+			registered, err := registryStore.GetDocument(item.DocumentId)
+			if err != nil {
+				log.Fatalf("Could not read the registry record of document %s: %v", item.DocumentId, err)
+			}
+			if registered != nil {
+				d.Class = registered.DocumentClass
+			}
+		}
+		timings = append(timings, d)
+	}
+	report := analytics.NewReport(timings, opts)
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.Fatalf("Could not create %s: %v", *output, err)
+		}
+		defer file.Close()
+		w = file
+	}
+	err = report.Write(w, *format)
+	if err != nil {
+		log.Fatalf("Could not write the report: %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dreamspider42/document-processing-pipeline/src/datastores"
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
+	"github.com/dreamspider42/document-processing-pipeline/src/webhooks"
+	"github.com/google/uuid"
+)
+
+const usage = `Manages the status webhooks of tenants.
+Usage:
+  webhooks register -tenant <id> -url <url> [-statuses SUCCEEDED,FAILED] [-secret <secret>]
+  webhooks list -tenant <id>
+  webhooks remove -tenant <id> -endpoint <id>
+  webhooks deliveries -document <id>
+  webhooks redeliver (-delivery <id> | -document <id>)
+Tables are read from WEBHOOK_ENDPOINTS_TABLE, WEBHOOK_DELIVERIES_TABLE and WEBHOOK_DELIVERIES_INDEX, or the -endpoints-table, -deliveries-table and -deliveries-index flags.`
+
+// Print a value as indented JSON
+func printJSON(v interface{}) {
+	output, _ := json.MarshalIndent(v, "", "  ")
+	fmt.Println(string(output))
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		log.Fatal(usage)
+	}
+	command := os.Args[1]
+
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	endpointsTable := flags.String("endpoints-table", os.Getenv("WEBHOOK_ENDPOINTS_TABLE"), "Webhook endpoints table")
+	deliveriesTable := flags.String("deliveries-table", os.Getenv("WEBHOOK_DELIVERIES_TABLE"), "Webhook deliveries table")
+	deliveriesIndex := flags.String("deliveries-index", os.Getenv("WEBHOOK_DELIVERIES_INDEX"), "Index of the deliveries by document")
+	tenantId := flags.String("tenant", "", "Tenant id, the owner in the document metadata")
+	url := flags.String("url", "", "Endpoint URL")
+	statuses := flags.String("statuses", "", "Comma separated statuses to send, SUCCEEDED and FAILED if empty")
+	secret := flags.String("secret", "", "Signing secret, generated if empty")
+	endpointId := flags.String("endpoint", "", "Endpoint id")
+	documentId := flags.String("document", "", "Document id")
+	deliveryId := flags.String("delivery", "", "Delivery id")
+	flags.Parse(os.Args[2:])
+
+	if *endpointsTable == "" || *deliveriesTable == "" || *deliveriesIndex == "" {
+		log.Fatal("Missing -endpoints-table, -deliveries-table or -deliveries-index flag (or WEBHOOK_ENDPOINTS_TABLE, WEBHOOK_DELIVERIES_TABLE, WEBHOOK_DELIVERIES_INDEX environment variables).")
+	}
+	endpoints := datastores.NewWebhookEndpointStore(*endpointsTable)
+	deliveries := datastores.NewWebhookDeliveryStore(*deliveriesTable, *deliveriesIndex)
+	notifier := webhooks.NewNotifier(endpoints, deliveries, webhooks.NewDispatcher())
+
+	switch command {
+	case "register":
+		if *tenantId == "" || *url == "" {
+			log.Fatal("Missing -tenant or -url flag.")
+		}
+		endpoint := datastores.WebhookEndpointItem{
+			TenantId:   *tenantId,
+			EndpointId: uuid.NewString(),
+			Url:        *url,
+			Secret:     *secret,
+			Enabled:    true,
+			CreatedAt:  metadata.FormatTimestamp(time.Now()),
+		}
+		if *statuses != "" {
+			endpoint.Statuses = strings.Split(*statuses, ",")
+		}
+		if endpoint.Secret == "" {
+			generated, err := webhooks.NewSecret()
+			if err != nil {
+				log.Fatalf("Could not generate a secret: %v", err)
+			}
+			endpoint.Secret = generated
+		}
+		err := endpoints.PutEndpoint(endpoint)
+		if err != nil {
+			log.Fatalf("Could not register the endpoint: %v", err)
+		}
+		printJSON(endpoint)
+	case "list":
+		if *tenantId == "" {
+			log.Fatal("Missing -tenant flag.")
+		}
+		items, err := endpoints.ListEndpoints(*tenantId)
+		if err != nil {
+			log.Fatalf("Could not list the endpoints: %v", err)
+		}
+		for i := range items {
+			items[i].Secret = ""
+		}
+		printJSON(items)
+	case "remove":
+		if *tenantId == "" || *endpointId == "" {
+			log.Fatal("Missing -tenant or -endpoint flag.")
+		}
+		err := endpoints.DeleteEndpoint(*tenantId, *endpointId)
+		if err != nil {
+			log.Fatalf("Could not remove the endpoint: %v", err)
+		}
+	case "deliveries":
+		if *documentId == "" {
+			log.Fatal("Missing -document flag.")
+		}
+		items, err := deliveries.ListDocumentDeliveries(*documentId)
+		if err != nil {
+			log.Fatalf("Could not list the deliveries: %v", err)
+		}
+		printJSON(items)
+	case "redeliver":
+		if *deliveryId != "" {
+			delivery, err := notifier.Redeliver(*deliveryId)
+			if err != nil {
+				log.Fatalf("Could not redeliver %s: %v", *deliveryId, err)
+			}
+			printJSON(delivery)
+		} else if *documentId != "" {
+			redelivered, err := notifier.RedeliverFailed(*documentId)
+			printJSON(redelivered)
+			if err != nil {
+				log.Fatalf("Redelivery finished with errors: %v", err)
+			}
+		} else {
+			log.Fatal("Missing -delivery or -document flag.")
+		}
+	default:
+		log.Fatal(usage)
+	}
+}
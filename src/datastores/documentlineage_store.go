@@ -2,6 +2,7 @@ package datastores
 
 import (
 	"log"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -9,6 +10,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
 )
 
 // Represents the Lineage DynamoDB
@@ -18,6 +20,9 @@ type LineageStore struct {
 	DynamoDBClient   dynamodbiface.DynamoDBAPI
 }
 
+// Index of the Lineage records by the signature of their source
+const LineageSourceSignatureIndex = "SourceSignatureIndex"
+
 // Represents the Lineage DynamoDB Index
 type LineageIndex struct {
 	DocumentSignature string `json:"documentSignature"`
@@ -37,6 +42,8 @@ type LineageItem struct {
 	VersionId         string `json:"versionId"`
 	SourceFileName    string `json:"sourceFileName"`
 	SourceBucketName  string `json:"sourceBucketName"`
+	SourceSignature   string `json:"sourceSignature,omitempty"`
+	Stage             string `json:"stage,omitempty"`
 }
 
 // Create a new instance of the LineageStore
@@ -51,11 +58,10 @@ func NewLineageStore(lineageTableName, lineageIndexName string) *LineageStore {
 
 // Create or update a Lineage record
 func (ls *LineageStore) CreateLineage(item LineageItem) error {
-	documentSignature := "BUCKET:" + item.TargetBucketName + "@FILE:" + item.TargetFileName
-	if item.VersionId != "" {
-		documentSignature = documentSignature + "@VERSION:" + item.VersionId
+	item.DocumentSignature = documentSignatureOf(item.TargetBucketName, item.TargetFileName, item.VersionId)
+	if item.SourceBucketName != "" {
+		item.SourceSignature = documentSignatureOf(item.SourceBucketName, item.SourceFileName, "")
 	}
-	item.DocumentSignature = documentSignature
 
 	av, err := dynamodbattribute.MarshalMap(item)
 	if err != nil {
@@ -87,12 +93,18 @@ func (ls *LineageStore) CreateLineage(item LineageItem) error {
 	return err
 }
 
-// Find a Lineage record by documentId
-func (ls *LineageStore) QueryDocumentId(targetBucketName string, targetFileName string, versionId string) (string, error) {
-	documentSignature := "BUCKET:" + targetBucketName + "@FILE:" + targetFileName
+// Signature of an object, the key of the lineage indexes
+func documentSignatureOf(bucketName string, fileName string, versionId string) string {
+	documentSignature := "BUCKET:" + bucketName + "@FILE:" + fileName
 	if versionId != "" {
 		documentSignature = documentSignature + "@VERSION:" + versionId
 	}
+	return documentSignature
+}
+
+// Find a Lineage record by documentId
+func (ls *LineageStore) QueryDocumentId(targetBucketName string, targetFileName string, versionId string) (string, error) {
+	documentSignature := documentSignatureOf(targetBucketName, targetFileName, versionId)
 
 	tableName := aws.String(ls.LineageTableName)
 	indexName := aws.String(ls.LineageIndexName)
@@ -125,3 +137,165 @@ func (ls *LineageStore) QueryDocumentId(targetBucketName string, targetFileName
 	}
 	return "", nil
 }
+
+// List the Lineage records of a documentId in time order
+func (ls *LineageStore) QueryLineage(documentId string) ([]LineageItem, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(ls.LineageTableName),
+		KeyConditionExpression: aws.String("documentId = :documentId"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":documentId": {S: aws.String(documentId)},
+		},
+	}
+
+	items := []LineageItem{}
+	var unmarshalErr error
+	err := ls.DynamoDBClient.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		pageItems := []LineageItem{}
+		unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &pageItems)
+		if unmarshalErr != nil {
+			return false
+		}
+		items = append(items, pageItems...)
+		return true
+	})
+	if err != nil {
+		logDynamoError(err)
+		return nil, err
+	}
+	if unmarshalErr != nil {
+		log.Println("Got error unmarshalling:")
+		log.Println(unmarshalErr.Error())
+		return nil, unmarshalErr
+	}
+	return items, nil
+}
+
+// List the Lineage records of every document written from `from` up to `to`. Zero times leave that end of the
+// range open. The whole table is scanned, since the records are only keyed by documentId.
+func (ls *LineageStore) ScanLineage(from time.Time, to time.Time) ([]LineageItem, error) {
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(ls.LineageTableName),
+	}
+
+	items := []LineageItem{}
+	var unmarshalErr error
+	err := ls.DynamoDBClient.ScanPages(input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		pageItems := []LineageItem{}
+		unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &pageItems)
+		if unmarshalErr != nil {
+			return false
+		}
+		// Compare parsed times, as legacy timestamps do not sort as strings
+		for _, item := range pageItems {
+			timestamp, err := metadata.ParseTimestamp(item.Timestamp)
+			if err != nil || (!from.IsZero() && timestamp.Before(from)) || (!to.IsZero() && timestamp.After(to)) {
+				continue
+			}
+			items = append(items, item)
+		}
+		return true
+	})
+	if err != nil {
+		logDynamoError(err)
+		return nil, err
+	}
+	if unmarshalErr != nil {
+		log.Println("Got error unmarshalling:")
+		log.Println(unmarshalErr.Error())
+		return nil, unmarshalErr
+	}
+	return items, nil
+}
+
+// An artifact derived from a source object, directly or through other artifacts
+type DerivedArtifact struct {
+	LineageItem
+	// Derivation steps from the source, 1 for artifacts made from the source itself
+	Depth int `json:"depth"`
+}
+
+// List every artifact derived from an object, following derived artifacts to the ones made from them in turn.
+// A versionId limits the artifacts to those of the document of that version; records written before the
+// SourceSignatureIndex existed are not found.
+func (ls *LineageStore) QueryDerivedArtifacts(sourceBucketName string, sourceFileName string, versionId string) ([]DerivedArtifact, error) {
+	documentId := ""
+	if versionId != "" {
+		var err error
+		documentId, err = ls.QueryDocumentId(sourceBucketName, sourceFileName, versionId)
+		if err != nil {
+			return nil, err
+		}
+		if documentId == "" {
+			log.Printf("No document of %s/%s version %s \n", sourceBucketName, sourceFileName, versionId)
+			return []DerivedArtifact{}, nil
+		}
+	}
+
+	type source struct {
+		signature string
+		depth     int
+	}
+	artifacts := []DerivedArtifact{}
+	sourceSignature := documentSignatureOf(sourceBucketName, sourceFileName, "")
+	queue := []source{{signature: sourceSignature, depth: 1}}
+	visited := map[string]bool{sourceSignature: true}
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+
+		items, err := ls.queryBySource(next.signature)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if documentId != "" && item.DocumentId != documentId {
+				continue
+			}
+			artifacts = append(artifacts, DerivedArtifact{LineageItem: item, Depth: next.depth})
+
+			// Index records are not the source of anything
+			target := documentSignatureOf(item.TargetBucketName, item.TargetFileName, "")
+			if item.S3Event == metadata.S3EventIndexWritten || visited[target] {
+				continue
+			}
+			visited[target] = true
+			queue = append(queue, source{signature: target, depth: next.depth + 1})
+		}
+	}
+	return artifacts, nil
+}
+
+// List the Lineage records of the artifacts made from a source signature, in time order
+func (ls *LineageStore) queryBySource(sourceSignature string) ([]LineageItem, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(ls.LineageTableName),
+		IndexName:              aws.String(LineageSourceSignatureIndex),
+		KeyConditionExpression: aws.String("sourceSignature = :sourceSignature"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":sourceSignature": {S: aws.String(sourceSignature)},
+		},
+	}
+
+	items := []LineageItem{}
+	var unmarshalErr error
+	err := ls.DynamoDBClient.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		pageItems := []LineageItem{}
+		unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &pageItems)
+		if unmarshalErr != nil {
+			return false
+		}
+		items = append(items, pageItems...)
+		return true
+	})
+	if err != nil {
+		logDynamoError(err)
+		return nil, err
+	}
+	if unmarshalErr != nil {
+		log.Println("Got error unmarshalling:")
+		log.Println(unmarshalErr.Error())
+		return nil, unmarshalErr
+	}
+	return items, nil
+}
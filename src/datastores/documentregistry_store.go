@@ -1,7 +1,10 @@
 package datastores
 
 import (
+	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -9,14 +12,32 @@ import (
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
 )
 
 // Represents the Document Registry DynamoDB
 type DocumentRegistryStore struct {
     registryTableName string
     dynamoDB          dynamodbiface.DynamoDBAPI
+    // Secret signing the page tokens of the list queries
+    pageTokenKey []byte
 }
 
+// Secondary indexes of the Document Registry, each sorted by timestamp
+const (
+    RegistryBucketIndex           = "BucketIndex"
+    RegistryOwnerIndex            = "OwnerIndex"
+    RegistryClassIndex            = "ClassIndex"
+    RegistryRegistrationDateIndex = "RegistrationDateIndex"
+    RegistryLogicalDocumentIndex  = "LogicalDocumentIndex"
+)
+
+// Layout of the registration date partitions of the RegistrationDateIndex
+const registrationDateLayout = "2006-01-02"
+
+// Most registration date partitions a time range page queries before returning a token for the next one
+const maxDaysPerPage = 31
+
 // Represents a Document Registry record
 type DocumentRegistryItem struct {
     DocumentId          string `json:"documentId"`
@@ -27,6 +48,20 @@ type DocumentRegistryItem struct {
     DocumentMetadata    map[string]interface{} `json:"documentMetadata"`
     Timestamp           string `json:"timestamp"`
     DocumentVersion     *string `json:"documentVersion"`
+    // Copied from the document metadata and timestamp for the secondary indexes
+    DocumentOwner       string `json:"documentOwner,omitempty"`
+    DocumentClass       string `json:"documentClass,omitempty"`
+    RegistrationDate    string `json:"registrationDate,omitempty"`
+    // Document this record is a revision of, shared by the versions and overwrites of the object
+    LogicalDocumentId   string `json:"logicalDocumentId,omitempty"`
+    // Sequencer of the S3 event of the upload, empty for documents registered before it was recorded
+    Sequencer           string `json:"sequencer,omitempty"`
+}
+
+// A page of Document Registry records
+type DocumentRegistryList struct {
+    Documents []DocumentRegistryItem `json:"items"`
+    NextToken string                 `json:"nextToken"`
 }
 
 // Create a new instance of the DocumentRegistryStore
@@ -41,11 +76,26 @@ func NewDocumentRegistryStore(documentRegistryName string) *DocumentRegistryStor
     return &DocumentRegistryStore{
         registryTableName: documentRegistryName,
         dynamoDB:          dynamodb.New(sess),
+        pageTokenKey:      pageTokenKeyFromEnv(),
     }
 }
 
-// Create or update a Document Registry record
+// Sign the page tokens of the list queries with a secret key instead of the one in PAGE_TOKEN_KEY, the same for
+// every instance serving the same callers
+func (s *DocumentRegistryStore) WithPageTokenKey(key []byte) *DocumentRegistryStore {
+    s.pageTokenKey = key
+    return s
+}
+
+// Create a Document Registry record. Registering a document that is already registered succeeds without changing it.
 func (s *DocumentRegistryStore) RegisterDocument(item DocumentRegistryItem) error {
+    item.DocumentOwner, _ = item.DocumentMetadata["owner"].(string)
+    item.DocumentClass, _ = item.DocumentMetadata["class"].(string)
+    item.LogicalDocumentId = logicalDocumentIdOf(item)
+    if timestamp, err := metadata.ParseTimestamp(item.Timestamp); err == nil {
+        item.RegistrationDate = timestamp.UTC().Format(registrationDateLayout)
+    }
+
     av, err := dynamodbattribute.MarshalMap(item)
 	if err != nil {
 		log.Println("Got error marshalling map:")
@@ -63,6 +113,11 @@ func (s *DocumentRegistryStore) RegisterDocument(item DocumentRegistryItem) erro
 	// Handle DynamoDB error codes
 	if err != nil {
 		if aerr, ok := err.(awserr.Error); ok {
+			// Registering a document again, as redelivered events do, leaves the first registration in place
+			if aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+				log.Printf("Document %s is already registered \n", item.DocumentId)
+				return nil
+			}
 			// Print the dynamo code and error message
 			log.Println(aerr.Code(), aerr.Error())
 		} else {
@@ -72,4 +127,196 @@ func (s *DocumentRegistryStore) RegisterDocument(item DocumentRegistryItem) erro
 		}
 	}
     return err
-}
\ No newline at end of file
+}
+
+// Get a Document Registry record, or nil if the document is not registered
+func (s *DocumentRegistryStore) GetDocument(documentId string) (*DocumentRegistryItem, error) {
+	result, err := s.dynamoDB.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(s.registryTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"documentId": {
+				S: aws.String(documentId),
+			},
+		},
+	})
+
+	// Handle DynamoDB error codes
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			// Print the dynamo code and error message
+			log.Println(aerr.Code(), aerr.Error())
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			log.Println(err.Error())
+		}
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	item := DocumentRegistryItem{}
+	err = dynamodbattribute.UnmarshalMap(result.Item, &item)
+	if err != nil {
+		log.Println("Got error unmarshalling:")
+		log.Println(err.Error())
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+// List the documents registered from a bucket, newest first
+func (s *DocumentRegistryStore) ListByBucket(bucketName string, limit int, nextToken string) (*DocumentRegistryList, error) {
+	return s.listByIndex(RegistryBucketIndex, "bucketName", bucketName, limit, nextToken)
+}
+
+// List the documents of an owner, as set in their document metadata, newest first
+func (s *DocumentRegistryStore) ListByOwner(owner string, limit int, nextToken string) (*DocumentRegistryList, error) {
+	return s.listByIndex(RegistryOwnerIndex, "documentOwner", owner, limit, nextToken)
+}
+
+// List the documents of a class, as set in their document metadata, newest first
+func (s *DocumentRegistryStore) ListByClass(class string, limit int, nextToken string) (*DocumentRegistryList, error) {
+	return s.listByIndex(RegistryClassIndex, "documentClass", class, limit, nextToken)
+}
+
+// List the documents registered from `from` up to `to`, newest first. The query runs over the registration date
+// partitions of the range, at most maxDaysPerPage of them per page, so a page may hold fewer documents than the
+// limit, or none, while more are left.
+func (s *DocumentRegistryStore) ListByTimeRange(from time.Time, to time.Time, limit int, nextToken string) (*DocumentRegistryList, error) {
+	if to.Before(from) {
+		return nil, fmt.Errorf("time range ends at %s before it starts at %s", to, from)
+	}
+	if len(s.pageTokenKey) == 0 {
+		return nil, ErrNoPageTokenKey
+	}
+
+	// Tokens are bound to the time range of the query that returned them
+	query := strings.Join([]string{RegistryRegistrationDateIndex, timeBound(from), timeBound(to)}, "|")
+	token, err := decodeSignedPageToken(s.pageTokenKey, query, nextToken)
+	if err != nil {
+		return nil, err
+	}
+
+	// Start with the latest day, or the day the previous page stopped in
+	firstDay := from.UTC().Format(registrationDateLayout)
+	day := to.UTC().Format(registrationDateLayout)
+	var startKey map[string]*dynamodb.AttributeValue
+	if token != nil {
+		if token.Partition < firstDay || token.Partition > day {
+			return nil, ErrInvalidPageToken
+		}
+		day, startKey = token.Partition, token.Key
+	}
+
+	list := &DocumentRegistryList{Documents: []DocumentRegistryItem{}}
+	size := pageSize(limit)
+	for days := 1; ; {
+		result, err := s.dynamoDB.Query(&dynamodb.QueryInput{
+			TableName:              aws.String(s.registryTableName),
+			IndexName:              aws.String(RegistryRegistrationDateIndex),
+			KeyConditionExpression: aws.String("registrationDate = :registrationDate AND #timestamp BETWEEN :from AND :to"),
+			ExpressionAttributeNames: map[string]*string{
+				"#timestamp": aws.String("timestamp"),
+			},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":registrationDate": {S: aws.String(day)},
+				":from":             {S: aws.String(metadata.FormatTimestamp(from.UTC()))},
+				":to":               {S: aws.String(metadata.FormatTimestamp(to.UTC()))},
+			},
+			ExclusiveStartKey: startKey,
+			ScanIndexForward:  aws.Bool(false),
+			Limit:             aws.Int64(size - int64(len(list.Documents))),
+		})
+		if err != nil {
+			logDynamoError(err)
+			return nil, err
+		}
+		items := []DocumentRegistryItem{}
+		err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &items)
+		if err != nil {
+			log.Println("Got error unmarshalling:")
+			log.Println(err.Error())
+			return nil, err
+		}
+		list.Documents = append(list.Documents, items...)
+
+		// Continue in the same day, in the previous day, or stop at the start of the range
+		startKey = result.LastEvaluatedKey
+		if startKey == nil {
+			if day == firstDay {
+				return list, nil
+			}
+			previous, _ := time.Parse(registrationDateLayout, day)
+			day = previous.AddDate(0, 0, -1).Format(registrationDateLayout)
+			days++
+		}
+		if int64(len(list.Documents)) >= size || days > maxDaysPerPage {
+			list.NextToken = encodeSignedPageToken(s.pageTokenKey, query, day, startKey)
+			return list, nil
+		}
+	}
+}
+
+// List the revisions of a logical document, newest first
+func (s *DocumentRegistryStore) ListRevisions(logicalDocumentId string, limit int, nextToken string) (*DocumentRegistryList, error) {
+	return s.listByIndex(RegistryLogicalDocumentIndex, "logicalDocumentId", logicalDocumentId, limit, nextToken)
+}
+
+// Logical document of a registry record: the one it was registered with, or else the one of its bucket and name
+func logicalDocumentIdOf(item DocumentRegistryItem) string {
+	if item.LogicalDocumentId != "" {
+		return item.LogicalDocumentId
+	}
+	return metadata.LogicalDocumentIdOf(item.BucketName, item.DocumentName)
+}
+
+// Query a page of an index sorted by timestamp, newest first
+func (s *DocumentRegistryStore) listByIndex(indexName string, keyName string, keyValue string, limit int, nextToken string) (*DocumentRegistryList, error) {
+	if len(s.pageTokenKey) == 0 {
+		return nil, ErrNoPageTokenKey
+	}
+
+	// Tokens are bound to the index and key of the query that returned them
+	query := strings.Join([]string{indexName, keyValue}, "|")
+	token, err := decodeSignedPageToken(s.pageTokenKey, query, nextToken)
+	if err != nil {
+		return nil, err
+	}
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(s.registryTableName),
+		IndexName:              aws.String(indexName),
+		KeyConditionExpression: aws.String("#key = :key"),
+		ExpressionAttributeNames: map[string]*string{
+			"#key": aws.String(keyName),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":key": {S: aws.String(keyValue)},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int64(pageSize(limit)),
+	}
+	if token != nil {
+		input.ExclusiveStartKey = token.Key
+	}
+
+	result, err := s.dynamoDB.Query(input)
+	if err != nil {
+		logDynamoError(err)
+		return nil, err
+	}
+
+	items := []DocumentRegistryItem{}
+	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &items)
+	if err != nil {
+		log.Println("Got error unmarshalling:")
+		log.Println(err.Error())
+		return nil, err
+	}
+	return &DocumentRegistryList{
+		Documents: items,
+		NextToken: encodeSignedPageToken(s.pageTokenKey, query, "", result.LastEvaluatedKey),
+	}, nil
+}
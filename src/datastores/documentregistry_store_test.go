@@ -0,0 +1,268 @@
+package datastores
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
+	"golang.org/x/exp/slices"
+)
+
+// Registry indexes keeping their records in memory, answering queries page by page like DynamoDB
+type fakeRegistryIndexes struct {
+	dynamodbiface.DynamoDBAPI
+	// Records of each partition key value, newest first
+	partitions map[string][]DocumentRegistryItem
+	// Partition key value of each query, in order
+	queried []string
+}
+
+func (f *fakeRegistryIndexes) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	values := input.ExpressionAttributeValues
+	partition := values[":key"]
+	if partition == nil {
+		partition = values[":registrationDate"]
+	}
+	f.queried = append(f.queried, aws.StringValue(partition.S))
+
+	items := []DocumentRegistryItem{}
+	for _, item := range f.partitions[aws.StringValue(partition.S)] {
+		if values[":from"] != nil && (item.Timestamp < aws.StringValue(values[":from"].S) || item.Timestamp > aws.StringValue(values[":to"].S)) {
+			continue
+		}
+		items = append(items, item)
+	}
+	if input.ExclusiveStartKey != nil {
+		start := slices.IndexFunc(items, func(item DocumentRegistryItem) bool {
+			return item.DocumentId == aws.StringValue(input.ExclusiveStartKey["documentId"].S)
+		})
+		items = items[start+1:]
+	}
+
+	output := &dynamodb.QueryOutput{}
+	if int64(len(items)) > aws.Int64Value(input.Limit) {
+		items = items[:aws.Int64Value(input.Limit)]
+		output.LastEvaluatedKey = map[string]*dynamodb.AttributeValue{"documentId": {S: aws.String(items[len(items)-1].DocumentId)}}
+	}
+	for _, item := range items {
+		attributes, err := dynamodbattribute.MarshalMap(item)
+		if err != nil {
+			return nil, err
+		}
+		output.Items = append(output.Items, attributes)
+	}
+	return output, nil
+}
+
+// Registry records of documents registered at the given times, by registration date, newest first
+func registeredAt(times map[string]time.Time) map[string][]DocumentRegistryItem {
+	partitions := map[string][]DocumentRegistryItem{}
+	for documentId, registered := range times {
+		day := registered.Format(registrationDateLayout)
+		partitions[day] = append(partitions[day], DocumentRegistryItem{
+			DocumentId:       documentId,
+			Timestamp:        metadata.FormatTimestamp(registered),
+			RegistrationDate: day,
+		})
+	}
+	for _, items := range partitions {
+		slices.SortFunc(items, func(a DocumentRegistryItem, b DocumentRegistryItem) bool { return a.Timestamp > b.Timestamp })
+	}
+	return partitions
+}
+
+// Document ids of every page of a list, and the number of pages
+func listAll(t *testing.T, list func(nextToken string) (*DocumentRegistryList, error)) ([]string, int) {
+	documentIds := []string{}
+	pages := 0
+	token := ""
+	for {
+		page, err := list(token)
+		if err != nil {
+			t.Fatalf("page %d error = %v", pages+1, err)
+		}
+		pages++
+		for _, document := range page.Documents {
+			documentIds = append(documentIds, document.DocumentId)
+		}
+		if page.NextToken == "" {
+			return documentIds, pages
+		}
+		if pages > 100 {
+			t.Fatal("list did not end")
+		}
+		token = page.NextToken
+	}
+}
+
+func TestListByTimeRange(t *testing.T) {
+	day := func(d int, hour int) time.Time { return time.Date(2024, 5, d, hour, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name      string
+		documents map[string]time.Time
+		from      time.Time
+		to        time.Time
+		limit     int
+		want      []string
+		wantPages int
+		// Most queries of a page
+		maxQueries int
+	}{
+		{
+			name:       "pages crossing day boundaries",
+			documents:  map[string]time.Time{"a": day(1, 8), "b": day(1, 9), "c": day(1, 10), "d": day(3, 8), "e": day(3, 9)},
+			from:       day(1, 0),
+			to:         day(3, 23),
+			limit:      2,
+			want:       []string{"e", "d", "c", "b", "a"},
+			wantPages:  3,
+			maxQueries: 3,
+		},
+		{
+			name:       "full page at the end of a day",
+			documents:  map[string]time.Time{"a": day(1, 8), "b": day(2, 8), "c": day(2, 9)},
+			from:       day(1, 0),
+			to:         day(2, 23),
+			limit:      2,
+			want:       []string{"c", "b", "a"},
+			wantPages:  2,
+			maxQueries: 2,
+		},
+		{
+			name:       "stops at the first day of the range",
+			documents:  map[string]time.Time{"before": time.Date(2024, 4, 30, 23, 0, 0, 0, time.UTC), "early": day(1, 8), "a": day(1, 13), "b": day(2, 8)},
+			from:       day(1, 12),
+			to:         day(2, 23),
+			limit:      10,
+			want:       []string{"b", "a"},
+			wantPages:  1,
+			maxQueries: 2,
+		},
+		{
+			name:       "single day",
+			documents:  map[string]time.Time{"a": day(1, 8), "b": day(1, 9), "late": day(1, 20)},
+			from:       day(1, 0),
+			to:         day(1, 12),
+			limit:      1,
+			want:       []string{"b", "a"},
+			wantPages:  2,
+			maxQueries: 1,
+		},
+		{
+			name:       "days per page capped",
+			documents:  map[string]time.Time{"a": day(1, 8), "b": time.Date(2024, 7, 29, 8, 0, 0, 0, time.UTC)},
+			from:       day(1, 0),
+			to:         time.Date(2024, 7, 29, 23, 0, 0, 0, time.UTC),
+			limit:      10,
+			want:       []string{"b", "a"},
+			wantPages:  3,
+			maxQueries: maxDaysPerPage,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			indexes := &fakeRegistryIndexes{partitions: registeredAt(tt.documents)}
+			store := (&DocumentRegistryStore{dynamoDB: indexes}).WithPageTokenKey([]byte("secret"))
+			queried := map[string]bool{}
+			got, pages := listAll(t, func(nextToken string) (*DocumentRegistryList, error) {
+				indexes.queried = nil
+				page, err := store.ListByTimeRange(tt.from, tt.to, tt.limit, nextToken)
+				if err == nil && len(page.Documents) > tt.limit {
+					t.Errorf("page of %d documents, limit %d", len(page.Documents), tt.limit)
+				}
+				if len(indexes.queried) > tt.maxQueries {
+					t.Errorf("page queried %d days, want at most %d", len(indexes.queried), tt.maxQueries)
+				}
+				for _, day := range indexes.queried {
+					queried[day] = true
+				}
+				return page, err
+			})
+
+			if !slices.Equal(got, tt.want) || pages != tt.wantPages {
+				t.Errorf("listed %v in %d pages, want %v in %d", got, pages, tt.want, tt.wantPages)
+			}
+			for day := range queried {
+				if day < tt.from.Format(registrationDateLayout) || day > tt.to.Format(registrationDateLayout) {
+					t.Errorf("queried %s outside of the range", day)
+				}
+			}
+		})
+	}
+}
+
+func TestListByTimeRangeToken(t *testing.T) {
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 5, 2, 23, 0, 0, 0, time.UTC)
+	indexes := &fakeRegistryIndexes{partitions: registeredAt(map[string]time.Time{
+		"a": from.Add(time.Hour),
+		"b": to.Add(-time.Hour),
+		"c": to.Add(-2 * time.Hour),
+	})}
+	store := (&DocumentRegistryStore{dynamoDB: indexes}).WithPageTokenKey([]byte("secret"))
+	page, err := store.ListByTimeRange(from, to, 1, "")
+	if err != nil || page.NextToken == "" {
+		t.Fatalf("ListByTimeRange() = %v, %v, want a page and a token", page, err)
+	}
+
+	tests := []struct {
+		name    string
+		store   *DocumentRegistryStore
+		from    time.Time
+		to      time.Time
+		wantErr error
+	}{
+		{name: "same range", store: store, from: from, to: to},
+		{name: "range starting later", store: store, from: from.Add(time.Hour), to: to, wantErr: ErrInvalidPageToken},
+		{name: "range ending earlier", store: store, from: from, to: to.Add(-time.Minute), wantErr: ErrInvalidPageToken},
+		{name: "another key", store: (&DocumentRegistryStore{dynamoDB: indexes}).WithPageTokenKey([]byte("other")), from: from, to: to, wantErr: ErrInvalidPageToken},
+		{name: "no key", store: &DocumentRegistryStore{dynamoDB: indexes}, from: from, to: to, wantErr: ErrNoPageTokenKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.store.ListByTimeRange(tt.from, tt.to, 1, page.NextToken)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ListByTimeRange() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestListByIndex(t *testing.T) {
+	indexes := &fakeRegistryIndexes{partitions: map[string][]DocumentRegistryItem{
+		"alice": {{DocumentId: "c", DocumentOwner: "alice"}, {DocumentId: "b", DocumentOwner: "alice"}, {DocumentId: "a", DocumentOwner: "alice"}},
+		"bob":   {{DocumentId: "d", DocumentOwner: "bob"}, {DocumentId: "e", DocumentOwner: "bob"}},
+	}}
+	store := (&DocumentRegistryStore{dynamoDB: indexes}).WithPageTokenKey([]byte("secret"))
+
+	got, pages := listAll(t, func(nextToken string) (*DocumentRegistryList, error) {
+		return store.ListByOwner("alice", 2, nextToken)
+	})
+	if !slices.Equal(got, []string{"c", "b", "a"}) || pages != 2 {
+		t.Errorf("listed %v in %d pages, want [c b a] in 2", got, pages)
+	}
+
+	page, err := store.ListByOwner("alice", 1, "")
+	if err != nil || page.NextToken == "" {
+		t.Fatalf("ListByOwner() = %v, %v, want a page and a token", page, err)
+	}
+	_, err = store.ListByOwner("bob", 1, page.NextToken)
+	if !errors.Is(err, ErrInvalidPageToken) {
+		t.Errorf("token of another owner: error = %v, want %v", err, ErrInvalidPageToken)
+	}
+	_, err = store.ListByClass("alice", 1, page.NextToken)
+	if !errors.Is(err, ErrInvalidPageToken) {
+		t.Errorf("token of another index: error = %v, want %v", err, ErrInvalidPageToken)
+	}
+	_, err = (&DocumentRegistryStore{dynamoDB: indexes}).ListByOwner("alice", 1, "")
+	if !errors.Is(err, ErrNoPageTokenKey) {
+		t.Errorf("store without a key: error = %v, want %v", err, ErrNoPageTokenKey)
+	}
+}
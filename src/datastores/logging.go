@@ -0,0 +1,17 @@
+package datastores
+
+import (
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// Log a DynamoDB error with its code when it has one
+func logDynamoError(err error) {
+	if aerr, ok := err.(awserr.Error); ok {
+		// Print the dynamo code and error message
+		log.Println(aerr.Code(), aerr.Error())
+	} else {
+		log.Println(err.Error())
+	}
+}
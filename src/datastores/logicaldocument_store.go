@@ -0,0 +1,121 @@
+package datastores
+
+import (
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
+)
+
+// Represents the Logical Documents DynamoDB: one record per bucket and key, pointing to its latest revision
+type LogicalDocumentStore struct {
+	logicalDocumentsTableName string
+	dynamoDB                  dynamodbiface.DynamoDBAPI
+}
+
+// Represents a Logical Document record
+type LogicalDocumentItem struct {
+	LogicalDocumentId string `json:"logicalDocumentId"`
+	BucketName        string `json:"bucketName"`
+	DocumentName      string `json:"documentName"`
+	// documentId of the latest revision in the Document Registry
+	LatestRevision  string `json:"latestRevision"`
+	LatestVersionId string `json:"latestVersionId,omitempty"`
+	// Ordered S3 event sequencer of the latest revision (metadata.OrderedSequencer)
+	LatestSequencer string `json:"latestSequencer,omitempty"`
+	LatestTimestamp string `json:"latestTimestamp"`
+	FirstRegistered string `json:"firstRegistered"`
+}
+
+// Create a new instance of the LogicalDocumentStore
+func NewLogicalDocumentStore(logicalDocumentsTableName string) *LogicalDocumentStore {
+	sess := session.Must(session.NewSession(
+		&aws.Config{
+			Region:     aws.String("us-east-1"),
+			MaxRetries: aws.Int(30),
+		},
+	))
+
+	return &LogicalDocumentStore{
+		logicalDocumentsTableName: logicalDocumentsTableName,
+		dynamoDB:                  dynamodb.New(sess),
+	}
+}
+
+// Record a registered revision of its logical document, creating the logical document on its first revision.
+// The latestRevision pointer only moves forward in the order of the S3 events of the object, so revisions
+// registered late or again leave it in place. Logical documents and revisions without a sequencer, registered
+// before it was recorded, are ordered by registration time instead.
+func (s *LogicalDocumentStore) RecordRevision(revision DocumentRegistryItem) error {
+	updateExpression := "SET bucketName = :bucketName, documentName = :documentName, latestRevision = :revision, " +
+		"latestTimestamp = :timestamp, firstRegistered = if_not_exists(firstRegistered, :timestamp)"
+	expressionAttributeValues := map[string]*dynamodb.AttributeValue{
+		":bucketName":   {S: aws.String(revision.BucketName)},
+		":documentName": {S: aws.String(revision.DocumentName)},
+		":revision":     {S: aws.String(revision.DocumentId)},
+		":timestamp":    {S: aws.String(revision.Timestamp)},
+	}
+	condition := "attribute_not_exists(latestSequencer) AND (attribute_not_exists(latestTimestamp) OR latestTimestamp <= :timestamp)"
+	if revision.Sequencer != "" {
+		updateExpression += ", latestSequencer = :sequencer"
+		expressionAttributeValues[":sequencer"] = &dynamodb.AttributeValue{S: aws.String(metadata.OrderedSequencer(revision.Sequencer))}
+		condition = "(" + condition + ") OR latestSequencer <= :sequencer"
+	}
+	if revision.DocumentVersion != nil && *revision.DocumentVersion != "" {
+		updateExpression += ", latestVersionId = :versionId"
+		expressionAttributeValues[":versionId"] = &dynamodb.AttributeValue{S: revision.DocumentVersion}
+	} else {
+		updateExpression += " REMOVE latestVersionId"
+	}
+
+	_, err := s.dynamoDB.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(s.logicalDocumentsTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"logicalDocumentId": {S: aws.String(logicalDocumentIdOf(revision))},
+		},
+		UpdateExpression:          aws.String(updateExpression),
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeValues: expressionAttributeValues,
+	})
+
+	// Handle DynamoDB error codes
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			log.Printf("Revision %s is older than the latest revision of %s \n", revision.DocumentId, logicalDocumentIdOf(revision))
+			return nil
+		}
+		logDynamoError(err)
+	}
+	return err
+}
+
+// Get a Logical Document record, or nil if there is none
+func (s *LogicalDocumentStore) GetLogicalDocument(logicalDocumentId string) (*LogicalDocumentItem, error) {
+	result, err := s.dynamoDB.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(s.logicalDocumentsTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"logicalDocumentId": {S: aws.String(logicalDocumentId)},
+		},
+	})
+	if err != nil {
+		logDynamoError(err)
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	item := LogicalDocumentItem{}
+	err = dynamodbattribute.UnmarshalMap(result.Item, &item)
+	if err != nil {
+		log.Println("Got error unmarshalling:")
+		log.Println(err.Error())
+		return nil, err
+	}
+	return &item, nil
+}
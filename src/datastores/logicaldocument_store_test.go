@@ -0,0 +1,195 @@
+package datastores
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
+)
+
+// Logical documents table holding one record, evaluating the conditions RecordRevision writes
+type fakeLogicalDocuments struct {
+	dynamodbiface.DynamoDBAPI
+	// String attributes of the record, nil before the first revision
+	item  map[string]string
+	input *dynamodb.UpdateItemInput
+	err   error
+}
+
+func (f *fakeLogicalDocuments) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	f.input = input
+	if f.err != nil {
+		return nil, f.err
+	}
+	values := map[string]string{}
+	for name, value := range input.ExpressionAttributeValues {
+		values[name] = aws.StringValue(value.S)
+	}
+	tokens := strings.Fields(strings.NewReplacer("(", " ( ", ")", " ) ").Replace(aws.StringValue(input.ConditionExpression)))
+	if !(&conditionParser{tokens: tokens, item: f.item, values: values}).or() {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+
+	if f.item == nil {
+		f.item = map[string]string{"firstRegistered": values[":timestamp"]}
+	}
+	f.item["latestRevision"] = values[":revision"]
+	f.item["latestTimestamp"] = values[":timestamp"]
+	if sequencer, ok := values[":sequencer"]; ok {
+		f.item["latestSequencer"] = sequencer
+	}
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+// Evaluates the condition expressions of RecordRevision: attribute_not_exists, <=, AND, OR and parentheses
+type conditionParser struct {
+	tokens []string
+	item   map[string]string
+	values map[string]string
+}
+
+func (p *conditionParser) next() string {
+	token := p.tokens[0]
+	p.tokens = p.tokens[1:]
+	return token
+}
+
+func (p *conditionParser) or() bool {
+	result := p.and()
+	for len(p.tokens) > 0 && p.tokens[0] == "OR" {
+		p.next()
+		result = p.and() || result
+	}
+	return result
+}
+
+func (p *conditionParser) and() bool {
+	result := p.operand()
+	for len(p.tokens) > 0 && p.tokens[0] == "AND" {
+		p.next()
+		result = p.operand() && result
+	}
+	return result
+}
+
+func (p *conditionParser) operand() bool {
+	switch token := p.next(); token {
+	case "(":
+		result := p.or()
+		p.next()
+		return result
+	case "attribute_not_exists":
+		p.next()
+		_, exists := p.item[p.next()]
+		p.next()
+		return !exists
+	default:
+		value, exists := p.item[token]
+		if operator := p.next(); operator != "<=" {
+			panic("unsupported operator " + operator)
+		}
+		return exists && value <= p.values[p.next()]
+	}
+}
+
+func TestRecordRevision(t *testing.T) {
+	const (
+		earlier = "2024-05-01T09:00:00Z"
+		latest  = "2024-05-01T10:00:00Z"
+		later   = "2024-05-01T11:00:00Z"
+	)
+	legacy := map[string]string{"latestRevision": "latest", "latestTimestamp": latest}
+	sequenced := map[string]string{"latestRevision": "latest", "latestTimestamp": latest, "latestSequencer": metadata.OrderedSequencer("0055AED6DCD90281E5")}
+
+	tests := []struct {
+		name      string
+		item      map[string]string
+		timestamp string
+		sequencer string
+		// Whether the revision becomes the latest one
+		wantLatest bool
+	}{
+		{name: "first revision", timestamp: later, sequencer: "0055AED6DCD90281E5", wantLatest: true},
+		{name: "first revision without a sequencer", timestamp: later, wantLatest: true},
+		{name: "no sequencer on either side, later", item: legacy, timestamp: later, wantLatest: true},
+		{name: "no sequencer on either side, registered again", item: legacy, timestamp: latest, wantLatest: true},
+		{name: "no sequencer on either side, earlier", item: legacy, timestamp: earlier},
+		{name: "sequencer of a revision after a legacy one", item: legacy, timestamp: later, sequencer: "0055AED6DCD90281E5", wantLatest: true},
+		{name: "record has a sequencer but the revision does not", item: sequenced, timestamp: later},
+		{name: "later sequencer", item: sequenced, timestamp: earlier, sequencer: "0055AED6DCD90281F0", wantLatest: true},
+		{name: "same sequencer redelivered", item: sequenced, timestamp: later, sequencer: "0055AED6DCD90281E5", wantLatest: true},
+		{name: "older sequencer", item: sequenced, timestamp: later, sequencer: "0055AED6DCD90281E4"},
+		{name: "older sequencer of another length", item: sequenced, timestamp: later, sequencer: "0055AED6DCD90281"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := &fakeLogicalDocuments{}
+			if tt.item != nil {
+				table.item = map[string]string{}
+				for name, value := range tt.item {
+					table.item[name] = value
+				}
+			}
+			store := &LogicalDocumentStore{logicalDocumentsTableName: "logical-documents", dynamoDB: table}
+			revision := DocumentRegistryItem{DocumentId: "revision", BucketName: "uploads", DocumentName: "invoice.pdf", Timestamp: tt.timestamp, Sequencer: tt.sequencer}
+
+			err := store.RecordRevision(revision)
+			if err != nil {
+				t.Fatalf("RecordRevision() error = %v", err)
+			}
+
+			if got := table.item["latestRevision"] == "revision"; got != tt.wantLatest {
+				t.Errorf("latest revision %s, want the revision to be the latest: %v", table.item["latestRevision"], tt.wantLatest)
+			}
+			key := aws.StringValue(table.input.Key["logicalDocumentId"].S)
+			if key != metadata.LogicalDocumentIdOf("uploads", "invoice.pdf") {
+				t.Errorf("updated logical document %s", key)
+			}
+			sequencer, ok := table.input.ExpressionAttributeValues[":sequencer"]
+			if tt.sequencer == "" && ok || tt.sequencer != "" && aws.StringValue(sequencer.S) != metadata.OrderedSequencer(tt.sequencer) {
+				t.Errorf("sequencer %v, want %q ordered", sequencer, tt.sequencer)
+			}
+		})
+	}
+}
+
+func TestRecordRevisionVersion(t *testing.T) {
+	tests := []struct {
+		name       string
+		version    *string
+		wantUpdate string
+	}{
+		{name: "versioned bucket", version: aws.String("v1"), wantUpdate: "latestVersionId = :versionId"},
+		{name: "unversioned bucket", wantUpdate: "REMOVE latestVersionId"},
+		{name: "empty version", version: aws.String(""), wantUpdate: "REMOVE latestVersionId"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := &fakeLogicalDocuments{}
+			store := &LogicalDocumentStore{dynamoDB: table}
+			err := store.RecordRevision(DocumentRegistryItem{DocumentId: "revision", Timestamp: "2024-05-01T10:00:00Z", DocumentVersion: tt.version})
+			if err != nil {
+				t.Fatalf("RecordRevision() error = %v", err)
+			}
+			if update := aws.StringValue(table.input.UpdateExpression); !strings.Contains(update, tt.wantUpdate) {
+				t.Errorf("update %q, want %q", update, tt.wantUpdate)
+			}
+		})
+	}
+}
+
+func TestRecordRevisionError(t *testing.T) {
+	throttled := awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "throttled", nil)
+	store := &LogicalDocumentStore{dynamoDB: &fakeLogicalDocuments{err: throttled}}
+	err := store.RecordRevision(DocumentRegistryItem{DocumentId: "revision"})
+	if !errors.Is(err, throttled) {
+		t.Errorf("RecordRevision() error = %v, want %v", err, throttled)
+	}
+}
@@ -0,0 +1,110 @@
+package datastores
+
+import (
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// How long relayed events are kept in the outbox, so that retried writes of the same events are recognised
+const MetadataOutboxRetention = 7 * 24 * time.Hour
+
+// Represents the Metadata Outbox DynamoDB, holding the metadata events waiting to be published
+type MetadataOutboxStore struct {
+	outboxTableName string
+	dynamoDB        dynamodbiface.DynamoDBAPI
+}
+
+// Represents a Metadata Outbox record: one event, in the order it was written for its source event
+type MetadataOutboxItem struct {
+	// Id of the event the metadata events were written for, such as the document id of an S3 upload
+	OutboxId     string `json:"outboxId"`
+	Sequence     int    `json:"sequence"`
+	MetadataType string `json:"metadataType"`
+	DocumentId   string `json:"documentId"`
+	// The marshalled metadata event
+	Message   string `json:"message"`
+	Timestamp string `json:"timestamp"`
+	// Epoch seconds after which DynamoDB removes the record
+	ExpiresAt int64 `json:"expiresAt"`
+}
+
+// Create a new instance of the MetadataOutboxStore
+func NewMetadataOutboxStore(outboxTableName string) *MetadataOutboxStore {
+	sess := session.Must(session.NewSession(
+		&aws.Config{
+			Region:     aws.String("us-east-1"),
+			MaxRetries: aws.Int(30),
+		},
+	))
+
+	return &MetadataOutboxStore{
+		outboxTableName: outboxTableName,
+		dynamoDB:        dynamodb.New(sess),
+	}
+}
+
+// Write the events of a source event in a single transaction, numbering them in order. Either all the events
+// are written or none is. Writing events that are already in the outbox, as a retry does, succeeds without
+// writing them again.
+func (s *MetadataOutboxStore) AddEvents(items []MetadataOutboxItem) error {
+	expiresAt := time.Now().Add(MetadataOutboxRetention).Unix()
+	transactItems := make([]*dynamodb.TransactWriteItem, 0, len(items))
+	for i, item := range items {
+		item.Sequence = i
+		item.ExpiresAt = expiresAt
+		av, err := dynamodbattribute.MarshalMap(item)
+		if err != nil {
+			log.Println("Got error marshalling map:")
+			log.Println(err.Error())
+			return err
+		}
+		transactItems = append(transactItems, &dynamodb.TransactWriteItem{
+			Put: &dynamodb.Put{
+				TableName:           aws.String(s.outboxTableName),
+				Item:                av,
+				ConditionExpression: aws.String("attribute_not_exists(outboxId)"),
+			},
+		})
+	}
+
+	_, err := s.dynamoDB.TransactWriteItems(&dynamodb.TransactWriteItemsInput{
+		TransactItems: transactItems,
+	})
+
+	// Handle DynamoDB error codes
+	if err != nil {
+		if canceled, ok := err.(*dynamodb.TransactionCanceledException); ok && alreadyWritten(canceled) {
+			log.Printf("Events of %s are already in the outbox \n", items[0].OutboxId)
+			return nil
+		}
+		if aerr, ok := err.(awserr.Error); ok {
+			// Print the dynamo code and error message
+			log.Println(aerr.Code(), aerr.Error())
+		} else {
+			// Print the error, cast err to awserr.Error to get the Code and
+			// Message from an error.
+			log.Println(err.Error())
+		}
+	}
+	return err
+}
+
+// Whether a transaction was canceled only because its items already exist
+func alreadyWritten(canceled *dynamodb.TransactionCanceledException) bool {
+	if len(canceled.CancellationReasons) == 0 {
+		return false
+	}
+	for _, reason := range canceled.CancellationReasons {
+		if aws.StringValue(reason.Code) != "ConditionalCheckFailed" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,89 @@
+package datastores
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// Outbox table answering transactions with a scripted error
+type fakeOutboxTable struct {
+	dynamodbiface.DynamoDBAPI
+	input *dynamodb.TransactWriteItemsInput
+	err   error
+}
+
+func (f *fakeOutboxTable) TransactWriteItems(input *dynamodb.TransactWriteItemsInput) (*dynamodb.TransactWriteItemsOutput, error) {
+	f.input = input
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+// Transaction canceled for the given reasons, one per item
+func canceledFor(codes ...string) error {
+	reasons := []*dynamodb.CancellationReason{}
+	for _, code := range codes {
+		reasons = append(reasons, &dynamodb.CancellationReason{Code: aws.String(code)})
+	}
+	return &dynamodb.TransactionCanceledException{Message_: aws.String("Transaction cancelled"), CancellationReasons: reasons}
+}
+
+func TestAddEvents(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		wantErr bool
+	}{
+		{name: "written"},
+		{name: "retried write of events already in the outbox", err: canceledFor("ConditionalCheckFailed", "ConditionalCheckFailed", "ConditionalCheckFailed")},
+		{name: "partial cancellation", err: canceledFor("ConditionalCheckFailed", "None", "None"), wantErr: true},
+		{name: "conflicting transaction", err: canceledFor("ConditionalCheckFailed", "TransactionConflict", "ConditionalCheckFailed"), wantErr: true},
+		{name: "cancellation without reasons", err: canceledFor(), wantErr: true},
+		{name: "throttled", err: awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "throttled", nil), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := &fakeOutboxTable{err: tt.err}
+			store := &MetadataOutboxStore{outboxTableName: "outbox", dynamoDB: table}
+			items := []MetadataOutboxItem{
+				{OutboxId: "upload", MetadataType: "document-registry", Message: "{}"},
+				{OutboxId: "upload", MetadataType: "document-lineage", Message: "{}"},
+				{OutboxId: "upload", MetadataType: "pipeline-operations", Message: "{}"},
+			}
+
+			err := store.AddEvents(items)
+			want := error(nil)
+			if tt.wantErr {
+				want = tt.err
+			}
+			if err != want {
+				t.Fatalf("AddEvents() error = %v, want %v", err, want)
+			}
+
+			// Every event is written in one transaction, numbered in order, only if it is not there yet
+			if len(table.input.TransactItems) != len(items) {
+				t.Fatalf("transaction of %d items, want %d", len(table.input.TransactItems), len(items))
+			}
+			for i, transactItem := range table.input.TransactItems {
+				item := MetadataOutboxItem{}
+				err := dynamodbattribute.UnmarshalMap(transactItem.Put.Item, &item)
+				if err != nil {
+					t.Fatalf("UnmarshalMap() error = %v", err)
+				}
+				if item.Sequence != i || item.MetadataType != items[i].MetadataType || item.ExpiresAt == 0 {
+					t.Errorf("item %d: %+v", i, item)
+				}
+				if aws.StringValue(transactItem.Put.ConditionExpression) != "attribute_not_exists(outboxId)" {
+					t.Errorf("item %d condition %q", i, aws.StringValue(transactItem.Put.ConditionExpression))
+				}
+			}
+		})
+	}
+}
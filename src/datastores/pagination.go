@@ -0,0 +1,119 @@
+package datastores
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Page size of list queries given no limit, and the largest page they return
+const (
+	DefaultPageSize = 25
+	MaxPageSize     = 100
+)
+
+// ErrInvalidPageToken is returned for page tokens that were not returned by the same query
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// Where a query continues: the last key it evaluated and, for queries over several partitions, the partition it
+// stopped in. Callers only see it base64 encoded.
+type pageToken struct {
+	Partition string                              `json:"p,omitempty"`
+	Key       map[string]*dynamodb.AttributeValue `json:"k,omitempty"`
+}
+
+// Opaque token continuing a query after the given key, empty when there is nothing left
+func encodePageToken(partition string, key map[string]*dynamodb.AttributeValue) string {
+	if len(key) == 0 && partition == "" {
+		return ""
+	}
+	data, err := json.Marshal(pageToken{Partition: partition, Key: key})
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Decode a page token, nil for the first page
+func decodePageToken(token string) (*pageToken, error) {
+	if token == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	decoded := &pageToken{}
+	err = json.Unmarshal(data, decoded)
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	return decoded, nil
+}
+
+// Page size of a requested limit
+func pageSize(limit int) int64 {
+	if limit <= 0 {
+		return DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		return MaxPageSize
+	}
+	return int64(limit)
+}
+
+// ErrNoPageTokenKey is returned by the list queries of a store without a key to sign its page tokens
+var ErrNoPageTokenKey = errors.New("no page token key to sign page tokens with")
+
+// Environment variable holding the key the stores sign their page tokens with by default
+const PageTokenKeyEnv = "PAGE_TOKEN_KEY"
+
+// Page token key configured in the environment, nil when there is none
+func pageTokenKeyFromEnv() []byte {
+	key := os.Getenv(PageTokenKeyEnv)
+	if key == "" {
+		return nil
+	}
+	return []byte(key)
+}
+
+// Opaque token continuing a query after the given key, signed with a secret and bound to the query, so that it
+// can neither be altered nor used to continue another query. Empty when there is nothing left.
+func encodeSignedPageToken(secret []byte, query string, partition string, key map[string]*dynamodb.AttributeValue) string {
+	payload := encodePageToken(partition, key)
+	if payload == "" {
+		return ""
+	}
+	return payload + "." + base64.RawURLEncoding.EncodeToString(pageTokenSignature(secret, query, payload))
+}
+
+// Verify and decode a signed page token, nil for the first page
+func decodeSignedPageToken(secret []byte, query string, token string) (*pageToken, error) {
+	if token == "" {
+		return nil, nil
+	}
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidPageToken
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(decoded, pageTokenSignature(secret, query, payload)) {
+		return nil, ErrInvalidPageToken
+	}
+	return decodePageToken(payload)
+}
+
+// HMAC of a page token payload and the query it continues
+func pageTokenSignature(secret []byte, query string, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(query))
+	mac.Write([]byte{0})
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
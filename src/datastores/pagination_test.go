@@ -0,0 +1,68 @@
+package datastores
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestSignedPageToken(t *testing.T) {
+	secret := []byte("secret")
+	const query = "StatusIndex|FAILED"
+	key := map[string]*dynamodb.AttributeValue{"documentId": {S: aws.String("doc-1")}}
+	token := encodeSignedPageToken(secret, query, "2024-05-01", key)
+	payload, signature, _ := strings.Cut(token, ".")
+	forged := encodePageToken("2024-05-01", map[string]*dynamodb.AttributeValue{"documentId": {S: aws.String("doc-2")}})
+
+	tests := []struct {
+		name    string
+		secret  []byte
+		query   string
+		token   string
+		wantErr bool
+	}{
+		{name: "first page", secret: secret, query: query, token: ""},
+		{name: "round trip", secret: secret, query: query, token: token},
+		{name: "replayed on another query", secret: secret, query: "StatusIndex|SUCCEEDED", token: token, wantErr: true},
+		{name: "signed with another key", secret: []byte("other"), query: query, token: token, wantErr: true},
+		{name: "altered payload", secret: secret, query: query, token: forged + "." + signature, wantErr: true},
+		{name: "altered signature", secret: secret, query: query, token: payload + "." + base64.RawURLEncoding.EncodeToString([]byte("signature")), wantErr: true},
+		{name: "unsigned", secret: secret, query: query, token: payload, wantErr: true},
+		{name: "raw document id", secret: secret, query: query, token: "doc-1", wantErr: true},
+		{name: "malformed signature", secret: secret, query: query, token: payload + ".!!", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoded, err := decodeSignedPageToken(tt.secret, tt.query, tt.token)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidPageToken) {
+					t.Errorf("decodeSignedPageToken() error = %v, want %v", err, ErrInvalidPageToken)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodeSignedPageToken() error = %v", err)
+			}
+			if tt.token == "" {
+				if decoded != nil {
+					t.Errorf("decodeSignedPageToken() = %v, want nil", decoded)
+				}
+				return
+			}
+			if decoded.Partition != "2024-05-01" || aws.StringValue(decoded.Key["documentId"].S) != "doc-1" {
+				t.Errorf("decodeSignedPageToken() = %+v", decoded)
+			}
+		})
+	}
+}
+
+func TestSignedPageTokenEmpty(t *testing.T) {
+	if token := encodeSignedPageToken([]byte("secret"), "scan", "", nil); token != "" {
+		t.Errorf("encodeSignedPageToken() = %q, want no token", token)
+	}
+}
@@ -1,7 +1,11 @@
 package datastores
 
 import (
+	"fmt"
 	"log"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -9,12 +13,33 @@ import (
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
+	"golang.org/x/exp/slices"
 )
 
 // Represents the Document Registry DynamoDB
 type PipelineOperationsStore struct {
 	opsTableName string
 	dynamoDB     dynamodbiface.DynamoDBAPI
+	// Secret signing the page tokens of the list queries
+	pageTokenKey []byte
+}
+
+// Secondary indexes of the Pipeline Operations table, each sorted by lastUpdate
+const (
+	OperationsStatusIndex = "StatusIndex"
+	OperationsStageIndex  = "StageIndex"
+)
+
+// OperationsFilter narrows a list query. Zero values select every document.
+type OperationsFilter struct {
+	// Only documents updated in this time range
+	Since time.Time
+	Until time.Time
+	// Only documents in this stage, status or bucket
+	Stage      string
+	Status     string
+	BucketName string
 }
 
 // Represents a Timeline item in a Pipeline Operations record
@@ -22,6 +47,7 @@ type TimelineItem struct {
 	Timestamp string `json:"timestamp"`
 	Stage     string `json:"stage"`
 	Status    string `json:"status"`
+	Message   string `json:"message,omitempty"`
 }
 
 // Represents a Pipeline Operations record
@@ -34,6 +60,11 @@ type PipelineOperationsItem struct {
 	LastUpdate      string         `json:"lastUpdate"`
 	Timeline        []TimelineItem `json:"timeline"`
 	DocumentVersion *string        `json:"documentVersion"`
+	// Incremented by every update, which is conditional on the version it read
+	Version int64 `json:"version"`
+	// Ids of the last events applied to the record, oldest first. Records written before the list was bounded
+	// hold a string set.
+	ProcessedEventIds []string `json:"processedEventIds,omitempty" dynamodbav:"processedEventIds,omitempty"`
 }
 
 type PipelineOperationsList struct {
@@ -53,11 +84,25 @@ func NewPipelineOperationsStore(opsTableName string) *PipelineOperationsStore {
 	return &PipelineOperationsStore{
 		opsTableName: opsTableName,
 		dynamoDB:     dynamodb.New(sess),
+		pageTokenKey: pageTokenKeyFromEnv(),
 	}
 }
 
+// Sign the page tokens of the list queries with a secret key instead of the one in PAGE_TOKEN_KEY, the same for
+// every instance serving the same callers
+func (s *PipelineOperationsStore) WithPageTokenKey(key []byte) *PipelineOperationsStore {
+	s.pageTokenKey = key
+	return s
+}
+
 // Start tracking a document
 func (s *PipelineOperationsStore) StartDocumentTracking(item PipelineOperationsItem, receipt string) error {
+	err := metadata.Pipeline.Start(item.DocumentId, metadata.StageState{Stage: item.DocumentStage, Status: item.DocumentStatus})
+	if err != nil {
+		log.Println(err.Error())
+		return err
+	}
+	item.Version = 1
 
 	av, err := dynamodbattribute.MarshalMap(item)
 	if err != nil {
@@ -76,6 +121,11 @@ func (s *PipelineOperationsStore) StartDocumentTracking(item PipelineOperationsI
 	// Handle DynamoDB error codes
 	if err != nil {
 		if aerr, ok := err.(awserr.Error); ok {
+			// Starting the tracking again, as redelivered events do, leaves the record in place
+			if aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+				log.Printf("Document %s is already tracked \n", item.DocumentId)
+				return nil
+			}
 			// Print the dynamo code and error message
 			log.Println(aerr.Code(), aerr.Error())
 		} else {
@@ -87,77 +137,184 @@ func (s *PipelineOperationsStore) StartDocumentTracking(item PipelineOperationsI
 	return err
 }
 
-func (s *PipelineOperationsStore) UpdateDocumentStatus(documentId, status, stage, timestamp string, messageNote interface{}) error {
-	// Create the update expression
-	updateExpression := "SET documentStatus = :documentStatus, documentStage = :documentStage, lastUpdate = :lastUpdate, timeline = list_append(timeline, :new_datapoint)"
-	// Create the expression attribute values
-	expressionAttributeValues := map[string]*dynamodb.AttributeValue{
-		":documentStatus": {
-			S: aws.String(status),
-		},
-		":documentStage": {
-			S: aws.String(stage),
-		},
-		":lastUpdate": {
-			S: aws.String(timestamp),
-		},
-		":new_datapoint": {
-			L: []*dynamodb.AttributeValue{
-				{
-					M: map[string]*dynamodb.AttributeValue{
-						"timestamp": {
-							S: aws.String(timestamp),
-						},
-						"stage": {
-							S: aws.String(stage),
-						},
-						"status": {
-							S: aws.String(status),
-						},
-					},
-				},
-			},
-		},
-	}
+// Attempts at updating a document before giving up on concurrent writers
+const maxUpdateAttempts = 5
+
+// Event ids kept in a record. Older events are still recognised by their timeline entry.
+const maxProcessedEventIds = 50
+
+// Apply a pipeline event to a tracked document. The event is added to the timeline in time order, and its status
+// and stage become the document's when it supersedes the last update; superseding events the pipeline state
+// machine does not allow are rejected with a *metadata.InvalidTransitionError. Events already applied, as
+// redelivered events are, leave the document unchanged. Writes are conditional on the version read, so concurrent updates
+// are read again and reapplied.
+func (s *PipelineOperationsStore) UpdateDocumentStatus(documentId, eventId, status, stage, timestamp string, messageNote interface{}) error {
+	datapoint := TimelineItem{Timestamp: timestamp, Stage: stage, Status: status}
 	if messageNote != nil {
-		expressionAttributeValues[":new_datapoint"].L[0].M["message"] = &dynamodb.AttributeValue{
-			S: aws.String(messageNote.(string)),
+		message, ok := messageNote.(string)
+		if !ok {
+			return fmt.Errorf("message note of document %s is a %T, not a string", documentId, messageNote)
 		}
+		datapoint.Message = message
 	}
 
-	// Update the item
-	_, err := s.dynamoDB.UpdateItem(&dynamodb.UpdateItemInput{
-		TableName: aws.String(s.opsTableName),
-		Key: map[string]*dynamodb.AttributeValue{
-			"documentId": {
-				S: aws.String(documentId),
+	for attempt := 1; attempt <= maxUpdateAttempts; attempt++ {
+		item, err := s.getDocument(documentId, true)
+		if err != nil {
+			return err
+		}
+		if item.DocumentId == "" {
+			return fmt.Errorf("document %s is not tracked", documentId)
+		}
+		if slices.Contains(item.ProcessedEventIds, eventId) || slices.Contains(item.Timeline, datapoint) {
+			log.Printf("Event %s of document %s is already applied \n", eventId, documentId)
+			return nil
+		}
+
+		latest, err := supersedes(datapoint, item)
+		if err != nil {
+			return err
+		}
+		// Events that supersede the document's state move it to their own, which the state machine must allow
+		if latest {
+			from := metadata.StageState{Stage: item.DocumentStage, Status: item.DocumentStatus}
+			err = metadata.Pipeline.Transition(documentId, from, metadata.StageState{Stage: stage, Status: status})
+			if err != nil {
+				log.Println(err.Error())
+				return err
+			}
+		}
+		timeline, err := insertDatapoint(item.Timeline, datapoint)
+		if err != nil {
+			return err
+		}
+		timelineValue, err := dynamodbattribute.Marshal(timeline)
+		if err != nil {
+			log.Println("Got error marshalling timeline:")
+			log.Println(err.Error())
+			return err
+		}
+		eventIds := append(slices.Clone(item.ProcessedEventIds), eventId)
+		if len(eventIds) > maxProcessedEventIds {
+			eventIds = eventIds[len(eventIds)-maxProcessedEventIds:]
+		}
+		eventIdsValue, err := dynamodbattribute.Marshal(eventIds)
+		if err != nil {
+			log.Println("Got error marshalling event ids:")
+			log.Println(err.Error())
+			return err
+		}
+
+		// Create the update expression
+		updateExpression := "SET timeline = :timeline, processedEventIds = :eventIds, #version = :next"
+		expressionAttributeValues := map[string]*dynamodb.AttributeValue{
+			":timeline": timelineValue,
+			":eventIds": eventIdsValue,
+			":next":     {N: aws.String(strconv.FormatInt(item.Version+1, 10))},
+		}
+		if latest {
+			updateExpression = "SET documentStatus = :documentStatus, documentStage = :documentStage, lastUpdate = :lastUpdate, timeline = :timeline, processedEventIds = :eventIds, #version = :next"
+			expressionAttributeValues[":documentStatus"] = &dynamodb.AttributeValue{S: aws.String(status)}
+			expressionAttributeValues[":documentStage"] = &dynamodb.AttributeValue{S: aws.String(stage)}
+			expressionAttributeValues[":lastUpdate"] = &dynamodb.AttributeValue{S: aws.String(timestamp)}
+		}
+		// Records tracked before versions existed have none
+		conditionExpression := "attribute_exists(documentId) AND #version = :version"
+		if item.Version == 0 {
+			conditionExpression = "attribute_exists(documentId) AND attribute_not_exists(#version)"
+		} else {
+			expressionAttributeValues[":version"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(item.Version, 10))}
+		}
+
+		// Update the item
+		_, err = s.dynamoDB.UpdateItem(&dynamodb.UpdateItemInput{
+			TableName: aws.String(s.opsTableName),
+			Key: map[string]*dynamodb.AttributeValue{
+				"documentId": {
+					S: aws.String(documentId),
+				},
 			},
-		},
-		UpdateExpression:          aws.String(updateExpression),
-		ConditionExpression:       aws.String("attribute_exists(documentId)"),
-		ExpressionAttributeValues: expressionAttributeValues,
-		ReturnValues:              aws.String("UPDATED_NEW"),
-	})
+			UpdateExpression:          aws.String(updateExpression),
+			ConditionExpression:       aws.String(conditionExpression),
+			ExpressionAttributeNames:  map[string]*string{"#version": aws.String("version")},
+			ExpressionAttributeValues: expressionAttributeValues,
+		})
+		if err == nil {
+			if !latest {
+				log.Printf("Event %s of document %s is older than its last update %s, added to its timeline only \n", eventId, documentId, item.LastUpdate)
+			}
+			return nil
+		}
 
-	// Handle DynamoDB error codes
+		// Another event updated the document since it was read
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			log.Printf("Document %s changed while applying event %s, attempt %d \n", documentId, eventId, attempt)
+			continue
+		}
+		logDynamoError(err)
+		return err
+	}
+	return fmt.Errorf("document %s kept changing while applying event %s", documentId, eventId)
+}
+
+// Ranks of the statuses of a stage at the same time: its outcome supersedes its progress
+var statusRanks = map[string]int{
+	metadata.StatusInProgress: 0,
+	metadata.StatusSucceeded:  1,
+	metadata.StatusFailed:     1,
+}
+
+// Whether an event supersedes the last update of a document: a later event does, and an event at the same time
+// does when it ranks higher
+func supersedes(datapoint TimelineItem, item *PipelineOperationsItem) (bool, error) {
+	eventTime, err := metadata.ParseTimestamp(datapoint.Timestamp)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			// Print the dynamo code and error message
-			log.Println(aerr.Code(), aerr.Error())
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			log.Println(err.Error())
+		return false, fmt.Errorf("event of document %s: %v", item.DocumentId, err)
+	}
+	lastUpdate, err := metadata.ParseTimestamp(item.LastUpdate)
+	if err != nil {
+		// Documents are only started by their first event, which sets no lastUpdate
+		return true, nil
+	}
+	if !eventTime.Equal(lastUpdate) {
+		return eventTime.After(lastUpdate), nil
+	}
+	return statusRanks[datapoint.Status] > statusRanks[item.DocumentStatus], nil
+}
+
+// Insert a datapoint in a timeline after the entries up to its time
+func insertDatapoint(timeline []TimelineItem, datapoint TimelineItem) ([]TimelineItem, error) {
+	eventTime, err := metadata.ParseTimestamp(datapoint.Timestamp)
+	if err != nil {
+		return nil, err
+	}
+	i := len(timeline)
+	for i > 0 {
+		entryTime, err := metadata.ParseTimestamp(timeline[i-1].Timestamp)
+		if err != nil || !entryTime.After(eventTime) {
+			break
 		}
+		i--
 	}
-	return err
+	return slices.Insert(slices.Clone(timeline), i, datapoint), nil
 }
 
+// Mark a stage of a document as succeeded
 func (s *PipelineOperationsStore) MarkDocumentComplete(documentId string, stage string, timestamp string) error {
-	return s.UpdateDocumentStatus(documentId, "SUCCEEDED", stage, timestamp, "")
+	event := metadata.OperationsEvent{
+		EventHeader: metadata.EventHeader{DocumentId: documentId, Timestamp: timestamp},
+		Stage:       stage,
+		Status:      metadata.StatusSucceeded,
+	}
+	return s.UpdateDocumentStatus(documentId, event.EventId(), event.Status, stage, timestamp, nil)
 }
 
 func (s *PipelineOperationsStore) GetDocument(documentId string) (*PipelineOperationsItem, error) {
+	return s.getDocument(documentId, false)
+}
+
+// Read a tracked document, empty if the document is not tracked
+func (s *PipelineOperationsStore) getDocument(documentId string, consistentRead bool) (*PipelineOperationsItem, error) {
 	result, err := s.dynamoDB.GetItem(&dynamodb.GetItemInput{
 		TableName: aws.String(s.opsTableName),
 		Key: map[string]*dynamodb.AttributeValue{
@@ -165,6 +322,7 @@ func (s *PipelineOperationsStore) GetDocument(documentId string) (*PipelineOpera
 				S: aws.String(documentId),
 			},
 		},
+		ConsistentRead: aws.Bool(consistentRead),
 	})
 
 	// Handle DynamoDB error codes
@@ -215,35 +373,26 @@ func (s *PipelineOperationsStore) DeleteDocument(documentId string) error {
 	return err
 }
 
+// List every tracked document, in no particular order
 func (s *PipelineOperationsStore) GetDocuments(nextToken string) (*PipelineOperationsList, error) {
 	// Prepare scanning input with paging.
 	input := &dynamodb.ScanInput{
 		TableName: aws.String(s.opsTableName),
-		Limit:     aws.Int64(25),
+		Limit:     aws.Int64(DefaultPageSize),
 	}
 
-	// If we have a nextToken, use it to get the next page of results
+	// The token is the documentId the scan stopped at. A scan lists every document, so there is no start key
+	// to protect and it needs no signature.
 	if nextToken != "" {
 		input.ExclusiveStartKey = map[string]*dynamodb.AttributeValue{
-			"documentId": {
-				S: aws.String(nextToken),
-			},
+			"documentId": {S: aws.String(nextToken)},
 		}
 	}
 
 	// Scan the table.
 	result, err := s.dynamoDB.Scan(input)
-
-	// Handle DynamoDB error codes
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			// Print the dynamo code and error message
-			log.Println(aerr.Code(), aerr.Error())
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			log.Println(err.Error())
-		}
+		logDynamoError(err)
 		return nil, err
 	}
 
@@ -256,13 +405,127 @@ func (s *PipelineOperationsStore) GetDocuments(nextToken string) (*PipelineOpera
 		return nil, err
 	}
 
-	list := PipelineOperationsList{
-		Documents: items,
+	list := &PipelineOperationsList{Documents: items}
+	if documentId, ok := result.LastEvaluatedKey["documentId"]; ok {
+		list.NextToken = aws.StringValue(documentId.S)
+	}
+	return list, nil
+}
+
+// List the documents with a status, most recently updated first
+func (s *PipelineOperationsStore) ListByStatus(status string, filter OperationsFilter, limit int, nextToken string) (*PipelineOperationsList, error) {
+	filter.Status = ""
+	return s.listByIndex(OperationsStatusIndex, "documentStatus", status, filter, limit, nextToken)
+}
+
+// List the documents in a stage, most recently updated first
+func (s *PipelineOperationsStore) ListByStage(stage string, filter OperationsFilter, limit int, nextToken string) (*PipelineOperationsList, error) {
+	filter.Stage = ""
+	return s.listByIndex(OperationsStageIndex, "documentStage", stage, filter, limit, nextToken)
+}
+
+// List the documents that failed since a time, most recently updated first
+func (s *PipelineOperationsStore) ListFailedSince(since time.Time, limit int, nextToken string) (*PipelineOperationsList, error) {
+	return s.ListByStatus(metadata.StatusFailed, OperationsFilter{Since: since}, limit, nextToken)
+}
+
+// Query a page of an index sorted by lastUpdate, newest first. The filter's time range is part of the key
+// condition; its stage, status and bucket are filtered by DynamoDB, so the query reads on until the page is
+// full or the index is exhausted. The range is compared as RFC 3339 strings, so records whose lastUpdate is still
+// in the legacy time.String() format only fall in the right range when it is not bounded on the day of their update.
+func (s *PipelineOperationsStore) listByIndex(indexName string, keyName string, keyValue string, filter OperationsFilter, limit int, nextToken string) (*PipelineOperationsList, error) {
+	if len(s.pageTokenKey) == 0 {
+		return nil, ErrNoPageTokenKey
+	}
+	if !filter.Since.IsZero() && !filter.Until.IsZero() && filter.Until.Before(filter.Since) {
+		return nil, fmt.Errorf("time range ends at %s before it starts at %s", filter.Until, filter.Since)
+	}
+
+	// Tokens are bound to the index, key and filter of the query that returned them
+	query := strings.Join([]string{indexName, keyValue, filter.Stage, filter.Status, filter.BucketName,
+		timeBound(filter.Since), timeBound(filter.Until)}, "|")
+	token, err := decodeSignedPageToken(s.pageTokenKey, query, nextToken)
+	if err != nil {
+		return nil, err
+	}
+
+	keyCondition := "#key = :key"
+	names := map[string]*string{"#key": aws.String(keyName)}
+	values := map[string]*dynamodb.AttributeValue{":key": {S: aws.String(keyValue)}}
+	switch {
+	case !filter.Since.IsZero() && !filter.Until.IsZero():
+		keyCondition += " AND lastUpdate BETWEEN :since AND :until"
+	case !filter.Since.IsZero():
+		keyCondition += " AND lastUpdate >= :since"
+	case !filter.Until.IsZero():
+		keyCondition += " AND lastUpdate <= :until"
+	}
+	if !filter.Since.IsZero() {
+		values[":since"] = &dynamodb.AttributeValue{S: aws.String(timeBound(filter.Since))}
+	}
+	if !filter.Until.IsZero() {
+		values[":until"] = &dynamodb.AttributeValue{S: aws.String(timeBound(filter.Until))}
 	}
 
-	if result.LastEvaluatedKey != nil {
-		list.NextToken = *result.LastEvaluatedKey["documentId"].S
+	conditions := []string{}
+	for _, attribute := range [][2]string{{"documentStage", filter.Stage}, {"documentStatus", filter.Status}, {"bucketName", filter.BucketName}} {
+		name, value := attribute[0], attribute[1]
+		if value == "" {
+			continue
+		}
+		names["#"+name] = aws.String(name)
+		values[":"+name] = &dynamodb.AttributeValue{S: aws.String(value)}
+		conditions = append(conditions, fmt.Sprintf("#%s = :%s", name, name))
+	}
+
+	list := &PipelineOperationsList{Documents: []PipelineOperationsItem{}}
+	size := pageSize(limit)
+	var startKey map[string]*dynamodb.AttributeValue
+	if token != nil {
+		startKey = token.Key
+	}
+	for {
+		input := &dynamodb.QueryInput{
+			TableName:                 aws.String(s.opsTableName),
+			IndexName:                 aws.String(indexName),
+			KeyConditionExpression:    aws.String(keyCondition),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+			ExclusiveStartKey:         startKey,
+			ScanIndexForward:          aws.Bool(false),
+			// Evaluate no more items than the page has room for, so the page ends at the last evaluated key
+			Limit: aws.Int64(size - int64(len(list.Documents))),
+		}
+		if len(conditions) > 0 {
+			input.FilterExpression = aws.String(strings.Join(conditions, " AND "))
+		}
+
+		result, err := s.dynamoDB.Query(input)
+		if err != nil {
+			logDynamoError(err)
+			return nil, err
+		}
+		items := []PipelineOperationsItem{}
+		err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &items)
+		if err != nil {
+			log.Println("Got error unmarshalling:")
+			log.Println(err.Error())
+			return nil, err
+		}
+		list.Documents = append(list.Documents, items...)
+
+		startKey = result.LastEvaluatedKey
+		if startKey == nil || int64(len(list.Documents)) >= size {
+			list.NextToken = encodeSignedPageToken(s.pageTokenKey, query, "", startKey)
+			return list, nil
+		}
 	}
+}
 
-	return &list, nil
+// A time range bound as compared with lastUpdate, empty for an open bound
+func timeBound(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return metadata.FormatTimestamp(t)
 }
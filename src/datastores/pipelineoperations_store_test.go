@@ -0,0 +1,42 @@
+package datastores
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"golang.org/x/exp/slices"
+)
+
+func TestProcessedEventIds(t *testing.T) {
+	tests := []struct {
+		name  string
+		value *dynamodb.AttributeValue
+		want  []string
+	}{
+		{name: "list", value: &dynamodb.AttributeValue{L: []*dynamodb.AttributeValue{{S: aws.String("a")}, {S: aws.String("b")}}}, want: []string{"a", "b"}},
+		{name: "string set of older records", value: &dynamodb.AttributeValue{SS: []*string{aws.String("a")}}, want: []string{"a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := PipelineOperationsItem{}
+			err := dynamodbattribute.UnmarshalMap(map[string]*dynamodb.AttributeValue{"processedEventIds": tt.value}, &item)
+			if err != nil {
+				t.Fatalf("UnmarshalMap() error = %v", err)
+			}
+			if !slices.Equal(item.ProcessedEventIds, tt.want) {
+				t.Errorf("processedEventIds %v, want %v", item.ProcessedEventIds, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateDocumentStatusMessageNote(t *testing.T) {
+	store := &PipelineOperationsStore{}
+	err := store.UpdateDocumentStatus("doc", "event", "FAILED", "DOCUMENT_CLASSIFIER", "2024-05-01T12:00:00Z", 42)
+	if err == nil {
+		t.Error("UpdateDocumentStatus() error = nil for a message note that is not a string")
+	}
+}
@@ -0,0 +1,86 @@
+package datastores
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// A value that differs between two revisions
+type ValueChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// RevisionDiff lists how the registry metadata of a revision differs from an earlier one
+type RevisionDiff struct {
+	LogicalDocumentId string `json:"logicalDocumentId"`
+	FromRevision      string `json:"fromRevision"`
+	ToRevision        string `json:"toRevision"`
+	// Changes of the registry fields: documentVersion, documentLink, principalIAMWriter and timestamp
+	Fields map[string]ValueChange `json:"fields"`
+	// Document metadata keys added, removed or changed in the later revision
+	Added   map[string]interface{} `json:"added"`
+	Removed map[string]interface{} `json:"removed"`
+	Changed map[string]ValueChange `json:"changed"`
+}
+
+// Diff the metadata of two revisions of the same logical document
+func (s *DocumentRegistryStore) DiffRevisions(fromDocumentId string, toDocumentId string) (*RevisionDiff, error) {
+	from, err := s.GetDocument(fromDocumentId)
+	if err != nil {
+		return nil, err
+	}
+	to, err := s.GetDocument(toDocumentId)
+	if err != nil {
+		return nil, err
+	}
+	if from == nil || to == nil {
+		return nil, fmt.Errorf("revision %s or %s is not registered", fromDocumentId, toDocumentId)
+	}
+	if logicalDocumentIdOf(*from) != logicalDocumentIdOf(*to) {
+		return nil, fmt.Errorf("revisions %s and %s are not of the same document", fromDocumentId, toDocumentId)
+	}
+	return DiffRevisionItems(*from, *to), nil
+}
+
+// Diff the metadata of two registry records
+func DiffRevisionItems(from DocumentRegistryItem, to DocumentRegistryItem) *RevisionDiff {
+	diff := &RevisionDiff{
+		LogicalDocumentId: logicalDocumentIdOf(to),
+		FromRevision:      from.DocumentId,
+		ToRevision:        to.DocumentId,
+		Fields:            map[string]ValueChange{},
+		Added:             map[string]interface{}{},
+		Removed:           map[string]interface{}{},
+		Changed:           map[string]ValueChange{},
+	}
+
+	fields := map[string][2]interface{}{
+		"documentVersion":    {aws.StringValue(from.DocumentVersion), aws.StringValue(to.DocumentVersion)},
+		"documentLink":       {from.DocumentLink, to.DocumentLink},
+		"principalIAMWriter": {from.PrincipalIAMWriter, to.PrincipalIAMWriter},
+		"timestamp":          {from.Timestamp, to.Timestamp},
+	}
+	for name, values := range fields {
+		if !reflect.DeepEqual(values[0], values[1]) {
+			diff.Fields[name] = ValueChange{From: values[0], To: values[1]}
+		}
+	}
+
+	for key, value := range to.DocumentMetadata {
+		previous, ok := from.DocumentMetadata[key]
+		if !ok {
+			diff.Added[key] = value
+		} else if !reflect.DeepEqual(previous, value) {
+			diff.Changed[key] = ValueChange{From: previous, To: value}
+		}
+	}
+	for key, value := range from.DocumentMetadata {
+		if _, ok := to.DocumentMetadata[key]; !ok {
+			diff.Removed[key] = value
+		}
+	}
+	return diff
+}
@@ -0,0 +1,106 @@
+package datastores
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
+)
+
+func TestDiffRevisionItems(t *testing.T) {
+	revision := func(documentId string, version *string, documentMetadata map[string]interface{}) DocumentRegistryItem {
+		return DocumentRegistryItem{
+			DocumentId:         documentId,
+			BucketName:         "uploads",
+			DocumentName:       "invoice.pdf",
+			DocumentLink:       "s3://uploads/invoice.pdf",
+			DocumentVersion:    version,
+			DocumentMetadata:   documentMetadata,
+			PrincipalIAMWriter: map[string]interface{}{"principalId": "AIDAUPLOADER"},
+			Timestamp:          "2024-05-01T10:00:00Z",
+		}
+	}
+	first := revision("rev-1", aws.String("v1"), map[string]interface{}{"owner": "alice", "class": "invoice", "tags": []interface{}{"q1"}})
+
+	tests := []struct {
+		name        string
+		to          DocumentRegistryItem
+		wantFields  map[string]ValueChange
+		wantAdded   map[string]interface{}
+		wantRemoved map[string]interface{}
+		wantChanged map[string]ValueChange
+	}{
+		{
+			name: "same metadata",
+			to:   revision("rev-2", aws.String("v1"), map[string]interface{}{"owner": "alice", "class": "invoice", "tags": []interface{}{"q1"}}),
+		},
+		{
+			name:        "metadata added, removed and changed",
+			to:          revision("rev-2", aws.String("v1"), map[string]interface{}{"owner": "bob", "tags": []interface{}{"q1", "paid"}, "pages": 2.0}),
+			wantAdded:   map[string]interface{}{"pages": 2.0},
+			wantRemoved: map[string]interface{}{"class": "invoice"},
+			wantChanged: map[string]ValueChange{
+				"owner": {From: "alice", To: "bob"},
+				"tags":  {From: []interface{}{"q1"}, To: []interface{}{"q1", "paid"}},
+			},
+		},
+		{
+			name:        "metadata removed entirely",
+			to:          revision("rev-2", aws.String("v1"), nil),
+			wantRemoved: map[string]interface{}{"owner": "alice", "class": "invoice", "tags": []interface{}{"q1"}},
+		},
+		{
+			name: "registry fields changed",
+			to: func() DocumentRegistryItem {
+				to := revision("rev-2", aws.String("v2"), first.DocumentMetadata)
+				to.DocumentLink = "s3://uploads/invoice.pdf?versionId=v2"
+				to.PrincipalIAMWriter = map[string]interface{}{"principalId": "AIDAOTHER"}
+				to.Timestamp = "2024-05-02T10:00:00Z"
+				return to
+			}(),
+			wantFields: map[string]ValueChange{
+				"documentVersion":    {From: "v1", To: "v2"},
+				"documentLink":       {From: "s3://uploads/invoice.pdf", To: "s3://uploads/invoice.pdf?versionId=v2"},
+				"principalIAMWriter": {From: map[string]interface{}{"principalId": "AIDAUPLOADER"}, To: map[string]interface{}{"principalId": "AIDAOTHER"}},
+				"timestamp":          {From: "2024-05-01T10:00:00Z", To: "2024-05-02T10:00:00Z"},
+			},
+		},
+		{
+			name:       "version of an unversioned bucket",
+			to:         revision("rev-2", nil, first.DocumentMetadata),
+			wantFields: map[string]ValueChange{"documentVersion": {From: "v1", To: ""}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := DiffRevisionItems(first, tt.to)
+
+			if diff.LogicalDocumentId != metadata.LogicalDocumentIdOf("uploads", "invoice.pdf") || diff.FromRevision != "rev-1" || diff.ToRevision != "rev-2" {
+				t.Errorf("diff of %s from %s to %s", diff.LogicalDocumentId, diff.FromRevision, diff.ToRevision)
+			}
+			for name, got := range map[string][2]interface{}{
+				"fields":  {diff.Fields, tt.wantFields},
+				"added":   {diff.Added, tt.wantAdded},
+				"removed": {diff.Removed, tt.wantRemoved},
+				"changed": {diff.Changed, tt.wantChanged},
+			} {
+				if reflect.ValueOf(got[0]).Len() == 0 && reflect.ValueOf(got[1]).Len() == 0 {
+					continue
+				}
+				if !reflect.DeepEqual(got[0], got[1]) {
+					t.Errorf("%s %v, want %v", name, got[0], got[1])
+				}
+			}
+		})
+	}
+}
+
+func TestDiffRevisionItemsLogicalDocument(t *testing.T) {
+	from := DocumentRegistryItem{DocumentId: "rev-1", BucketName: "uploads", DocumentName: "invoice.pdf"}
+	to := DocumentRegistryItem{DocumentId: "rev-2", BucketName: "uploads", DocumentName: "invoice.pdf", LogicalDocumentId: "logical"}
+	if diff := DiffRevisionItems(from, to); diff.LogicalDocumentId != "logical" {
+		t.Errorf("logical document %s, want the one the later revision was registered with", diff.LogicalDocumentId)
+	}
+}
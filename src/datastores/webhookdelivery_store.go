@@ -0,0 +1,162 @@
+package datastores
+
+import (
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// Represents the Webhook Deliveries DynamoDB, the log of the webhooks sent to each endpoint
+type WebhookDeliveryStore struct {
+	deliveriesTableName string
+	documentIndexName   string
+	dynamoDB            dynamodbiface.DynamoDBAPI
+}
+
+// Represents a Webhook Delivery record
+type WebhookDeliveryItem struct {
+	DeliveryId string `json:"deliveryId"`
+	TenantId   string `json:"tenantId"`
+	EndpointId string `json:"endpointId"`
+	DocumentId string `json:"documentId"`
+	EventType  string `json:"eventType"`
+	// The webhook body, resent as is on redelivery
+	Payload        string `json:"payload"`
+	Status         string `json:"status"`
+	Attempts       int    `json:"attempts"`
+	ResponseStatus int    `json:"responseStatus,omitempty"`
+	ResponseBody   string `json:"responseBody,omitempty"`
+	LastError      string `json:"lastError,omitempty"`
+	CreatedAt      string `json:"createdAt"`
+	UpdatedAt      string `json:"updatedAt"`
+}
+
+// Create a new instance of the WebhookDeliveryStore. Deliveries are listed by document through the given index.
+func NewWebhookDeliveryStore(deliveriesTableName string, documentIndexName string) *WebhookDeliveryStore {
+	sess := session.Must(session.NewSession(
+		&aws.Config{
+			Region:     aws.String("us-east-1"),
+			MaxRetries: aws.Int(30),
+		},
+	))
+
+	return &WebhookDeliveryStore{
+		deliveriesTableName: deliveriesTableName,
+		documentIndexName:   documentIndexName,
+		dynamoDB:            dynamodb.New(sess),
+	}
+}
+
+// Create or replace a Webhook Delivery record
+func (s *WebhookDeliveryStore) PutDelivery(item WebhookDeliveryItem) error {
+	av, err := dynamodbattribute.MarshalMap(item)
+	if err != nil {
+		log.Println("Got error marshalling map:")
+		log.Println(err.Error())
+		return err
+	}
+
+	_, err = s.dynamoDB.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(s.deliveriesTableName),
+		Item:      av,
+	})
+	if err != nil {
+		logDynamoError(err)
+	}
+	return err
+}
+
+// Create a Webhook Delivery record, or replace it when it has the given retryable status. Returns false, with no
+// error, when the record exists with another status: the delivery succeeded or another sender claimed it.
+func (s *WebhookDeliveryStore) ClaimDelivery(item WebhookDeliveryItem, retryableStatus string) (bool, error) {
+	av, err := dynamodbattribute.MarshalMap(item)
+	if err != nil {
+		log.Println("Got error marshalling map:")
+		log.Println(err.Error())
+		return false, err
+	}
+
+	_, err = s.dynamoDB.PutItem(&dynamodb.PutItemInput{
+		TableName:                aws.String(s.deliveriesTableName),
+		Item:                     av,
+		ConditionExpression:      aws.String("attribute_not_exists(deliveryId) OR #status = :retryable"),
+		ExpressionAttributeNames: map[string]*string{"#status": aws.String("status")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":retryable": {S: aws.String(retryableStatus)},
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return false, nil
+		}
+		logDynamoError(err)
+		return false, err
+	}
+	return true, nil
+}
+
+// Get a Webhook Delivery record, or nil if there is none
+func (s *WebhookDeliveryStore) GetDelivery(deliveryId string) (*WebhookDeliveryItem, error) {
+	result, err := s.dynamoDB.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(s.deliveriesTableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"deliveryId": {
+				S: aws.String(deliveryId),
+			},
+		},
+	})
+	if err != nil {
+		logDynamoError(err)
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	item := WebhookDeliveryItem{}
+	err = dynamodbattribute.UnmarshalMap(result.Item, &item)
+	if err != nil {
+		log.Println("Got error unmarshalling:")
+		log.Println(err.Error())
+		return nil, err
+	}
+	return &item, nil
+}
+
+// List the Webhook Delivery records of a document, oldest first
+func (s *WebhookDeliveryStore) ListDocumentDeliveries(documentId string) ([]WebhookDeliveryItem, error) {
+	items := []WebhookDeliveryItem{}
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(s.deliveriesTableName),
+		IndexName:              aws.String(s.documentIndexName),
+		KeyConditionExpression: aws.String("documentId = :documentId"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":documentId": {
+				S: aws.String(documentId),
+			},
+		},
+	}
+
+	var unmarshalErr error
+	err := s.dynamoDB.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		pageItems := []WebhookDeliveryItem{}
+		unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &pageItems)
+		items = append(items, pageItems...)
+		return unmarshalErr == nil
+	})
+	if err != nil {
+		logDynamoError(err)
+		return nil, err
+	}
+	if unmarshalErr != nil {
+		log.Println("Got error unmarshalling:")
+		log.Println(unmarshalErr.Error())
+		return nil, unmarshalErr
+	}
+	return items, nil
+}
@@ -0,0 +1,144 @@
+package datastores
+
+import (
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// Represents the Webhook Endpoints DynamoDB, holding the endpoints each tenant registered for status webhooks
+type WebhookEndpointStore struct {
+	endpointsTableName string
+	dynamoDB           dynamodbiface.DynamoDBAPI
+}
+
+// Represents a Webhook Endpoint record
+type WebhookEndpointItem struct {
+	// Tenant the endpoint receives the webhooks of, matched against the owner in the document metadata
+	TenantId   string `json:"tenantId"`
+	EndpointId string `json:"endpointId"`
+	Url        string `json:"url"`
+	// Shared secret the webhooks are signed with
+	Secret string `json:"secret"`
+	// Pipeline statuses sent to the endpoint, all of SUCCEEDED and FAILED if empty
+	Statuses  []string `json:"statuses"`
+	Enabled   bool     `json:"enabled"`
+	CreatedAt string   `json:"createdAt"`
+}
+
+// Create a new instance of the WebhookEndpointStore
+func NewWebhookEndpointStore(endpointsTableName string) *WebhookEndpointStore {
+	sess := session.Must(session.NewSession(
+		&aws.Config{
+			Region:     aws.String("us-east-1"),
+			MaxRetries: aws.Int(30),
+		},
+	))
+
+	return &WebhookEndpointStore{
+		endpointsTableName: endpointsTableName,
+		dynamoDB:           dynamodb.New(sess),
+	}
+}
+
+// Create or replace a Webhook Endpoint record
+func (s *WebhookEndpointStore) PutEndpoint(item WebhookEndpointItem) error {
+	av, err := dynamodbattribute.MarshalMap(item)
+	if err != nil {
+		log.Println("Got error marshalling map:")
+		log.Println(err.Error())
+		return err
+	}
+
+	_, err = s.dynamoDB.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(s.endpointsTableName),
+		Item:      av,
+	})
+	if err != nil {
+		logDynamoError(err)
+	}
+	return err
+}
+
+// Get a Webhook Endpoint record, or nil if there is none
+func (s *WebhookEndpointStore) GetEndpoint(tenantId string, endpointId string) (*WebhookEndpointItem, error) {
+	result, err := s.dynamoDB.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(s.endpointsTableName),
+		Key:       endpointKey(tenantId, endpointId),
+	})
+	if err != nil {
+		logDynamoError(err)
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	item := WebhookEndpointItem{}
+	err = dynamodbattribute.UnmarshalMap(result.Item, &item)
+	if err != nil {
+		log.Println("Got error unmarshalling:")
+		log.Println(err.Error())
+		return nil, err
+	}
+	return &item, nil
+}
+
+// List the Webhook Endpoint records of a tenant
+func (s *WebhookEndpointStore) ListEndpoints(tenantId string) ([]WebhookEndpointItem, error) {
+	items := []WebhookEndpointItem{}
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(s.endpointsTableName),
+		KeyConditionExpression: aws.String("tenantId = :tenantId"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":tenantId": {
+				S: aws.String(tenantId),
+			},
+		},
+	}
+
+	var unmarshalErr error
+	err := s.dynamoDB.QueryPages(input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		pageItems := []WebhookEndpointItem{}
+		unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &pageItems)
+		items = append(items, pageItems...)
+		return unmarshalErr == nil
+	})
+	if err != nil {
+		logDynamoError(err)
+		return nil, err
+	}
+	if unmarshalErr != nil {
+		log.Println("Got error unmarshalling:")
+		log.Println(unmarshalErr.Error())
+		return nil, unmarshalErr
+	}
+	return items, nil
+}
+
+// Delete a Webhook Endpoint record
+func (s *WebhookEndpointStore) DeleteEndpoint(tenantId string, endpointId string) error {
+	_, err := s.dynamoDB.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(s.endpointsTableName),
+		Key:       endpointKey(tenantId, endpointId),
+	})
+	if err != nil {
+		logDynamoError(err)
+	}
+	return err
+}
+
+func endpointKey(tenantId string, endpointId string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"tenantId": {
+			S: aws.String(tenantId),
+		},
+		"endpointId": {
+			S: aws.String(endpointId),
+		},
+	}
+}
@@ -3,10 +3,12 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -16,24 +18,50 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/textract"
 	"github.com/dreamspider42/document-processing-pipeline/src/awshelper"
+	"github.com/dreamspider42/document-processing-pipeline/src/datastores"
 	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
+	"github.com/dreamspider42/document-processing-pipeline/src/search"
 	"github.com/dreamspider42/document-processing-pipeline/src/textractparser"
 )
 
 const (
-	PIPELINE_STAGE             = "SYNC_PROCESS_COMPREHEND"
+	PIPELINE_STAGE             = metadata.StageSyncProcessComprehend
 	COMPREHEND_CHARACTER_LIMIT = 4096
 )
 
 // Represents the resources used by the handler
 type handler struct {
+	metadataBatch            *metadata.MetadataBatch
 	pipelineOperationsClient *metadata.PipelineOperationsClient
 	documentLineageClient    *metadata.DocumentLineageClient
+	documentRegistryStore    *datastores.DocumentRegistryStore
 	s3                       *awshelper.S3Helper
-	es                       *awshelper.ESHelper
+	indexer                  search.SearchIndexer
+	searchConfig             search.BackendConfig
+	esDeadLetterPrefix       string
 	comprehendBucketName     string
 }
 
+// Open the search index on first use so a cluster outage fails the stage instead of the whole Lambda
+func (h *handler) searchIndex() (search.SearchIndexer, error) {
+	if h.indexer != nil || !h.searchConfig.Configured() {
+		return h.indexer, nil
+	}
+
+	h.searchConfig.DeadLetter = &awshelper.ESDeadLetter{
+		S3:         h.s3,
+		BucketName: h.comprehendBucketName,
+		Prefix:     h.esDeadLetterPrefix,
+	}
+	indexer, err := search.OpenIndexer(h.searchConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	h.indexer = indexer
+	return h.indexer, nil
+}
+
 func (h *handler) dissectObjectName(objectName string) (string, string) {
 	objectParts := strings.Split(objectName, "/ocr-analysis/")
 	objectPath := strings.Split(objectParts[0], "/")
@@ -184,11 +212,10 @@ func (h *handler) runComprehend(bucketName string, objectName string, callerId s
 	}
 
 	document := textractparser.NewDocument(results)
-	originalFileName := fmt.Sprintf("%s/%s", documentId, documentName)
-	comprehendFileName := originalFileName + "/comprehend-output.json"
+	comprehendFileName := search.ComprehendOutputKey(documentId, documentName)
 	tagging := "documentId=" + documentId
 
-	esPayload := []map[string]interface{}{}
+	esPayload := []search.PageRecord{}
 	pageNum := 1
 
 	for _, page := range document.Pages {
@@ -235,41 +262,71 @@ func (h *handler) runComprehend(bucketName string, objectName string, callerId s
 			}
 		}
 
-		esPageLoad := map[string]interface{}{
-			"documentId": documentId,
-			"page":       pageNum,
-			"KeyPhrases": keyPhrases,
-			"Entities":   entitiesDetected,
-			"text":       text,
-			"table":      table,
-			"forms":      forms,
-		}
-
-		esPayload = append(esPayload, esPageLoad)
+		esPayload = append(esPayload, search.PageRecord{
+			RecordType: search.RecordTypePage,
+			DocumentId: documentId,
+			Page:       pageNum,
+			KeyPhrases: keyPhrases,
+			Entities:   entitiesDetected,
+			Text:       text,
+			Table:      table,
+			Forms:      forms,
+		})
 		pageNum = pageNum + 1
+	}
+
+	// Bulk post the document and its pages to the search index
+	indexer, err := h.searchIndex()
+	if err != nil {
+		log.Println("Error connecting to ES: ", err)
+		failerr := h.pipelineOperationsClient.StageFailed(operationsBody, "Could not connect to the search index")
+		if failerr != nil {
+			log.Printf("Error updating pipeline stage for document %s. Error: %s \n", documentId, failerr)
+		}
+		return err
+	}
+	if indexer != nil {
+		documentRecord := search.NewDocumentRecord(documentId, documentName, bucketName, objectName, esPayload)
 
-		// Marshal ES payload
-		esPageBytes, err := json.Marshal(esPageLoad)
+		// Copy the registry metadata onto the records so searches can filter and authorize on it
+		registryItem, err := h.documentRegistryStore.GetDocument(documentId)
 		if err != nil {
-			log.Println("Error serializing response: ", err)
-			return err
+			log.Printf("Could not read the registry record of document %s, indexing without it. Error: %s \n", documentId, err)
 		}
+		documentRecord.RecordMetadata = search.NewRecordMetadata(registryItem, time.Now())
 
-		// Bulk post to ES
-		if h.es != nil {
-			err = h.es.PostBulk(documentId, esPageBytes)
-			if err != nil {
-				log.Println("Error writing to ES: ", err)
-				failerr := h.pipelineOperationsClient.StageFailed(operationsBody, "Failed to write comprehend payload to ES")
-				if failerr != nil {
-					log.Printf("Error updating pipeline stage for document %s. Error: %s \n", documentId, failerr)
-				}
-				return err
+		result, err := indexer.IndexDocument(documentRecord, esPayload)
+		if err != nil {
+			log.Println("Error writing to ES: ", err)
+			failMessage := "Failed to write comprehend payload to ES"
+			var bulkErr *awshelper.BulkIndexError
+			if errors.As(err, &bulkErr) {
+				failMessage = fmt.Sprintf("%s: %d of %d records failed, %d parked for replay under %s", failMessage, len(bulkErr.Failures), len(esPayload)+1, bulkErr.DeadLettered(), h.esDeadLetterPrefix)
 			}
-			log.Println("data uploaded to ES")
-		} else {
-			log.Println("Elasticsearch is not configured. Skipping ES upload.")
+			failerr := h.pipelineOperationsClient.StageFailed(operationsBody, failMessage)
+			if failerr != nil {
+				log.Printf("Error updating pipeline stage for document %s. Error: %s \n", documentId, failerr)
+			}
+			return err
 		}
+		log.Printf("Indexed %d search records for document %s \n", result.Indexed, documentId)
+
+		// Record the lineage of the index write
+		var indexLineageBody = map[string]interface{}{
+			"documentId":       documentId,
+			"callerId":         callerId,
+			"sourceBucketName": bucketName,
+			"targetBucketName": h.searchConfig.Target(),
+			"sourceFileName":   objectName,
+			"targetFileName":   documentId,
+			"stage":            PIPELINE_STAGE,
+		}
+		err = h.documentLineageClient.RecordLineageOfIndexWrite(indexLineageBody)
+		if err != nil {
+			log.Printf("Error recording lineage of the index write for document %s. Error: %s \n", documentId, err)
+		}
+	} else {
+		log.Println("The search index is not configured. Skipping indexing.")
 	}
 
 	// Marshal ES payload
@@ -300,6 +357,7 @@ func (h *handler) runComprehend(bucketName string, objectName string, callerId s
 		"targetBucketName": h.comprehendBucketName,
 		"sourceFileName":   objectName,
 		"targetFileName":   comprehendFileName,
+		"stage":            PIPELINE_STAGE,
 	}
 
 	err = h.documentLineageClient.RecordLineage(lineageBody)
@@ -318,7 +376,10 @@ func (h *handler) runComprehend(bucketName string, objectName string, callerId s
 
 }
 
-func (h *handler) handleRequest(ctx context.Context, s3Event events.S3Event) error {
+func (h *handler) handleRequest(ctx context.Context, s3Event events.S3Event) (err error) {
+	// Publish the metadata events buffered during the invocation
+	defer h.metadataBatch.FlushOnReturn(&err)
+
 	// Print the event
 	log.Printf("Comprehend Processor event: {%+v} \n", s3Event)
 
@@ -346,40 +407,49 @@ func (h *handler) handleRequest(ctx context.Context, s3Event events.S3Event) err
 // main is called only once, when the Lambda is initialised (started for the first time). Code in this function should
 // primarily be used to create service clients, read environments variables, read configuration from disk etc.
 func main() {
-	metadataTopic := os.Getenv("METADATA_SNS_TOPIC_ARN")
+	metadataTopic := metadata.TargetArnFromEnv()
 	comprehendBucketName := os.Getenv("TARGET_COMPREHEND_BUCKET")
-	esCluster := os.Getenv("TARGET_ES_CLUSTER")
-	esIndex := os.Getenv("ES_CLUSTER_INDEX")
+	searchConfig, err := search.BackendConfigFromEnv()
+	if err != nil {
+		panic(err)
+	}
+	esDeadLetterPrefix := os.Getenv("ES_DEAD_LETTER_PREFIX")
+	registryTable := os.Getenv("REGISTRY_TABLE")
 
 	if metadataTopic == "" {
-		panic("Missing METADATA_SNS_TOPIC_ARN environment variable.")
+		panic("Missing METADATA_TARGET_ARN or METADATA_SNS_TOPIC_ARN environment variable.")
 	}
 	if comprehendBucketName == "" {
 		panic("Missing TEXTRACT_RESULTS_BUCKET_NAME environment variable.")
 	}
-	if esCluster != "" {
-		esCluster = "https://" + esCluster
-	}
-	if esIndex == "" {
+	if searchConfig.ES.Index == "" {
 		panic("Missing ES_CLUSTER_INDEX environment variable.")
 	}
+	if registryTable == "" {
+		panic("Missing REGISTRY_TABLE environment variable.")
+	}
+	if esDeadLetterPrefix == "" {
+		esDeadLetterPrefix = "es-dead-letter"
+	}
 
 	// Create AWS helpers
 	s3helper := awshelper.S3Helper{S3Client: s3.New(awshelper.NewAWSSession())}
-	var eshelper *awshelper.ESHelper
-	if esCluster != "" {
-		eshelper = awshelper.NewESHelper(esCluster, esIndex)
-	}
+
+	// Buffer the metadata events of each invocation and publish them in batches
+	metadataBatch := metadata.NewMetadataBatch()
 
 	//Create Metadata Clients
-	pipelineClient := metadata.NewPipelineOperationsClient(metadataTopic)
-	lineageClient := metadata.NewDocumentLineageClient(metadataTopic)
+	pipelineClient := metadata.NewPipelineOperationsClient(metadataTopic, metadata.WithBatch(metadataBatch))
+	lineageClient := metadata.NewDocumentLineageClient(metadataTopic, metadata.WithBatch(metadataBatch))
 	h := handler{
+		metadataBatch:            metadataBatch,
 		pipelineOperationsClient: pipelineClient,
 		documentLineageClient:    lineageClient,
+		documentRegistryStore:    datastores.NewDocumentRegistryStore(registryTable),
 		s3:                       &s3helper,
 		comprehendBucketName:     comprehendBucketName,
-		es:                       eshelper,
+		searchConfig:             searchConfig,
+		esDeadLetterPrefix:       esDeadLetterPrefix,
 	}
 
 	lambda.Start(h.handleRequest)
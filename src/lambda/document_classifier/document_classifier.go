@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"os"
 
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/dreamspider42/document-processing-pipeline/src/awshelper"
@@ -12,11 +11,12 @@ import (
 	"golang.org/x/exp/slices"
 )
 
-var PIPELINE_STAGE = "DOCUMENT_CLASSIFIER"
+var PIPELINE_STAGE = metadata.StageDocumentClassifier
 var documentTypes = map[string][]string{}
 
 // Represents the resources used by the handler
 type handler struct {
+	metadataBatch            *metadata.MetadataBatch
 	pipelineOperationsClient *metadata.PipelineOperationsClient
 }
 
@@ -70,7 +70,10 @@ func (h *handler) startNLPProcessing(bucketName string, objectName string, docum
 }
 
 // Lambda request handler
-func (h *handler) handleRequest(ctx context.Context, dbEvent awshelper.DynamoDBEvent) error {
+func (h *handler) handleRequest(ctx context.Context, dbEvent awshelper.DynamoDBEvent) (err error) {
+	// Publish the metadata events buffered during the invocation
+	defer h.metadataBatch.FlushOnReturn(&err)
+
 	// Print the event
 	log.Printf("event: {%+v} \n", dbEvent)
 
@@ -116,15 +119,19 @@ func main() {
 	documentTypes["NLP_INVALID"] = []string{"classified_report"}
 
 	// Check for missing arguments
-	metadataTopic := os.Getenv("METADATA_SNS_TOPIC_ARN")
+	metadataTopic := metadata.TargetArnFromEnv()
 	if metadataTopic == "" {
-		panic("Missing METADATA_SNS_TOPIC_ARN environment variable.")
+		panic("Missing METADATA_TARGET_ARN or METADATA_SNS_TOPIC_ARN environment variable.")
 	}
 
+	// Buffer the metadata events of each invocation and publish them in batches
+	metadataBatch := metadata.NewMetadataBatch()
+
 	//Create Pipeline Operations Client
-	pipelineClient := metadata.NewPipelineOperationsClient(metadataTopic)
+	pipelineClient := metadata.NewPipelineOperationsClient(metadataTopic, metadata.WithBatch(metadataBatch))
 
 	h := handler{
+		metadataBatch:            metadataBatch,
 		pipelineOperationsClient: pipelineClient,
 	}
 
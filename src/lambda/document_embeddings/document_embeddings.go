@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/textract"
+	"github.com/dreamspider42/document-processing-pipeline/src/awshelper"
+	"github.com/dreamspider42/document-processing-pipeline/src/datastores"
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
+	"github.com/dreamspider42/document-processing-pipeline/src/search"
+	"github.com/dreamspider42/document-processing-pipeline/src/textractparser"
+)
+
+const (
+	PIPELINE_STAGE = metadata.StageSyncProcessEmbeddings
+)
+
+// Represents the resources used by the handler
+type handler struct {
+	metadataBatch            *metadata.MetadataBatch
+	pipelineOperationsClient *metadata.PipelineOperationsClient
+	documentLineageClient    *metadata.DocumentLineageClient
+	documentRegistryStore    *datastores.DocumentRegistryStore
+	embeddingProvider        search.EmbeddingProvider
+	s3                       *awshelper.S3Helper
+	indexer                  search.SearchIndexer
+	searchConfig             search.BackendConfig
+	esDeadLetterPrefix       string
+	comprehendBucketName     string
+	textractBucketName       string
+	chunkCharacters          int
+}
+
+// Open the search index on first use so a cluster outage fails the stage instead of the whole Lambda
+func (h *handler) searchIndex() (search.SearchIndexer, error) {
+	if h.indexer != nil {
+		return h.indexer, nil
+	}
+
+	h.searchConfig.DeadLetter = &awshelper.ESDeadLetter{
+		S3:         h.s3,
+		BucketName: h.comprehendBucketName,
+		Prefix:     h.esDeadLetterPrefix,
+	}
+	indexer, err := search.OpenIndexer(h.searchConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	h.indexer = indexer
+	return h.indexer, nil
+}
+
+// Mark the stage failed, logging rather than masking the original error if that fails too
+func (h *handler) stageFailed(operationsBody map[string]interface{}, message string) {
+	failerr := h.pipelineOperationsClient.StageFailed(operationsBody, message)
+	if failerr != nil {
+		log.Printf("Error updating pipeline stage for document %s. Error: %s \n", operationsBody["documentId"], failerr)
+	}
+}
+
+// Chunk the Textract text of a document, embed the chunks and index them with their page citations
+func (h *handler) embedDocument(objectName string, callerId string) error {
+	documentId, documentName, err := search.ParseComprehendOutputKey(objectName)
+	if err != nil {
+		log.Printf("Skipping %s. Error: %s \n", objectName, err)
+		return nil
+	}
+	textractObjectName := fmt.Sprintf("%s/%s/ocr-analysis/fullresponse.json", documentId, documentName)
+
+	var operationsBody = map[string]interface{}{
+		"documentId": documentId,
+		"bucketName": h.textractBucketName,
+		"objectName": textractObjectName,
+		"stage":      PIPELINE_STAGE,
+	}
+
+	err = h.pipelineOperationsClient.StageInProgress(operationsBody, "")
+	if err != nil {
+		log.Printf("Error updating pipeline stage for document %s. Error: %s \n", documentId, err)
+		return err
+	}
+
+	// Without a provider vector search is disabled, and the stage completes without embedding anything
+	if h.embeddingProvider == nil {
+		log.Printf("No embedding provider configured, skipping the embeddings of document %s \n", documentId)
+		err = h.pipelineOperationsClient.StageSucceeded(operationsBody, "No embedding provider configured.")
+		if err != nil {
+			log.Printf("Error updating pipeline stage for document %s. Error: %s \n", documentId, err)
+		}
+		return err
+	}
+
+	textractOutputBytes, err := h.s3.ReadFromS3(h.textractBucketName, textractObjectName)
+	if err != nil {
+		log.Printf("Failed to read from S3. Error: %s \n", err)
+		h.stageFailed(operationsBody, "Could not read Textract results from S3.")
+		return err
+	}
+
+	var results *textract.AnalyzeDocumentOutput
+	err = json.Unmarshal(textractOutputBytes, &results)
+	if err != nil {
+		h.stageFailed(operationsBody, "Could not convert results from Textract into processable object. Try again.")
+		return err
+	}
+
+	document := textractparser.NewDocument(results)
+	chunks := []search.ChunkRecord{}
+	for i, page := range document.Pages {
+		chunks = append(chunks, search.ChunkPage(documentId, i+1, page, h.chunkCharacters)...)
+	}
+	log.Printf("Embedding %d chunks of document %s with %s \n", len(chunks), documentId, h.embeddingProvider.Name())
+
+	err = search.EmbedChunks(h.embeddingProvider, chunks)
+	if err != nil {
+		log.Printf("Failed to embed document %s. Error: %s \n", documentId, err)
+		h.stageFailed(operationsBody, "Could not compute the embeddings of the document text.")
+		return err
+	}
+
+	indexer, err := h.searchIndex()
+	if err != nil {
+		log.Println("Error connecting to ES: ", err)
+		h.stageFailed(operationsBody, "Could not connect to the search index")
+		return err
+	}
+
+	registryItem, err := h.documentRegistryStore.GetDocument(documentId)
+	if err != nil {
+		log.Printf("Could not read the registry record of document %s, indexing without it. Error: %s \n", documentId, err)
+	}
+	result, err := indexer.IndexChunks(documentId, search.NewRecordMetadata(registryItem, time.Now()), chunks)
+	if err != nil {
+		log.Println("Error writing to ES: ", err)
+		failMessage := "Failed to write embeddings to ES"
+		var bulkErr *awshelper.BulkIndexError
+		if errors.As(err, &bulkErr) {
+			failMessage = fmt.Sprintf("%s: %d of %d records failed, %d parked for replay under %s", failMessage, len(bulkErr.Failures), len(chunks), bulkErr.DeadLettered(), h.esDeadLetterPrefix)
+		}
+		h.stageFailed(operationsBody, failMessage)
+		return err
+	}
+	log.Printf("Indexed %d chunk records for document %s \n", result.Indexed, documentId)
+
+	// Record the lineage of the index write
+	var lineageBody = map[string]interface{}{
+		"documentId":       documentId,
+		"callerId":         callerId,
+		"sourceBucketName": h.textractBucketName,
+		"targetBucketName": h.searchConfig.Target(),
+		"sourceFileName":   textractObjectName,
+		"targetFileName":   documentId,
+		"stage":            PIPELINE_STAGE,
+	}
+	err = h.documentLineageClient.RecordLineageOfIndexWrite(lineageBody)
+	if err != nil {
+		log.Printf("Error recording lineage of the index write for document %s. Error: %s \n", documentId, err)
+	}
+
+	err = h.pipelineOperationsClient.StageSucceeded(operationsBody, "")
+	if err != nil {
+		log.Printf("Error updating pipeline stage for document %s. Error: %s \n", documentId, err)
+		return err
+	}
+	return nil
+}
+
+// Lambda request handler
+func (h *handler) handleRequest(ctx context.Context, s3Event events.S3Event) (err error) {
+	// Publish the metadata events buffered during the invocation
+	defer h.metadataBatch.FlushOnReturn(&err)
+
+	log.Printf("Document Embeddings event: {%+v} \n", s3Event)
+
+	// Get the caller id
+	lc, _ := lambdacontext.FromContext(ctx)
+	callerId := lc.InvokedFunctionArn
+
+	for _, record := range s3Event.Records {
+		s3 := record.S3
+		log.Printf("[%s - %s] Bucket = %s, Key = %s \n", record.EventSource, record.EventTime, s3.Bucket.Name, s3.Object.Key)
+
+		err := h.embedDocument(s3.Object.Key, callerId)
+		if err != nil {
+			log.Printf("Failed to embed document. Error: %s \n", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// main is called only once, when the Lambda is initialised (started for the first time). Code in this function should
+// primarily be used to create service clients, read environments variables, read configuration from disk etc.
+func main() {
+	metadataTopic := metadata.TargetArnFromEnv()
+	comprehendBucketName := os.Getenv("TARGET_COMPREHEND_BUCKET")
+	textractBucketName := os.Getenv("TEXTRACT_RESULTS_BUCKET_NAME")
+	searchConfig, err := search.BackendConfigFromEnv()
+	if err != nil {
+		panic(err)
+	}
+	esDeadLetterPrefix := os.Getenv("ES_DEAD_LETTER_PREFIX")
+	registryTable := os.Getenv("REGISTRY_TABLE")
+	embeddingProvider := os.Getenv("EMBEDDING_PROVIDER")
+	embeddingEndpoint := os.Getenv("EMBEDDING_ENDPOINT")
+	chunkCharacters := os.Getenv("EMBEDDING_CHUNK_CHARACTERS")
+
+	if metadataTopic == "" {
+		panic("Missing METADATA_TARGET_ARN or METADATA_SNS_TOPIC_ARN environment variable.")
+	}
+	if comprehendBucketName == "" {
+		panic("Missing TARGET_COMPREHEND_BUCKET environment variable.")
+	}
+	if textractBucketName == "" {
+		panic("Missing TEXTRACT_RESULTS_BUCKET_NAME environment variable.")
+	}
+	if !searchConfig.Configured() {
+		panic("Missing TARGET_ES_CLUSTER environment variable.")
+	}
+	if searchConfig.ES.Index == "" {
+		panic("Missing ES_CLUSTER_INDEX environment variable.")
+	}
+	if registryTable == "" {
+		panic("Missing REGISTRY_TABLE environment variable.")
+	}
+	if esDeadLetterPrefix == "" {
+		esDeadLetterPrefix = "es-dead-letter"
+	}
+
+	chunkSize := search.DefaultChunkCharacters
+	if chunkCharacters != "" {
+		size, err := strconv.Atoi(chunkCharacters)
+		if err != nil || size <= 0 {
+			panic("EMBEDDING_CHUNK_CHARACTERS must be a positive number.")
+		}
+		chunkSize = size
+	}
+
+	provider, err := search.NewEmbeddingProvider(embeddingProvider, embeddingEndpoint)
+	if err != nil {
+		panic(err)
+	}
+
+	// Create AWS helpers
+	s3helper := awshelper.S3Helper{S3Client: s3.New(awshelper.NewAWSSession())}
+
+	// Buffer the metadata events of each invocation and publish them in batches
+	metadataBatch := metadata.NewMetadataBatch()
+
+	h := handler{
+		metadataBatch:            metadataBatch,
+		pipelineOperationsClient: metadata.NewPipelineOperationsClient(metadataTopic, metadata.WithBatch(metadataBatch)),
+		documentLineageClient:    metadata.NewDocumentLineageClient(metadataTopic, metadata.WithBatch(metadataBatch)),
+		documentRegistryStore:    datastores.NewDocumentRegistryStore(registryTable),
+		embeddingProvider:        provider,
+		s3:                       &s3helper,
+		searchConfig:             searchConfig,
+		esDeadLetterPrefix:       esDeadLetterPrefix,
+		comprehendBucketName:     comprehendBucketName,
+		textractBucketName:       textractBucketName,
+		chunkCharacters:          chunkSize,
+	}
+
+	lambda.Start(h.handleRequest)
+}
@@ -6,27 +6,49 @@ import (
 	"log"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/dreamspider42/document-processing-pipeline/src/awshelper"
+	"github.com/dreamspider42/document-processing-pipeline/src/datastores"
 	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
-	"github.com/google/uuid"
 )
 
 // Represents the resources used by the handler
 type handler struct {
-	documentRegistryClient *metadata.DocumentRegistryClient
-	documentLineageClient  *metadata.DocumentLineageClient
-	s3                     *awshelper.S3Helper
+	outboxStore      *datastores.MetadataOutboxStore
+	documentMetadata map[string]interface{}
+	s3               *awshelper.S3Helper
 }
 
-// Process a create event from S3
-func (h *handler) processCreateRequest(bucketName string, documentName string, documentVersion string, principalIAMWriter events.S3UserIdentity, eventName string) error {
+// Write the metadata events of an S3 event to the outbox in one transaction, for the relay to publish
+func (h *handler) writeOutbox(outboxId string, metadataEvents ...metadata.Event) error {
+	items := make([]datastores.MetadataOutboxItem, 0, len(metadataEvents))
+	for _, event := range metadataEvents {
+		message, err := metadata.MarshalEvent(event)
+		if err != nil {
+			return err
+		}
+		header := metadata.HeaderOf(event)
+		items = append(items, datastores.MetadataOutboxItem{
+			OutboxId:     outboxId,
+			MetadataType: event.Type(),
+			DocumentId:   header.DocumentId,
+			Message:      string(message),
+			Timestamp:    header.Timestamp,
+		})
+	}
+	return h.outboxStore.AddEvents(items)
+}
+
+// Process a create event from S3. The documentId is derived from the event, so a retry tags the object and
+// writes the outbox with the same id, and the outbox keeps only the first write.
+func (h *handler) processCreateRequest(bucketName string, documentName string, documentVersion string, sequencer string, principalIAMWriter events.S3UserIdentity, eventName string) error {
 
-	// New uuid for item id
-	documentId := uuid.New().String()
+	// Item id derived from the S3 event
+	documentId := metadata.DocumentIdOf(bucketName, documentName, documentVersion, sequencer)
 
 	// Log what we're tagging and tag S3 with metadata.
 	log.Printf("Input Object: %s/%s version %s \n", bucketName, documentName, documentVersion)
@@ -39,39 +61,34 @@ func (h *handler) processCreateRequest(bucketName string, documentName string, d
 
 	// Create document link for s3 object
 	documentLink := fmt.Sprintf("s3://%s/%s", bucketName, documentName)
-
-	// Create metadata registry item
-	registryItem := map[string]interface{}{
-		"documentId":         documentId,
-		"bucketName":         bucketName,
-		"documentName":       documentName,
-		"documentLink":       documentLink,
-		"principalIAMWriter": principalIAMWriter,
+	timestamp := metadata.FormatTimestamp(time.Now())
+
+	// Create metadata registry event
+	registryEvent := &metadata.RegistryEvent{
+		EventHeader:        metadata.EventHeader{DocumentId: documentId, Timestamp: timestamp},
+		BucketName:         bucketName,
+		DocumentName:       documentName,
+		DocumentLink:       documentLink,
+		DocumentVersion:    documentVersion,
+		DocumentMetadata:   h.documentMetadata,
+		PrincipalIAMWriter: map[string]interface{}{"principalId": principalIAMWriter.PrincipalID},
+		LogicalDocumentId:  metadata.LogicalDocumentIdOf(bucketName, documentName),
+		Sequencer:          sequencer,
 	}
 
-	// Create metadata lineage item
-	lineageItem := map[string]interface{}{
-		"documentId":       documentId,
-		"callerId":         principalIAMWriter,
-		"targetBucketName": bucketName,
-		"targetFileName":   documentName,
-		"s3Event":          eventName,
+	// Create metadata lineage event
+	lineageEvent := &metadata.LineageEvent{
+		EventHeader:      metadata.EventHeader{DocumentId: documentId, Timestamp: timestamp},
+		CallerId:         metadata.CallerId{PrincipalId: principalIAMWriter.PrincipalID},
+		TargetBucketName: bucketName,
+		TargetFileName:   documentName,
+		S3Event:          eventName,
+		VersionId:        documentVersion,
 	}
 
-	if documentVersion != "" {
-		registryItem["documentVersion"] = documentVersion
-		lineageItem["versionId"] = documentVersion
-	}
-
-	err = h.documentRegistryClient.RegisterDocument(registryItem)
+	err = h.writeOutbox(documentId, registryEvent, lineageEvent)
 	if err != nil {
-		log.Printf("Failed to register document %s/%s with documentId %s. Error: %v", bucketName, documentName, documentId, err)
-		return err
-	}
-
-	err = h.documentLineageClient.RecordLineage(lineageItem)
-	if err != nil {
-		log.Printf("Failed to record lineage for document %s/%s with documentId %s. Error: %v", bucketName, documentName, documentId, err)
+		log.Printf("Failed to write the registry and lineage events of document %s/%s with documentId %s. Error: %v", bucketName, documentName, documentId, err)
 		return err
 	}
 
@@ -80,20 +97,19 @@ func (h *handler) processCreateRequest(bucketName string, documentName string, d
 }
 
 // Process a delete event from S3
-func (h *handler) processDeleteRequest(bucketName string, documentName string, documentVersion string, principalIAMWriter events.S3UserIdentity, eventName string) error {
+func (h *handler) processDeleteRequest(bucketName string, documentName string, documentVersion string, sequencer string, principalIAMWriter events.S3UserIdentity, eventName string) error {
 	log.Printf("Remove Object Processing: %s/%s version %s \n", bucketName, documentName, documentVersion)
 
-	lineageItem := map[string]interface{}{
-		"documentId":       "UNKNOWN_YET",
-		"callerId":         principalIAMWriter,
-		"targetBucketName": bucketName,
-		"targetFileName":   documentName,
-		"s3Event":          eventName,
-	}
-	if documentVersion != "" {
-		lineageItem["versionId"] = documentVersion
+	// The lineage consumer looks up the documentId of the removed object
+	lineageEvent := &metadata.LineageEvent{
+		EventHeader:      metadata.EventHeader{DocumentId: "UNKNOWN_YET"},
+		CallerId:         metadata.CallerId{PrincipalId: principalIAMWriter.PrincipalID},
+		TargetBucketName: bucketName,
+		TargetFileName:   documentName,
+		S3Event:          eventName,
+		VersionId:        documentVersion,
 	}
-	err := h.documentLineageClient.RecordLineage(lineageItem)
+	err := h.writeOutbox(metadata.DocumentIdOf(bucketName, documentName, documentVersion, sequencer), lineageEvent)
 	if err != nil {
 		log.Printf("Failed to record lineage for document %s/%s version %s. Error: %v", bucketName, documentName, documentVersion, err)
 		return err
@@ -116,9 +132,9 @@ func (h *handler) handleRequest(ctx context.Context, s3Event events.S3Event) err
 		eventName := record.EventName
 		var err error
 		if eventName == "ObjectRemoved:Delete" {
-			err = h.processDeleteRequest(s3.Bucket.Name, s3.Object.Key, s3.Object.VersionID, record.PrincipalID, eventName)
+			err = h.processDeleteRequest(s3.Bucket.Name, s3.Object.Key, s3.Object.VersionID, s3.Object.Sequencer, record.PrincipalID, eventName)
 		} else if strings.HasPrefix(eventName, "ObjectCreated") {
-			err = h.processCreateRequest(s3.Bucket.Name, s3.Object.Key, s3.Object.VersionID, record.PrincipalID, eventName)
+			err = h.processCreateRequest(s3.Bucket.Name, s3.Object.Key, s3.Object.VersionID, s3.Object.Sequencer, record.PrincipalID, eventName)
 		} else {
 			log.Printf("Processing not implement yet for event: %s \n", eventName)
 		}
@@ -133,23 +149,21 @@ func (h *handler) handleRequest(ctx context.Context, s3Event events.S3Event) err
 // main is called only once, when the Lambda is initialised (started for the first time). Code in this function should
 // primarily be used to create service clients, read environments variables, read configuration from disk etc.
 func main() {
-	metadataTopic := os.Getenv("METADATA_SNS_TOPIC_ARN")
-	if metadataTopic == "" {
-		panic("Missing METADATA_SNS_TOPIC_ARN environment variable.")
+	outboxTable := os.Getenv("METADATA_OUTBOX_TABLE")
+	if outboxTable == "" {
+		panic("Missing METADATA_OUTBOX_TABLE environment variable.")
 	}
 
 	// Create S3Helper
 	s3helper := awshelper.S3Helper{S3Client: s3.New(awshelper.NewAWSSession())}
 
-	// Create metadata clients
-	documentMetaData := map[string]interface{}{"owner": "CustomerName", "class": "external_public_report"}
-	registryClient := metadata.NewDocumentRegistryClient(metadataTopic, map[string]interface{}{"documentMetadata": documentMetaData})
-	lineageClient := metadata.NewDocumentLineageClient(metadataTopic)
+	// Create the outbox the relay publishes the metadata events from
+	outboxStore := datastores.NewMetadataOutboxStore(outboxTable)
 
 	h := handler{
-		documentRegistryClient: registryClient,
-		documentLineageClient:  lineageClient,
-		s3:                     &s3helper,
+		outboxStore:      outboxStore,
+		documentMetadata: map[string]interface{}{"owner": "CustomerName", "class": "external_public_report"},
+		s3:               &s3helper,
 	}
 
 	lambda.Start(h.handleRequest)
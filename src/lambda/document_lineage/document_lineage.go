@@ -8,21 +8,37 @@ import (
 	"os"
 	"strings"
 
-	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/dreamspider42/document-processing-pipeline/src/awshelper"
 	"github.com/dreamspider42/document-processing-pipeline/src/datastores"
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
+	"github.com/dreamspider42/document-processing-pipeline/src/search"
 )
 
+// Store the handler records lineage in, which local runs and tests replace
+type lineageStore interface {
+	QueryDocumentId(bucketName, fileName, versionId string) (string, error)
+	CreateLineage(item datastores.LineageItem) error
+}
+
 // Represents the resources used by the handler
 type handler struct {
-	lineageStore *datastores.LineageStore
-	queueArn     string
-	sqs          *awshelper.SQSHelper
+	lineageStore  lineageStore
+	searchIndexer search.SearchIndexer
+	queueArn      string
+	sqs           *awshelper.SQSHelper
+}
+
+// Remove a processed message from the queue. Events invoked directly have no message to remove.
+func (h *handler) deleteMessage(receipt string) error {
+	if receipt == "" {
+		return nil
+	}
+	return h.sqs.DeleteMessage(h.queueArn, receipt)
 }
 
-// Handle the Lineage processing.
+// Handle the Lineage processing. Removed objects are also removed from the search index.
 func (h *handler) postLineage(lineagePayload datastores.LineageItem, receipt string) error {
 	var actualDocumentId string
 	var err error
@@ -33,63 +49,57 @@ func (h *handler) postLineage(lineagePayload datastores.LineageItem, receipt str
 			return fmt.Errorf("unable to find documentId for %s/%s Version %s: %v", lineagePayload.TargetBucketName, lineagePayload.TargetFileName, lineagePayload.VersionId, err)
 		} else if actualDocumentId == "" {
 			log.Printf("Could not find corresponding documentId for this deletion event")
-			return h.sqs.DeleteMessage(h.queueArn, receipt)
+			return h.deleteMessage(receipt)
 		}
 		lineagePayload.DocumentId = actualDocumentId
+
+		err = h.searchIndexer.DeleteDocument(actualDocumentId)
+		if err != nil {
+			return fmt.Errorf("unable to remove document %s from the search index: %v", actualDocumentId, err)
+		}
 	}
 
 	err = h.lineageStore.CreateLineage(lineagePayload)
 	if err == nil {
 		// If no errors remove from the queue as processed.
-		err = h.sqs.DeleteMessage(h.queueArn, receipt)
+		err = h.deleteMessage(receipt)
 	} else {
 		return fmt.Errorf("unable to update progress of document %s: %v", lineagePayload.DocumentId, err)
 	}
 	return err
 }
 
-// Lambda request handler
-func (h *handler) handleRequest(ctx context.Context, event events.SQSEvent) error {
+// Lambda request handler. Events arrive from the lineage queue or directly from a metadata client targeting this function.
+func (h *handler) handleRequest(ctx context.Context, payload json.RawMessage) error {
+	received, err := metadata.UnwrapEvents(payload)
+	if err != nil {
+		return err
+	}
+
 	// Process each record in the event
-	for _, record := range event.Records {
-		if record.EventSourceARN != h.queueArn {
+	for _, record := range received {
+		if record.EventSourceARN != "" && record.EventSourceARN != h.queueArn {
 			return fmt.Errorf("unexpected lambda event source ARN. Expected %s, got %s", h.queueArn, record.EventSourceARN)
 		}
-		log.Printf("Record: %v \n", record)
-
-		// Unwrap the payload
-		payload := map[string]interface{}{}
-		log.Printf("Body: %v \n", record.Body)
-		err := json.Unmarshal([]byte(record.Body), &payload)
-		if err != nil {
-			return err
-		}
-
-		// Unwrap the message
-		message := map[string]interface{}{}
-		log.Printf("Payload: %v", payload["Message"])
-		err = json.Unmarshal([]byte(payload["Message"].(string)), &message)
+		log.Printf("Payload: %s", record.Message)
+		event, err := metadata.DecodeLineageEvent(record.Message)
 		if err != nil {
+			log.Printf("Rejecting lineage event: %v \n", err)
 			return err
 		}
 
 		receipt := record.ReceiptHandle
 		lineagePayload := datastores.LineageItem{
-			DocumentId:       message["documentId"].(string),
-			CallerId:         message["callerId"].(map[string]interface{}),
-			TargetFileName:   message["targetFileName"].(string),
-			TargetBucketName: message["targetBucketName"].(string),
-			Timestamp:        message["timestamp"].(string),
-			S3Event:          message["s3Event"].(string),
-		}
-
-		if message["versionId"] != nil {
-			lineagePayload.VersionId = message["versionId"].(string)
-		}
-
-		if message["s3Event"] == "ObjectCreated:Copy" {
-			lineagePayload.SourceFileName = message["sourceFileName"].(string)
-			lineagePayload.SourceBucketName = message["sourceBucketName"].(string)
+			DocumentId:       event.DocumentId,
+			CallerId:         event.CallerId.Map(),
+			TargetFileName:   event.TargetFileName,
+			TargetBucketName: event.TargetBucketName,
+			Timestamp:        event.Timestamp,
+			S3Event:          event.S3Event,
+			VersionId:        event.VersionId,
+			SourceFileName:   event.SourceFileName,
+			SourceBucketName: event.SourceBucketName,
+			Stage:            event.Stage,
 		}
 
 		err = h.postLineage(lineagePayload, receipt)
@@ -108,6 +118,10 @@ func main() {
 	LINEAGE_TABLE := os.Getenv("LINEAGE_TABLE")
 	LINEAGE_INDEX := os.Getenv("LINEAGE_INDEX")
 	SQS_QUEUE_ARN := os.Getenv("LINEAGE_SQS_QUEUE_ARN")
+	searchConfig, err := search.BackendConfigFromEnv()
+	if err != nil {
+		panic(err)
+	}
 
 	if LINEAGE_TABLE == "" {
 		panic("Missing LINEAGE_TABLE environment variable.")
@@ -118,17 +132,30 @@ func main() {
 	if SQS_QUEUE_ARN == "" {
 		panic("Missing LINEAGE_SQS_QUEUE_ARN environment variable.")
 	}
+	if !searchConfig.Configured() {
+		panic("Missing TARGET_ES_CLUSTER environment variable.")
+	}
+	if searchConfig.ES.Index == "" {
+		panic("Missing ES_CLUSTER_INDEX environment variable.")
+	}
 
 	// Create Document Lineage Store
 	documentLineageStore := datastores.NewLineageStore(LINEAGE_TABLE, LINEAGE_INDEX)
 
+	// Open the search index removed documents are deleted from
+	searchIndexer, err := search.OpenIndexer(searchConfig)
+	if err != nil {
+		panic(fmt.Sprintf("Could not open the search index: %v", err))
+	}
+
 	// Create SQS Helper
 	sqshelper := awshelper.SQSHelper{SQSClient: sqs.New(awshelper.NewAWSSession())}
 
 	h := handler{
-		queueArn:     SQS_QUEUE_ARN,
-		lineageStore: documentLineageStore,
-		sqs:          &sqshelper,
+		queueArn:      SQS_QUEUE_ARN,
+		lineageStore:  documentLineageStore,
+		searchIndexer: searchIndexer,
+		sqs:           &sqshelper,
 	}
 
 	lambda.Start(h.handleRequest)
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/dreamspider42/document-processing-pipeline/src/datastores"
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
+	"github.com/dreamspider42/document-processing-pipeline/src/search"
+	"golang.org/x/exp/slices"
+)
+
+// Lineage store keeping its records in memory, with the documents of the objects it knows
+type fakeLineageStore struct {
+	documentIds map[string]string
+	lineage     []datastores.LineageItem
+}
+
+func (s *fakeLineageStore) QueryDocumentId(bucketName, fileName, versionId string) (string, error) {
+	return s.documentIds[bucketName+"/"+fileName], nil
+}
+
+func (s *fakeLineageStore) CreateLineage(item datastores.LineageItem) error {
+	s.lineage = append(s.lineage, item)
+	return nil
+}
+
+// Search index recording the documents deleted from it
+type fakeIndexer struct {
+	search.SearchIndexer
+	deleted []string
+}
+
+func (i *fakeIndexer) DeleteDocument(documentId string) error {
+	i.deleted = append(i.deleted, documentId)
+	return nil
+}
+
+func ignore(ctx context.Context, payload json.RawMessage) error {
+	return nil
+}
+
+func TestLineageThroughBus(t *testing.T) {
+	event := func(documentId string, fileName string, s3Event string) *metadata.LineageEvent {
+		return &metadata.LineageEvent{
+			EventHeader:      metadata.EventHeader{DocumentId: documentId},
+			CallerId:         metadata.NewCallerId("arn:aws:lambda:us-east-1:000000000000:function:documentIngest"),
+			TargetBucketName: "bucket",
+			TargetFileName:   fileName,
+			S3Event:          s3Event,
+		}
+	}
+	tests := []struct {
+		name        string
+		events      []*metadata.LineageEvent
+		wantLineage []string
+		wantDeleted []string
+	}{
+		{
+			name:        "created object",
+			events:      []*metadata.LineageEvent{event("doc-1", "report.pdf", "ObjectCreated:Put")},
+			wantLineage: []string{"doc-1"},
+			wantDeleted: []string{},
+		},
+		{
+			name: "removed object recorded against its document and removed from the index",
+			events: []*metadata.LineageEvent{
+				event("doc-1", "report.pdf", "ObjectCreated:Put"),
+				event("unknown", "report.pdf", "ObjectRemoved:Delete"),
+			},
+			wantLineage: []string{"doc-1", "doc-1"},
+			wantDeleted: []string{"doc-1"},
+		},
+		{
+			name:        "removed object of no document",
+			events:      []*metadata.LineageEvent{event("unknown", "other.pdf", "ObjectRemoved:Delete")},
+			wantLineage: []string{},
+			wantDeleted: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeLineageStore{documentIds: map[string]string{"bucket/report.pdf": "doc-1"}}
+			indexer := &fakeIndexer{deleted: []string{}}
+			h := handler{lineageStore: store, searchIndexer: indexer}
+			bus := metadata.NewInMemoryBus()
+			bus.SubscribeConsumers(ignore, h.handleRequest, ignore, ignore)
+			client := metadata.NewDocumentLineageClient("", metadata.WithTransport(bus))
+
+			for _, e := range tt.events {
+				err := client.RecordLineageEvent(e)
+				if err != nil {
+					t.Fatalf("RecordLineageEvent() error = %v", err)
+				}
+			}
+
+			lineage := []string{}
+			for _, item := range store.lineage {
+				lineage = append(lineage, item.DocumentId)
+			}
+			if !slices.Equal(lineage, tt.wantLineage) {
+				t.Errorf("lineage of %v, want %v", lineage, tt.wantLineage)
+			}
+			if !slices.Equal(indexer.deleted, tt.wantDeleted) {
+				t.Errorf("deleted %v from the index, want %v", indexer.deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
@@ -14,10 +14,11 @@ import (
 	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
 )
 
-var PIPELINE_STAGE = "DOCUMENT_PROCESSOR"
+var PIPELINE_STAGE = metadata.StageDocumentProcessor
 
 // Represents the resources used by the handler
 type handler struct {
+	metadataBatch            *metadata.MetadataBatch
 	pipelineOperationsClient *metadata.PipelineOperationsClient
 	documentLineageClient    *metadata.DocumentLineageClient
 	s3                       *awshelper.S3Helper
@@ -88,6 +89,7 @@ func (h *handler) processRequest(documentId string, bucketName string, objectNam
 		"targetBucketName": targetBucketName,
 		"sourceFileName":   objectName,
 		"targetFileName":   targetFileName,
+		"stage":            PIPELINE_STAGE,
 	}
 	err = h.documentLineageClient.RecordLineageOfCopy(lineageBody)
 	if err != nil {
@@ -129,7 +131,10 @@ func (h *handler) processRecord(record awshelper.DynamoDBEventRecord, syncBucket
 }
 
 // Lambda request handler
-func (h *handler) handleRequest(ctx context.Context, dbEvent awshelper.DynamoDBEvent) error {
+func (h *handler) handleRequest(ctx context.Context, dbEvent awshelper.DynamoDBEvent) (err error) {
+	// Publish the metadata events buffered during the invocation
+	defer h.metadataBatch.FlushOnReturn(&err)
+
 	// Print the caller id
 	lc, _ := lambdacontext.FromContext(ctx)
 	callerId := lc.InvokedFunctionArn
@@ -139,7 +144,6 @@ func (h *handler) handleRequest(ctx context.Context, dbEvent awshelper.DynamoDBE
 	log.Printf("Event: %v \n", dbEvent)
 
 	// Process each record
-	var err error
 	for _, record := range dbEvent.Records {
 		log.Printf("Processing record: %v \n", record)
 
@@ -161,12 +165,12 @@ func (h *handler) handleRequest(ctx context.Context, dbEvent awshelper.DynamoDBE
 // primarily be used to create service clients, read environments variables, read configuration from disk etc.
 func main() {
 	// Check for missing arguments
-	metadataTopic := os.Getenv("METADATA_SNS_TOPIC_ARN")
+	metadataTopic := metadata.TargetArnFromEnv()
 	syncBucketName := os.Getenv("SYNC_TEXTRACT_BUCKET_NAME")
 	asyncBucketName := os.Getenv("ASYNC_TEXTRACT_BUCKET_NAME")
 
 	if metadataTopic == "" {
-		panic("Missing METADATA_SNS_TOPIC_ARN environment variable.")
+		panic("Missing METADATA_TARGET_ARN or METADATA_SNS_TOPIC_ARN environment variable.")
 	}
 	if syncBucketName == "" {
 		panic("Missing SYNC_TEXTRACT_BUCKET_NAME environment variable.")
@@ -178,11 +182,15 @@ func main() {
 	// Create S3Helper
 	s3helper := awshelper.S3Helper{S3Client: s3.New(awshelper.NewAWSSession())}
 
+	// Buffer the metadata events of each invocation and publish them in batches
+	metadataBatch := metadata.NewMetadataBatch()
+
 	//Create Metadata Clients
-	pipelineClient := metadata.NewPipelineOperationsClient(metadataTopic)
-	lineageClient := metadata.NewDocumentLineageClient(metadataTopic)
+	pipelineClient := metadata.NewPipelineOperationsClient(metadataTopic, metadata.WithBatch(metadataBatch))
+	lineageClient := metadata.NewDocumentLineageClient(metadataTopic, metadata.WithBatch(metadataBatch))
 
 	h := handler{
+		metadataBatch:            metadataBatch,
 		pipelineOperationsClient: pipelineClient,
 		documentLineageClient:    lineageClient,
 		s3:                       &s3helper,
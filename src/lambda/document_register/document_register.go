@@ -7,28 +7,50 @@ import (
 	"log"
 	"os"
 
-	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/dreamspider42/document-processing-pipeline/src/awshelper"
 	"github.com/dreamspider42/document-processing-pipeline/src/datastores"
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
 )
 
+// Stores the handler writes to, which local runs and tests replace
+type registryStore interface {
+	RegisterDocument(item datastores.DocumentRegistryItem) error
+}
+type logicalDocumentStore interface {
+	RecordRevision(revision datastores.DocumentRegistryItem) error
+}
+
 // Represents the resources used by the handler
 type handler struct {
-	documentRegistryStore *datastores.DocumentRegistryStore
+	documentRegistryStore registryStore
+	logicalDocumentStore  logicalDocumentStore
 	queueArn              string
 	sqs                   *awshelper.SQSHelper
 }
 
+// Remove a processed message from the queue. Events invoked directly have no message to remove.
+func (h *handler) deleteMessage(receipt string) error {
+	if receipt == "" {
+		return nil
+	}
+	return h.sqs.DeleteMessage(h.queueArn, receipt)
+}
+
 // Handle the registration processing
 func (h *handler) postRegistration(registryPayload datastores.DocumentRegistryItem, receipt string) error {
 	err := h.documentRegistryStore.RegisterDocument(registryPayload)
 
+	// Point the logical document at the revision, also when it was registered before the pointer was moved
+	if err == nil {
+		err = h.logicalDocumentStore.RecordRevision(registryPayload)
+	}
+
 	// If no errors remove from the queue as processed.
 	if err == nil {
-		h.sqs.DeleteMessage(h.queueArn, receipt)
+		h.deleteMessage(receipt)
 	} else {
 		log.Printf("Unable to update progress of document %s: %v \n", registryPayload.DocumentId, err)
 	}
@@ -36,44 +58,40 @@ func (h *handler) postRegistration(registryPayload datastores.DocumentRegistryIt
 	return err
 }
 
-// Lambda request handler
-func (h *handler) handleRequest(ctx context.Context, event events.SQSEvent) error {
+// Lambda request handler. Events arrive from the registry queue or directly from a metadata client targeting this function.
+func (h *handler) handleRequest(ctx context.Context, payload json.RawMessage) error {
+	received, err := metadata.UnwrapEvents(payload)
+	if err != nil {
+		return err
+	}
+
 	// Process each record in the event
-	for _, record := range event.Records {
-		if record.EventSourceARN != h.queueArn {
+	for _, record := range received {
+		if record.EventSourceARN != "" && record.EventSourceARN != h.queueArn {
 			return fmt.Errorf("unexpected lambda event source ARN. Expected %s, got %s", h.queueArn, record.EventSourceARN)
 		}
-		log.Printf("Record: %v \n", record)
-
-		// Unwrap the payload
-		payload := map[string]interface{}{}
-		log.Printf("Body: %v \n", record.Body)
-		err := json.Unmarshal([]byte(record.Body), &payload)
-		if err != nil {
-			return err
-		}
-
-		// Unwrap the message
-		message := map[string]interface{}{}
-		log.Printf("Payload: %v", payload["Message"])
-		err = json.Unmarshal([]byte(payload["Message"].(string)), &message)
+		log.Printf("Payload: %s", record.Message)
+		event, err := metadata.DecodeRegistryEvent(record.Message)
 		if err != nil {
+			log.Printf("Rejecting registry event: %v \n", err)
 			return err
 		}
 
 		receipt := record.ReceiptHandle
 		registryPayload := datastores.DocumentRegistryItem{
-			DocumentId:         message["documentId"].(string),
-			BucketName:         message["bucketName"].(string),
-			DocumentName:       message["documentName"].(string),
-			DocumentMetadata:   message["documentMetadata"].(map[string]interface{}),
-			DocumentLink:       message["documentLink"].(string),
-			PrincipalIAMWriter: message["principalIAMWriter"].(map[string]interface{}),
-			Timestamp:          message["timestamp"].(string),
+			DocumentId:         event.DocumentId,
+			BucketName:         event.BucketName,
+			DocumentName:       event.DocumentName,
+			DocumentMetadata:   event.DocumentMetadata,
+			DocumentLink:       event.DocumentLink,
+			PrincipalIAMWriter: event.PrincipalIAMWriter,
+			Timestamp:          event.Timestamp,
+			LogicalDocumentId:  event.LogicalDocumentId,
+			Sequencer:          event.Sequencer,
 		}
 
-		if payload["documentVersion"] != nil {
-			registryPayload.DocumentVersion = aws.String(payload["documentVersion"].(string))
+		if event.DocumentVersion != "" {
+			registryPayload.DocumentVersion = aws.String(event.DocumentVersion)
 		}
 
 		err = h.postRegistration(registryPayload, receipt)
@@ -89,10 +107,14 @@ func (h *handler) handleRequest(ctx context.Context, event events.SQSEvent) erro
 func main() {
 	// Check for missing arguments
 	REGISTRY_TABLE := os.Getenv("REGISTRY_TABLE")
+	LOGICAL_DOCUMENTS_TABLE := os.Getenv("LOGICAL_DOCUMENTS_TABLE")
 	SQS_QUEUE_ARN := os.Getenv("REGISTRY_SQS_QUEUE_ARN")
 	if REGISTRY_TABLE == "" {
 		panic("Missing REGISTRY_TABLE environment variable.")
 	}
+	if LOGICAL_DOCUMENTS_TABLE == "" {
+		panic("Missing LOGICAL_DOCUMENTS_TABLE environment variable.")
+	}
 	if SQS_QUEUE_ARN == "" {
 		panic("Missing REGISTRY_SQS_QUEUE_ARNenvironment variable.")
 	}
@@ -100,12 +122,16 @@ func main() {
 	// Create Document Registry Store
 	documentStore := datastores.NewDocumentRegistryStore(REGISTRY_TABLE)
 
+	// Create Logical Document Store
+	logicalDocumentStore := datastores.NewLogicalDocumentStore(LOGICAL_DOCUMENTS_TABLE)
+
 	// Create SQS Helper
 	sqshelper := awshelper.SQSHelper{SQSClient: sqs.New(awshelper.NewAWSSession())}
 
 	h := handler{
 		queueArn:              SQS_QUEUE_ARN,
 		documentRegistryStore: documentStore,
+		logicalDocumentStore:  logicalDocumentStore,
 		sqs:                   &sqshelper,
 	}
 
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/dreamspider42/document-processing-pipeline/src/datastores"
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
+)
+
+// Registry and logical document stores keeping their records in memory
+type fakeStores struct {
+	registerErr error
+	registered  []datastores.DocumentRegistryItem
+	revisions   []string
+}
+
+func (s *fakeStores) RegisterDocument(item datastores.DocumentRegistryItem) error {
+	if s.registerErr != nil {
+		return s.registerErr
+	}
+	s.registered = append(s.registered, item)
+	return nil
+}
+
+func (s *fakeStores) RecordRevision(revision datastores.DocumentRegistryItem) error {
+	s.revisions = append(s.revisions, revision.DocumentId)
+	return nil
+}
+
+func ignore(ctx context.Context, payload json.RawMessage) error {
+	return nil
+}
+
+func TestRegisterThroughBus(t *testing.T) {
+	event := func(documentId string, version string) *metadata.RegistryEvent {
+		return &metadata.RegistryEvent{
+			EventHeader:        metadata.EventHeader{DocumentId: documentId},
+			BucketName:         "bucket",
+			DocumentName:       "report.pdf",
+			DocumentLink:       "s3://bucket/report.pdf",
+			DocumentVersion:    version,
+			DocumentMetadata:   map[string]interface{}{"owner": "alice"},
+			PrincipalIAMWriter: map[string]interface{}{"principalId": "AWS:alice"},
+		}
+	}
+	tests := []struct {
+		name          string
+		events        []*metadata.RegistryEvent
+		registerErr   error
+		wantRevisions []string
+		wantErr       bool
+	}{
+		{
+			name:          "revisions registered in order",
+			events:        []*metadata.RegistryEvent{event("doc-1", "v1"), event("doc-2", "v2")},
+			wantRevisions: []string{"doc-1", "doc-2"},
+		},
+		{
+			name:          "unversioned object",
+			events:        []*metadata.RegistryEvent{event("doc-1", "")},
+			wantRevisions: []string{"doc-1"},
+		},
+		{
+			name:          "registry failure",
+			events:        []*metadata.RegistryEvent{event("doc-1", "v1")},
+			registerErr:   errors.New("throttled"),
+			wantRevisions: []string{},
+			wantErr:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stores := &fakeStores{registerErr: tt.registerErr, revisions: []string{}}
+			h := handler{documentRegistryStore: stores, logicalDocumentStore: stores}
+			bus := metadata.NewInMemoryBus()
+			bus.SubscribeConsumers(h.handleRequest, ignore, ignore, ignore)
+			client := metadata.NewDocumentRegistryClient("", nil, metadata.WithTransport(bus))
+
+			var err error
+			for _, e := range tt.events {
+				err = client.RegisterDocumentEvent(e)
+			}
+
+			subscriberErr := &metadata.SubscriberError{}
+			if tt.wantErr != errors.As(err, &subscriberErr) {
+				t.Fatalf("RegisterDocumentEvent() error = %v, want error %v", err, tt.wantErr)
+			}
+			if len(stores.revisions) != len(tt.wantRevisions) {
+				t.Fatalf("recorded revisions %v, want %v", stores.revisions, tt.wantRevisions)
+			}
+			for i, documentId := range tt.wantRevisions {
+				if stores.revisions[i] != documentId {
+					t.Errorf("recorded revisions %v, want %v", stores.revisions, tt.wantRevisions)
+				}
+				item := stores.registered[i]
+				e := tt.events[i]
+				if item.DocumentId != documentId || item.BucketName != e.BucketName || item.DocumentName != e.DocumentName ||
+					item.DocumentMetadata["owner"] != "alice" || item.Timestamp == "" {
+					t.Errorf("registered %+v", item)
+				}
+				if (item.DocumentVersion == nil) != (e.DocumentVersion == "") || aws.StringValue(item.DocumentVersion) != e.DocumentVersion {
+					t.Errorf("registered version %v, want %q", item.DocumentVersion, e.DocumentVersion)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/dreamspider42/document-processing-pipeline/src/datastores"
+	"github.com/dreamspider42/document-processing-pipeline/src/search"
+	"golang.org/x/exp/slices"
+)
+
+// Registry records the handler re-checks access against, as the DocumentRegistryStore provides them
+type registryStore interface {
+	GetDocument(documentId string) (*datastores.DocumentRegistryItem, error)
+}
+
+// Represents the resources used by the handler
+type handler struct {
+	documentRegistryStore registryStore
+	embeddingProvider     search.EmbeddingProvider
+	searcher              search.Searcher
+	searchConfig          search.BackendConfig
+	adminGroup            string
+	// Search access of IAM callers, by role or user ARN as returned by iamPrincipalArn
+	iamPrincipals map[string]iamAccess
+}
+
+// Search access granted to an IAM role or user in SEARCH_IAM_PRINCIPALS
+type iamAccess struct {
+	Owners []string `json:"owners"`
+	Admin  bool     `json:"admin"`
+}
+
+// Connect to the search index on first use so a cluster outage surfaces as a 503 instead of a crashed Lambda.
+// Searches never create or change indices; the pipeline's indexers manage them.
+func (h *handler) searchIndex() (search.Searcher, error) {
+	if h.searcher != nil {
+		return h.searcher, nil
+	}
+
+	searcher, err := search.OpenSearcher(h.searchConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	h.searcher = searcher
+	return h.searcher, nil
+}
+
+// Read the search access of IAM roles and users from a JSON object keyed by their ARN
+func parseIAMPrincipals(value string) (map[string]iamAccess, error) {
+	principals := map[string]iamAccess{}
+	if value == "" {
+		return principals, nil
+	}
+	configured := map[string]iamAccess{}
+	err := json.Unmarshal([]byte(value), &configured)
+	if err != nil {
+		return nil, fmt.Errorf("SEARCH_IAM_PRINCIPALS must be a JSON object of ARNs to {\"owners\": [...], \"admin\": bool}: %v", err)
+	}
+	for arn, access := range configured {
+		principals[iamPrincipalArn(arn)] = access
+	}
+	return principals, nil
+}
+
+// Role or user ARN of an IAM caller, without its path: assumed role sessions map to their role, so a caller
+// matches the role granted access whichever session it signed the request with
+func iamPrincipalArn(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" {
+		return arn
+	}
+	resource := strings.Split(parts[5], "/")
+	switch {
+	case parts[2] == "sts" && resource[0] == "assumed-role" && len(resource) >= 2:
+		return fmt.Sprintf("arn:%s:iam::%s:role/%s", parts[1], parts[4], resource[1])
+	case parts[2] == "iam" && (resource[0] == "role" || resource[0] == "user") && len(resource) >= 2:
+		return fmt.Sprintf("arn:%s:iam::%s:%s/%s", parts[1], parts[4], resource[0], resource[len(resource)-1])
+	}
+	return arn
+}
+
+// Split repeated and comma separated query string parameters into a single list
+func (h *handler) listParameter(request events.APIGatewayProxyRequest, name string) []string {
+	values := request.MultiValueQueryStringParameters[name]
+	if len(values) == 0 && request.QueryStringParameters[name] != "" {
+		values = []string{request.QueryStringParameters[name]}
+	}
+
+	list := []string{}
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+// Parse a date parameter given either as a date or as an RFC 3339 timestamp
+func (h *handler) timeParameter(request events.APIGatewayProxyRequest, name string) (*time.Time, error) {
+	value := request.QueryStringParameters[name]
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return &t, nil
+	}
+	return nil, fmt.Errorf("%s must be a date (2006-01-02) or an RFC 3339 timestamp", name)
+}
+
+// Build the search query from the query string parameters
+func (h *handler) parseQuery(request events.APIGatewayProxyRequest) (search.Query, error) {
+	params := request.QueryStringParameters
+	query := search.Query{
+		Text:            params["q"],
+		Mode:            params["mode"],
+		DocumentClasses: h.listParameter(request, "class"),
+		Facets:          h.listParameter(request, "facet"),
+		Highlight:       params["highlight"] == "true",
+	}
+
+	// Entities are given as TYPE or TYPE:value
+	entities := request.MultiValueQueryStringParameters["entity"]
+	if len(entities) == 0 && params["entity"] != "" {
+		entities = []string{params["entity"]}
+	}
+	for _, entity := range entities {
+		entityType, value, _ := strings.Cut(entity, ":")
+		query.Entities = append(query.Entities, search.EntityFilter{Type: strings.ToUpper(entityType), Value: value})
+	}
+
+	var err error
+	if query.RegisteredAfter, err = h.timeParameter(request, "registeredAfter"); err != nil {
+		return query, err
+	}
+	if query.RegisteredBefore, err = h.timeParameter(request, "registeredBefore"); err != nil {
+		return query, err
+	}
+	if params["from"] != "" {
+		if query.From, err = strconv.Atoi(params["from"]); err != nil {
+			return query, fmt.Errorf("from must be a number")
+		}
+	}
+	if params["size"] != "" {
+		if query.Size, err = strconv.Atoi(params["size"]); err != nil {
+			return query, fmt.Errorf("size must be a number")
+		}
+	}
+
+	return query, query.Validate()
+}
+
+// Identify the caller from a Cognito or Lambda authorizer, falling back to the IAM identity of the request.
+// IAM callers are matched against the S3 principal that wrote the document (AWS:<user id>), and get the owners
+// and admin access SEARCH_IAM_PRINCIPALS grants their role or user.
+func (h *handler) principal(request events.APIGatewayProxyRequest) search.Principal {
+	authorizer := request.RequestContext.Authorizer
+	claims, _ := authorizer["claims"].(map[string]interface{})
+	claim := func(names ...string) string {
+		for _, name := range names {
+			if value, ok := claims[name].(string); ok && value != "" {
+				return value
+			}
+			if value, ok := authorizer[name].(string); ok && value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+	split := func(value string) []string {
+		list := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		return list
+	}
+
+	principal := search.Principal{
+		Id:     claim("sub", "principalId"),
+		Owners: split(claim("custom:owners", "owners")),
+	}
+	identity := request.RequestContext.Identity
+	if principal.Id == "" && identity.User != "" {
+		principal.Id = "AWS:" + identity.User
+	}
+	principal.Admin = h.adminGroup != "" && slices.Contains(split(claim("cognito:groups", "groups")), h.adminGroup)
+
+	if identity.UserArn != "" {
+		if access, ok := h.iamPrincipals[iamPrincipalArn(identity.UserArn)]; ok {
+			principal.Owners = append(principal.Owners, access.Owners...)
+			principal.Admin = principal.Admin || access.Admin
+		}
+	}
+	return principal
+}
+
+// Respond with a JSON body
+func (h *handler) respond(status int, body interface{}) (events.APIGatewayProxyResponse, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusInternalServerError}, err
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: status,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(payload),
+	}, nil
+}
+
+// Lambda request handler
+func (h *handler) handleRequest(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	log.Printf("Search request: %v \n", request.QueryStringParameters)
+
+	query, err := h.parseQuery(request)
+	if err != nil {
+		return h.respond(http.StatusBadRequest, map[string]string{"message": err.Error()})
+	}
+	if query.Mode != search.SearchModeKeyword && h.embeddingProvider == nil {
+		return h.respond(http.StatusBadRequest, map[string]string{"message": fmt.Sprintf("%s search is not enabled: no embedding provider is configured", query.Mode)})
+	}
+	query.Principal = h.principal(request)
+
+	searcher, err := h.searchIndex()
+	if err != nil {
+		log.Printf("Could not connect to the search index. Error: %v \n", err)
+		return h.respond(http.StatusServiceUnavailable, map[string]string{"message": "search index unavailable"})
+	}
+
+	results, err := searcher.Search(query, h.embeddingProvider)
+	if err != nil {
+		log.Printf("Search failed. Error: %v \n", err)
+		return h.respond(http.StatusBadGateway, map[string]string{"message": "search failed"})
+	}
+
+	// Join the registry record of each document, re-checking access against the registry itself
+	hits := []search.Hit{}
+	for _, hit := range results.Hits {
+		registryItem, err := h.documentRegistryStore.GetDocument(hit.DocumentId)
+		if err != nil {
+			log.Printf("Could not read registry record of document %s. Error: %v \n", hit.DocumentId, err)
+			return h.respond(http.StatusInternalServerError, map[string]string{"message": "registry lookup failed"})
+		}
+		if registryItem == nil {
+			log.Printf("Document %s is indexed but not registered \n", hit.DocumentId)
+			continue
+		}
+		recordMetadata := search.NewRecordMetadata(registryItem, time.Time{})
+		if !query.Principal.CanAccess(recordMetadata.Owner, recordMetadata.Writer) {
+			log.Printf("Dropping document %s: registry denies access to %s \n", hit.DocumentId, query.Principal.Id)
+			continue
+		}
+		hit.Registry = registryItem
+		hits = append(hits, hit)
+	}
+
+	// The index counted the dropped documents, so take them off its total, which may still count those of other pages
+	if dropped := len(results.Hits) - len(hits); dropped > 0 {
+		results.Total -= dropped
+		if results.Total < query.From+len(hits) {
+			results.Total = query.From + len(hits)
+		}
+		results.TotalApproximate = true
+	}
+	results.Hits = hits
+
+	return h.respond(http.StatusOK, results)
+}
+
+// main is called only once, when the Lambda is initialised (started for the first time). Code in this function should
+// primarily be used to create service clients, read environments variables, read configuration from disk etc.
+func main() {
+	registryTable := os.Getenv("REGISTRY_TABLE")
+	searchConfig, err := search.BackendConfigFromEnv()
+	if err != nil {
+		panic(err)
+	}
+	adminGroup := os.Getenv("SEARCH_ADMIN_GROUP")
+	iamPrincipals, err := parseIAMPrincipals(os.Getenv("SEARCH_IAM_PRINCIPALS"))
+	if err != nil {
+		panic(err)
+	}
+	embeddingProvider := os.Getenv("EMBEDDING_PROVIDER")
+	embeddingEndpoint := os.Getenv("EMBEDDING_ENDPOINT")
+
+	if registryTable == "" {
+		panic("Missing REGISTRY_TABLE environment variable.")
+	}
+	if !searchConfig.Configured() {
+		panic("Missing TARGET_ES_CLUSTER environment variable.")
+	}
+	if searchConfig.ES.Index == "" {
+		panic("Missing ES_CLUSTER_INDEX environment variable.")
+	}
+
+	// Queries are embedded with the same provider as the chunks, only keyword search works without one
+	provider, err := search.NewEmbeddingProvider(embeddingProvider, embeddingEndpoint)
+	if err != nil {
+		panic(err)
+	}
+
+	h := handler{
+		documentRegistryStore: datastores.NewDocumentRegistryStore(registryTable),
+		embeddingProvider:     provider,
+		searchConfig:          searchConfig,
+		adminGroup:            adminGroup,
+		iamPrincipals:         iamPrincipals,
+	}
+
+	lambda.Start(h.handleRequest)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/dreamspider42/document-processing-pipeline/src/datastores"
+	"github.com/dreamspider42/document-processing-pipeline/src/search"
+	"golang.org/x/exp/slices"
+)
+
+func TestIAMPrincipalArn(t *testing.T) {
+	tests := []struct {
+		arn  string
+		want string
+	}{
+		{"arn:aws:sts::123456789012:assumed-role/Readers/session-1", "arn:aws:iam::123456789012:role/Readers"},
+		{"arn:aws:iam::123456789012:role/teams/Readers", "arn:aws:iam::123456789012:role/Readers"},
+		{"arn:aws:iam::123456789012:user/ops/jane", "arn:aws:iam::123456789012:user/jane"},
+		{"arn:aws-us-gov:sts::123456789012:assumed-role/Readers/s", "arn:aws-us-gov:iam::123456789012:role/Readers"},
+		{"arn:aws:iam::123456789012:root", "arn:aws:iam::123456789012:root"},
+		{"not-an-arn", "not-an-arn"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arn, func(t *testing.T) {
+			if got := iamPrincipalArn(tt.arn); got != tt.want {
+				t.Errorf("iamPrincipalArn(%q) = %q, want %q", tt.arn, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrincipal(t *testing.T) {
+	iamPrincipals, err := parseIAMPrincipals(`{
+		"arn:aws:iam::123456789012:role/path/Readers": {"owners": ["CustomerName"]},
+		"arn:aws:iam::123456789012:user/admin": {"admin": true}
+	}`)
+	if err != nil {
+		t.Fatalf("parseIAMPrincipals() error = %v", err)
+	}
+	h := &handler{adminGroup: "document-admins", iamPrincipals: iamPrincipals}
+
+	tests := []struct {
+		name       string
+		context    events.APIGatewayProxyRequestContext
+		wantId     string
+		wantOwners []string
+		wantAdmin  bool
+	}{
+		{
+			name: "IAM role session granted owners",
+			context: events.APIGatewayProxyRequestContext{Identity: events.APIGatewayRequestIdentity{
+				User: "AROAEXAMPLE:session-1", UserArn: "arn:aws:sts::123456789012:assumed-role/Readers/session-1",
+			}},
+			wantId:     "AWS:AROAEXAMPLE:session-1",
+			wantOwners: []string{"CustomerName"},
+		},
+		{
+			name: "IAM user granted admin",
+			context: events.APIGatewayProxyRequestContext{Identity: events.APIGatewayRequestIdentity{
+				User: "AIDAEXAMPLE", UserArn: "arn:aws:iam::123456789012:user/admin",
+			}},
+			wantId:     "AWS:AIDAEXAMPLE",
+			wantOwners: []string{},
+			wantAdmin:  true,
+		},
+		{
+			name: "IAM caller without configured access",
+			context: events.APIGatewayProxyRequestContext{Identity: events.APIGatewayRequestIdentity{
+				User: "AROAOTHER:s", UserArn: "arn:aws:sts::123456789012:assumed-role/Other/s",
+			}},
+			wantId:     "AWS:AROAOTHER:s",
+			wantOwners: []string{},
+		},
+		{
+			name: "Cognito claims",
+			context: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{
+				"claims": map[string]interface{}{"sub": "user-1", "custom:owners": "A, B", "cognito:groups": "document-admins"},
+			}},
+			wantId:     "user-1",
+			wantOwners: []string{"A", "B"},
+			wantAdmin:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal := h.principal(events.APIGatewayProxyRequest{RequestContext: tt.context})
+			if principal.Id != tt.wantId || !slices.Equal(principal.Owners, tt.wantOwners) || principal.Admin != tt.wantAdmin {
+				t.Errorf("principal() = %+v, want id %q, owners %v, admin %v", principal, tt.wantId, tt.wantOwners, tt.wantAdmin)
+			}
+		})
+	}
+}
+
+func TestParseIAMPrincipalsInvalid(t *testing.T) {
+	if _, err := parseIAMPrincipals(`["arn"]`); err == nil {
+		t.Error("parseIAMPrincipals() accepted a list")
+	}
+}
+
+// Searcher finding no documents
+type emptySearcher struct{}
+
+func (emptySearcher) Search(q search.Query, provider search.EmbeddingProvider) (*search.Results, error) {
+	return &search.Results{Hits: []search.Hit{}}, nil
+}
+
+func TestSearchWithoutEmbeddingProvider(t *testing.T) {
+	tests := []struct {
+		mode       string
+		wantStatus int
+	}{
+		{mode: "", wantStatus: http.StatusOK},
+		{mode: search.SearchModeKeyword, wantStatus: http.StatusOK},
+		{mode: search.SearchModeVector, wantStatus: http.StatusBadRequest},
+		{mode: search.SearchModeHybrid, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			h := &handler{searcher: emptySearcher{}}
+			request := events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"q": "invoice", "mode": tt.mode}}
+			response, err := h.handleRequest(context.Background(), request)
+			if err != nil {
+				t.Fatalf("handleRequest() error = %v", err)
+			}
+			if response.StatusCode != tt.wantStatus {
+				t.Errorf("status %d, want %d: %s", response.StatusCode, tt.wantStatus, response.Body)
+			}
+		})
+	}
+}
+
+// Searcher returning the same results to every query
+type fixedSearcher struct {
+	results search.Results
+}
+
+func (s fixedSearcher) Search(q search.Query, provider search.EmbeddingProvider) (*search.Results, error) {
+	results := s.results
+	results.Hits = append([]search.Hit{}, s.results.Hits...)
+	return &results, nil
+}
+
+// Registry of documents by owner
+type fakeRegistryStore struct {
+	owners map[string]string
+}
+
+func (s *fakeRegistryStore) GetDocument(documentId string) (*datastores.DocumentRegistryItem, error) {
+	owner, ok := s.owners[documentId]
+	if !ok {
+		return nil, nil
+	}
+	return &datastores.DocumentRegistryItem{DocumentId: documentId, DocumentMetadata: map[string]interface{}{"owner": owner}}, nil
+}
+
+func TestSearchRegistryRecheck(t *testing.T) {
+	registry := &fakeRegistryStore{owners: map[string]string{"a": "CustomerName", "b": "OtherCustomer", "d": "CustomerName"}}
+	tests := []struct {
+		name            string
+		hits            []string
+		total           int
+		from            string
+		wantHits        []string
+		wantTotal       int
+		wantApproximate bool
+	}{
+		{name: "all readable", hits: []string{"a", "d"}, total: 2, wantHits: []string{"a", "d"}, wantTotal: 2},
+		{name: "owner changed and unregistered documents dropped", hits: []string{"a", "b", "c"}, total: 10, wantHits: []string{"a"}, wantTotal: 8, wantApproximate: true},
+		{name: "total never below the documents seen", hits: []string{"b", "d"}, total: 2, from: "5", wantHits: []string{"d"}, wantTotal: 6, wantApproximate: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := search.Results{Total: tt.total}
+			for _, documentId := range tt.hits {
+				results.Hits = append(results.Hits, search.Hit{DocumentId: documentId})
+			}
+			h := &handler{documentRegistryStore: registry, searcher: fixedSearcher{results: results}}
+			request := events.APIGatewayProxyRequest{
+				QueryStringParameters: map[string]string{"q": "invoice", "from": tt.from},
+				RequestContext: events.APIGatewayProxyRequestContext{Authorizer: map[string]interface{}{
+					"principalId": "reader", "owners": "CustomerName",
+				}},
+			}
+
+			response, err := h.handleRequest(context.Background(), request)
+			if err != nil {
+				t.Fatalf("handleRequest() error = %v", err)
+			}
+			got := search.Results{}
+			err = json.Unmarshal([]byte(response.Body), &got)
+			if err != nil {
+				t.Fatalf("response %d %s: %v", response.StatusCode, response.Body, err)
+			}
+			hits := []string{}
+			for _, hit := range got.Hits {
+				hits = append(hits, hit.DocumentId)
+			}
+			if !slices.Equal(hits, tt.wantHits) {
+				t.Errorf("hits %v, want %v", hits, tt.wantHits)
+			}
+			if got.Total != tt.wantTotal || got.TotalApproximate != tt.wantApproximate {
+				t.Errorf("total %d (approximate %v), want %d (approximate %v)", got.Total, got.TotalApproximate, tt.wantTotal, tt.wantApproximate)
+			}
+		})
+	}
+}
@@ -3,27 +3,53 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 
-	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/dreamspider42/document-processing-pipeline/src/awshelper"
 	"github.com/dreamspider42/document-processing-pipeline/src/datastores"
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
 )
 
+// Store the handler applies the events to, which local runs and tests replace
+type operationsStore interface {
+	StartDocumentTracking(item datastores.PipelineOperationsItem, receipt string) error
+	UpdateDocumentStatus(documentId, eventId, status, stage, timestamp string, messageNote interface{}) error
+}
+
 // Represents the resources used by the handler
 type handler struct {
-	pipelineOpsStore *datastores.PipelineOperationsStore
-	queueArn         string
-	sqs              *awshelper.SQSHelper
+	pipelineOpsStore         operationsStore
+	pipelineOperationsClient *metadata.PipelineOperationsClient
+	queueArn                 string
+	sqs                      *awshelper.SQSHelper
+}
+
+// Report an event the pipeline state machine rejected and drop it, as retrying would reject it again
+func (h *handler) rejectTransition(invalid *metadata.InvalidTransitionError, receipt string) error {
+	log.Printf("Rejected event: %v \n", invalid)
+	err := h.pipelineOperationsClient.ReportInvalidTransition(invalid, metadata.DetectedByStore)
+	if err != nil {
+		return err
+	}
+	return h.deleteMessage(receipt)
+}
+
+// Remove a processed message from the queue. Events invoked directly have no message to remove.
+func (h *handler) deleteMessage(receipt string) error {
+	if receipt == "" {
+		return nil
+	}
+	return h.sqs.DeleteMessage(h.queueArn, receipt)
 }
 
 // Start tracking the document progress in the pipeline.
-func (h *handler) startDocumentTracking(documentId, bucketName, objectName, status, stage, timestamp, receipt string, versionId interface{}) error {
+func (h *handler) startDocumentTracking(documentId, eventId, bucketName, objectName, status, stage, timestamp, receipt, versionId string) error {
 	// Create the document payload
 	documentPayload := datastores.PipelineOperationsItem{
 		DocumentId:     documentId,
@@ -31,6 +57,7 @@ func (h *handler) startDocumentTracking(documentId, bucketName, objectName, stat
 		ObjectName:     objectName,
 		DocumentStatus: status,
 		DocumentStage:  stage,
+		LastUpdate:     timestamp,
 		Timeline: []datastores.TimelineItem{
 			{
 				Timestamp: timestamp,
@@ -38,72 +65,81 @@ func (h *handler) startDocumentTracking(documentId, bucketName, objectName, stat
 				Status:    status,
 			},
 		},
+		ProcessedEventIds: []string{eventId},
 	}
-	if versionId != nil {
-		documentPayload.DocumentVersion = aws.String(versionId.(string))
+	if versionId != "" {
+		documentPayload.DocumentVersion = aws.String(versionId)
 	}
 
 	// Start tracking the document
 	err := h.pipelineOpsStore.StartDocumentTracking(documentPayload, receipt)
+	invalid := &metadata.InvalidTransitionError{}
+	if errors.As(err, &invalid) {
+		return h.rejectTransition(invalid, receipt)
+	}
 	if err != nil {
 		return err
 	}
 
 	// Remove the message from the queue if processed successfully
-	err = h.sqs.DeleteMessage(h.queueArn, receipt)
+	err = h.deleteMessage(receipt)
 
 	return err
 }
 
 // Update the document status in the pipeline.
-func (h *handler) updateDocumentTracking(documentId, status, stage, timestamp, receipt string, messageNote interface{}) error {
+func (h *handler) updateDocumentTracking(documentId, eventId, status, stage, timestamp, receipt string, messageNote interface{}) error {
 	// Update the document tracking record
-	err := h.pipelineOpsStore.UpdateDocumentStatus(documentId, status, stage, timestamp, messageNote)
+	err := h.pipelineOpsStore.UpdateDocumentStatus(documentId, eventId, status, stage, timestamp, messageNote)
+	invalid := &metadata.InvalidTransitionError{}
+	if errors.As(err, &invalid) {
+		return h.rejectTransition(invalid, receipt)
+	}
 	if err != nil {
 		return err
 	}
 
 	// Remove the message from the queue if processed successfully
-	err = h.sqs.DeleteMessage(h.queueArn, receipt)
+	err = h.deleteMessage(receipt)
 
 	return err
 }
 
-func (h *handler) handleRequest(ctx context.Context, event events.SQSEvent) error {
-	// Loop through each message in the SQS event
-	for _, message := range event.Records {
-		// Check the event source ARN
-		if message.EventSourceARN != h.queueArn {
-			return fmt.Errorf("unexpected Lambda event source ARN. Expected %s, got %s", h.queueArn, message.EventSourceARN)
-		}
+// Lambda request handler. Events arrive from the operations queue or directly from a metadata client targeting this function.
+func (h *handler) handleRequest(ctx context.Context, payload json.RawMessage) error {
+	received, err := metadata.UnwrapEvents(payload)
+	if err != nil {
+		return fmt.Errorf("failed to parse event: %s", err)
+	}
 
-		// Parse the message body
-		var payload map[string]interface{}
-		err := json.Unmarshal([]byte(message.Body), &payload)
-		if err != nil {
-			return fmt.Errorf("failed to parse message body: %s", err)
+	// Loop through each message in the event
+	for _, message := range received {
+		// Check the event source ARN of queued messages
+		if message.EventSourceARN != "" && message.EventSourceARN != h.queueArn {
+			return fmt.Errorf("unexpected Lambda event source ARN. Expected %s, got %s", h.queueArn, message.EventSourceARN)
 		}
-
-		// Parse the message payload
-		var messagePayload map[string]interface{}
-		err = json.Unmarshal([]byte(payload["Message"].(string)), &messagePayload)
+		event, err := metadata.DecodeOperationsEvent(message.Message)
 		if err != nil {
 			return fmt.Errorf("failed to parse message payload: %s", err)
 		}
 
 		// Print the message payload
-		log.Printf("Message payload: %v", messagePayload)
+		log.Printf("Message payload: %+v", event)
 
 		// Check if this is the first message for this document
-		if messagePayload["initDoc"] == "True" {
+		if event.InitDoc {
 			// Start tracking the document
-			err = h.startDocumentTracking(messagePayload["documentId"].(string), messagePayload["bucketName"].(string), messagePayload["objectName"].(string), messagePayload["status"].(string), messagePayload["stage"].(string), messagePayload["timestamp"].(string), message.ReceiptHandle, messagePayload["versionId"])
+			err = h.startDocumentTracking(event.DocumentId, event.EventId(), event.BucketName, event.ObjectName, event.Status, event.Stage, event.Timestamp, message.ReceiptHandle, event.VersionId)
 			if err != nil {
 				return fmt.Errorf("failed to start tracking document: %s", err)
 			}
 		} else {
 			// Update the document status
-			err = h.updateDocumentTracking(messagePayload["documentId"].(string), messagePayload["status"].(string), messagePayload["stage"].(string), messagePayload["timestamp"].(string), message.ReceiptHandle, messagePayload["message"])
+			var note interface{}
+			if event.Message != "" {
+				note = event.Message
+			}
+			err = h.updateDocumentTracking(event.DocumentId, event.EventId(), event.Status, event.Stage, event.Timestamp, message.ReceiptHandle, note)
 			if err != nil {
 				return fmt.Errorf("failed to update document status: %s", err)
 			}
@@ -119,12 +155,16 @@ func main() {
 	// Check for missing arguments
 	PIPELINEOPS_TABLE := os.Getenv("PIPELINE_OPS_TABLE")
 	SQS_QUEUE_ARN := os.Getenv("OPS_SQS_QUEUE_ARN")
+	METADATA_TOPIC := metadata.TargetArnFromEnv()
 	if PIPELINEOPS_TABLE == "" {
 		panic("Missing PIPELINE_OPS_TABLE environment variable.")
 	}
 	if SQS_QUEUE_ARN == "" {
 		panic("Missing OPS_SQS_QUEUE_ARN environment variable.")
 	}
+	if METADATA_TOPIC == "" {
+		panic("Missing METADATA_TARGET_ARN or METADATA_SNS_TOPIC_ARN environment variable.")
+	}
 
 	// Create a Pipeline Operations Store
 	pipelineOpsStore := datastores.NewPipelineOperationsStore(PIPELINEOPS_TABLE)
@@ -132,10 +172,14 @@ func main() {
 	// Create SQS Helper
 	sqshelper := awshelper.SQSHelper{SQSClient: sqs.New(awshelper.NewAWSSession())}
 
+	// Create a Pipeline Operations Client to report the events the store rejects
+	pipelineOperationsClient := metadata.NewPipelineOperationsClient(METADATA_TOPIC)
+
 	h := handler{
-		queueArn:         SQS_QUEUE_ARN,
-		pipelineOpsStore: pipelineOpsStore,
-		sqs:              &sqshelper,
+		queueArn:                 SQS_QUEUE_ARN,
+		pipelineOpsStore:         pipelineOpsStore,
+		pipelineOperationsClient: pipelineOperationsClient,
+		sqs:                      &sqshelper,
 	}
 
 	lambda.Start(h.handleRequest)
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/dreamspider42/document-processing-pipeline/src/datastores"
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
+)
+
+// Pipeline operations store keeping the state of each document in memory and checking transitions as the
+// DynamoDB store does
+type fakeOperationsStore struct {
+	states map[string]metadata.StageState
+	notes  []interface{}
+}
+
+func (s *fakeOperationsStore) StartDocumentTracking(item datastores.PipelineOperationsItem, receipt string) error {
+	state := metadata.StageState{Stage: item.DocumentStage, Status: item.DocumentStatus}
+	err := metadata.Pipeline.Start(item.DocumentId, state)
+	if err != nil {
+		return err
+	}
+	s.states[item.DocumentId] = state
+	return nil
+}
+
+func (s *fakeOperationsStore) UpdateDocumentStatus(documentId, eventId, status, stage, timestamp string, messageNote interface{}) error {
+	state := metadata.StageState{Stage: stage, Status: status}
+	err := metadata.Pipeline.Transition(documentId, s.states[documentId], state)
+	if err != nil {
+		return err
+	}
+	s.states[documentId] = state
+	if messageNote != nil {
+		s.notes = append(s.notes, messageNote)
+	}
+	return nil
+}
+
+func ignore(ctx context.Context, payload json.RawMessage) error {
+	return nil
+}
+
+func TestTrackingThroughBus(t *testing.T) {
+	event := func(stage string, status string, initDoc bool, message string) *metadata.OperationsEvent {
+		return &metadata.OperationsEvent{
+			EventHeader: metadata.EventHeader{DocumentId: "doc"},
+			BucketName:  "bucket",
+			ObjectName:  "report.pdf",
+			Stage:       stage,
+			Status:      status,
+			Message:     message,
+			InitDoc:     metadata.Flag(initDoc),
+		}
+	}
+	tests := []struct {
+		name         string
+		events       []*metadata.OperationsEvent
+		wantState    metadata.StageState
+		wantRejected []string
+		wantNotes    int
+	}{
+		{
+			name: "document moving through the stages",
+			events: []*metadata.OperationsEvent{
+				event(metadata.StageDocumentClassifier, metadata.StatusInProgress, true, ""),
+				event(metadata.StageDocumentClassifier, metadata.StatusSucceeded, false, ""),
+				event(metadata.StageDocumentProcessor, metadata.StatusInProgress, false, ""),
+				event(metadata.StageDocumentProcessor, metadata.StatusFailed, false, "timed out"),
+			},
+			wantState:    metadata.StageState{Stage: metadata.StageDocumentProcessor, Status: metadata.StatusFailed},
+			wantRejected: []string{},
+			wantNotes:    1,
+		},
+		{
+			name: "transition rejected by the store",
+			events: []*metadata.OperationsEvent{
+				event(metadata.StageDocumentClassifier, metadata.StatusInProgress, true, ""),
+				event(metadata.StageDocumentClassifier, metadata.StatusFailed, false, ""),
+				event(metadata.StageDocumentProcessor, metadata.StatusInProgress, false, ""),
+			},
+			wantState:    metadata.StageState{Stage: metadata.StageDocumentClassifier, Status: metadata.StatusFailed},
+			wantRejected: []string{metadata.DetectedByStore},
+		},
+		{
+			name: "first event outside the first stage rejected by the client",
+			events: []*metadata.OperationsEvent{
+				event(metadata.StageDocumentProcessor, metadata.StatusInProgress, true, ""),
+			},
+			wantRejected: []string{metadata.DetectedByClient},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus := metadata.NewInMemoryBus()
+			client := metadata.NewPipelineOperationsClient("", metadata.WithTransport(bus))
+			store := &fakeOperationsStore{states: map[string]metadata.StageState{}}
+			h := handler{pipelineOpsStore: store, pipelineOperationsClient: client}
+			bus.SubscribeConsumers(ignore, ignore, h.handleRequest, ignore)
+
+			rejected := []string{}
+			bus.Subscribe("transitions", metadata.MetadataTypeFilter(metadata.MetadataTypeInvalidTransition), func(ctx context.Context, payload json.RawMessage) error {
+				received, err := metadata.UnwrapEvents(payload)
+				if err != nil {
+					return err
+				}
+				for _, record := range received {
+					invalid, err := metadata.DecodeInvalidTransitionEvent(record.Message)
+					if err != nil {
+						return err
+					}
+					rejected = append(rejected, invalid.DetectedBy)
+				}
+				return nil
+			})
+
+			for _, e := range tt.events {
+				err := client.PublishEvent(e)
+				if err != nil && len(tt.wantRejected) == 0 {
+					t.Fatalf("PublishEvent() error = %v", err)
+				}
+			}
+
+			if store.states["doc"] != tt.wantState {
+				t.Errorf("document in %s, want %s", store.states["doc"], tt.wantState)
+			}
+			if len(rejected) != len(tt.wantRejected) {
+				t.Fatalf("rejected by %v, want %v", rejected, tt.wantRejected)
+			}
+			for i := range rejected {
+				if rejected[i] != tt.wantRejected[i] {
+					t.Errorf("rejected by %v, want %v", rejected, tt.wantRejected)
+				}
+			}
+			if len(store.notes) != tt.wantNotes {
+				t.Errorf("%d notes recorded, want %d", len(store.notes), tt.wantNotes)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/dreamspider42/document-processing-pipeline/src/awshelper"
+	"github.com/dreamspider42/document-processing-pipeline/src/datastores"
+	"github.com/dreamspider42/document-processing-pipeline/src/lineage"
+)
+
+// Represents the resources used by the handler
+type handler struct {
+	s3               *awshelper.S3Helper
+	exportBucketName string
+	openLineage      *lineage.OpenLineageClient
+	jobNamespace     string
+}
+
+// Export a lineage record: its OpenLineage event and PROV document are written to the export bucket, and the
+// event is posted to the OpenLineage endpoint when one is configured
+func (h *handler) export(item datastores.LineageItem) error {
+	event, err := lineage.ToOpenLineage(item, h.jobNamespace)
+	if err != nil {
+		return err
+	}
+	recordId := event.Run.RunId
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error marshalling OpenLineage event: %v", err)
+	}
+	err = h.s3.WriteToS3(string(body), h.exportBucketName, fmt.Sprintf("openlineage/%s/%s.json", item.DocumentId, recordId), nil)
+	if err != nil {
+		return err
+	}
+
+	doc, err := lineage.ToProv([]datastores.LineageItem{item})
+	if err != nil {
+		return err
+	}
+	var prov bytes.Buffer
+	err = doc.Write(&prov)
+	if err != nil {
+		return err
+	}
+	err = h.s3.WriteToS3(prov.String(), h.exportBucketName, fmt.Sprintf("prov/%s/%s.json", item.DocumentId, recordId), nil)
+	if err != nil {
+		return err
+	}
+
+	if h.openLineage != nil {
+		return h.openLineage.Emit(event)
+	}
+	return nil
+}
+
+// Lambda request handler. Exports the lineage records written to the lineage table; failed batches are retried by
+// the stream, and the exports of a record keep their run id.
+func (h *handler) handleRequest(ctx context.Context, dbEvent awshelper.DynamoDBEvent) error {
+	exported := 0
+	for _, record := range dbEvent.Records {
+		if record.EventName == "REMOVE" || record.Change.NewImage == nil {
+			continue
+		}
+		item := datastores.LineageItem{}
+		err := dynamodbattribute.UnmarshalMap(record.Change.NewImage, &item)
+		if err != nil {
+			log.Printf("Failed to deserialize DynamoDB record. Error: %v", err)
+			return err
+		}
+
+		err = h.export(item)
+		if err != nil {
+			log.Printf("Failed to export the lineage of %s/%s for document %s. Error: %v", item.TargetBucketName, item.TargetFileName, item.DocumentId, err)
+			return err
+		}
+		exported++
+	}
+	log.Printf("Exported %d lineage records \n", exported)
+	return nil
+}
+
+// main is called only once, when the Lambda is initialised (started for the first time). Code in this function should
+// primarily be used to create service clients, read environments variables, read configuration from disk etc.
+func main() {
+	exportBucketName := os.Getenv("LINEAGE_EXPORT_BUCKET")
+	jobNamespace := os.Getenv("OPENLINEAGE_NAMESPACE")
+	openLineageUrl := os.Getenv("OPENLINEAGE_URL")
+	openLineageApiKey := os.Getenv("OPENLINEAGE_API_KEY")
+
+	if exportBucketName == "" {
+		panic("Missing LINEAGE_EXPORT_BUCKET environment variable.")
+	}
+	if jobNamespace == "" {
+		panic("Missing OPENLINEAGE_NAMESPACE environment variable.")
+	}
+
+	// Create S3Helper
+	s3helper := awshelper.S3Helper{S3Client: s3.New(awshelper.NewAWSSession())}
+
+	h := handler{
+		s3:               &s3helper,
+		exportBucketName: exportBucketName,
+		jobNamespace:     jobNamespace,
+	}
+
+	// Post to an OpenLineage endpoint only when one is configured
+	if openLineageUrl != "" {
+		h.openLineage = lineage.NewOpenLineageClient(openLineageUrl, openLineageApiKey)
+	}
+
+	lambda.Start(h.handleRequest)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/dreamspider42/document-processing-pipeline/src/awshelper"
+	"github.com/dreamspider42/document-processing-pipeline/src/datastores"
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
+)
+
+// Represents the resources used by the handler
+type handler struct {
+	metadataBatch *metadata.MetadataBatch
+	// Metadata clients by metadata type
+	metadataClients map[string]*metadata.MetadataClient
+}
+
+// Publish an outbox event with the client of its metadata type, keeping the timestamp it was written with
+func (h *handler) relay(item datastores.MetadataOutboxItem) error {
+	client, ok := h.metadataClients[item.MetadataType]
+	event := metadata.NewEvent(item.MetadataType)
+	if !ok || event == nil {
+		return fmt.Errorf("no metadata client for %s events", item.MetadataType)
+	}
+
+	err := metadata.DecodeEvent([]byte(item.Message), event)
+	if err != nil {
+		return err
+	}
+	return client.PublishEvent(event)
+}
+
+// Lambda request handler. Publishes the events inserted in the outbox, in the order they were written for each
+// source event. Failed batches are retried by the stream; the metadata topic deduplicates the republished events.
+func (h *handler) handleRequest(ctx context.Context, dbEvent awshelper.DynamoDBEvent) (err error) {
+	// Publish the metadata events buffered during the invocation
+	defer h.metadataBatch.FlushOnReturn(&err)
+
+	// Collect the inserted events. Updates and the removals of expired events are not relayed.
+	items := []datastores.MetadataOutboxItem{}
+	for _, record := range dbEvent.Records {
+		if record.EventName != "INSERT" || record.Change.NewImage == nil {
+			continue
+		}
+		item := datastores.MetadataOutboxItem{}
+		err := dynamodbattribute.UnmarshalMap(record.Change.NewImage, &item)
+		if err != nil {
+			log.Printf("Failed to deserialize DynamoDB record. Error: %v", err)
+			return err
+		}
+		items = append(items, item)
+	}
+
+	// The events of a transaction can reach the stream in any order
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].OutboxId != items[j].OutboxId {
+			return items[i].OutboxId < items[j].OutboxId
+		}
+		return items[i].Sequence < items[j].Sequence
+	})
+
+	for _, item := range items {
+		err := h.relay(item)
+		if err != nil {
+			log.Printf("Failed to relay %s event %d of %s. Error: %v", item.MetadataType, item.Sequence, item.OutboxId, err)
+			return err
+		}
+	}
+	log.Printf("Relayed %d outbox events \n", len(items))
+	return nil
+}
+
+// main is called only once, when the Lambda is initialised (started for the first time). Code in this function should
+// primarily be used to create service clients, read environments variables, read configuration from disk etc.
+func main() {
+	metadataTopic := metadata.TargetArnFromEnv()
+	if metadataTopic == "" {
+		panic("Missing METADATA_TARGET_ARN or METADATA_SNS_TOPIC_ARN environment variable.")
+	}
+
+	// Buffer the metadata events of each invocation and publish them in batches
+	metadataBatch := metadata.NewMetadataBatch()
+
+	// Create a metadata client for each metadata type
+	metadataClients := map[string]*metadata.MetadataClient{}
+	for _, metadataType := range metadata.EventTypes() {
+		metadataClients[metadataType] = metadata.NewMetadataClient(metadataType, metadataTopic, "", nil, nil, metadata.WithBatch(metadataBatch))
+	}
+
+	h := handler{
+		metadataBatch:   metadataBatch,
+		metadataClients: metadataClients,
+	}
+
+	lambda.Start(h.handleRequest)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/dreamspider42/document-processing-pipeline/src/awshelper"
+	"github.com/dreamspider42/document-processing-pipeline/src/datastores"
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
+	"golang.org/x/exp/slices"
+)
+
+// Transport recording the messages it is sent
+type recordingTransport struct {
+	messages []metadata.TransportMessage
+}
+
+func (t *recordingTransport) Send(message metadata.TransportMessage) error {
+	t.messages = append(t.messages, message)
+	return nil
+}
+
+func (t *recordingTransport) MessageSize(message metadata.TransportMessage) (int, int) {
+	return len(message.Body), metadata.MaxSNSMessageBytes
+}
+
+// Handler relaying to a recording transport
+func newTestHandler(transport *recordingTransport) *handler {
+	batch := metadata.NewMetadataBatch()
+	clients := map[string]*metadata.MetadataClient{}
+	for _, metadataType := range metadata.EventTypes() {
+		clients[metadataType] = metadata.NewMetadataClient(metadataType, "", "", nil, nil, metadata.WithTransport(transport), metadata.WithBatch(batch))
+	}
+	return &handler{metadataBatch: batch, metadataClients: clients}
+}
+
+// Stream record of an outbox event written for a source event, its message naming both
+func outboxRecord(t *testing.T, eventName string, outboxId string, sequence int) awshelper.DynamoDBEventRecord {
+	event := &metadata.OperationsEvent{
+		EventHeader: metadata.EventHeader{DocumentId: outboxId, Timestamp: "2024-05-01T10:00:00Z"},
+		BucketName:  "uploads",
+		ObjectName:  "invoice.pdf",
+		Stage:       metadata.StageDocumentClassifier,
+		Status:      metadata.StatusInProgress,
+		Message:     fmt.Sprintf("%s/%d", outboxId, sequence),
+	}
+	message, err := metadata.MarshalEvent(event)
+	if err != nil {
+		t.Fatalf("MarshalEvent() error = %v", err)
+	}
+	image, err := dynamodbattribute.MarshalMap(datastores.MetadataOutboxItem{
+		OutboxId:     outboxId,
+		Sequence:     sequence,
+		MetadataType: metadata.MetadataTypeOperations,
+		DocumentId:   outboxId,
+		Message:      string(message),
+	})
+	if err != nil {
+		t.Fatalf("MarshalMap() error = %v", err)
+	}
+	record := awshelper.DynamoDBEventRecord{EventName: eventName}
+	if eventName == "REMOVE" {
+		record.Change.OldImage = image
+	} else {
+		record.Change.NewImage = image
+	}
+	return record
+}
+
+func TestRelayOrder(t *testing.T) {
+	records := []awshelper.DynamoDBEventRecord{}
+	for _, outboxId := range []string{"upload-a", "upload-b", "upload-c"} {
+		for sequence := 0; sequence < 4; sequence++ {
+			records = append(records, outboxRecord(t, "INSERT", outboxId, sequence))
+		}
+	}
+	// Updates and expired events are not relayed
+	records = append(records, outboxRecord(t, "MODIFY", "upload-a", 9), outboxRecord(t, "REMOVE", "upload-b", 9))
+
+	for seed := int64(1); seed <= 20; seed++ {
+		t.Run(fmt.Sprintf("shuffle %d", seed), func(t *testing.T) {
+			shuffled := slices.Clone(records)
+			random := rand.New(rand.NewSource(seed))
+			random.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+			transport := &recordingTransport{}
+
+			err := newTestHandler(transport).handleRequest(context.Background(), awshelper.DynamoDBEvent{Records: shuffled})
+			if err != nil {
+				t.Fatalf("handleRequest() error = %v", err)
+			}
+
+			relayed := map[string][]string{}
+			for _, message := range transport.messages {
+				event := metadata.OperationsEvent{}
+				err := json.Unmarshal(message.Body, &event)
+				if err != nil {
+					t.Fatalf("relayed message %s: %v", message.Body, err)
+				}
+				outboxId, _, _ := strings.Cut(event.Message, "/")
+				relayed[outboxId] = append(relayed[outboxId], event.Message)
+			}
+			for _, outboxId := range []string{"upload-a", "upload-b", "upload-c"} {
+				want := []string{outboxId + "/0", outboxId + "/1", outboxId + "/2", outboxId + "/3"}
+				if !slices.Equal(relayed[outboxId], want) {
+					t.Errorf("relayed %v of %s, want %v", relayed[outboxId], outboxId, want)
+				}
+			}
+			if len(transport.messages) != 12 {
+				t.Errorf("relayed %d events, want 12", len(transport.messages))
+			}
+		})
+	}
+}
+
+func TestRelayUnknownType(t *testing.T) {
+	record := outboxRecord(t, "INSERT", "upload-a", 0)
+	record.Change.NewImage["metadataType"].S = aws.String("unknown")
+	transport := &recordingTransport{}
+
+	err := newTestHandler(transport).handleRequest(context.Background(), awshelper.DynamoDBEvent{Records: []awshelper.DynamoDBEventRecord{record}})
+	if err == nil {
+		t.Error("handleRequest() error = nil for an event of an unknown metadata type")
+	}
+	if len(transport.messages) != 0 {
+		t.Errorf("relayed %d events, want none", len(transport.messages))
+	}
+}
@@ -17,10 +17,11 @@ import (
 	"github.com/dreamspider42/document-processing-pipeline/src/textractparser"
 )
 
-var PIPELINE_STAGE = "ASYNC_PROCESS_TEXTRACT"
+var PIPELINE_STAGE = metadata.StageAsyncProcessTextract
 
 // Represents the resources used by the handler
 type handler struct {
+	metadataBatch            *metadata.MetadataBatch
 	pipelineOperationsClient *metadata.PipelineOperationsClient
 	documentLineageClient    *metadata.DocumentLineageClient
 	s3                       *awshelper.S3Helper
@@ -112,7 +113,8 @@ func (h *handler) processRequest(message MessageMap, callerId string) error {
 		"sourceBucketName": message.DocumentLocation.BucketName,
 		"targetBucketName": h.textractBucketName,
 		"sourceFileName":   message.DocumentLocation.ObjectName,
-		"targetFileName":   message.DocumentLocation.ObjectName,
+		"targetFileName":   opg.FullResponsePath(),
+		"stage":            PIPELINE_STAGE,
 	})
 
 	output := fmt.Sprintf("Processed -> Document: %s, Object: %s/%s processed.", message.DocumentId, h.textractBucketName, message.DocumentLocation.ObjectName)
@@ -125,7 +127,10 @@ func (h *handler) processRequest(message MessageMap, callerId string) error {
 	return nil
 }
 
-func (h *handler) handleRequest(ctx context.Context, snsEvent events.SNSEvent) error {
+func (h *handler) handleRequest(ctx context.Context, snsEvent events.SNSEvent) (err error) {
+	// Publish the metadata events buffered during the invocation
+	defer h.metadataBatch.FlushOnReturn(&err)
+
 	// Print the event
 	log.Printf("Async Textract Completed event: {%+v} \n", snsEvent)
 
@@ -155,11 +160,11 @@ func (h *handler) handleRequest(ctx context.Context, snsEvent events.SNSEvent) e
 // main is called only once, when the Lambda is initialised (started for the first time). Code in this function should
 // primarily be used to create service clients, read environments variables, read configuration from disk etc.
 func main() {
-	metadataTopic := os.Getenv("METADATA_SNS_TOPIC_ARN")
+	metadataTopic := metadata.TargetArnFromEnv()
 	textractBucketName := os.Getenv("TEXTRACT_RESULTS_BUCKET_NAME")
 
 	if metadataTopic == "" {
-		panic("Missing METADATA_SNS_TOPIC_ARN environment variable.")
+		panic("Missing METADATA_TARGET_ARN or METADATA_SNS_TOPIC_ARN environment variable.")
 	}
 	if textractBucketName == "" {
 		panic("Missing TEXTRACT_RESULTS_BUCKET_NAME environment variable.")
@@ -168,11 +173,15 @@ func main() {
 	// Create S3Helper
 	s3helper := awshelper.S3Helper{S3Client: s3.New(awshelper.NewAWSSession())}
 
+	// Buffer the metadata events of each invocation and publish them in batches
+	metadataBatch := metadata.NewMetadataBatch()
+
 	//Create Metadata Clients
-	pipelineClient := metadata.NewPipelineOperationsClient(metadataTopic)
-	lineageClient := metadata.NewDocumentLineageClient(metadataTopic)
+	pipelineClient := metadata.NewPipelineOperationsClient(metadataTopic, metadata.WithBatch(metadataBatch))
+	lineageClient := metadata.NewDocumentLineageClient(metadataTopic, metadata.WithBatch(metadataBatch))
 
 	h := handler{
+		metadataBatch:            metadataBatch,
 		pipelineOperationsClient: pipelineClient,
 		documentLineageClient:    lineageClient,
 		s3:                       &s3helper,
@@ -15,10 +15,11 @@ import (
 	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
 )
 
-var PIPELINE_STAGE = "ASYNC_START_TEXTRACT"
+var PIPELINE_STAGE = metadata.StageAsyncStartTextract
 
 // Represents the resources used by the handler
 type handler struct {
+	metadataBatch            *metadata.MetadataBatch
 	pipelineOperationsClient *metadata.PipelineOperationsClient
 	s3                       *awshelper.S3Helper
 	textractBucketName       string
@@ -108,7 +109,10 @@ func (h *handler) processItem(bucketName string, objectName string, snsTopic str
 	return nil
 }
 
-func (h *handler) handleRequest(ctx context.Context, s3Event events.S3Event) error {
+func (h *handler) handleRequest(ctx context.Context, s3Event events.S3Event) (err error) {
+	// Publish the metadata events buffered during the invocation
+	defer h.metadataBatch.FlushOnReturn(&err)
+
 	// Print the event
 	log.Printf("Async Textract Started event: {%+v} \n", s3Event)
 
@@ -129,13 +133,13 @@ func (h *handler) handleRequest(ctx context.Context, s3Event events.S3Event) err
 // main is called only once, when the Lambda is initialised (started for the first time). Code in this function should
 // primarily be used to create service clients, read environments variables, read configuration from disk etc.
 func main() {
-	metadataTopic := os.Getenv("METADATA_SNS_TOPIC_ARN")
+	metadataTopic := metadata.TargetArnFromEnv()
 	textractBucketName := os.Getenv("TEXTRACT_RESULTS_BUCKET_NAME")
 	snsRole := os.Getenv("TEXTRACT_SNS_ROLE_ARN")
 	snsTopic := os.Getenv("TEXTRACT_SNS_TOPIC_ARN")
 
 	if metadataTopic == "" {
-		panic("Missing METADATA_SNS_TOPIC_ARN environment variable.")
+		panic("Missing METADATA_TARGET_ARN or METADATA_SNS_TOPIC_ARN environment variable.")
 	}
 	if textractBucketName == "" {
 		panic("Missing TEXTRACT_RESULTS_BUCKET_NAME environment variable.")
@@ -147,13 +151,17 @@ func main() {
 		panic("Missing TEXTRACT_SNS_TOPIC_ARN environment variable.")
 	}
 
+	// Buffer the metadata events of each invocation and publish them in batches
+	metadataBatch := metadata.NewMetadataBatch()
+
 	//Create Metadata Clients
-	pipelineClient := metadata.NewPipelineOperationsClient(metadataTopic)
+	pipelineClient := metadata.NewPipelineOperationsClient(metadataTopic, metadata.WithBatch(metadataBatch))
 
 	// Create S3Helper
 	s3helper := awshelper.S3Helper{S3Client: s3.New(awshelper.NewAWSSession())}
 
 	h := handler{
+		metadataBatch:            metadataBatch,
 		pipelineOperationsClient: pipelineClient,
 		s3:                       &s3helper,
 		textractBucketName:       textractBucketName,
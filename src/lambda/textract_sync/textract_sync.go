@@ -16,10 +16,11 @@ import (
 	"github.com/dreamspider42/document-processing-pipeline/src/textractparser"
 )
 
-var PIPELINE_STAGE = "SYNC_PROCESS_TEXTRACT"
+var PIPELINE_STAGE = metadata.StageSyncProcessTextract
 
 // Represents the resources used by the handler
 type handler struct {
+	metadataBatch            *metadata.MetadataBatch
 	pipelineOperationsClient *metadata.PipelineOperationsClient
 	documentLineageClient    *metadata.DocumentLineageClient
 	s3                       *awshelper.S3Helper
@@ -76,7 +77,8 @@ func (h *handler) processImage(documentId string, bucketName string, objectName
 		"sourceBucketName": bucketName,
 		"targetBucketName": h.textractBucketName,
 		"sourceFileName":   objectName,
-		"targetFileName":   objectName,
+		"targetFileName":   opg.FullResponsePath(),
+		"stage":            PIPELINE_STAGE,
 	}
 	err = h.documentLineageClient.RecordLineageOfCopy(lineageBody)
 	if err != nil {
@@ -138,7 +140,10 @@ func (h *handler) processRequest(bucketName string, objectName string, callerId
 	return nil
 }
 
-func (h *handler) handleRequest(ctx context.Context, s3Event events.S3Event) error {
+func (h *handler) handleRequest(ctx context.Context, s3Event events.S3Event) (err error) {
+	// Publish the metadata events buffered during the invocation
+	defer h.metadataBatch.FlushOnReturn(&err)
+
 	// Print the caller id
 	lc, _ := lambdacontext.FromContext(ctx)
 	callerId := lc.InvokedFunctionArn
@@ -165,11 +170,11 @@ func (h *handler) handleRequest(ctx context.Context, s3Event events.S3Event) err
 // main is called only once, when the Lambda is initialised (started for the first time). Code in this function should
 // primarily be used to create service clients, read environments variables, read configuration from disk etc.
 func main() {
-	metadataTopic := os.Getenv("METADATA_SNS_TOPIC_ARN")
+	metadataTopic := metadata.TargetArnFromEnv()
 	textractBucketName := os.Getenv("TEXTRACT_RESULTS_BUCKET_NAME")
 
 	if metadataTopic == "" {
-		panic("Missing METADATA_SNS_TOPIC_ARN environment variable.")
+		panic("Missing METADATA_TARGET_ARN or METADATA_SNS_TOPIC_ARN environment variable.")
 	}
 	if textractBucketName == "" {
 		panic("Missing TEXTRACT_RESULTS_BUCKET_NAME environment variable.")
@@ -178,11 +183,15 @@ func main() {
 	// Create S3Helper
 	s3helper := awshelper.S3Helper{S3Client: s3.New(awshelper.NewAWSSession())}
 
+	// Buffer the metadata events of each invocation and publish them in batches
+	metadataBatch := metadata.NewMetadataBatch()
+
 	//Create Metadata Clients
-	pipelineClient := metadata.NewPipelineOperationsClient(metadataTopic)
-	lineageClient := metadata.NewDocumentLineageClient(metadataTopic)
+	pipelineClient := metadata.NewPipelineOperationsClient(metadataTopic, metadata.WithBatch(metadataBatch))
+	lineageClient := metadata.NewDocumentLineageClient(metadataTopic, metadata.WithBatch(metadataBatch))
 
 	h := handler{
+		metadataBatch:            metadataBatch,
 		pipelineOperationsClient: pipelineClient,
 		documentLineageClient:    lineageClient,
 		s3:                       &s3helper,
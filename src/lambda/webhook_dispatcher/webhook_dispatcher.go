@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/dreamspider42/document-processing-pipeline/src/awshelper"
+	"github.com/dreamspider42/document-processing-pipeline/src/datastores"
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
+	"github.com/dreamspider42/document-processing-pipeline/src/webhooks"
+)
+
+// Represents the resources used by the handler
+type handler struct {
+	documentRegistryStore *datastores.DocumentRegistryStore
+	notifier              *webhooks.Notifier
+	queueArn              string
+	sqs                   *awshelper.SQSHelper
+}
+
+// Remove a processed message from the queue. Events invoked directly have no message to remove.
+func (h *handler) deleteMessage(receipt string) error {
+	if receipt == "" {
+		return nil
+	}
+	return h.sqs.DeleteMessage(h.queueArn, receipt)
+}
+
+// Tenant of a document: the owner in its registered metadata, empty if it has none
+func (h *handler) tenantOf(documentId string) (string, error) {
+	document, err := h.documentRegistryStore.GetDocument(documentId)
+	if err != nil || document == nil {
+		return "", err
+	}
+	owner, _ := document.DocumentMetadata["owner"].(string)
+	return owner, nil
+}
+
+// Send the webhooks of a document reaching the end of the pipeline or failing a stage
+func (h *handler) dispatch(event *metadata.OperationsEvent) error {
+	if webhooks.EventTypeOf(event.Stage, event.Status) == "" {
+		return nil
+	}
+	tenantId, err := h.tenantOf(event.DocumentId)
+	if err != nil {
+		return err
+	}
+	if tenantId == "" {
+		log.Printf("Document %s has no owner to send webhooks to \n", event.DocumentId)
+		return nil
+	}
+	return h.notifier.Notify(tenantId, event)
+}
+
+// Lambda request handler. Events arrive from the webhook queue or from the metadata event bus.
+func (h *handler) handleRequest(ctx context.Context, payload json.RawMessage) error {
+	received, err := metadata.UnwrapEvents(payload)
+	if err != nil {
+		return err
+	}
+
+	// Process each record in the event
+	for _, record := range received {
+		if record.EventSourceARN != "" && record.EventSourceARN != h.queueArn {
+			return fmt.Errorf("unexpected lambda event source ARN. Expected %s, got %s", h.queueArn, record.EventSourceARN)
+		}
+		event, err := metadata.DecodeOperationsEvent(record.Message)
+		if err != nil {
+			log.Printf("Rejecting operations event: %v \n", err)
+			return err
+		}
+
+		err = h.dispatch(event)
+		if err != nil {
+			log.Printf("Failed to send the webhooks of document %s: %v \n", event.DocumentId, err)
+			return err
+		}
+
+		// Remove the message from the queue if processed successfully
+		err = h.deleteMessage(record.ReceiptHandle)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// main is called only once, when the Lambda is initialised (started for the first time). Code in this function should
+// primarily be used to create service clients, read environments variables, read configuration from disk etc.
+func main() {
+	// Check for missing arguments
+	REGISTRY_TABLE := os.Getenv("REGISTRY_TABLE")
+	ENDPOINTS_TABLE := os.Getenv("WEBHOOK_ENDPOINTS_TABLE")
+	DELIVERIES_TABLE := os.Getenv("WEBHOOK_DELIVERIES_TABLE")
+	DELIVERIES_INDEX := os.Getenv("WEBHOOK_DELIVERIES_INDEX")
+	SQS_QUEUE_ARN := os.Getenv("WEBHOOK_SQS_QUEUE_ARN")
+	if REGISTRY_TABLE == "" {
+		panic("Missing REGISTRY_TABLE environment variable.")
+	}
+	if ENDPOINTS_TABLE == "" || DELIVERIES_TABLE == "" || DELIVERIES_INDEX == "" {
+		panic("Missing WEBHOOK_ENDPOINTS_TABLE, WEBHOOK_DELIVERIES_TABLE or WEBHOOK_DELIVERIES_INDEX environment variable.")
+	}
+	if SQS_QUEUE_ARN == "" {
+		panic("Missing WEBHOOK_SQS_QUEUE_ARN environment variable.")
+	}
+
+	// Create the stores and the notifier sending the webhooks
+	notifier := webhooks.NewNotifier(
+		datastores.NewWebhookEndpointStore(ENDPOINTS_TABLE),
+		datastores.NewWebhookDeliveryStore(DELIVERIES_TABLE, DELIVERIES_INDEX),
+		webhooks.NewDispatcher(),
+	)
+
+	// Create SQS Helper
+	sqshelper := awshelper.SQSHelper{SQSClient: sqs.New(awshelper.NewAWSSession())}
+
+	h := handler{
+		documentRegistryStore: datastores.NewDocumentRegistryStore(REGISTRY_TABLE),
+		notifier:              notifier,
+		queueArn:              SQS_QUEUE_ARN,
+		sqs:                   &sqshelper,
+	}
+
+	lambda.Start(h.handleRequest)
+}
@@ -0,0 +1,79 @@
+package lineage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Export formats of a graph
+const (
+	FormatJSON = "json"
+	FormatDOT  = "dot"
+)
+
+// Graphviz shapes and fill colours of the node kinds
+var dotStyles = map[string][2]string{
+	KindSource:     {"box", "lightblue"},
+	KindCopy:       {"box", "white"},
+	KindTextract:   {"note", "lightyellow"},
+	KindComprehend: {"note", "palegreen"},
+	KindIndex:      {"cylinder", "plum"},
+	KindObject:     {"box", "white"},
+}
+
+// Write the graph in a format
+func (g *Graph) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatJSON:
+		return g.WriteJSON(w)
+	case FormatDOT:
+		return g.WriteDOT(w)
+	}
+	return fmt.Errorf("unknown lineage graph format %q, expected %s or %s", format, FormatJSON, FormatDOT)
+}
+
+// Write the graph as indented JSON
+func (g *Graph) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(g)
+}
+
+// Write the graph as a Graphviz DOT digraph. Removed artifacts are dashed; edges are labelled with their
+// event and actor.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "digraph %s {\n", dotQuote("lineage "+g.DocumentId))
+	fmt.Fprintf(b, "  rankdir=LR;\n  label=%s;\n  node [style=filled];\n", dotQuote("Document "+g.DocumentId))
+
+	for _, n := range g.Nodes {
+		style, ok := dotStyles[n.Kind]
+		if !ok {
+			style = dotStyles[KindObject]
+		}
+		label := fmt.Sprintf("%s\n%s\n%s", n.Kind, n.BucketName, n.FileName)
+		attributes := fmt.Sprintf("label=%s, shape=%s, fillcolor=%s", dotQuote(label), style[0], style[1])
+		if n.RemovedAt != "" {
+			attributes += `, style="filled,dashed"`
+		}
+		fmt.Fprintf(b, "  %s [%s];\n", dotQuote(n.Id), attributes)
+	}
+	for _, e := range g.Edges {
+		label := fmt.Sprintf("%s\n%s", e.S3Event, ActorName(e.Actor))
+		fmt.Fprintf(b, "  %s -> %s [label=%s];\n", dotQuote(e.From), dotQuote(e.To), dotQuote(label))
+	}
+
+	fmt.Fprintln(b, "}")
+	return b.Flush()
+}
+
+// Quote a DOT identifier or label
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
@@ -0,0 +1,43 @@
+package lineage
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteDOT(t *testing.T) {
+	g := &Graph{
+		DocumentId: `doc "1"`,
+		Nodes: []Node{
+			{Id: `s3://uploads/a "quoted" \ name.pdf`, Kind: KindSource, BucketName: "uploads", FileName: `a "quoted" \ name.pdf`, RemovedAt: "2024-05-01T11:00:00Z"},
+			{Id: "documents/doc", Kind: KindIndex, BucketName: "documents", FileName: "doc"},
+			{Id: "s3://other/x", Kind: "unknown", BucketName: "other", FileName: "x"},
+		},
+		Edges: []Edge{
+			{From: `s3://uploads/a "quoted" \ name.pdf`, To: "documents/doc", S3Event: "IndexWritten:Bulk", Actor: indexerArn},
+		},
+	}
+	want := `digraph "lineage doc \"1\"" {
+  rankdir=LR;
+  label="Document doc \"1\"";
+  node [style=filled];
+  "s3://uploads/a \"quoted\" \\ name.pdf" [label="source\nuploads\na \"quoted\" \\ name.pdf", shape=box, fillcolor=lightblue, style="filled,dashed"];
+  "documents/doc" [label="index\ndocuments\ndoc", shape=cylinder, fillcolor=plum];
+  "s3://other/x" [label="unknown\nother\nx", shape=box, fillcolor=white];
+  "s3://uploads/a \"quoted\" \\ name.pdf" -> "documents/doc" [label="IndexWritten:Bulk\nsearchIndexer"];
+}
+`
+
+	b := &bytes.Buffer{}
+	err := g.Write(b, FormatDOT)
+	if err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if b.String() != want {
+		t.Errorf("Write() wrote\n%s\nwant\n%s", b.String(), want)
+	}
+
+	if err := g.Write(b, "svg"); err == nil {
+		t.Error("Write() error = nil for an unknown format")
+	}
+}
@@ -0,0 +1,244 @@
+package lineage
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dreamspider42/document-processing-pipeline/src/datastores"
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
+	"golang.org/x/exp/slices"
+)
+
+// Kinds of artifacts in a lineage graph
+const (
+	// The uploaded object the document started from
+	KindSource = "source"
+	// A copy made for processing, such as the objects in the image and large document buckets
+	KindCopy       = "copy"
+	KindTextract   = "textract"
+	KindComprehend = "comprehend"
+	// The records of the document in a search index
+	KindIndex = "index"
+	// Any other derived object
+	KindObject = "object"
+)
+
+// Node is an artifact of a document: an S3 object or the records of a search index
+type Node struct {
+	Id         string `json:"id"`
+	Kind       string `json:"kind"`
+	BucketName string `json:"bucketName"`
+	FileName   string `json:"fileName"`
+	VersionId  string `json:"versionId,omitempty"`
+	CreatedAt  string `json:"createdAt,omitempty"`
+	CreatedBy  string `json:"createdBy,omitempty"`
+	RemovedAt  string `json:"removedAt,omitempty"`
+	RemovedBy  string `json:"removedBy,omitempty"`
+}
+
+// Edge records an artifact derived from another
+type Edge struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	S3Event   string `json:"s3Event"`
+	Actor     string `json:"actor"`
+	Timestamp string `json:"timestamp"`
+}
+
+// Actor is a system or user that touched the artifacts of a document
+type Actor struct {
+	// ARN of a pipeline Lambda or principal id of an S3 user
+	Id        string `json:"id"`
+	Events    int    `json:"events"`
+	FirstSeen string `json:"firstSeen"`
+	LastSeen  string `json:"lastSeen"`
+}
+
+// Graph is the lineage DAG of a document: the artifacts derived from its upload and the actors that touched them
+type Graph struct {
+	DocumentId string  `json:"documentId"`
+	Nodes      []Node  `json:"nodes"`
+	Edges      []Edge  `json:"edges"`
+	Actors     []Actor `json:"actors"`
+}
+
+// Filter selects the lineage records a graph is built from
+type Filter struct {
+	// Zero times leave that end of the range open
+	From time.Time
+	To   time.Time
+	// Caller ARNs, principal ids or Lambda function names. Empty selects every actor.
+	Actors []string
+}
+
+// Whether a lineage record passes the filter
+func (f Filter) Matches(item datastores.LineageItem, timestamp time.Time) bool {
+	if !f.From.IsZero() && timestamp.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && timestamp.After(f.To) {
+		return false
+	}
+	if len(f.Actors) == 0 {
+		return true
+	}
+	actor := ActorOf(item)
+	return slices.Contains(f.Actors, actor) || slices.Contains(f.Actors, ActorName(actor))
+}
+
+// Whether a lineage record passes the filter, parsing its timestamp
+func (f Filter) Includes(item datastores.LineageItem) bool {
+	timestamp, err := metadata.ParseTimestamp(item.Timestamp)
+	if err != nil {
+		return false
+	}
+	return f.Matches(item, timestamp)
+}
+
+// Options of a graph
+type Options struct {
+	Filter Filter
+	// Kinds of the objects in a bucket, such as KindTextract for the Textract results bucket. Objects of other
+	// buckets are sources, copies or other objects as their lineage records tell.
+	BucketKinds map[string]string
+}
+
+// Id of the actor of a lineage record
+func ActorOf(item datastores.LineageItem) string {
+	if arn, ok := item.CallerId["arn"].(string); ok && arn != "" {
+		return arn
+	}
+	principalId, _ := item.CallerId["principalId"].(string)
+	return principalId
+}
+
+// Short name of an actor: the function name of a Lambda ARN, or else the id itself
+func ActorName(actor string) string {
+	if i := strings.LastIndex(actor, ":function:"); i >= 0 {
+		name := actor[i+len(":function:"):]
+		// Drop the version or alias of a qualified ARN
+		name, _, _ = strings.Cut(name, ":")
+		return name
+	}
+	return actor
+}
+
+// Id of the node of an artifact
+func NodeId(bucketName string, fileName string, s3Event string) string {
+	if s3Event == metadata.S3EventIndexWritten {
+		return fmt.Sprintf("%s/%s", bucketName, fileName)
+	}
+	return fmt.Sprintf("s3://%s/%s", bucketName, fileName)
+}
+
+// Build the lineage graph of a document from its lineage records. Records with an unparseable timestamp are
+// logged and left out.
+func Build(documentId string, items []datastores.LineageItem, opts Options) (*Graph, error) {
+	type record struct {
+		item      datastores.LineageItem
+		timestamp time.Time
+	}
+	records := make([]record, 0, len(items))
+	for _, item := range items {
+		timestamp, err := metadata.ParseTimestamp(item.Timestamp)
+		if err != nil {
+			log.Printf("Skipping lineage record of %s/%s: %v", item.TargetBucketName, item.TargetFileName, err)
+			continue
+		}
+		if opts.Filter.Matches(item, timestamp) {
+			records = append(records, record{item: item, timestamp: timestamp})
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].timestamp.Before(records[j].timestamp) })
+
+	g := &Graph{DocumentId: documentId, Nodes: []Node{}, Edges: []Edge{}, Actors: []Actor{}}
+	nodes := map[string]int{}
+	actors := map[string]int{}
+	edges := map[string]bool{}
+
+	// Node of an artifact, added on first sight
+	node := func(bucketName string, fileName string, s3Event string) *Node {
+		id := NodeId(bucketName, fileName, s3Event)
+		if i, ok := nodes[id]; ok {
+			return &g.Nodes[i]
+		}
+		nodes[id] = len(g.Nodes)
+		g.Nodes = append(g.Nodes, Node{Id: id, BucketName: bucketName, FileName: fileName})
+		return &g.Nodes[len(g.Nodes)-1]
+	}
+
+	for _, r := range records {
+		item := r.item
+		actor := ActorOf(item)
+		if i, ok := actors[actor]; ok {
+			g.Actors[i].Events++
+			g.Actors[i].LastSeen = item.Timestamp
+		} else {
+			actors[actor] = len(g.Actors)
+			g.Actors = append(g.Actors, Actor{Id: actor, Events: 1, FirstSeen: item.Timestamp, LastSeen: item.Timestamp})
+		}
+
+		if strings.HasPrefix(item.S3Event, "ObjectRemoved") {
+			target := node(item.TargetBucketName, item.TargetFileName, item.S3Event)
+			target.RemovedAt = item.Timestamp
+			target.RemovedBy = actor
+			continue
+		}
+
+		// Add the source first so nodes are listed in the order the artifacts appeared
+		var sourceId string
+		if item.SourceBucketName != "" {
+			sourceId = node(item.SourceBucketName, item.SourceFileName, "").Id
+		}
+		target := node(item.TargetBucketName, item.TargetFileName, item.S3Event)
+		if target.CreatedAt == "" {
+			target.CreatedAt = item.Timestamp
+			target.CreatedBy = actor
+			target.VersionId = item.VersionId
+			target.Kind = kindOf(item, opts.BucketKinds)
+		}
+		if sourceId == "" {
+			continue
+		}
+
+		// Retried events record the same derivation again
+		key := sourceId + "|" + target.Id + "|" + item.S3Event
+		if edges[key] {
+			continue
+		}
+		edges[key] = true
+		g.Edges = append(g.Edges, Edge{From: sourceId, To: target.Id, S3Event: item.S3Event, Actor: actor, Timestamp: item.Timestamp})
+	}
+
+	// Nodes only seen as sources or removed, as in filtered graphs, have no creation record to tell their kind
+	for i := range g.Nodes {
+		n := &g.Nodes[i]
+		if n.Kind != "" {
+			continue
+		}
+		if kind, ok := opts.BucketKinds[n.BucketName]; ok {
+			n.Kind = kind
+		} else {
+			n.Kind = KindObject
+		}
+	}
+	return g, nil
+}
+
+// Kind of the artifact created by a lineage record
+func kindOf(item datastores.LineageItem, bucketKinds map[string]string) string {
+	switch {
+	case item.S3Event == metadata.S3EventIndexWritten:
+		return KindIndex
+	case bucketKinds[item.TargetBucketName] != "":
+		return bucketKinds[item.TargetBucketName]
+	case item.S3Event == "ObjectCreated:Copy":
+		return KindCopy
+	case item.SourceBucketName == "":
+		return KindSource
+	}
+	return KindObject
+}
@@ -0,0 +1,199 @@
+package lineage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dreamspider42/document-processing-pipeline/src/datastores"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	uploader           = "AIDAUPLOADER"
+	registrarArn       = "arn:aws:lambda:us-east-1:000000000000:function:documentRegistrar"
+	textractArn        = "arn:aws:lambda:us-east-1:000000000000:function:textractProcessor:live"
+	comprehendArn      = "arn:aws:lambda:us-east-1:000000000000:function:comprehendProcessor"
+	indexerArn         = "arn:aws:lambda:us-east-1:000000000000:function:searchIndexer"
+	textractBucketName = "textract-results"
+)
+
+// Lineage records of a document uploaded, copied, parsed by Textract, analysed, indexed and removed, with a
+// retried Textract event and a record of an unparseable timestamp
+func lineageRecords() []datastores.LineageItem {
+	record := func(caller string, s3Event string, timestamp string, source string, target string) datastores.LineageItem {
+		item := datastores.LineageItem{DocumentId: "doc", S3Event: s3Event, Timestamp: timestamp, CallerId: map[string]interface{}{"principalId": caller}}
+		if caller != uploader {
+			item.CallerId["arn"] = caller
+		}
+		fmt.Sscanf(target, "%s %s", &item.TargetBucketName, &item.TargetFileName)
+		if source != "" {
+			fmt.Sscanf(source, "%s %s", &item.SourceBucketName, &item.SourceFileName)
+		}
+		return item
+	}
+	return []datastores.LineageItem{
+		record(uploader, "ObjectRemoved:Delete", "2024-05-01T11:00:00Z", "", "uploads invoice.pdf"),
+		record(textractArn, "ObjectCreated:Put", "2024-05-01T10:02:00Z", "processing doc/invoice.pdf", "textract-results doc/output.json"),
+		record(uploader, "ObjectCreated:Put", "2024-05-01T10:00:00Z", "", "uploads invoice.pdf"),
+		record(registrarArn, "ObjectCreated:Copy", "2024-05-01T10:01:00Z", "uploads invoice.pdf", "processing doc/invoice.pdf"),
+		record(textractArn, "ObjectCreated:Put", "2024-05-01T10:03:00Z", "processing doc/invoice.pdf", "textract-results doc/output.json"),
+		record(comprehendArn, "ObjectCreated:Put", "2024-05-01T10:04:00Z", "textract-results doc/output.json", "comprehend-results doc/entities.json"),
+		record(indexerArn, "IndexWritten:Bulk", "2024-05-01T10:05:00Z", "textract-results doc/output.json", "documents doc"),
+		record(indexerArn, "IndexWritten:Bulk", "yesterday", "textract-results doc/output.json", "documents doc"),
+	}
+}
+
+func TestBuild(t *testing.T) {
+	at := func(timestamp string) time.Time {
+		parsed, _ := time.Parse(time.RFC3339, timestamp)
+		return parsed
+	}
+	tests := []struct {
+		name   string
+		filter Filter
+		// Nodes as "id kind", in the order they appeared
+		wantNodes []string
+		// Edges as "from -> to"
+		wantEdges   []string
+		wantRemoved []string
+	}{
+		{
+			name: "every record",
+			wantNodes: []string{
+				"s3://uploads/invoice.pdf source",
+				"s3://processing/doc/invoice.pdf copy",
+				"s3://textract-results/doc/output.json textract",
+				"s3://comprehend-results/doc/entities.json object",
+				"documents/doc index",
+			},
+			wantEdges: []string{
+				"s3://uploads/invoice.pdf -> s3://processing/doc/invoice.pdf",
+				"s3://processing/doc/invoice.pdf -> s3://textract-results/doc/output.json",
+				"s3://textract-results/doc/output.json -> s3://comprehend-results/doc/entities.json",
+				"s3://textract-results/doc/output.json -> documents/doc",
+			},
+			wantRemoved: []string{"s3://uploads/invoice.pdf"},
+		},
+		{
+			name:   "time range",
+			filter: Filter{From: at("2024-05-01T10:02:00Z"), To: at("2024-05-01T10:04:00Z")},
+			wantNodes: []string{
+				"s3://processing/doc/invoice.pdf object",
+				"s3://textract-results/doc/output.json textract",
+				"s3://comprehend-results/doc/entities.json object",
+			},
+			wantEdges: []string{
+				"s3://processing/doc/invoice.pdf -> s3://textract-results/doc/output.json",
+				"s3://textract-results/doc/output.json -> s3://comprehend-results/doc/entities.json",
+			},
+		},
+		{
+			name:   "actor by function name",
+			filter: Filter{Actors: []string{"textractProcessor"}},
+			wantNodes: []string{
+				"s3://processing/doc/invoice.pdf object",
+				"s3://textract-results/doc/output.json textract",
+			},
+			wantEdges: []string{"s3://processing/doc/invoice.pdf -> s3://textract-results/doc/output.json"},
+		},
+		{
+			name:        "actor by principal id",
+			filter:      Filter{Actors: []string{uploader}},
+			wantNodes:   []string{"s3://uploads/invoice.pdf source"},
+			wantRemoved: []string{"s3://uploads/invoice.pdf"},
+		},
+		{
+			name:        "removal only",
+			filter:      Filter{From: at("2024-05-01T10:30:00Z")},
+			wantNodes:   []string{"s3://uploads/invoice.pdf object"},
+			wantRemoved: []string{"s3://uploads/invoice.pdf"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := Build("doc", lineageRecords(), Options{Filter: tt.filter, BucketKinds: map[string]string{textractBucketName: KindTextract}})
+			if err != nil {
+				t.Fatalf("Build() error = %v", err)
+			}
+
+			nodes, removed := []string{}, []string{}
+			for _, n := range g.Nodes {
+				nodes = append(nodes, n.Id+" "+n.Kind)
+				if n.RemovedAt != "" {
+					removed = append(removed, n.Id)
+				}
+			}
+			edges := []string{}
+			for _, e := range g.Edges {
+				edges = append(edges, e.From+" -> "+e.To)
+			}
+			if !slices.Equal(nodes, tt.wantNodes) {
+				t.Errorf("nodes %q, want %q", nodes, tt.wantNodes)
+			}
+			if !slices.Equal(edges, tt.wantEdges) {
+				t.Errorf("edges %q, want %q", edges, tt.wantEdges)
+			}
+			if !slices.Equal(removed, tt.wantRemoved) {
+				t.Errorf("removed %q, want %q", removed, tt.wantRemoved)
+			}
+		})
+	}
+}
+
+func TestBuildNodesAndActors(t *testing.T) {
+	g, err := Build("doc", lineageRecords(), Options{BucketKinds: map[string]string{textractBucketName: KindTextract}})
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+
+	upload := g.Nodes[0]
+	if upload.CreatedAt != "2024-05-01T10:00:00Z" || upload.CreatedBy != uploader {
+		t.Errorf("upload created at %s by %s", upload.CreatedAt, upload.CreatedBy)
+	}
+	if upload.RemovedAt != "2024-05-01T11:00:00Z" || upload.RemovedBy != uploader {
+		t.Errorf("upload removed at %s by %s", upload.RemovedAt, upload.RemovedBy)
+	}
+	// The retried event neither adds an edge nor moves the creation of its artifact
+	textract := g.Nodes[2]
+	if textract.CreatedAt != "2024-05-01T10:02:00Z" || textract.CreatedBy != textractArn {
+		t.Errorf("Textract output created at %s by %s", textract.CreatedAt, textract.CreatedBy)
+	}
+	if edge := g.Edges[1]; edge.Timestamp != "2024-05-01T10:02:00Z" || edge.S3Event != "ObjectCreated:Put" {
+		t.Errorf("Textract edge %+v", edge)
+	}
+
+	actors := []string{}
+	for _, a := range g.Actors {
+		actors = append(actors, fmt.Sprintf("%s %d %s %s", ActorName(a.Id), a.Events, a.FirstSeen, a.LastSeen))
+	}
+	want := []string{
+		uploader + " 2 2024-05-01T10:00:00Z 2024-05-01T11:00:00Z",
+		"documentRegistrar 1 2024-05-01T10:01:00Z 2024-05-01T10:01:00Z",
+		"textractProcessor 2 2024-05-01T10:02:00Z 2024-05-01T10:03:00Z",
+		"comprehendProcessor 1 2024-05-01T10:04:00Z 2024-05-01T10:04:00Z",
+		"searchIndexer 1 2024-05-01T10:05:00Z 2024-05-01T10:05:00Z",
+	}
+	if !slices.Equal(actors, want) {
+		t.Errorf("actors %q, want %q", actors, want)
+	}
+}
+
+func TestActorName(t *testing.T) {
+	tests := []struct {
+		actor string
+		want  string
+	}{
+		{actor: comprehendArn, want: "comprehendProcessor"},
+		{actor: textractArn, want: "textractProcessor"},
+		{actor: uploader, want: uploader},
+		{actor: "arn:aws:iam::000000000000:user/alice", want: "arn:aws:iam::000000000000:user/alice"},
+	}
+
+	for _, tt := range tests {
+		if got := ActorName(tt.actor); got != tt.want {
+			t.Errorf("ActorName(%q) = %q, want %q", tt.actor, got, tt.want)
+		}
+	}
+}
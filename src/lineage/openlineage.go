@@ -0,0 +1,194 @@
+package lineage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dreamspider42/document-processing-pipeline/src/datastores"
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
+	"github.com/google/uuid"
+)
+
+// Producer and schema of the exported OpenLineage events
+const (
+	Producer                = "https://github.com/dreamspider42/document-processing-pipeline"
+	OpenLineageSchemaURL    = "https://openlineage.io/spec/2-0-2/OpenLineage.json#/$defs/RunEvent"
+	lifecycleSchemaURL      = "https://openlineage.io/spec/facets/1-0-1/LifecycleStateChangeDatasetFacet.json#/$defs/LifecycleStateChangeDatasetFacet"
+	datasetVersionSchemaURL = "https://openlineage.io/spec/facets/1-0-1/DatasetVersionDatasetFacet.json#/$defs/DatasetVersionDatasetFacet"
+	// Facet carrying the document and lineage record of a run
+	PipelineFacet          = "documentProcessingPipeline"
+	pipelineFacetSchemaURL = Producer + "#lineage-exports"
+)
+
+// OpenLineageRunEvent is an OpenLineage run event. Each lineage record is exported as a COMPLETE event of its own run.
+type OpenLineageRunEvent struct {
+	EventType string               `json:"eventType"`
+	EventTime string               `json:"eventTime"`
+	Run       OpenLineageRun       `json:"run"`
+	Job       OpenLineageJob       `json:"job"`
+	Inputs    []OpenLineageDataset `json:"inputs"`
+	Outputs   []OpenLineageDataset `json:"outputs"`
+	Producer  string               `json:"producer"`
+	SchemaURL string               `json:"schemaURL"`
+}
+
+type OpenLineageRun struct {
+	RunId  string                 `json:"runId"`
+	Facets map[string]interface{} `json:"facets,omitempty"`
+}
+
+type OpenLineageJob struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+type OpenLineageDataset struct {
+	Namespace string                 `json:"namespace"`
+	Name      string                 `json:"name"`
+	Facets    map[string]interface{} `json:"facets,omitempty"`
+}
+
+// Id of a lineage record, stable across exports so consumers can recognise records exported again
+func RecordId(item datastores.LineageItem) string {
+	name := fmt.Sprintf("%s|%s|%s|%s|%s", item.DocumentId, item.Timestamp, item.TargetBucketName, item.TargetFileName, item.S3Event)
+	return uuid.NewSHA1(metadata.DocumentIdNamespace, []byte(name)).String()
+}
+
+// Timestamp of a lineage record in RFC 3339, converting legacy timestamps
+func recordTime(item datastores.LineageItem) (string, error) {
+	timestamp, err := metadata.ParseTimestamp(item.Timestamp)
+	if err != nil {
+		return "", fmt.Errorf("lineage record of %s/%s: %v", item.TargetBucketName, item.TargetFileName, err)
+	}
+	return metadata.FormatTimestamp(timestamp), nil
+}
+
+// OpenLineage dataset of an artifact: S3 objects are named by their key in the s3://<bucket> namespace, index
+// records by their documentId in the namespace of the index
+func openLineageDataset(bucketName string, fileName string, s3Event string) OpenLineageDataset {
+	if s3Event == metadata.S3EventIndexWritten {
+		return OpenLineageDataset{Namespace: bucketName, Name: fileName}
+	}
+	return OpenLineageDataset{Namespace: "s3://" + bucketName, Name: fileName}
+}
+
+// OpenLineage run event of a lineage record, with jobs in the given namespace named after the actor
+func ToOpenLineage(item datastores.LineageItem, jobNamespace string) (*OpenLineageRunEvent, error) {
+	eventTime, err := recordTime(item)
+	if err != nil {
+		return nil, err
+	}
+	actor := ActorOf(item)
+
+	event := &OpenLineageRunEvent{
+		EventType: "COMPLETE",
+		EventTime: eventTime,
+		Run: OpenLineageRun{
+			RunId: RecordId(item),
+			Facets: map[string]interface{}{
+				PipelineFacet: map[string]interface{}{
+					"_producer":  Producer,
+					"_schemaURL": pipelineFacetSchemaURL,
+					"documentId": item.DocumentId,
+					"s3Event":    item.S3Event,
+					"callerId":   actor,
+				},
+			},
+		},
+		Job:       OpenLineageJob{Namespace: jobNamespace, Name: ActorName(actor)},
+		Inputs:    []OpenLineageDataset{},
+		Outputs:   []OpenLineageDataset{},
+		Producer:  Producer,
+		SchemaURL: OpenLineageSchemaURL,
+	}
+	if item.SourceBucketName != "" {
+		event.Inputs = append(event.Inputs, openLineageDataset(item.SourceBucketName, item.SourceFileName, ""))
+	}
+
+	output := openLineageDataset(item.TargetBucketName, item.TargetFileName, item.S3Event)
+	output.Facets = map[string]interface{}{}
+	switch {
+	case strings.HasPrefix(item.S3Event, "ObjectRemoved"):
+		output.Facets["lifecycleStateChange"] = lifecycleFacet("DROP")
+	case item.SourceBucketName == "":
+		output.Facets["lifecycleStateChange"] = lifecycleFacet("CREATE")
+	}
+	if item.VersionId != "" {
+		output.Facets["version"] = map[string]interface{}{
+			"_producer":      Producer,
+			"_schemaURL":     datasetVersionSchemaURL,
+			"datasetVersion": item.VersionId,
+		}
+	}
+	event.Outputs = append(event.Outputs, output)
+	return event, nil
+}
+
+func lifecycleFacet(change string) map[string]interface{} {
+	return map[string]interface{}{
+		"_producer":            Producer,
+		"_schemaURL":           lifecycleSchemaURL,
+		"lifecycleStateChange": change,
+	}
+}
+
+// Write the OpenLineage events of lineage records as newline delimited JSON
+func WriteOpenLineage(w io.Writer, items []datastores.LineageItem, jobNamespace string) error {
+	encoder := json.NewEncoder(w)
+	for _, item := range items {
+		event, err := ToOpenLineage(item, jobNamespace)
+		if err != nil {
+			return err
+		}
+		err = encoder.Encode(event)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OpenLineageClient posts run events to an OpenLineage HTTP endpoint, such as Marquez's /api/v1/lineage
+type OpenLineageClient struct {
+	Url string
+	// Sent as a bearer token when set
+	ApiKey string
+	Client *http.Client
+}
+
+// Create a client of an OpenLineage endpoint
+func NewOpenLineageClient(url string, apiKey string) *OpenLineageClient {
+	return &OpenLineageClient{Url: url, ApiKey: apiKey, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Post a run event
+func (c *OpenLineageClient) Emit(event *OpenLineageRunEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("error marshalling OpenLineage event: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, c.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.ApiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.ApiKey)
+	}
+
+	res, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		responseBody, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("OpenLineage endpoint responded %s: %s", res.Status, responseBody)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package lineage
+
+import (
+	"testing"
+
+	"github.com/dreamspider42/document-processing-pipeline/src/datastores"
+	"golang.org/x/exp/slices"
+)
+
+func TestToOpenLineage(t *testing.T) {
+	records := lineageRecords()
+	versioned := records[2]
+	versioned.VersionId = "v1"
+	legacy := records[3]
+	legacy.Timestamp = "2024-05-01 10:01:00 +0000 UTC"
+
+	tests := []struct {
+		name          string
+		item          datastores.LineageItem
+		wantJob       string
+		wantTime      string
+		wantInputs    []OpenLineageDataset
+		wantOutput    OpenLineageDataset
+		wantLifecycle string
+		wantVersion   string
+	}{
+		{
+			name:          "upload",
+			item:          versioned,
+			wantJob:       uploader,
+			wantTime:      "2024-05-01T10:00:00.000000000Z",
+			wantOutput:    OpenLineageDataset{Namespace: "s3://uploads", Name: "invoice.pdf"},
+			wantLifecycle: "CREATE",
+			wantVersion:   "v1",
+		},
+		{
+			name:       "copy of a legacy timestamp",
+			item:       legacy,
+			wantJob:    "documentRegistrar",
+			wantTime:   "2024-05-01T10:01:00.000000000Z",
+			wantInputs: []OpenLineageDataset{{Namespace: "s3://uploads", Name: "invoice.pdf"}},
+			wantOutput: OpenLineageDataset{Namespace: "s3://processing", Name: "doc/invoice.pdf"},
+		},
+		{
+			name:       "index write",
+			item:       records[6],
+			wantJob:    "searchIndexer",
+			wantTime:   "2024-05-01T10:05:00.000000000Z",
+			wantInputs: []OpenLineageDataset{{Namespace: "s3://textract-results", Name: "doc/output.json"}},
+			wantOutput: OpenLineageDataset{Namespace: "documents", Name: "doc"},
+		},
+		{
+			name:          "removal",
+			item:          records[0],
+			wantJob:       uploader,
+			wantTime:      "2024-05-01T11:00:00.000000000Z",
+			wantOutput:    OpenLineageDataset{Namespace: "s3://uploads", Name: "invoice.pdf"},
+			wantLifecycle: "DROP",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := ToOpenLineage(tt.item, "pipeline")
+			if err != nil {
+				t.Fatalf("ToOpenLineage() error = %v", err)
+			}
+
+			if event.EventType != "COMPLETE" || event.EventTime != tt.wantTime || event.Run.RunId != RecordId(tt.item) {
+				t.Errorf("event %s at %s of run %s", event.EventType, event.EventTime, event.Run.RunId)
+			}
+			if event.Job != (OpenLineageJob{Namespace: "pipeline", Name: tt.wantJob}) {
+				t.Errorf("job %+v, want %s", event.Job, tt.wantJob)
+			}
+			facet := event.Run.Facets[PipelineFacet].(map[string]interface{})
+			if facet["documentId"] != "doc" || facet["s3Event"] != tt.item.S3Event || facet["callerId"] != ActorOf(tt.item) {
+				t.Errorf("run facet %v", facet)
+			}
+			if !slices.EqualFunc(event.Inputs, tt.wantInputs, func(a OpenLineageDataset, b OpenLineageDataset) bool {
+				return a.Namespace == b.Namespace && a.Name == b.Name
+			}) {
+				t.Errorf("inputs %+v, want %+v", event.Inputs, tt.wantInputs)
+			}
+			if len(event.Outputs) != 1 {
+				t.Fatalf("outputs %+v, want one", event.Outputs)
+			}
+
+			output := event.Outputs[0]
+			if output.Namespace != tt.wantOutput.Namespace || output.Name != tt.wantOutput.Name {
+				t.Errorf("output %s %s, want %s %s", output.Namespace, output.Name, tt.wantOutput.Namespace, tt.wantOutput.Name)
+			}
+			if got := facetField(output.Facets, "lifecycleStateChange", "lifecycleStateChange"); got != tt.wantLifecycle {
+				t.Errorf("lifecycle state change %q, want %q", got, tt.wantLifecycle)
+			}
+			if got := facetField(output.Facets, "version", "datasetVersion"); got != tt.wantVersion {
+				t.Errorf("dataset version %q, want %q", got, tt.wantVersion)
+			}
+		})
+	}
+}
+
+// Field of a dataset facet, empty without the facet
+func facetField(facets map[string]interface{}, facet string, field string) string {
+	fields, _ := facets[facet].(map[string]interface{})
+	value, _ := fields[field].(string)
+	return value
+}
+
+func TestToOpenLineageTimestamp(t *testing.T) {
+	records := lineageRecords()
+	_, err := ToOpenLineage(records[len(records)-1], "pipeline")
+	if err == nil {
+		t.Error("ToOpenLineage() error = nil for an unparseable timestamp")
+	}
+}
+
+func TestRecordId(t *testing.T) {
+	records := lineageRecords()
+	retried := records[4]
+	if RecordId(records[1]) != RecordId(records[1]) {
+		t.Error("RecordId() changed between exports")
+	}
+	if RecordId(records[1]) == RecordId(retried) {
+		t.Error("RecordId() is the same for the records of a retried event")
+	}
+}
@@ -0,0 +1,162 @@
+package lineage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/dreamspider42/document-processing-pipeline/src/datastores"
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
+)
+
+// Namespace of the pipeline's PROV identifiers
+const ProvNamespace = "urn:document-processing-pipeline:"
+
+// ProvDocument is a W3C PROV-JSON document. Each lineage record is an activity that generated its target entity,
+// used its source entity when it has one, and was associated with its caller as agent.
+type ProvDocument struct {
+	Prefix            map[string]string `json:"prefix"`
+	Entity            provRecords       `json:"entity"`
+	Activity          provRecords       `json:"activity"`
+	Agent             provRecords       `json:"agent"`
+	WasGeneratedBy    provRecords       `json:"wasGeneratedBy"`
+	Used              provRecords       `json:"used"`
+	WasDerivedFrom    provRecords       `json:"wasDerivedFrom"`
+	WasAssociatedWith provRecords       `json:"wasAssociatedWith"`
+	WasInvalidatedBy  provRecords       `json:"wasInvalidatedBy"`
+}
+
+// PROV-JSON records by identifier
+type provRecords map[string]map[string]interface{}
+
+// Create an empty PROV document
+func NewProvDocument() *ProvDocument {
+	return &ProvDocument{
+		Prefix: map[string]string{
+			"pipeline": ProvNamespace,
+			"s3":       "s3://",
+		},
+		Entity:            provRecords{},
+		Activity:          provRecords{},
+		Agent:             provRecords{},
+		WasGeneratedBy:    provRecords{},
+		Used:              provRecords{},
+		WasDerivedFrom:    provRecords{},
+		WasAssociatedWith: provRecords{},
+		WasInvalidatedBy:  provRecords{},
+	}
+}
+
+// PROV document of lineage records
+func ToProv(items []datastores.LineageItem) (*ProvDocument, error) {
+	doc := NewProvDocument()
+	for _, item := range items {
+		err := doc.Add(item)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// Identifier of the entity of an artifact. S3 keys are escaped segment by segment, so the id expands to the
+// object's URL.
+func provEntityId(bucketName string, fileName string, s3Event string) string {
+	if s3Event == metadata.S3EventIndexWritten {
+		return "pipeline:index/" + url.PathEscape(bucketName) + "/" + url.PathEscape(fileName)
+	}
+	segments := strings.Split(fileName, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return "s3:" + url.PathEscape(bucketName) + "/" + strings.Join(segments, "/")
+}
+
+// Add the activity of a lineage record and the entities and agent it involves
+func (d *ProvDocument) Add(item datastores.LineageItem) error {
+	timestamp, err := recordTime(item)
+	if err != nil {
+		return err
+	}
+	recordId := RecordId(item)
+	actor := ActorOf(item)
+
+	activityId := "pipeline:activity/" + recordId
+	d.Activity[activityId] = map[string]interface{}{
+		"prov:startTime":      timestamp,
+		"prov:endTime":        timestamp,
+		"prov:type":           item.S3Event,
+		"pipeline:documentId": item.DocumentId,
+	}
+
+	agentType := "prov:Person"
+	if strings.HasPrefix(actor, "arn:") {
+		agentType = "prov:SoftwareAgent"
+	}
+	agentId := "pipeline:agent/" + url.PathEscape(actor)
+	d.Agent[agentId] = map[string]interface{}{
+		"prov:type":         map[string]string{"$": agentType, "type": "prov:QUALIFIED_NAME"},
+		"prov:label":        ActorName(actor),
+		"pipeline:callerId": actor,
+	}
+	d.WasAssociatedWith["_:assoc/"+recordId] = map[string]interface{}{
+		"prov:activity": activityId,
+		"prov:agent":    agentId,
+	}
+
+	targetId := provEntityId(item.TargetBucketName, item.TargetFileName, item.S3Event)
+	d.addEntity(targetId, item.TargetBucketName, item.TargetFileName, item.DocumentId)
+	if item.VersionId != "" {
+		d.Entity[targetId]["pipeline:versionId"] = item.VersionId
+	}
+
+	if strings.HasPrefix(item.S3Event, "ObjectRemoved") {
+		d.WasInvalidatedBy["_:inv/"+recordId] = map[string]interface{}{
+			"prov:entity":   targetId,
+			"prov:activity": activityId,
+			"prov:time":     timestamp,
+		}
+		return nil
+	}
+	d.WasGeneratedBy["_:gen/"+recordId] = map[string]interface{}{
+		"prov:entity":   targetId,
+		"prov:activity": activityId,
+		"prov:time":     timestamp,
+	}
+	if item.SourceBucketName == "" {
+		return nil
+	}
+
+	sourceId := provEntityId(item.SourceBucketName, item.SourceFileName, "")
+	d.addEntity(sourceId, item.SourceBucketName, item.SourceFileName, item.DocumentId)
+	d.Used["_:use/"+recordId] = map[string]interface{}{
+		"prov:activity": activityId,
+		"prov:entity":   sourceId,
+		"prov:time":     timestamp,
+	}
+	d.WasDerivedFrom["_:der/"+recordId] = map[string]interface{}{
+		"prov:generatedEntity": targetId,
+		"prov:usedEntity":      sourceId,
+		"prov:activity":        activityId,
+	}
+	return nil
+}
+
+func (d *ProvDocument) addEntity(id string, bucketName string, fileName string, documentId string) {
+	if _, ok := d.Entity[id]; ok {
+		return
+	}
+	d.Entity[id] = map[string]interface{}{
+		"prov:label":          fmt.Sprintf("%s/%s", bucketName, fileName),
+		"pipeline:documentId": documentId,
+	}
+}
+
+// Write the document as indented PROV-JSON
+func (d *ProvDocument) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(d)
+}
@@ -0,0 +1,92 @@
+package lineage
+
+import (
+	"testing"
+
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
+)
+
+func TestProvEntityId(t *testing.T) {
+	tests := []struct {
+		bucketName string
+		fileName   string
+		s3Event    string
+		want       string
+	}{
+		{bucketName: "uploads", fileName: "invoice.pdf", want: "s3:uploads/invoice.pdf"},
+		{bucketName: "uploads", fileName: "scans/Q1 report#1?.pdf", want: "s3:uploads/scans/Q1%20report%231%3F.pdf"},
+		{bucketName: "uploads", fileName: `a "b" c.pdf`, want: "s3:uploads/a%20%22b%22%20c.pdf"},
+		{bucketName: "opensearch:documents", fileName: "doc/1", s3Event: metadata.S3EventIndexWritten, want: "pipeline:index/opensearch:documents/doc%2F1"},
+	}
+
+	for _, tt := range tests {
+		if got := provEntityId(tt.bucketName, tt.fileName, tt.s3Event); got != tt.want {
+			t.Errorf("provEntityId(%q, %q) = %q, want %q", tt.bucketName, tt.fileName, got, tt.want)
+		}
+	}
+}
+
+func TestToProv(t *testing.T) {
+	records := lineageRecords()
+	doc, err := ToProv(records[:len(records)-1])
+	if err != nil {
+		t.Fatalf("ToProv() error = %v", err)
+	}
+
+	const (
+		upload   = "s3:uploads/invoice.pdf"
+		copied   = "s3:processing/doc/invoice.pdf"
+		textract = "s3:textract-results/doc/output.json"
+		index    = "pipeline:index/documents/doc"
+	)
+	for _, id := range []string{upload, copied, textract, "s3:comprehend-results/doc/entities.json", index} {
+		if _, ok := doc.Entity[id]; !ok {
+			t.Errorf("no entity %s", id)
+		}
+	}
+	if len(doc.Entity) != 5 || len(doc.Activity) != 7 || len(doc.Agent) != 5 {
+		t.Errorf("%d entities, %d activities and %d agents, want 5, 7 and 5", len(doc.Entity), len(doc.Activity), len(doc.Agent))
+	}
+
+	// Every record but the removal generated its target, and the removal invalidated the upload
+	removal := "pipeline:activity/" + RecordId(records[0])
+	if len(doc.WasGeneratedBy) != 6 || len(doc.WasInvalidatedBy) != 1 {
+		t.Errorf("%d generations and %d invalidations, want 6 and 1", len(doc.WasGeneratedBy), len(doc.WasInvalidatedBy))
+	}
+	invalidation := doc.WasInvalidatedBy["_:inv/"+RecordId(records[0])]
+	if invalidation["prov:entity"] != upload || invalidation["prov:activity"] != removal || invalidation["prov:time"] != "2024-05-01T11:00:00.000000000Z" {
+		t.Errorf("invalidation %v", invalidation)
+	}
+	if _, ok := doc.WasGeneratedBy["_:gen/"+RecordId(records[0])]; ok {
+		t.Error("the removal generated its target")
+	}
+
+	derivation := doc.WasDerivedFrom["_:der/"+RecordId(records[3])]
+	if derivation["prov:generatedEntity"] != copied || derivation["prov:usedEntity"] != upload {
+		t.Errorf("derivation of the copy %v", derivation)
+	}
+	derivation = doc.WasDerivedFrom["_:der/"+RecordId(records[6])]
+	if derivation["prov:generatedEntity"] != index || derivation["prov:usedEntity"] != textract {
+		t.Errorf("derivation of the index write %v", derivation)
+	}
+	if len(doc.Used) != 5 || len(doc.WasDerivedFrom) != 5 {
+		t.Errorf("%d uses and %d derivations, want 5 and 5", len(doc.Used), len(doc.WasDerivedFrom))
+	}
+
+	agentType := func(actor string) string {
+		agent := doc.Agent["pipeline:agent/"+actor]
+		kind, _ := agent["prov:type"].(map[string]string)
+		return kind["$"]
+	}
+	if got := agentType(uploader); got != "prov:Person" {
+		t.Errorf("uploader is a %s, want prov:Person", got)
+	}
+	if got := agentType("arn:aws:lambda:us-east-1:000000000000:function:documentRegistrar"); got != "prov:SoftwareAgent" {
+		t.Errorf("registrar is a %s, want prov:SoftwareAgent", got)
+	}
+
+	_, err = ToProv(records)
+	if err == nil {
+		t.Error("ToProv() error = nil for a record of an unparseable timestamp")
+	}
+}
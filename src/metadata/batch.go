@@ -0,0 +1,375 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+const (
+	// Limits of an SNS PublishBatch or EventBridge PutEvents request
+	MaxBatchEntries = 10
+	MaxBatchBytes   = 256 * 1024
+
+	defaultBatchMaxRetries   = 3
+	defaultBatchRetryBackoff = 200 * time.Millisecond
+)
+
+// MetadataBatch buffers the events of the clients created WithBatch until Flush publishes them with batch calls.
+// Create one per handler and flush it at the end of each invocation.
+type MetadataBatch struct {
+	// Retries of entries failing with a retryable error, backing off exponentially
+	MaxRetries   int
+	RetryBackoff time.Duration
+
+	mu      sync.Mutex
+	entries []batchEntry
+}
+
+// A buffered event, ready to send
+type batchEntry struct {
+	client  *MetadataClient
+	message TransportMessage
+}
+
+// A transport sending several messages with one request
+type batchTransport interface {
+	Transport
+	// Send a chunk of entries, returning the entries that failed
+	sendBatch(chunk []batchEntry) []entryFailure
+}
+
+// An entry a send attempt failed on
+type entryFailure struct {
+	entry batchEntry
+	// Position of the entry in the chunk sent
+	index     int
+	code      string
+	message   string
+	retryable bool
+	// Not sent, as an earlier entry of its document failed
+	skipped bool
+}
+
+// BatchFailure is an event Flush could not publish
+type BatchFailure struct {
+	Target       string `json:"target"`
+	MetadataType string `json:"metadataType"`
+	DocumentId   string `json:"documentId"`
+	Code         string `json:"code"`
+	Message      string `json:"message"`
+	// Not sent because an earlier event of the document failed
+	Skipped bool `json:"skipped"`
+}
+
+// BatchError is returned by Flush when some events were not published
+type BatchError struct {
+	Published int
+	Failures  []BatchFailure
+}
+
+func (e *BatchError) Error() string {
+	first := e.Failures[0]
+	return fmt.Sprintf("%d of %d metadata events not published, first %s event of document %s: %s %s",
+		len(e.Failures), len(e.Failures)+e.Published, first.MetadataType, first.DocumentId, first.Code, first.Message)
+}
+
+// Create a new, empty batch
+func NewMetadataBatch() *MetadataBatch {
+	return &MetadataBatch{
+		MaxRetries:   defaultBatchMaxRetries,
+		RetryBackoff: defaultBatchRetryBackoff,
+	}
+}
+
+// Buffer the client's events in the batch instead of publishing them one by one
+func WithBatch(batch *MetadataBatch) MetadataClientOption {
+	return func(m *MetadataClient) {
+		m.batch = batch
+	}
+}
+
+func (b *MetadataBatch) add(client *MetadataClient, message TransportMessage) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.entries = append(b.entries, batchEntry{client: client, message: message})
+}
+
+// Number of buffered events
+func (b *MetadataBatch) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.entries)
+}
+
+// Publish the buffered events and empty the buffer. Events to the same target, from any client, are sent in the
+// order they were published, up to MaxBatchEntries per request. When an event fails, the later events of its
+// document in the same request are sent again after it, which the FIFO topic deduplicates; once an event fails
+// for good, the later events of its document on that target are skipped rather than published out of order.
+// Failures are reported in a *BatchError.
+func (b *MetadataBatch) Flush() error {
+	b.mu.Lock()
+	entries := b.entries
+	b.entries = nil
+	b.mu.Unlock()
+	if len(entries) == 0 {
+		return nil
+	}
+
+	// Group the entries by target, keeping their order
+	targets := []interface{}{}
+	byTarget := map[interface{}][]batchEntry{}
+	for _, entry := range entries {
+		target := targetOf(entry.client.transport)
+		if _, ok := byTarget[target]; !ok {
+			targets = append(targets, target)
+		}
+		byTarget[target] = append(byTarget[target], entry)
+	}
+
+	result := &BatchError{}
+	for _, target := range targets {
+		b.flushTarget(byTarget[target], result)
+	}
+	log.Printf("Published %d of %d metadata events \n", result.Published, len(entries))
+	if len(result.Failures) > 0 {
+		return result
+	}
+	return nil
+}
+
+// Target of a transport's messages. Each client has its own transport, so the AWS transports are grouped by
+// what they deliver to, and other transports by identity.
+func targetOf(transport Transport) interface{} {
+	switch t := transport.(type) {
+	case *SNSTransport:
+		return "sns|" + t.TopicArn
+	case *EventBridgeTransport:
+		return "eventbridge|" + t.EventBusName + "|" + t.Source
+	case *LambdaTransport:
+		return "lambda|" + t.FunctionName + "|" + t.InvocationType
+	}
+	return transport
+}
+
+// Flush at the end of a handler invocation with `defer batch.FlushOnReturn(&err)`. A flush failure becomes the
+// handler error unless the handler already failed.
+func (b *MetadataBatch) FlushOnReturn(err *error) {
+	flushErr := b.Flush()
+	if flushErr != nil {
+		log.Printf("Failed to publish metadata events: %v \n", flushErr)
+		if *err == nil {
+			*err = flushErr
+		}
+	}
+}
+
+// Publish the entries of one target in requests of up to MaxBatchEntries and MaxBatchBytes
+func (b *MetadataBatch) flushTarget(entries []batchEntry, result *BatchError) {
+	failedDocuments := map[string]bool{}
+	maxEntries := MaxBatchEntries
+	if _, ok := entries[0].client.transport.(batchTransport); !ok {
+		// Transports without batch requests, such as Lambda, send one event at a time
+		maxEntries = 1
+	}
+
+	var chunk []batchEntry
+	chunkBytes := 0
+	send := func() {
+		failures := b.sendWithRetries(chunk)
+		for _, failure := range failures {
+			failedDocuments[failure.entry.message.DocumentId] = true
+			result.Failures = append(result.Failures, newBatchFailure(failure.entry, failure.code, failure.message, failure.skipped))
+		}
+		result.Published += len(chunk) - len(failures)
+		chunk = nil
+		chunkBytes = 0
+	}
+
+	for _, entry := range entries {
+		if failedDocuments[entry.message.DocumentId] {
+			result.Failures = append(result.Failures, newBatchFailure(entry, skippedCode, skippedMessage, true))
+			continue
+		}
+		size, _ := entry.client.transport.MessageSize(entry.message)
+		if len(chunk) > 0 && (len(chunk) == maxEntries || chunkBytes+size > MaxBatchBytes) {
+			send()
+			if failedDocuments[entry.message.DocumentId] {
+				result.Failures = append(result.Failures, newBatchFailure(entry, skippedCode, skippedMessage, true))
+				continue
+			}
+		}
+		chunk = append(chunk, entry)
+		chunkBytes += size
+	}
+	if len(chunk) > 0 {
+		send()
+	}
+}
+
+// Failures of the events following a failed event of their document, either not sent or published out of order
+const (
+	skippedCode       = "Skipped"
+	skippedMessage    = "an earlier event of the document failed"
+	outOfOrderCode    = "OutOfOrder"
+	outOfOrderMessage = "published before an earlier event of the document"
+)
+
+// Send a chunk, retrying the entries that fail with a retryable error in chunk order. A FIFO topic fails the
+// later entries of a message group after a failed one, so they are retried with it. Returns the entries that
+// still failed, in chunk order.
+func (b *MetadataBatch) sendWithRetries(chunk []batchEntry) []entryFailure {
+	failed := []entryFailure{}
+	pending := chunk
+	for attempt := 0; len(pending) > 0; attempt++ {
+		failures := map[int]entryFailure{}
+		for _, failure := range sendChunk(pending) {
+			failures[failure.index] = failure
+		}
+
+		retry := []batchEntry{}
+		failedDocuments := map[string]bool{}
+		retriedDocuments := map[string]bool{}
+		for i, entry := range pending {
+			documentId := entry.message.DocumentId
+			failure, ok := failures[i]
+			switch {
+			case failedDocuments[documentId] && ok:
+				failed = append(failed, entryFailure{entry: entry, code: skippedCode, message: skippedMessage, skipped: true})
+			case failedDocuments[documentId] || (retriedDocuments[documentId] && !ok):
+				// Published ahead of an earlier event of its document, and deduplicated if sent again
+				failed = append(failed, entryFailure{entry: entry, code: outOfOrderCode, message: outOfOrderMessage})
+			case ok && failure.retryable && attempt < b.MaxRetries:
+				retry = append(retry, entry)
+				retriedDocuments[documentId] = true
+			case ok:
+				failed = append(failed, failure)
+				failedDocuments[documentId] = true
+			}
+		}
+		if len(retry) > 0 {
+			log.Printf("Retrying %d of %d metadata events \n", len(retry), len(pending))
+			time.Sleep(b.RetryBackoff << attempt)
+		}
+		pending = retry
+	}
+	return failed
+}
+
+// Send a chunk of entries to their shared transport with a single request, returning the entries that failed
+func sendChunk(chunk []batchEntry) []entryFailure {
+	if transport, ok := chunk[0].client.transport.(batchTransport); ok {
+		return transport.sendBatch(chunk)
+	}
+
+	failures := []entryFailure{}
+	for i, entry := range chunk {
+		err := entry.client.transport.Send(entry.message)
+		if err != nil {
+			// Consumers that failed processing the event are not sent it again
+			var functionErr *FunctionError
+			var subscriberErr *SubscriberError
+			retryable := !errors.As(err, &functionErr) && !errors.As(err, &subscriberErr)
+			failures = append(failures, requestFailure(i, entry, err, retryable))
+		}
+	}
+	return failures
+}
+
+func (t *SNSTransport) sendBatch(chunk []batchEntry) []entryFailure {
+	input := &sns.PublishBatchInput{TopicArn: &t.TopicArn}
+	for i, entry := range chunk {
+		input.PublishBatchRequestEntries = append(input.PublishBatchRequestEntries, &sns.PublishBatchRequestEntry{
+			Id:                aws.String(strconv.Itoa(i)),
+			Message:           aws.String(string(entry.message.Body)),
+			MessageGroupId:    aws.String(entry.message.DocumentId),
+			MessageAttributes: messageAttributes(entry.message.MetadataType),
+		})
+	}
+
+	res, err := t.Client.PublishBatch(input)
+	if err != nil {
+		return requestFailures(chunk, err)
+	}
+	failures := []entryFailure{}
+	for _, failed := range res.Failed {
+		i, err := strconv.Atoi(aws.StringValue(failed.Id))
+		if err != nil || i < 0 || i >= len(chunk) {
+			continue
+		}
+		failures = append(failures, entryFailure{
+			entry:     chunk[i],
+			index:     i,
+			code:      aws.StringValue(failed.Code),
+			message:   aws.StringValue(failed.Message),
+			retryable: !aws.BoolValue(failed.SenderFault),
+		})
+	}
+	return failures
+}
+
+func (t *EventBridgeTransport) sendBatch(chunk []batchEntry) []entryFailure {
+	input := &eventbridge.PutEventsInput{}
+	for _, entry := range chunk {
+		input.Entries = append(input.Entries, t.entry(entry.message))
+	}
+
+	res, err := t.Client.PutEvents(input)
+	if err != nil {
+		return requestFailures(chunk, err)
+	}
+	failures := []entryFailure{}
+	for i, result := range res.Entries {
+		if result.ErrorCode == nil || i >= len(chunk) {
+			continue
+		}
+		code := aws.StringValue(result.ErrorCode)
+		failures = append(failures, entryFailure{
+			entry:     chunk[i],
+			index:     i,
+			code:      code,
+			message:   aws.StringValue(result.ErrorMessage),
+			retryable: code == "InternalFailure" || code == "ThrottlingException",
+		})
+	}
+	return failures
+}
+
+// Fail every entry of a request that failed as a whole
+func requestFailures(chunk []batchEntry, err error) []entryFailure {
+	retryable := request.IsErrorRetryable(err) || request.IsErrorThrottle(err)
+	failures := make([]entryFailure, 0, len(chunk))
+	for i, entry := range chunk {
+		failures = append(failures, requestFailure(i, entry, err, retryable))
+	}
+	return failures
+}
+
+func requestFailure(index int, entry batchEntry, err error, retryable bool) entryFailure {
+	failure := entryFailure{entry: entry, index: index, code: "RequestFailed", message: err.Error(), retryable: retryable}
+	if aerr, ok := err.(awserr.Error); ok {
+		failure.code = aerr.Code()
+		failure.message = aerr.Message()
+	}
+	return failure
+}
+
+func newBatchFailure(entry batchEntry, code string, message string, skipped bool) BatchFailure {
+	return BatchFailure{
+		Target:       entry.client.targetArn,
+		MetadataType: entry.message.MetadataType,
+		DocumentId:   entry.message.DocumentId,
+		Code:         code,
+		Message:      message,
+		Skipped:      skipped,
+	}
+}
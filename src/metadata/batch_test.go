@@ -0,0 +1,212 @@
+package metadata
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"golang.org/x/exp/slices"
+)
+
+// A batch transport failing entries as scripted, by body, for each attempt at sending them. As a FIFO topic, it
+// fails the later entries of a document after a failed one and drops entries it already published.
+type fakeBatchTransport struct {
+	// Outcome of each attempt at an entry: "retry" for a retryable failure, "fatal" for a permanent one
+	script    map[string][]string
+	attempts  map[string]int
+	requests  [][]string
+	published []string
+}
+
+func (t *fakeBatchTransport) Send(message TransportMessage) error {
+	return errors.New("not batched")
+}
+
+func (t *fakeBatchTransport) MessageSize(message TransportMessage) (int, int) {
+	return len(message.Body), MaxSNSMessageBytes
+}
+
+func (t *fakeBatchTransport) sendBatch(chunk []batchEntry) []entryFailure {
+	request := []string{}
+	failures := []entryFailure{}
+	failedDocuments := map[string]bool{}
+	for i, entry := range chunk {
+		body := string(entry.message.Body)
+		request = append(request, body)
+		if failedDocuments[entry.message.DocumentId] {
+			failures = append(failures, entryFailure{entry: entry, index: i, code: "retry", retryable: true})
+			continue
+		}
+		attempt := t.attempts[body]
+		t.attempts[body]++
+		if attempt < len(t.script[body]) {
+			outcome := t.script[body][attempt]
+			failures = append(failures, entryFailure{entry: entry, index: i, code: outcome, retryable: outcome == "retry"})
+			failedDocuments[entry.message.DocumentId] = true
+			continue
+		}
+		if !slices.Contains(t.published, body) {
+			t.published = append(t.published, body)
+		}
+	}
+	t.requests = append(t.requests, request)
+	return failures
+}
+
+func TestMetadataBatchFlushOrdering(t *testing.T) {
+	tests := []struct {
+		name string
+		// Events as document/sequence
+		events        []string
+		script        map[string][]string
+		wantPublished []string
+		wantFailed    []string
+		wantSkipped   []string
+		wantRequests  int
+	}{
+		{
+			name:          "all published",
+			events:        []string{"a/1", "b/1", "a/2", "b/2"},
+			wantPublished: []string{"a/1", "b/1", "a/2", "b/2"},
+			wantRequests:  1,
+		},
+		{
+			name:          "retried event published before the later events of its document",
+			events:        []string{"a/1", "b/1", "a/2", "b/2", "a/3"},
+			script:        map[string][]string{"a/1": {"retry", "retry"}},
+			wantPublished: []string{"b/1", "b/2", "a/1", "a/2", "a/3"},
+			wantRequests:  3,
+		},
+		{
+			name:          "failed event skips the later events of its document",
+			events:        []string{"a/1", "b/1", "a/2", "b/2", "a/3"},
+			script:        map[string][]string{"a/2": {"fatal"}},
+			wantPublished: []string{"a/1", "b/1", "b/2"},
+			wantFailed:    []string{"a/2"},
+			wantSkipped:   []string{"a/3"},
+			wantRequests:  1,
+		},
+		{
+			name:          "retries exhausted",
+			events:        []string{"a/1", "a/2", "b/1"},
+			script:        map[string][]string{"a/1": {"retry", "retry", "retry", "retry"}},
+			wantPublished: []string{"b/1"},
+			wantFailed:    []string{"a/1"},
+			wantSkipped:   []string{"a/2"},
+			wantRequests:  4,
+		},
+		{
+			name: "more events than fit in a request",
+			events: []string{"a/1", "b/1", "c/1", "d/1", "e/1", "f/1", "g/1", "h/1", "i/1", "j/1", "k/1",
+				"a/2"},
+			script: map[string][]string{"c/1": {"retry"}},
+			wantPublished: []string{"a/1", "b/1", "d/1", "e/1", "f/1", "g/1", "h/1", "i/1", "j/1", "c/1", "k/1",
+				"a/2"},
+			wantRequests: 3,
+		},
+		{
+			name:          "events of a document in one request",
+			events:        []string{"a/1", "a/2", "a/3"},
+			wantPublished: []string{"a/1", "a/2", "a/3"},
+			wantRequests:  1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := &fakeBatchTransport{script: tt.script, attempts: map[string]int{}}
+			batch := NewMetadataBatch()
+			batch.RetryBackoff = 0
+			client := NewMetadataClient(MetadataTypeOperations, "topic", TargetTypeSNS, nil, nil,
+				WithTransport(transport), WithBatch(batch), WithEnvelope(EnvelopeNone))
+			for _, event := range tt.events {
+				documentId := strings.Split(event, "/")[0]
+				batch.add(client, TransportMessage{MetadataType: MetadataTypeOperations, DocumentId: documentId, Body: []byte(event)})
+			}
+
+			err := batch.Flush()
+
+			if !slices.Equal(transport.published, tt.wantPublished) {
+				t.Errorf("published %v, want %v", transport.published, tt.wantPublished)
+			}
+			if len(transport.requests) != tt.wantRequests {
+				t.Errorf("sent %d requests %v, want %d", len(transport.requests), transport.requests, tt.wantRequests)
+			}
+			for _, request := range transport.requests {
+				if len(request) > MaxBatchEntries {
+					t.Errorf("request of %d entries", len(request))
+				}
+			}
+
+			failed, skipped := []string{}, []string{}
+			var batchErr *BatchError
+			if errors.As(err, &batchErr) {
+				if batchErr.Published != len(tt.wantPublished) {
+					t.Errorf("reported %d published, want %d", batchErr.Published, len(tt.wantPublished))
+				}
+				for _, failure := range batchErr.Failures {
+					if failure.Skipped {
+						skipped = append(skipped, failure.DocumentId)
+					} else {
+						failed = append(failed, failure.DocumentId)
+					}
+				}
+			} else if err != nil {
+				t.Fatalf("Flush() error = %v", err)
+			}
+			if !slices.Equal(failed, documentsOf(tt.wantFailed)) || !slices.Equal(skipped, documentsOf(tt.wantSkipped)) {
+				t.Errorf("failed %v and skipped %v, want %v and %v", failed, skipped, tt.wantFailed, tt.wantSkipped)
+			}
+			if batch.Len() != 0 {
+				t.Errorf("%d events left in the batch", batch.Len())
+			}
+		})
+	}
+}
+
+// SNS client recording the message types of each PublishBatch request
+type fakeSNSClient struct {
+	snsiface.SNSAPI
+	requests [][]string
+}
+
+func (c *fakeSNSClient) PublishBatch(input *sns.PublishBatchInput) (*sns.PublishBatchOutput, error) {
+	request := []string{}
+	for _, entry := range input.PublishBatchRequestEntries {
+		request = append(request, aws.StringValue(entry.MessageAttributes["metadataType"].StringValue))
+	}
+	c.requests = append(c.requests, request)
+	return &sns.PublishBatchOutput{}, nil
+}
+
+func TestMetadataBatchFlushSharedTopic(t *testing.T) {
+	snsClient := &fakeSNSClient{}
+	batch := NewMetadataBatch()
+	types := []string{MetadataTypeRegistry, MetadataTypeLineage, MetadataTypeOperations, MetadataTypeOperations}
+	for _, metadataType := range types {
+		// Each client has its own transport to the topic, as in the pipeline Lambdas
+		client := NewMetadataClient(metadataType, "topic", TargetTypeSNS, nil, nil,
+			WithSNSClient(snsClient), WithBatch(batch), WithEnvelope(EnvelopeNone))
+		batch.add(client, TransportMessage{MetadataType: metadataType, DocumentId: "doc", Body: []byte("{}")})
+	}
+
+	err := batch.Flush()
+	if err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if len(snsClient.requests) != 1 || !slices.Equal(snsClient.requests[0], types) {
+		t.Errorf("sent requests %v, want one of %v", snsClient.requests, types)
+	}
+}
+
+// Documents of events given as document/sequence
+func documentsOf(events []string) []string {
+	documents := []string{}
+	for _, event := range events {
+		documents = append(documents, strings.Split(event, "/")[0])
+	}
+	return documents
+}
@@ -0,0 +1,138 @@
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/google/uuid"
+	"golang.org/x/exp/slices"
+)
+
+// Handler consumes the events of a subscription, with the signature of the consumer Lambda handlers
+type Handler func(ctx context.Context, payload json.RawMessage) error
+
+// FilterPolicy selects the messages of a subscription by their attributes, like an SNS filter policy:
+// every attribute it names must have one of the listed values. An empty policy accepts every message.
+type FilterPolicy map[string][]string
+
+// Filter policy of the subscriptions to the given metadata types, as used by the consumer queues
+func MetadataTypeFilter(metadataTypes ...string) FilterPolicy {
+	return FilterPolicy{"metadataType": metadataTypes}
+}
+
+// Whether a message with the given attributes passes the policy
+func (p FilterPolicy) Matches(attributes map[string]string) bool {
+	for name, values := range p {
+		value, ok := attributes[name]
+		if !ok || !slices.Contains(values, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// A consumer subscribed to an InMemoryBus
+type subscription struct {
+	name    string
+	policy  FilterPolicy
+	handler Handler
+}
+
+// InMemoryBus is a Transport delivering events within the process, for local runs and tests without AWS.
+// Like the metadata topic and its queues, each subscriber receives the events passing its filter policy as an
+// SQS batch of one SNS notification, so the consumer handlers can be subscribed as they are. Records have no
+// receipt handle or event source ARN, which the consumers skip deleting and checking. Events are delivered
+// synchronously and in order.
+type InMemoryBus struct {
+	// Arn the notifications appear to come from
+	TopicArn string
+
+	mu            sync.RWMutex
+	subscriptions []subscription
+}
+
+// Create a bus without subscribers
+func NewInMemoryBus() *InMemoryBus {
+	return &InMemoryBus{TopicArn: "arn:aws:sns:local:000000000000:metadata.fifo"}
+}
+
+// Subscribe a handler to the events passing a filter policy
+func (b *InMemoryBus) Subscribe(name string, policy FilterPolicy, handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscriptions = append(b.subscriptions, subscription{name: name, policy: policy, handler: handler})
+}
+
+// Subscribe the registry, lineage, tracking and webhook handlers with the filter policies of their queues
+func (b *InMemoryBus) SubscribeConsumers(register, lineage, tracking, webhook Handler) {
+	b.Subscribe("DocumentRegistryQueue", MetadataTypeFilter(MetadataTypeRegistry), register)
+	b.Subscribe("DocumentLineageQueue", MetadataTypeFilter(MetadataTypeLineage), lineage)
+	b.Subscribe("PipelineOpsQueue", MetadataTypeFilter(MetadataTypeOperations), tracking)
+	b.Subscribe("WebhookQueue", MetadataTypeFilter(MetadataTypeOperations), webhook)
+}
+
+// Deliver a message to every subscriber whose policy it passes. Every subscriber receives it even if an earlier
+// one fails; the first failure is returned as a *SubscriberError.
+func (b *InMemoryBus) Send(message TransportMessage) error {
+	b.mu.RLock()
+	subscriptions := slices.Clone(b.subscriptions)
+	b.mu.RUnlock()
+
+	var firstErr error
+	attributes := map[string]string{"metadataType": message.MetadataType}
+	for _, s := range subscriptions {
+		if !s.policy.Matches(attributes) {
+			continue
+		}
+		payload, err := b.sqsPayload(message)
+		if err != nil {
+			return err
+		}
+		err = s.handler(context.Background(), payload)
+		if err != nil {
+			log.Printf("Subscription %s failed to process %s event of document %s: %v \n", s.name, message.MetadataType, message.DocumentId, err)
+			if firstErr == nil {
+				firstErr = &SubscriberError{Subscription: s.name, Err: err}
+			}
+		}
+	}
+	return firstErr
+}
+
+// Messages are held to the limit of the metadata topic
+func (b *InMemoryBus) MessageSize(message TransportMessage) (int, int) {
+	return len(message.Body), MaxSNSMessageBytes
+}
+
+// SQS batch of the SNS notification of a message, as a queue subscribed to the topic receives it
+func (b *InMemoryBus) sqsPayload(message TransportMessage) (json.RawMessage, error) {
+	notification, err := json.Marshal(map[string]interface{}{
+		"Type":      "Notification",
+		"MessageId": uuid.NewString(),
+		"TopicArn":  b.TopicArn,
+		"Message":   string(message.Body),
+		"Timestamp": time.Now().UTC().Format(time.RFC3339Nano),
+		"MessageAttributes": map[string]interface{}{
+			"metadataType": map[string]string{"Type": "String", "Value": message.MetadataType},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling notification: %v", err)
+	}
+
+	payload, err := json.Marshal(events.SQSEvent{Records: []events.SQSMessage{{
+		MessageId:   uuid.NewString(),
+		Body:        string(notification),
+		EventSource: "aws:sqs",
+		Attributes:  map[string]string{"MessageGroupId": message.DocumentId},
+	}}})
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling SQS event: %v", err)
+	}
+	return payload, nil
+}
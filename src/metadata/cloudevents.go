@@ -0,0 +1,137 @@
+package metadata
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Envelopes events can be published in
+const (
+	// The bare event
+	EnvelopeNone = "none"
+	// A CloudEvents 1.0 event in structured JSON mode
+	EnvelopeCloudEvents = "cloudevents"
+)
+
+const (
+	CloudEventsSpecVersion = "1.0"
+	// Prefix of the CloudEvents type, followed by the metadata type
+	CloudEventTypePrefix = "com.github.dreamspider42.document-processing-pipeline."
+)
+
+// CloudEvent is a CloudEvents 1.0 event in structured JSON mode
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	Id              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            string          `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	DataBase64      string          `json:"data_base64,omitempty"`
+
+	// Extension attributes
+	MetadataType  string `json:"metadatatype,omitempty"`
+	SchemaVersion int    `json:"schemaversion,omitempty"`
+}
+
+// Envelope of the metadata clients that are not given one: METADATA_EVENT_ENVELOPE, or the bare event
+func defaultEnvelope() string {
+	if envelope := os.Getenv("METADATA_EVENT_ENVELOPE"); envelope != "" {
+		return envelope
+	}
+	return EnvelopeNone
+}
+
+// CloudEvents type of a metadata type
+func CloudEventTypeOf(metadataType string) string {
+	return CloudEventTypePrefix + metadataType
+}
+
+// Wrap a marshalled event in a CloudEvent about its document, timed by the event timestamp
+func NewCloudEvent(source string, metadataType string, documentId string, timestamp string, data []byte) (*CloudEvent, error) {
+	t, err := ParseTimestamp(timestamp)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &CloudEvent{
+		SpecVersion:     CloudEventsSpecVersion,
+		Id:              uuid.NewString(),
+		Source:          source,
+		Type:            CloudEventTypeOf(metadataType),
+		Subject:         documentId,
+		Time:            t.UTC().Format(time.RFC3339Nano),
+		DataContentType: "application/json",
+		Data:            data,
+		MetadataType:    metadataType,
+	}
+	if NewEvent(metadataType) != nil {
+		event.SchemaVersion = EventSchemaVersion
+	}
+	return event, nil
+}
+
+// Check the required context attributes and the data of the event
+func (e *CloudEvent) Validate() error {
+	if e.SpecVersion != CloudEventsSpecVersion {
+		return fmt.Errorf("unsupported CloudEvents specversion %q", e.SpecVersion)
+	}
+	if e.Id == "" || e.Source == "" || e.Type == "" {
+		return fmt.Errorf("CloudEvent is missing its id, source or type")
+	}
+	if e.DataContentType != "" && !strings.Contains(e.DataContentType, "json") {
+		return fmt.Errorf("unsupported CloudEvent datacontenttype %q", e.DataContentType)
+	}
+	return nil
+}
+
+// The data of the event, decoding data_base64
+func (e *CloudEvent) EventData() (json.RawMessage, error) {
+	if e.DataBase64 != "" {
+		return base64.StdEncoding.DecodeString(e.DataBase64)
+	}
+	return e.Data, nil
+}
+
+// Metadata type of the event, from its extension attribute or else its type
+func (e *CloudEvent) EventMetadataType() string {
+	if e.MetadataType != "" {
+		return e.MetadataType
+	}
+	return strings.TrimPrefix(e.Type, CloudEventTypePrefix)
+}
+
+// Unwrap the event from a CloudEvent if it is in one
+func unwrapCloudEvent(received ReceivedEvent) (ReceivedEvent, error) {
+	probe := struct {
+		SpecVersion string `json:"specversion"`
+	}{}
+	if json.Unmarshal(received.Message, &probe) != nil || probe.SpecVersion == "" {
+		return received, nil
+	}
+
+	event := CloudEvent{}
+	err := json.Unmarshal(received.Message, &event)
+	if err != nil {
+		return received, fmt.Errorf("unrecognised CloudEvent: %v", err)
+	}
+	err = event.Validate()
+	if err != nil {
+		return received, err
+	}
+	received.Message, err = event.EventData()
+	if err != nil {
+		return received, fmt.Errorf("unrecognised CloudEvent data: %v", err)
+	}
+	received.MetadataType = event.EventMetadataType()
+	received.EventId = event.Id
+	return received, nil
+}
@@ -0,0 +1,40 @@
+package metadata
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Namespace of the name-based (version 5) UUIDs derived from S3 events
+var DocumentIdNamespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("https://github.com/dreamspider42/document-processing-pipeline"))
+
+// Document id of an S3 object event, derived from the object, its version and the event sequencer so that
+// redeliveries and retries of the same event get the same id while every new upload gets a new one
+func DocumentIdOf(bucketName string, objectKey string, versionId string, sequencer string) string {
+	name := url.URL{Scheme: "s3", Host: bucketName, Path: "/" + objectKey}
+	query := url.Values{}
+	query.Set("versionId", versionId)
+	query.Set("sequencer", sequencer)
+	name.RawQuery = query.Encode()
+	return uuid.NewSHA1(DocumentIdNamespace, []byte(name.String())).String()
+}
+
+// Logical document id of an S3 object, shared by all its versions and overwrites
+func LogicalDocumentIdOf(bucketName string, objectKey string) string {
+	name := url.URL{Scheme: "s3", Host: bucketName, Path: "/" + objectKey}
+	return uuid.NewSHA1(DocumentIdNamespace, []byte(name.String())).String()
+}
+
+// Width S3 event sequencers are padded to, beyond the length S3 uses
+const sequencerWidth = 32
+
+// S3 event sequencer in a form whose string order is the order of the events of an object: S3 sequencers of
+// different lengths compare after right padding the shorter one with zeros. Empty for no sequencer.
+func OrderedSequencer(sequencer string) string {
+	if sequencer == "" || len(sequencer) >= sequencerWidth {
+		return strings.ToUpper(sequencer)
+	}
+	return strings.ToUpper(sequencer) + strings.Repeat("0", sequencerWidth-len(sequencer))
+}
@@ -0,0 +1,32 @@
+package metadata
+
+import "testing"
+
+func TestOrderedSequencer(t *testing.T) {
+	tests := []struct {
+		name   string
+		before string
+		after  string
+	}{
+		{name: "same length", before: "0055AED6DCD90281E5", after: "0055AED6DCD90281E6"},
+		{name: "shorter sequencer later", before: "0055AED6DCD90281E5", after: "0055AED6DCD9029"},
+		{name: "longer sequencer later", before: "0055AED6DCD9029", after: "0055AED6DCD90290001"},
+		{name: "lower case", before: "0055aed6dcd90281e5", after: "0055AED6DCD90281F0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, after := OrderedSequencer(tt.before), OrderedSequencer(tt.after)
+			if before >= after {
+				t.Errorf("OrderedSequencer(%q) = %q, not before OrderedSequencer(%q) = %q", tt.before, before, tt.after, after)
+			}
+		})
+	}
+
+	if OrderedSequencer("") != "" {
+		t.Errorf("OrderedSequencer(\"\") = %q, want no sequencer", OrderedSequencer(""))
+	}
+	if OrderedSequencer("0055AED6DCD90281E5") != OrderedSequencer("0055AED6DCD90281E500") {
+		t.Error("sequencers equal after padding are not equal")
+	}
+}
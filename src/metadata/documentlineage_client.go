@@ -10,13 +10,14 @@ type DocumentLineageClient struct {
 	metadataClient *MetadataClient
 }
 
-func NewDocumentLineageClient(metadataTopic string) *DocumentLineageClient {
+func NewDocumentLineageClient(metadataTopic string, opts ...MetadataClientOption) *DocumentLineageClient {
 	metadataClient := NewMetadataClient(
-		"document-lineage",
+		MetadataTypeLineage,
 		metadataTopic,
-		"sns",
+		"",
 		nil,
 		[]string{"documentId", "callerId", "targetBucketName", "targetFileName", "s3Event"},
+		opts...,
 	)
 	return &DocumentLineageClient{
 		metadataClient: metadataClient,
@@ -40,3 +41,17 @@ func (d *DocumentLineageClient) RecordLineageOfCopy(body map[string]interface{})
 	maps.Copy(body, map[string]interface{}{"s3Event": "ObjectCreated:Copy"})
 	return d.metadataClient.Publish(body)
 }
+
+// Record the records of a document written to a search index
+func (d *DocumentLineageClient) RecordLineageOfIndexWrite(body map[string]interface{}) error {
+	log.Printf("*DocumentLineage* Event to record lineage of index write: %v \n", body)
+
+	maps.Copy(body, map[string]interface{}{"s3Event": S3EventIndexWritten})
+	return d.metadataClient.Publish(body)
+}
+
+// Publish a typed lineage event
+func (d *DocumentLineageClient) RecordLineageEvent(event *LineageEvent) error {
+	log.Printf("*DocumentLineage* Event to record lineage: %+v \n", event)
+	return d.metadataClient.PublishEvent(event)
+}
@@ -6,13 +6,14 @@ type DocumentRegistryClient struct {
 	metadataClient *MetadataClient
 }
 
-func NewDocumentRegistryClient(metadataTopic string, body map[string]interface{}) *DocumentRegistryClient {
+func NewDocumentRegistryClient(metadataTopic string, body map[string]interface{}, opts ...MetadataClientOption) *DocumentRegistryClient {
 	metadataClient := NewMetadataClient(
-		"document-registry",
+		MetadataTypeRegistry,
 		metadataTopic,
-		"sns",
+		"",
 		body,
 		[]string{"documentId", "bucketName", "documentName", "documentLink", "principalIAMWriter"},
+		opts...,
 	)
 	return &DocumentRegistryClient{
 		metadataClient: metadataClient,
@@ -23,3 +24,9 @@ func (d *DocumentRegistryClient) RegisterDocument(body map[string]interface{}) e
 	log.Printf("*DocumentRegistration* Publishing event with body: %v \n", body)
 	return d.metadataClient.Publish(body)
 }
+
+// Publish a typed registry event
+func (d *DocumentRegistryClient) RegisterDocumentEvent(event *RegistryEvent) error {
+	log.Printf("*DocumentRegistration* Publishing event: %+v \n", event)
+	return d.metadataClient.PublishEvent(event)
+}
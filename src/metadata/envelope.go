@@ -0,0 +1,100 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// LambdaEvent is the payload a Lambda target is invoked with
+type LambdaEvent struct {
+	MetadataType string          `json:"metadataType"`
+	Message      json.RawMessage `json:"message"`
+}
+
+// ReceivedEvent is a metadata event unwrapped from the envelope it was delivered in.
+// ReceiptHandle and EventSourceARN are empty for events invoked directly rather than read from a queue.
+type ReceivedEvent struct {
+	MetadataType   string
+	Message        json.RawMessage
+	ReceiptHandle  string
+	EventSourceARN string
+	// Id of the EventBridge event or CloudEvent the event arrived in
+	EventId string
+}
+
+// The envelopes an event can arrive in: an SNS notification, an EventBridge event or a LambdaEvent.
+// SNS and LambdaEvent messages share a field since encoding/json matches names case-insensitively.
+type eventEnvelope struct {
+	Type              string `json:"Type"`
+	MessageAttributes map[string]struct {
+		Value string `json:"Value"`
+	} `json:"MessageAttributes"`
+	MetadataType string          `json:"metadataType"`
+	Message      json.RawMessage `json:"Message"`
+	Id           string          `json:"id"`
+	DetailType   string          `json:"detail-type"`
+	Detail       json.RawMessage `json:"detail"`
+}
+
+// Unwrap a single event from its envelope and any CloudEvent inside it. Bodies in no known envelope are taken as
+// the bare event.
+func unwrapEnvelope(body []byte) (ReceivedEvent, error) {
+	envelope := eventEnvelope{}
+	err := json.Unmarshal(body, &envelope)
+	if err != nil {
+		return ReceivedEvent{}, fmt.Errorf("unrecognised metadata event: %v", err)
+	}
+
+	switch {
+	case envelope.Type == "Notification" && len(envelope.Message) > 0:
+		// Queues subscribed without raw message delivery receive the SNS notification around the event
+		var message string
+		err = json.Unmarshal(envelope.Message, &message)
+		if err != nil {
+			return ReceivedEvent{}, fmt.Errorf("unrecognised SNS notification: %v", err)
+		}
+		return unwrapCloudEvent(ReceivedEvent{MetadataType: envelope.MessageAttributes["metadataType"].Value, Message: json.RawMessage(message)})
+	case envelope.DetailType != "" && len(envelope.Detail) > 0:
+		return unwrapCloudEvent(ReceivedEvent{MetadataType: MetadataTypeOfDetailType(envelope.DetailType), Message: envelope.Detail, EventId: envelope.Id})
+	case envelope.MetadataType != "" && len(envelope.Message) > 0:
+		return unwrapCloudEvent(ReceivedEvent{MetadataType: envelope.MetadataType, Message: envelope.Message})
+	}
+	return unwrapCloudEvent(ReceivedEvent{Message: body})
+}
+
+// Unwrap the metadata events of a consumer invocation: an SQS batch of SNS notifications, EventBridge events or bare
+// events, or a single EventBridge event or LambdaEvent invoked directly
+func UnwrapEvents(raw json.RawMessage) ([]ReceivedEvent, error) {
+	batch := struct {
+		Records []events.SQSMessage `json:"Records"`
+	}{}
+	err := json.Unmarshal(raw, &batch)
+	if err != nil {
+		return nil, fmt.Errorf("unrecognised metadata event: %v", err)
+	}
+
+	if batch.Records == nil {
+		event, err := unwrapEnvelope(raw)
+		if err != nil {
+			return nil, err
+		}
+		return []ReceivedEvent{event}, nil
+	}
+
+	received := make([]ReceivedEvent, 0, len(batch.Records))
+	for _, record := range batch.Records {
+		event, err := unwrapEnvelope([]byte(record.Body))
+		if err != nil {
+			return nil, err
+		}
+		if attribute, ok := record.MessageAttributes["metadataType"]; ok && event.MetadataType == "" && attribute.StringValue != nil {
+			event.MetadataType = *attribute.StringValue
+		}
+		event.ReceiptHandle = record.ReceiptHandle
+		event.EventSourceARN = record.EventSourceARN
+		received = append(received, event)
+	}
+	return received, nil
+}
@@ -0,0 +1,57 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// PayloadTooLargeError is returned when an event exceeds the size limit of its target
+type PayloadTooLargeError struct {
+	Target string
+	Size   int
+	Limit  int
+}
+
+func (e *PayloadTooLargeError) Error() string {
+	return fmt.Sprintf("metadata event of %d bytes exceeds the %d byte limit of %s", e.Size, e.Limit, e.Target)
+}
+
+// FunctionError is returned when a Lambda target ran but failed to process the event
+type FunctionError struct {
+	FunctionName string
+	// Unhandled for runtime failures such as panics and timeouts, Handled for errors the handler returned
+	Kind       string
+	ErrorType  string   `json:"errorType"`
+	Message    string   `json:"errorMessage"`
+	StackTrace []string `json:"stackTrace"`
+}
+
+func (e *FunctionError) Error() string {
+	if e.ErrorType == "" {
+		return fmt.Sprintf("%s failed (%s): %s", e.FunctionName, e.Kind, e.Message)
+	}
+	return fmt.Sprintf("%s failed (%s): %s: %s", e.FunctionName, e.Kind, e.ErrorType, e.Message)
+}
+
+// Build the error of a failed invocation from the error document the function returned
+func newFunctionError(functionName, kind string, payload []byte) *FunctionError {
+	e := &FunctionError{FunctionName: functionName, Kind: kind}
+	if err := json.Unmarshal(payload, e); err != nil || e.Message == "" {
+		e.Message = string(payload)
+	}
+	return e
+}
+
+// SubscriberError is returned when a subscriber of an InMemoryBus failed to process the event
+type SubscriberError struct {
+	Subscription string
+	Err          error
+}
+
+func (e *SubscriberError) Error() string {
+	return fmt.Sprintf("subscription %s failed: %v", e.Subscription, e.Err)
+}
+
+func (e *SubscriberError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,310 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Metadata types, also the metadataType attribute consumers subscribe on
+const (
+	MetadataTypeRegistry   = "document-registry"
+	MetadataTypeLineage    = "document-lineage"
+	MetadataTypeOperations = "pipeline-operations"
+	// Pipeline events rejected by the pipeline state machine
+	MetadataTypeInvalidTransition = "invalid-transition"
+)
+
+// EventBridge detail-types of the metadata types
+var detailTypes = map[string]string{
+	MetadataTypeRegistry:          "Document Registered",
+	MetadataTypeLineage:           "Document Lineage Recorded",
+	MetadataTypeOperations:        "Pipeline Stage Updated",
+	MetadataTypeInvalidTransition: "Invalid Pipeline Transition",
+}
+
+// Detail-type of the EventBridge events of a metadata type. Types without one are put under their own name.
+func DetailTypeOf(metadataType string) string {
+	if detailType, ok := detailTypes[metadataType]; ok {
+		return detailType
+	}
+	return metadataType
+}
+
+// Metadata type of an EventBridge detail-type, the inverse of DetailTypeOf
+func MetadataTypeOfDetailType(detailType string) string {
+	for metadataType, dt := range detailTypes {
+		if dt == detailType {
+			return metadataType
+		}
+	}
+	return detailType
+}
+
+// Schema version of the events published by this package. Version 1 is the untyped events published before
+// schemaVersion existed; they are still decoded.
+const EventSchemaVersion = 2
+
+// Pipeline operations statuses
+const (
+	StatusInProgress = "IN_PROGRESS"
+	StatusSucceeded  = "SUCCEEDED"
+	StatusFailed     = "FAILED"
+)
+
+// Event is a typed metadata event
+type Event interface {
+	// Metadata type the event is published as
+	Type() string
+	// Check the event against its schema
+	Validate() error
+	header() *EventHeader
+}
+
+// EventHeader holds the fields shared by every metadata event
+type EventHeader struct {
+	SchemaVersion int    `json:"schemaVersion" schema:"required"`
+	DocumentId    string `json:"documentId" schema:"required"`
+	Timestamp     string `json:"timestamp" schema:"required"`
+}
+
+func (h *EventHeader) header() *EventHeader {
+	return h
+}
+
+// Header fields of an event
+func HeaderOf(event Event) EventHeader {
+	return *event.header()
+}
+
+// RegistryEvent registers a newly ingested document
+type RegistryEvent struct {
+	EventHeader
+	BucketName         string                 `json:"bucketName" schema:"required"`
+	DocumentName       string                 `json:"documentName" schema:"required"`
+	DocumentLink       string                 `json:"documentLink" schema:"required"`
+	DocumentVersion    string                 `json:"documentVersion,omitempty"`
+	DocumentMetadata   map[string]interface{} `json:"documentMetadata,omitempty"`
+	PrincipalIAMWriter map[string]interface{} `json:"principalIAMWriter" schema:"required"`
+	// Document the registered object is a revision of, derived from its bucket and name when unset
+	LogicalDocumentId string `json:"logicalDocumentId,omitempty"`
+	// Sequencer of the S3 event of the upload, which orders the revisions of an object
+	Sequencer string `json:"sequencer,omitempty"`
+}
+
+func (e *RegistryEvent) Type() string {
+	return MetadataTypeRegistry
+}
+
+func (e *RegistryEvent) Validate() error {
+	return validateFields(e)
+}
+
+// LineageEvent records an object created or removed on behalf of a document
+type LineageEvent struct {
+	EventHeader
+	CallerId         CallerId `json:"callerId" schema:"required"`
+	TargetBucketName string   `json:"targetBucketName" schema:"required"`
+	TargetFileName   string   `json:"targetFileName" schema:"required"`
+	S3Event          string   `json:"s3Event" schema:"required" pattern:"^(Object(Created|Removed)|IndexWritten):"`
+	VersionId        string   `json:"versionId,omitempty"`
+	SourceBucketName string   `json:"sourceBucketName,omitempty"`
+	SourceFileName   string   `json:"sourceFileName,omitempty"`
+	// Pipeline stage that created the target
+	Stage string `json:"stage,omitempty"`
+}
+
+// S3Event of the records of a document written to a search index. The target bucket names the index and the
+// target file the document.
+const S3EventIndexWritten = "IndexWritten:Bulk"
+
+func (e *LineageEvent) Type() string {
+	return MetadataTypeLineage
+}
+
+func (e *LineageEvent) Validate() error {
+	err := validateFields(e)
+	if err != nil {
+		return err
+	}
+	if e.S3Event == "ObjectCreated:Copy" && (e.SourceBucketName == "" || e.SourceFileName == "") {
+		return &ValidationError{Type: e.Type(), Field: "sourceFileName", Reason: "is required for copies"}
+	}
+	return nil
+}
+
+// OperationsEvent reports the status of a document in a pipeline stage
+type OperationsEvent struct {
+	EventHeader
+	BucketName string `json:"bucketName" schema:"required"`
+	ObjectName string `json:"objectName" schema:"required"`
+	Stage      string `json:"stage" schema:"required"`
+	Status     string `json:"status" schema:"required" enum:"IN_PROGRESS,SUCCEEDED,FAILED"`
+	Message    string `json:"message,omitempty"`
+	VersionId  string `json:"versionId,omitempty"`
+	// Set on the first event of a document, which starts its tracking
+	InitDoc Flag `json:"initDoc,omitempty"`
+}
+
+func (e *OperationsEvent) Type() string {
+	return MetadataTypeOperations
+}
+
+func (e *OperationsEvent) Validate() error {
+	return validateFields(e)
+}
+
+// Id of an operations event, derived from its document, stage, status and time so that redeliveries and
+// republished copies of the event get the same id
+func (e *OperationsEvent) EventId() string {
+	name := fmt.Sprintf("operations|%s|%s|%s|%s", e.DocumentId, e.Stage, e.Status, e.Timestamp)
+	return uuid.NewSHA1(DocumentIdNamespace, []byte(name)).String()
+}
+
+// Components that reject invalid transitions
+const (
+	DetectedByClient = "client"
+	DetectedByStore  = "store"
+)
+
+// InvalidTransitionEvent reports a pipeline event rejected by the pipeline state machine
+type InvalidTransitionEvent struct {
+	EventHeader
+	// State of the document when the event was rejected, empty for the first event of a document
+	FromStage  string `json:"fromStage,omitempty"`
+	FromStatus string `json:"fromStatus,omitempty"`
+	// State the rejected event would have moved the document to
+	Stage  string `json:"stage" schema:"required"`
+	Status string `json:"status" schema:"required"`
+	Reason string `json:"reason" schema:"required"`
+	// The client that refused to publish the event, or the store that refused to apply it
+	DetectedBy string `json:"detectedBy" schema:"required" enum:"client,store"`
+}
+
+func (e *InvalidTransitionEvent) Type() string {
+	return MetadataTypeInvalidTransition
+}
+
+func (e *InvalidTransitionEvent) Validate() error {
+	return validateFields(e)
+}
+
+// Event reporting an invalid transition
+func NewInvalidTransitionEvent(err *InvalidTransitionError, detectedBy string) *InvalidTransitionEvent {
+	return &InvalidTransitionEvent{
+		EventHeader: EventHeader{DocumentId: err.DocumentId},
+		FromStage:   err.From.Stage,
+		FromStatus:  err.From.Status,
+		Stage:       err.To.Stage,
+		Status:      err.To.Status,
+		Reason:      err.Reason,
+		DetectedBy:  detectedBy,
+	}
+}
+
+// CallerId identifies what caused a lineage event: the ARN of a pipeline Lambda or the principal of an S3 user.
+// Version 1 events sent Lambda callers as a bare string.
+type CallerId struct {
+	Arn         string `json:"arn,omitempty"`
+	PrincipalId string `json:"principalId,omitempty"`
+}
+
+func (c *CallerId) UnmarshalJSON(data []byte) error {
+	var id string
+	if json.Unmarshal(data, &id) == nil {
+		*c = NewCallerId(id)
+		return nil
+	}
+	type plain CallerId
+	return json.Unmarshal(data, (*plain)(c))
+}
+
+// Caller of an ARN or principal id
+func NewCallerId(id string) CallerId {
+	if strings.HasPrefix(id, "arn:") {
+		return CallerId{Arn: id}
+	}
+	return CallerId{PrincipalId: id}
+}
+
+// The caller as stored in the lineage table
+func (c CallerId) Map() map[string]interface{} {
+	m := map[string]interface{}{}
+	if c.Arn != "" {
+		m["arn"] = c.Arn
+	}
+	if c.PrincipalId != "" {
+		m["principalId"] = c.PrincipalId
+	}
+	return m
+}
+
+// Flag is a boolean that version 1 events sent as the string "True"
+type Flag bool
+
+func (f *Flag) UnmarshalJSON(data []byte) error {
+	var value string
+	if json.Unmarshal(data, &value) == nil {
+		*f = Flag(strings.EqualFold(value, "true"))
+		return nil
+	}
+	return json.Unmarshal(data, (*bool)(f))
+}
+
+// New empty event of a metadata type, nil for types without a schema
+func NewEvent(metadataType string) Event {
+	switch metadataType {
+	case MetadataTypeRegistry:
+		return &RegistryEvent{}
+	case MetadataTypeLineage:
+		return &LineageEvent{}
+	case MetadataTypeOperations:
+		return &OperationsEvent{}
+	case MetadataTypeInvalidTransition:
+		return &InvalidTransitionEvent{}
+	}
+	return nil
+}
+
+// Decode and validate an event of any schema version up to EventSchemaVersion
+func DecodeEvent(message []byte, event Event) error {
+	err := json.Unmarshal(message, event)
+	if err != nil {
+		return &ValidationError{Type: event.Type(), Reason: fmt.Sprintf("is not valid JSON: %v", err)}
+	}
+
+	header := event.header()
+	if header.SchemaVersion == 0 {
+		header.SchemaVersion = 1
+	}
+	if header.SchemaVersion > EventSchemaVersion {
+		return &ValidationError{Type: event.Type(), Field: "schemaVersion", Reason: fmt.Sprintf("%d is newer than the supported version %d", header.SchemaVersion, EventSchemaVersion)}
+	}
+	return event.Validate()
+}
+
+// Decode and validate a registry event
+func DecodeRegistryEvent(message []byte) (*RegistryEvent, error) {
+	event := &RegistryEvent{}
+	return event, DecodeEvent(message, event)
+}
+
+// Decode and validate a lineage event
+func DecodeLineageEvent(message []byte) (*LineageEvent, error) {
+	event := &LineageEvent{}
+	return event, DecodeEvent(message, event)
+}
+
+// Decode and validate an operations event
+func DecodeOperationsEvent(message []byte) (*OperationsEvent, error) {
+	event := &OperationsEvent{}
+	return event, DecodeEvent(message, event)
+}
+
+// Decode and validate an invalid transition event
+func DecodeInvalidTransitionEvent(message []byte) (*InvalidTransitionEvent, error) {
+	event := &InvalidTransitionEvent{}
+	return event, DecodeEvent(message, event)
+}
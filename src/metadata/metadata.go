@@ -4,50 +4,185 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"os"
+	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
 	"github.com/aws/aws-sdk-go/service/lambda"
 	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
-	"github.com/aws/aws-sdk-go/service/sns"
 	"github.com/aws/aws-sdk-go/service/sns/snsiface"
-	"github.com/dreamspider42/document-processing-pipeline/src/awshelper"
 	"golang.org/x/exp/maps"
 )
 
+const (
+	TargetTypeSNS         = "sns"
+	TargetTypeLambda      = "lambda"
+	TargetTypeEventBridge = "eventbridge"
+
+	// Source of the events put on an EventBridge bus
+	DefaultEventSource = "document-processing-pipeline"
+
+	// Lambda invocation types: wait for the consumer to finish, or queue the event and return
+	InvocationSync  = lambda.InvocationTypeRequestResponse
+	InvocationAsync = lambda.InvocationTypeEvent
+
+	// Service limits on the size of a published event
+	MaxSNSMessageBytes         = 256 * 1024
+	MaxLambdaSyncPayloadBytes  = 6 * 1024 * 1024
+	MaxLambdaAsyncPayloadBytes = 256 * 1024
+	MaxEventBridgeEntryBytes   = 256 * 1024
+)
+
 type MetadataClient struct {
-	targetArn    string
-	targetType   string
-	snsclient    snsiface.SNSAPI
-	lambdaclient lambdaiface.LambdaAPI
-	metadataType string
-	requiredKeys []string
-	body         map[string]interface{}
-}
-
-func NewMetadataClient(metadataType string, targetArn string, targetType string, body map[string]interface{}, requiredKeys []string) *MetadataClient {
-	var snsClient snsiface.SNSAPI = nil
-	var lambdaClient lambdaiface.LambdaAPI = nil
-	if targetType == "sns" {
-		snsClient = sns.New(awshelper.NewAWSSession())
-	} else if targetType == "lambda" {
-		lambdaClient = lambda.New(awshelper.NewAWSSession())
-	} else {
-		panic(fmt.Sprintf("MetadataClient does not accept targets of type %s", targetType))
+	targetArn         string
+	targetType        string
+	invocationType    string
+	eventSource       string
+	envelope          string
+	batch             *MetadataBatch
+	transport         Transport
+	snsclient         snsiface.SNSAPI
+	lambdaclient      lambdaiface.LambdaAPI
+	eventbridgeclient eventbridgeiface.EventBridgeAPI
+	metadataType      string
+	requiredKeys      []string
+	body              map[string]interface{}
+}
+
+// Optional settings of a MetadataClient
+type MetadataClientOption func(*MetadataClient)
+
+// Publish through the given SNS client instead of one created from the default session
+func WithSNSClient(client snsiface.SNSAPI) MetadataClientOption {
+	return func(m *MetadataClient) {
+		m.snsclient = client
+	}
+}
+
+// Invoke through the given Lambda client instead of one created from the default session
+func WithLambdaClient(client lambdaiface.LambdaAPI) MetadataClientOption {
+	return func(m *MetadataClient) {
+		m.lambdaclient = client
+	}
+}
+
+// Put events through the given EventBridge client instead of one created from the default session
+func WithEventBridgeClient(client eventbridgeiface.EventBridgeAPI) MetadataClientOption {
+	return func(m *MetadataClient) {
+		m.eventbridgeclient = client
+	}
+}
+
+// Source of the events on an EventBridge bus and in CloudEvents, instead of DefaultEventSource
+func WithEventSource(source string) MetadataClientOption {
+	return func(m *MetadataClient) {
+		m.eventSource = source
+	}
+}
+
+// Publish events in the given envelope, EnvelopeNone or EnvelopeCloudEvents, instead of METADATA_EVENT_ENVELOPE's
+func WithEnvelope(envelope string) MetadataClientOption {
+	return func(m *MetadataClient) {
+		m.envelope = envelope
+	}
+}
+
+// Invoke Lambda targets synchronously (InvocationSync, the default) or asynchronously (InvocationAsync)
+func WithInvocationType(invocationType string) MetadataClientOption {
+	return func(m *MetadataClient) {
+		m.invocationType = invocationType
+	}
+}
+
+// Deliver events through the given transport, such as an InMemoryBus, instead of one for the target ARN.
+// No AWS client is created.
+func WithTransport(transport Transport) MetadataClientOption {
+	return func(m *MetadataClient) {
+		m.transport = transport
+	}
+}
+
+// Environment variables naming the target of the pipeline's metadata events and, when it cannot be detected from
+// the ARN, its type. METADATA_SNS_TOPIC_ARN, the metadata topic, is the target when METADATA_TARGET_ARN is unset.
+const (
+	TargetArnEnv  = "METADATA_TARGET_ARN"
+	TargetTypeEnv = "METADATA_TARGET_TYPE"
+	TopicArnEnv   = "METADATA_SNS_TOPIC_ARN"
+)
+
+// Target of the pipeline's metadata events: METADATA_TARGET_ARN, or else METADATA_SNS_TOPIC_ARN
+func TargetArnFromEnv() string {
+	if targetArn := os.Getenv(TargetArnEnv); targetArn != "" {
+		return targetArn
+	}
+	return os.Getenv(TopicArnEnv)
+}
+
+// Target type of the clients that are not given one: METADATA_TARGET_TYPE for the target configured in the
+// environment, or else the type detected from the ARN
+func defaultTargetType(targetArn string) string {
+	if targetType := os.Getenv(TargetTypeEnv); targetType != "" && targetArn != "" && targetArn == TargetArnFromEnv() {
+		return targetType
+	}
+	return TargetTypeOf(targetArn)
+}
+
+// Target type of an ARN: lambda for functions, eventbridge for event buses, sns otherwise
+func TargetTypeOf(targetArn string) string {
+	parts := strings.Split(targetArn, ":")
+	if len(parts) > 2 && parts[0] == "arn" {
+		switch parts[2] {
+		case "lambda":
+			return TargetTypeLambda
+		case "events":
+			return TargetTypeEventBridge
+		}
 	}
+	return TargetTypeSNS
+}
 
+// Create a new instance of the metadata client. An empty targetType is METADATA_TARGET_TYPE's for the target
+// configured in the environment, and is detected from any other target ARN. Events are delivered through the SNS,
+// Lambda or EventBridge transport unless WithTransport gives another.
+func NewMetadataClient(metadataType string, targetArn string, targetType string, body map[string]interface{}, requiredKeys []string, opts ...MetadataClientOption) *MetadataClient {
+	if targetType == "" {
+		targetType = defaultTargetType(targetArn)
+	}
 	if body == nil {
 		body = make(map[string]interface{})
 	}
-	return &MetadataClient{
-		targetArn:    targetArn,
-		targetType:   targetType,
-		snsclient:    snsClient,
-		lambdaclient: lambdaClient,
-		metadataType: metadataType,
-		requiredKeys: requiredKeys,
-		body:         body,
+
+	m := &MetadataClient{
+		targetArn:      targetArn,
+		targetType:     targetType,
+		invocationType: InvocationSync,
+		eventSource:    DefaultEventSource,
+		envelope:       defaultEnvelope(),
+		metadataType:   metadataType,
+		requiredKeys:   requiredKeys,
+		body:           body,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	if m.envelope != EnvelopeNone && m.envelope != EnvelopeCloudEvents {
+		panic(fmt.Sprintf("MetadataClient does not accept envelope %s", m.envelope))
 	}
+	if m.transport != nil {
+		return m
+	}
+	if targetType == TargetTypeSNS {
+		m.transport = NewSNSTransport(targetArn, m.snsclient)
+	} else if targetType == TargetTypeLambda {
+		m.transport = NewLambdaTransport(targetArn, m.invocationType, m.lambdaclient)
+	} else if targetType == TargetTypeEventBridge {
+		m.transport = NewEventBridgeTransport(targetArn, m.eventSource, m.eventbridgeclient)
+	} else {
+		panic(fmt.Sprintf("MetadataClient does not accept targets of type %s", targetType))
+	}
+	return m
 }
 
 func (m *MetadataClient) _validatePayload(payload map[string]interface{}) bool {
@@ -60,28 +195,10 @@ func (m *MetadataClient) _validatePayload(payload map[string]interface{}) bool {
 	return true
 }
 
-func (m *MetadataClient) _publishSNS(message string, messageGroupId string, messageAttributes map[string]interface{}) error {
-	log.Println("publishing to SNS")
-	if m.targetType != "sns" {
-		return fmt.Errorf("invalid targetType")
-	}
-	client := m.snsclient.(*sns.SNS)
-	_, err := client.Publish(&sns.PublishInput{
-		TopicArn:       &m.targetArn,
-		Message:        &message,
-		MessageGroupId: &messageGroupId,
-		MessageAttributes: map[string]*sns.MessageAttributeValue{
-			"metadataType": {
-				DataType:    aws.String("String"),
-				StringValue: aws.String(m.metadataType),
-			},
-		},
-	})
-	return err
-}
-
+// Publish an event body. Bodies of a metadata type with a schema are decoded into their typed event and
+// published with PublishEvent, so they are validated and stamped with the schema version.
 func (m *MetadataClient) Publish(payload map[string]interface{}) error {
-	timestamp := time.Now().UTC().String()
+	timestamp := FormatTimestamp(time.Now())
 	maps.Copy(payload, m.body)
 	payload["timestamp"] = timestamp
 	log.Printf("Payload to publish is: %v \n", payload)
@@ -91,26 +208,77 @@ func (m *MetadataClient) Publish(payload map[string]interface{}) error {
 		return fmt.Errorf("incorrect client payload structure. Please double check the required keys")
 	}
 
-	documentId := payload["documentId"].(string)
 	payloadJson, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("error marshalling payload: %v", err)
 	}
 
-	if m.targetType == "sns" {
-		metaDataTypeAttribute := map[string]interface{}{
-			"DataType":    "String",
-			"StringValue": m.metadataType,
+	if event := NewEvent(m.metadataType); event != nil {
+		err = json.Unmarshal(payloadJson, event)
+		if err != nil {
+			return &ValidationError{Type: m.metadataType, Reason: err.Error()}
 		}
+		return m.PublishEvent(event)
+	}
+
+	documentId := payload["documentId"].(string)
+	return m.send(payloadJson, documentId, timestamp)
+}
+
+// Publish a typed event, stamping it with the current schema version and, if unset, the current time
+func (m *MetadataClient) PublishEvent(event Event) error {
+	if event.Type() != m.metadataType {
+		return fmt.Errorf("cannot publish %s events with a %s client", event.Type(), m.metadataType)
+	}
+	message, err := MarshalEvent(event)
+	if err != nil {
+		return err
+	}
+	header := event.header()
+	return m.send(message, header.DocumentId, header.Timestamp)
+}
+
+// Stamp a typed event with the current schema version and, if unset, the current time, then validate and marshal it
+func MarshalEvent(event Event) ([]byte, error) {
+	header := event.header()
+	header.SchemaVersion = EventSchemaVersion
+	if header.Timestamp == "" {
+		header.Timestamp = FormatTimestamp(time.Now())
+	}
 
-		messageAttributes := map[string]interface{}{
-			"metadataType": metaDataTypeAttribute,
+	err := event.Validate()
+	if err != nil {
+		return nil, err
+	}
+	message, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling event: %v", err)
+	}
+	return message, nil
+}
+
+// Send a marshalled event to the target in the client's envelope, grouped by document.
+// Clients with a batch add the event to it instead.
+func (m *MetadataClient) send(message []byte, documentId string, timestamp string) error {
+	if m.envelope == EnvelopeCloudEvents {
+		event, err := NewCloudEvent(m.eventSource, m.metadataType, documentId, timestamp, message)
+		if err != nil {
+			return err
 		}
-		err = m._publishSNS(string(payloadJson), documentId, messageAttributes)
-	} else if m.targetType == "lambda" {
-		return fmt.Errorf("lambda not implemented yet")
-	} else {
-		return fmt.Errorf("invalid targetType")
+		message, err = json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("error marshalling CloudEvent: %v", err)
+		}
+	}
+
+	transportMessage := TransportMessage{MetadataType: m.metadataType, DocumentId: documentId, Body: message}
+	size, limit := m.transport.MessageSize(transportMessage)
+	if size > limit {
+		return &PayloadTooLargeError{Target: m.targetArn, Size: size, Limit: limit}
+	}
+	if m.batch != nil {
+		m.batch.add(m, transportMessage)
+		return nil
 	}
-	return err
+	return m.transport.Send(transportMessage)
 }
@@ -0,0 +1,61 @@
+package metadata
+
+import "testing"
+
+func TestTargetFromEnv(t *testing.T) {
+	const (
+		topicArn     = "arn:aws:sns:us-east-1:000000000000:metadata.fifo"
+		busArn       = "arn:aws:events:us-east-1:000000000000:event-bus/metadata"
+		functionName = "documentTracking"
+	)
+	tests := []struct {
+		name       string
+		env        map[string]string
+		clientArn  string
+		wantArn    string
+		wantTarget string
+	}{
+		{
+			name:       "metadata topic",
+			env:        map[string]string{TopicArnEnv: topicArn},
+			clientArn:  topicArn,
+			wantArn:    topicArn,
+			wantTarget: TargetTypeSNS,
+		},
+		{
+			name:       "target detected from its ARN",
+			env:        map[string]string{TopicArnEnv: topicArn, TargetArnEnv: busArn},
+			clientArn:  busArn,
+			wantArn:    busArn,
+			wantTarget: TargetTypeEventBridge,
+		},
+		{
+			name:       "target of a configured type",
+			env:        map[string]string{TopicArnEnv: topicArn, TargetArnEnv: functionName, TargetTypeEnv: TargetTypeLambda},
+			clientArn:  functionName,
+			wantArn:    functionName,
+			wantTarget: TargetTypeLambda,
+		},
+		{
+			name:       "configured type only applies to the configured target",
+			env:        map[string]string{TopicArnEnv: topicArn, TargetArnEnv: busArn, TargetTypeEnv: TargetTypeLambda},
+			clientArn:  topicArn,
+			wantArn:    busArn,
+			wantTarget: TargetTypeSNS,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{TopicArnEnv, TargetArnEnv, TargetTypeEnv} {
+				t.Setenv(name, tt.env[name])
+			}
+			if got := TargetArnFromEnv(); got != tt.wantArn {
+				t.Errorf("TargetArnFromEnv() = %q, want %q", got, tt.wantArn)
+			}
+			if got := defaultTargetType(tt.clientArn); got != tt.wantTarget {
+				t.Errorf("defaultTargetType(%q) = %q, want %q", tt.clientArn, got, tt.wantTarget)
+			}
+		})
+	}
+}
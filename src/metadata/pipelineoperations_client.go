@@ -1,47 +1,55 @@
 package metadata
 
-import "log"
+import (
+	"errors"
+	"log"
+)
 
 type PipelineOperationsClient struct {
 	metadataClient *MetadataClient
+	// Publishes the events the state machine rejects
+	transitionsClient *MetadataClient
 }
 
-func NewPipelineOperationsClient(metadataTopic string) *PipelineOperationsClient {
+func NewPipelineOperationsClient(metadataTopic string, opts ...MetadataClientOption) *PipelineOperationsClient {
 	metadataClient := NewMetadataClient(
-		"pipeline-operations",
+		MetadataTypeOperations,
 		metadataTopic,
-		"sns",
+		"",
 		nil,
 		[]string{"documentId", "bucketName", "objectName", "status", "stage"},
+		opts...,
 	)
+	transitionsClient := NewMetadataClient(MetadataTypeInvalidTransition, metadataTopic, "", nil, nil, opts...)
 	return &PipelineOperationsClient{
-		metadataClient: metadataClient,
+		metadataClient:    metadataClient,
+		transitionsClient: transitionsClient,
 	}
 }
 
 func (p *PipelineOperationsClient) InitDoc(body map[string]interface{}) error {
-	body["status"] = "IN_PROGRESS"
-	body["initDoc"] = "True"
+	body["status"] = StatusInProgress
+	body["initDoc"] = true
 
 	return p.publish(body, "")
 }
 
 func (p *PipelineOperationsClient) StageInProgress(body map[string]interface{}, message string) error {
-	body["status"] = "IN_PROGRESS"
+	body["status"] = StatusInProgress
 	delete(body, "initDoc")
 
 	return p.publish(body, message)
 }
 
 func (p *PipelineOperationsClient) StageSucceeded(body map[string]interface{}, message string) error {
-	body["status"] = "SUCCEEDED"
+	body["status"] = StatusSucceeded
 	delete(body, "initDoc")
 
 	return p.publish(body, message)
 }
 
 func (p *PipelineOperationsClient) StageFailed(body map[string]interface{}, message string) error {
-	body["status"] = "FAILED"
+	body["status"] = StatusFailed
 	delete(body, "initDoc")
 
 	return p.publish(body, message)
@@ -52,6 +60,55 @@ func (p *PipelineOperationsClient) publish(body map[string]interface{}, message
 		body["message"] = message
 	}
 
+	documentId, _ := body["documentId"].(string)
+	stage, _ := body["stage"].(string)
+	status, _ := body["status"].(string)
+	initDoc, _ := body["initDoc"].(bool)
+	err := p.checkTransition(documentId, StageState{Stage: stage, Status: status}, initDoc)
+	if err != nil {
+		return err
+	}
+
 	log.Printf("*PipelineOperations* Publishing event with body: %v \n", body)
 	return p.metadataClient.Publish(body)
 }
+
+// Publish a typed operations event
+func (p *PipelineOperationsClient) PublishEvent(event *OperationsEvent) error {
+	err := p.checkTransition(event.DocumentId, StageState{Stage: event.Stage, Status: event.Status}, bool(event.InitDoc))
+	if err != nil {
+		return err
+	}
+
+	log.Printf("*PipelineOperations* Publishing event: %+v \n", event)
+	return p.metadataClient.PublishEvent(event)
+}
+
+// Check the state of an event against the pipeline state machine. Transitions from the document's previous state
+// are checked by the store, which has it. Rejected events are reported instead of published.
+func (p *PipelineOperationsClient) checkTransition(documentId string, state StageState, initDoc bool) error {
+	var err error
+	if initDoc {
+		err = Pipeline.Start(documentId, state)
+	} else {
+		err = Pipeline.ValidState(documentId, state)
+	}
+
+	invalid := &InvalidTransitionError{}
+	if errors.As(err, &invalid) {
+		log.Printf("*PipelineOperations* Not publishing event: %v \n", err)
+		reportErr := p.ReportInvalidTransition(invalid, DetectedByClient)
+		if reportErr != nil {
+			log.Printf("*PipelineOperations* Failed to report invalid transition: %v \n", reportErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// Publish an invalid transition event
+func (p *PipelineOperationsClient) ReportInvalidTransition(err *InvalidTransitionError, detectedBy string) error {
+	event := NewInvalidTransitionEvent(err, detectedBy)
+	log.Printf("*PipelineOperations* Publishing invalid transition: %+v \n", event)
+	return p.transitionsClient.PublishEvent(event)
+}
@@ -0,0 +1,164 @@
+package metadata
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// Event types with a schema, in the order their schemas are listed
+var eventTypes = []string{MetadataTypeRegistry, MetadataTypeLineage, MetadataTypeOperations, MetadataTypeInvalidTransition}
+
+// ValidationError is returned for events that do not match their schema
+type ValidationError struct {
+	Type   string
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("invalid %s event: %s", e.Type, e.Reason)
+	}
+	return fmt.Sprintf("invalid %s event: %s %s", e.Type, e.Field, e.Reason)
+}
+
+// A struct field of an event with its schema tags
+type schemaField struct {
+	name     string
+	required bool
+	enum     []string
+	pattern  string
+	value    reflect.Value
+}
+
+// List the JSON fields of an event struct, flattening embedded structs the way encoding/json does
+func schemaFields(v reflect.Value) []schemaField {
+	fields := []schemaField{}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			fields = append(fields, schemaFields(v.Field(i))...)
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" || !f.IsExported() {
+			continue
+		}
+		field := schemaField{
+			name:     name,
+			required: f.Tag.Get("schema") == "required",
+			pattern:  f.Tag.Get("pattern"),
+			value:    v.Field(i),
+		}
+		if enum := f.Tag.Get("enum"); enum != "" {
+			field.enum = strings.Split(enum, ",")
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// Check the required, enum and pattern tags of an event's fields
+func validateFields(event Event) error {
+	for _, field := range schemaFields(reflect.ValueOf(event).Elem()) {
+		if field.value.IsZero() {
+			if field.required {
+				return &ValidationError{Type: event.Type(), Field: field.name, Reason: "is required"}
+			}
+			continue
+		}
+		if field.value.Kind() != reflect.String {
+			continue
+		}
+		value := field.value.String()
+		if field.enum != nil && !slices.Contains(field.enum, value) {
+			return &ValidationError{Type: event.Type(), Field: field.name, Reason: fmt.Sprintf("must be one of %s, got %q", strings.Join(field.enum, ", "), value)}
+		}
+		if field.pattern != "" && !regexp.MustCompile(field.pattern).MatchString(value) {
+			return &ValidationError{Type: event.Type(), Field: field.name, Reason: fmt.Sprintf("must match %s, got %q", field.pattern, value)}
+		}
+	}
+	return nil
+}
+
+// JSON Schema of a Go type
+func typeSchema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Struct:
+		return structSchema(reflect.New(t).Elem())
+	}
+	return map[string]interface{}{"type": "object"}
+}
+
+// JSON Schema of a struct from its json and schema tags
+func structSchema(v reflect.Value) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	for _, field := range schemaFields(v) {
+		property := typeSchema(field.value.Type())
+		if field.enum != nil {
+			property["enum"] = field.enum
+		}
+		if field.pattern != "" {
+			property["pattern"] = field.pattern
+		}
+		if field.required && field.value.Kind() == reflect.String {
+			property["minLength"] = 1
+		}
+		properties[field.name] = property
+		if field.required {
+			required = append(required, field.name)
+		}
+	}
+
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// JSON Schema (draft 2020-12) of the events of a metadata type at EventSchemaVersion.
+// Unknown properties are allowed so consumers keep accepting events of newer minor revisions.
+func JSONSchema(metadataType string) (map[string]interface{}, error) {
+	event := NewEvent(metadataType)
+	if event == nil {
+		return nil, fmt.Errorf("no schema for metadata type %s", metadataType)
+	}
+
+	v := reflect.ValueOf(event).Elem()
+	schema := structSchema(v)
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["$id"] = SchemaFileName(metadataType)
+	schema["title"] = v.Type().Name()
+	schema["properties"].(map[string]interface{})["schemaVersion"] = map[string]interface{}{"const": EventSchemaVersion}
+	return schema, nil
+}
+
+// File name of the published JSON Schema of a metadata type
+func SchemaFileName(metadataType string) string {
+	return fmt.Sprintf("%s.v%d.schema.json", metadataType, EventSchemaVersion)
+}
+
+// Metadata types that have a schema
+func EventTypes() []string {
+	return slices.Clone(eventTypes)
+}
@@ -0,0 +1,149 @@
+package metadata
+
+import (
+	"fmt"
+
+	"golang.org/x/exp/slices"
+)
+
+// Pipeline stages
+const (
+	StageDocumentClassifier    = "DOCUMENT_CLASSIFIER"
+	StageDocumentProcessor     = "DOCUMENT_PROCESSOR"
+	StageSyncProcessTextract   = "SYNC_PROCESS_TEXTRACT"
+	StageAsyncStartTextract    = "ASYNC_START_TEXTRACT"
+	StageAsyncProcessTextract  = "ASYNC_PROCESS_TEXTRACT"
+	StageSyncProcessComprehend = "SYNC_PROCESS_COMPREHEND"
+	StageSyncProcessEmbeddings = "SYNC_PROCESS_EMBEDDINGS"
+)
+
+// StageState is the state of a document in the pipeline: its stage and its status there
+type StageState struct {
+	Stage  string `json:"stage"`
+	Status string `json:"status"`
+}
+
+func (s StageState) String() string {
+	return s.Stage + "/" + s.Status
+}
+
+// StageDefinition declares a stage and the stages a document moves on to from it
+type StageDefinition struct {
+	Name string
+	// Empty for the last stage, whose success ends the pipeline
+	Next []string
+}
+
+// StateMachine declares the stages of the pipeline and the transitions between the states of a document
+type StateMachine struct {
+	// Stages in pipeline order. Documents start in the first one.
+	Stages []StageDefinition
+	// Statuses reachable from each status without leaving the stage
+	Transitions map[string][]string
+	// Statuses of a stage from which a document moves on to the next stages, in any status. A stage is under
+	// way once its successor started, as stages are triggered by the outputs they leave before they report.
+	Advance []string
+}
+
+// Pipeline is the state machine of the document processing pipeline. A stage is retried after it failed and
+// reports its outcome again when redelivered; it is never reopened after it succeeded.
+var Pipeline = &StateMachine{
+	Stages: []StageDefinition{
+		{Name: StageDocumentClassifier, Next: []string{StageDocumentProcessor}},
+		{Name: StageDocumentProcessor, Next: []string{StageSyncProcessTextract, StageAsyncStartTextract}},
+		{Name: StageSyncProcessTextract, Next: []string{StageSyncProcessComprehend}},
+		{Name: StageAsyncStartTextract, Next: []string{StageAsyncProcessTextract}},
+		{Name: StageAsyncProcessTextract, Next: []string{StageSyncProcessComprehend}},
+		{Name: StageSyncProcessComprehend, Next: []string{StageSyncProcessEmbeddings}},
+		{Name: StageSyncProcessEmbeddings},
+	},
+	Transitions: map[string][]string{
+		StatusInProgress: {StatusInProgress, StatusSucceeded, StatusFailed},
+		StatusFailed:     {StatusInProgress, StatusSucceeded, StatusFailed},
+		StatusSucceeded:  {StatusSucceeded},
+	},
+	Advance: []string{StatusInProgress, StatusSucceeded},
+}
+
+// InvalidTransitionError is returned for a pipeline event that moves a document to a state it cannot reach
+type InvalidTransitionError struct {
+	DocumentId string
+	// Empty for the first event of a document
+	From   StageState
+	To     StageState
+	Reason string
+}
+
+func (e *InvalidTransitionError) Error() string {
+	if e.From == (StageState{}) {
+		return fmt.Sprintf("invalid transition of document %s to %s: %s", e.DocumentId, e.To, e.Reason)
+	}
+	return fmt.Sprintf("invalid transition of document %s from %s to %s: %s", e.DocumentId, e.From, e.To, e.Reason)
+}
+
+// Definition of a stage
+func (m *StateMachine) Stage(name string) (StageDefinition, bool) {
+	for _, stage := range m.Stages {
+		if stage.Name == name {
+			return stage, true
+		}
+	}
+	return StageDefinition{}, false
+}
+
+// Check that a state is in a declared stage and has a declared status
+func (m *StateMachine) ValidState(documentId string, state StageState) error {
+	if _, ok := m.Stage(state.Stage); !ok {
+		return &InvalidTransitionError{DocumentId: documentId, To: state, Reason: fmt.Sprintf("unknown stage %q", state.Stage)}
+	}
+	if _, ok := m.Transitions[state.Status]; !ok {
+		return &InvalidTransitionError{DocumentId: documentId, To: state, Reason: fmt.Sprintf("unknown status %q", state.Status)}
+	}
+	return nil
+}
+
+// Check the state a document starts in: the first stage, in progress
+func (m *StateMachine) Start(documentId string, state StageState) error {
+	err := m.ValidState(documentId, state)
+	if err != nil {
+		return err
+	}
+	if state.Stage != m.Stages[0].Name || state.Status != StatusInProgress {
+		return &InvalidTransitionError{DocumentId: documentId, To: state, Reason: fmt.Sprintf("documents start in %s/%s", m.Stages[0].Name, StatusInProgress)}
+	}
+	return nil
+}
+
+// Check a transition of a document. Documents in a state the machine does not declare, as recorded before it
+// existed, may move to any valid state.
+func (m *StateMachine) Transition(documentId string, from StageState, to StageState) error {
+	err := m.ValidState(documentId, to)
+	if err != nil {
+		err.(*InvalidTransitionError).From = from
+		return err
+	}
+	if m.ValidState(documentId, from) != nil {
+		return nil
+	}
+	stage, _ := m.Stage(from.Stage)
+
+	if to.Stage == from.Stage {
+		if slices.Contains(m.Transitions[from.Status], to.Status) {
+			return nil
+		}
+		return &InvalidTransitionError{DocumentId: documentId, From: from, To: to, Reason: fmt.Sprintf("%s does not lead to %s within a stage", from.Status, to.Status)}
+	}
+	if !slices.Contains(stage.Next, to.Stage) {
+		return &InvalidTransitionError{DocumentId: documentId, From: from, To: to, Reason: fmt.Sprintf("%s does not lead to %s", from.Stage, to.Stage)}
+	}
+	if !slices.Contains(m.Advance, from.Status) {
+		return &InvalidTransitionError{DocumentId: documentId, From: from, To: to, Reason: fmt.Sprintf("documents do not move on from a stage that is %s", from.Status)}
+	}
+	return nil
+}
+
+// Whether a state ends the pipeline: the success of its last stage
+func (m *StateMachine) Terminal(state StageState) bool {
+	stage, ok := m.Stage(state.Stage)
+	return ok && len(stage.Next) == 0 && state.Status == StatusSucceeded
+}
@@ -0,0 +1,69 @@
+package metadata
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPipelineTransition(t *testing.T) {
+	state := func(stage, status string) StageState { return StageState{Stage: stage, Status: status} }
+	tests := []struct {
+		name    string
+		from    StageState
+		to      StageState
+		wantErr bool
+	}{
+		{name: "progress to success", from: state(StageDocumentClassifier, StatusInProgress), to: state(StageDocumentClassifier, StatusSucceeded)},
+		{name: "retry after failure", from: state(StageSyncProcessTextract, StatusFailed), to: state(StageSyncProcessTextract, StatusInProgress)},
+		{name: "redelivered success", from: state(StageSyncProcessTextract, StatusSucceeded), to: state(StageSyncProcessTextract, StatusSucceeded)},
+		{name: "reopened after success", from: state(StageSyncProcessTextract, StatusSucceeded), to: state(StageSyncProcessTextract, StatusInProgress), wantErr: true},
+		{name: "next stage after success", from: state(StageDocumentProcessor, StatusSucceeded), to: state(StageAsyncStartTextract, StatusInProgress)},
+		{name: "next stage while in progress", from: state(StageDocumentProcessor, StatusInProgress), to: state(StageSyncProcessTextract, StatusSucceeded)},
+		{name: "next stage after failure", from: state(StageDocumentProcessor, StatusFailed), to: state(StageSyncProcessTextract, StatusInProgress), wantErr: true},
+		{name: "skipped stage", from: state(StageDocumentClassifier, StatusSucceeded), to: state(StageSyncProcessComprehend, StatusInProgress), wantErr: true},
+		{name: "previous stage", from: state(StageSyncProcessComprehend, StatusInProgress), to: state(StageSyncProcessTextract, StatusSucceeded), wantErr: true},
+		{name: "unknown stage", from: state(StageDocumentClassifier, StatusSucceeded), to: state("UNKNOWN", StatusInProgress), wantErr: true},
+		{name: "unknown status", from: state(StageDocumentClassifier, StatusInProgress), to: state(StageDocumentClassifier, "DONE"), wantErr: true},
+		{name: "from an undeclared state", from: state("LEGACY", StatusSucceeded), to: state(StageSyncProcessComprehend, StatusInProgress)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Pipeline.Transition("doc", tt.from, tt.to)
+			if !tt.wantErr {
+				if err != nil {
+					t.Errorf("Transition() error = %v", err)
+				}
+				return
+			}
+			invalid := &InvalidTransitionError{}
+			if !errors.As(err, &invalid) {
+				t.Fatalf("Transition() error = %v, want an InvalidTransitionError", err)
+			}
+			if invalid.From != tt.from || invalid.To != tt.to {
+				t.Errorf("Transition() error from %s to %s, want from %s to %s", invalid.From, invalid.To, tt.from, tt.to)
+			}
+		})
+	}
+}
+
+func TestPipelineTerminal(t *testing.T) {
+	tests := []struct {
+		state StageState
+		want  bool
+	}{
+		{StageState{StageSyncProcessEmbeddings, StatusSucceeded}, true},
+		{StageState{StageSyncProcessEmbeddings, StatusInProgress}, false},
+		{StageState{StageSyncProcessEmbeddings, StatusFailed}, false},
+		{StageState{StageSyncProcessComprehend, StatusSucceeded}, false},
+		{StageState{"UNKNOWN", StatusSucceeded}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.state.String(), func(t *testing.T) {
+			if got := Pipeline.Terminal(tt.state); got != tt.want {
+				t.Errorf("Terminal(%s) = %v, want %v", tt.state, got, tt.want)
+			}
+		})
+	}
+}
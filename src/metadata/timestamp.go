@@ -0,0 +1,28 @@
+package metadata
+
+import (
+	"fmt"
+	"time"
+)
+
+// RFC 3339 layout of event timestamps. The fixed width fraction keeps timestamps sorting in time order as strings.
+const TimestampLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// Layout of time.Time.String(), which older metadata events used for their timestamps
+const legacyTimestampLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// Parse a metadata event timestamp, accepting RFC 3339 as well as the legacy time.Time.String() format
+func ParseTimestamp(timestamp string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, timestamp); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(legacyTimestampLayout, timestamp); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("unrecognised timestamp %q", timestamp)
+}
+
+// Format a time as an event timestamp, in UTC
+func FormatTimestamp(t time.Time) string {
+	return t.UTC().Format(TimestampLayout)
+}
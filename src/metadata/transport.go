@@ -0,0 +1,166 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/dreamspider42/document-processing-pipeline/src/awshelper"
+)
+
+// TransportMessage is a marshalled metadata event, in its envelope, on its way to the consumers
+type TransportMessage struct {
+	MetadataType string
+	// Events of a document are grouped, and ordered where the transport supports it, by their document
+	DocumentId string
+	Body       []byte
+}
+
+// Transport delivers the events of a metadata client to their consumers.
+// Transports are compared by identity when batching, so implement them on pointer types.
+type Transport interface {
+	// Deliver a message
+	Send(message TransportMessage) error
+	// Size of a message as the transport counts it, and the transport's limit
+	MessageSize(message TransportMessage) (int, int)
+}
+
+// SNSTransport publishes to an SNS topic with the metadataType attribute subscriptions filter on
+type SNSTransport struct {
+	TopicArn string
+	Client   snsiface.SNSAPI
+}
+
+// Create an SNS transport. A nil client is created from the default session.
+func NewSNSTransport(topicArn string, client snsiface.SNSAPI) *SNSTransport {
+	if client == nil {
+		client = sns.New(awshelper.NewAWSSession())
+	}
+	return &SNSTransport{TopicArn: topicArn, Client: client}
+}
+
+func (t *SNSTransport) Send(message TransportMessage) error {
+	log.Println("publishing to SNS")
+	_, err := t.Client.Publish(&sns.PublishInput{
+		TopicArn:          &t.TopicArn,
+		Message:           aws.String(string(message.Body)),
+		MessageGroupId:    aws.String(message.DocumentId),
+		MessageAttributes: messageAttributes(message.MetadataType),
+	})
+	return err
+}
+
+func (t *SNSTransport) MessageSize(message TransportMessage) (int, int) {
+	return len(message.Body), MaxSNSMessageBytes
+}
+
+// Attributes subscribers filter SNS messages on
+func messageAttributes(metadataType string) map[string]*sns.MessageAttributeValue {
+	return map[string]*sns.MessageAttributeValue{
+		"metadataType": {
+			DataType:    aws.String("String"),
+			StringValue: aws.String(metadataType),
+		},
+	}
+}
+
+// LambdaTransport invokes a function with each event wrapped in a LambdaEvent
+type LambdaTransport struct {
+	FunctionName string
+	// InvocationSync or InvocationAsync
+	InvocationType string
+	Client         lambdaiface.LambdaAPI
+}
+
+// Create a Lambda transport. A nil client is created from the default session.
+func NewLambdaTransport(functionName string, invocationType string, client lambdaiface.LambdaAPI) *LambdaTransport {
+	if invocationType != InvocationSync && invocationType != InvocationAsync {
+		panic(fmt.Sprintf("LambdaTransport does not accept invocation type %s", invocationType))
+	}
+	if client == nil {
+		client = lambda.New(awshelper.NewAWSSession())
+	}
+	return &LambdaTransport{FunctionName: functionName, InvocationType: invocationType, Client: client}
+}
+
+// Invoke the function. Synchronous invocations surface errors raised by the function as a *FunctionError.
+func (t *LambdaTransport) Send(message TransportMessage) error {
+	log.Printf("invoking Lambda (%s)", t.InvocationType)
+	payload, err := json.Marshal(LambdaEvent{MetadataType: message.MetadataType, Message: message.Body})
+	if err != nil {
+		return fmt.Errorf("error marshalling payload: %v", err)
+	}
+
+	res, err := t.Client.Invoke(&lambda.InvokeInput{
+		FunctionName:   &t.FunctionName,
+		InvocationType: &t.InvocationType,
+		Payload:        payload,
+	})
+	if err != nil {
+		return err
+	}
+	if res.FunctionError != nil {
+		return newFunctionError(t.FunctionName, *res.FunctionError, res.Payload)
+	}
+	return nil
+}
+
+func (t *LambdaTransport) MessageSize(message TransportMessage) (int, int) {
+	size := len(message.Body) + len(message.MetadataType) + len(`{"metadataType":"","message":}`)
+	if t.InvocationType == InvocationAsync {
+		return size, MaxLambdaAsyncPayloadBytes
+	}
+	return size, MaxLambdaSyncPayloadBytes
+}
+
+// EventBridgeTransport puts events on a bus with the detail-type of their metadata type
+type EventBridgeTransport struct {
+	EventBusName string
+	Source       string
+	Client       eventbridgeiface.EventBridgeAPI
+}
+
+// Create an EventBridge transport. A nil client is created from the default session.
+func NewEventBridgeTransport(eventBusName string, source string, client eventbridgeiface.EventBridgeAPI) *EventBridgeTransport {
+	if client == nil {
+		client = eventbridge.New(awshelper.NewAWSSession())
+	}
+	return &EventBridgeTransport{EventBusName: eventBusName, Source: source, Client: client}
+}
+
+func (t *EventBridgeTransport) Send(message TransportMessage) error {
+	log.Println("putting event on EventBridge")
+	res, err := t.Client.PutEvents(&eventbridge.PutEventsInput{
+		Entries: []*eventbridge.PutEventsRequestEntry{t.entry(message)},
+	})
+	if err != nil {
+		return err
+	}
+	if aws.Int64Value(res.FailedEntryCount) > 0 && len(res.Entries) > 0 {
+		entry := res.Entries[0]
+		return fmt.Errorf("event rejected by %s: %s %s", t.EventBusName, aws.StringValue(entry.ErrorCode), aws.StringValue(entry.ErrorMessage))
+	}
+	return nil
+}
+
+// EventBridge counts the source, detail-type, detail and 14 bytes of event time towards the entry size
+func (t *EventBridgeTransport) MessageSize(message TransportMessage) (int, int) {
+	return len(t.Source) + len(DetailTypeOf(message.MetadataType)) + len(message.Body) + 14, MaxEventBridgeEntryBytes
+}
+
+// Entry putting a message on the bus
+func (t *EventBridgeTransport) entry(message TransportMessage) *eventbridge.PutEventsRequestEntry {
+	return &eventbridge.PutEventsRequestEntry{
+		EventBusName: &t.EventBusName,
+		Source:       &t.Source,
+		DetailType:   aws.String(DetailTypeOf(message.MetadataType)),
+		Detail:       aws.String(string(message.Body)),
+	}
+}
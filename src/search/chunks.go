@@ -0,0 +1,156 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/dreamspider42/document-processing-pipeline/src/awshelper"
+	"github.com/dreamspider42/document-processing-pipeline/src/textractparser"
+)
+
+const (
+	RecordTypeChunk = "chunk"
+
+	// Chunks stay well under the input limit of common sentence embedding models
+	DefaultChunkCharacters = 1000
+)
+
+// Page coordinates of a chunk, as ratios of the page size like Textract geometry
+type BoundingBox struct {
+	Left   float64 `json:"left"`
+	Top    float64 `json:"top"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// Represents the search record of a passage of page text and its embedding
+type ChunkRecord struct {
+	RecordMetadata
+	RecordType     string      `json:"recordType"`
+	DocumentId     string      `json:"documentId"`
+	Page           int         `json:"page"`
+	Chunk          int         `json:"chunk"`
+	Text           string      `json:"text"`
+	BoundingBox    BoundingBox `json:"boundingBox"`
+	EmbeddingModel string      `json:"embeddingModel,omitempty"`
+	Embedding      []float32   `json:"embedding,omitempty"`
+}
+
+// Where a passage matching a vector search was found
+type Citation struct {
+	Page        int         `json:"page"`
+	Chunk       int         `json:"chunk"`
+	Text        string      `json:"text"`
+	BoundingBox BoundingBox `json:"boundingBox"`
+	Score       float64     `json:"score"`
+}
+
+// Stable search record id of a chunk: documentId#page#chunk
+func ChunkRecordId(documentId string, page, chunk int) string {
+	return fmt.Sprintf("%s#%d#%d", documentId, page, chunk)
+}
+
+// Split the lines of a page into chunks of at most maxCharacters, never splitting a line.
+// Each chunk keeps the box enclosing its lines so results can be cited on the page.
+func ChunkPage(documentId string, pageNum int, page *textractparser.Page, maxCharacters int) []ChunkRecord {
+	chunks := []ChunkRecord{}
+	var text strings.Builder
+	var box *BoundingBox
+
+	flush := func() {
+		if text.Len() == 0 {
+			return
+		}
+		chunk := ChunkRecord{
+			RecordType: RecordTypeChunk,
+			DocumentId: documentId,
+			Page:       pageNum,
+			Chunk:      len(chunks) + 1,
+			Text:       strings.TrimSpace(text.String()),
+		}
+		if box != nil {
+			chunk.BoundingBox = *box
+		}
+		chunks = append(chunks, chunk)
+		text.Reset()
+		box = nil
+	}
+
+	for _, line := range page.Lines {
+		if line.Text == nil || *line.Text == "" {
+			continue
+		}
+		if text.Len() > 0 && text.Len()+len(*line.Text) > maxCharacters {
+			flush()
+		}
+		text.WriteString(*line.Text)
+		text.WriteString("\n")
+		box = enclose(box, line.Geometry)
+	}
+	flush()
+	return chunks
+}
+
+// Grow a box to enclose the bounding box of a geometry
+func enclose(box *BoundingBox, geometry *textractparser.Geometry) *BoundingBox {
+	if geometry == nil || geometry.BoundingBox == nil || geometry.BoundingBox.Left == nil || geometry.BoundingBox.Top == nil ||
+		geometry.BoundingBox.Width == nil || geometry.BoundingBox.Height == nil {
+		return box
+	}
+	b := geometry.BoundingBox
+	line := BoundingBox{Left: *b.Left, Top: *b.Top, Width: *b.Width, Height: *b.Height}
+	if box == nil {
+		return &line
+	}
+
+	left := math.Min(box.Left, line.Left)
+	top := math.Min(box.Top, line.Top)
+	right := math.Max(box.Left+box.Width, line.Left+line.Width)
+	bottom := math.Max(box.Top+box.Height, line.Top+line.Height)
+	return &BoundingBox{Left: left, Top: top, Width: right - left, Height: bottom - top}
+}
+
+// Embed the chunks in place, recording which provider produced the vectors
+func EmbedChunks(provider EmbeddingProvider, chunks []ChunkRecord) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	if provider.Dimensions() != EmbeddingDimensions {
+		return fmt.Errorf("embedding provider %s produces %d dimensions, the index expects %d", provider.Name(), provider.Dimensions(), EmbeddingDimensions)
+	}
+
+	texts := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		texts[i] = chunk.Text
+	}
+	vectors, err := provider.Embed(texts)
+	if err != nil {
+		return err
+	}
+	if len(vectors) != len(chunks) {
+		return fmt.Errorf("embedding provider %s returned %d vectors for %d chunks", provider.Name(), len(vectors), len(chunks))
+	}
+
+	for i := range chunks {
+		chunks[i].Embedding = vectors[i]
+		chunks[i].EmbeddingModel = provider.Name()
+	}
+	return nil
+}
+
+// Convert chunks into bulk items carrying the document's record metadata
+func ChunkBulkItems(recordMetadata RecordMetadata, chunks []ChunkRecord) ([]awshelper.BulkItem, error) {
+	items := make([]awshelper.BulkItem, 0, len(chunks))
+	for _, chunk := range chunks {
+		chunk.RecordType = RecordTypeChunk
+		chunk.RecordMetadata = recordMetadata
+		body, err := json.Marshal(chunk)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, awshelper.BulkItem{DocumentID: ChunkRecordId(chunk.DocumentId, chunk.Page, chunk.Chunk), Body: body})
+	}
+	return items, nil
+}
@@ -0,0 +1,122 @@
+package search
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/dreamspider42/document-processing-pipeline/src/textractparser"
+)
+
+// A Textract line with its bounding box
+func line(text string, left, top, width, height float64) *textractparser.Line {
+	return &textractparser.Line{
+		Text: &text,
+		Geometry: &textractparser.Geometry{BoundingBox: &textractparser.BoundingBox{
+			Left: &left, Top: &top, Width: &width, Height: &height,
+		}},
+	}
+}
+
+func TestChunkPage(t *testing.T) {
+	empty := ""
+	tests := []struct {
+		name          string
+		lines         []*textractparser.Line
+		maxCharacters int
+		wantTexts     []string
+		wantBoxes     []BoundingBox
+	}{
+		{
+			name:          "no lines",
+			maxCharacters: 100,
+			wantTexts:     []string{},
+		},
+		{
+			name:          "lines joined under the limit",
+			lines:         []*textractparser.Line{line("Invoice 42", 0.1, 0.1, 0.2, 0.05), line("Total due", 0.5, 0.2, 0.3, 0.05)},
+			maxCharacters: 100,
+			wantTexts:     []string{"Invoice 42\nTotal due"},
+			wantBoxes:     []BoundingBox{{Left: 0.1, Top: 0.1, Width: 0.7, Height: 0.15}},
+		},
+		{
+			name:          "split before the line that would exceed the limit",
+			lines:         []*textractparser.Line{line("aaaa", 0, 0, 0.1, 0.1), line("bbbb", 0, 0.2, 0.1, 0.1), line("cccc", 0, 0.4, 0.1, 0.1)},
+			maxCharacters: 10,
+			wantTexts:     []string{"aaaa\nbbbb", "cccc"},
+			wantBoxes:     []BoundingBox{{Left: 0, Top: 0, Width: 0.1, Height: 0.3}, {Left: 0, Top: 0.4, Width: 0.1, Height: 0.1}},
+		},
+		{
+			name:          "lines longer than the limit are not split",
+			lines:         []*textractparser.Line{line("a very long line", 0, 0, 1, 0.1), line("short", 0, 0.2, 0.5, 0.1)},
+			maxCharacters: 5,
+			wantTexts:     []string{"a very long line", "short"},
+		},
+		{
+			name:          "empty lines skipped",
+			lines:         []*textractparser.Line{{Text: &empty}, {}, line("text", 0.2, 0.2, 0.2, 0.2)},
+			maxCharacters: 100,
+			wantTexts:     []string{"text"},
+			wantBoxes:     []BoundingBox{{Left: 0.2, Top: 0.2, Width: 0.2, Height: 0.2}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := ChunkPage("doc", 3, &textractparser.Page{Lines: tt.lines}, tt.maxCharacters)
+			if len(chunks) != len(tt.wantTexts) {
+				t.Fatalf("got %d chunks, want %d", len(chunks), len(tt.wantTexts))
+			}
+			for i, chunk := range chunks {
+				if chunk.Text != tt.wantTexts[i] {
+					t.Errorf("chunk %d text = %q, want %q", i, chunk.Text, tt.wantTexts[i])
+				}
+				if chunk.DocumentId != "doc" || chunk.Page != 3 || chunk.Chunk != i+1 || chunk.RecordType != RecordTypeChunk {
+					t.Errorf("chunk %d = %s page %d chunk %d %s", i, chunk.DocumentId, chunk.Page, chunk.Chunk, chunk.RecordType)
+				}
+				if i < len(tt.wantBoxes) && !boxEqual(chunk.BoundingBox, tt.wantBoxes[i]) {
+					t.Errorf("chunk %d box = %+v, want %+v", i, chunk.BoundingBox, tt.wantBoxes[i])
+				}
+			}
+		})
+	}
+}
+
+func boxEqual(a, b BoundingBox) bool {
+	const epsilon = 1e-9
+	return math.Abs(a.Left-b.Left) < epsilon && math.Abs(a.Top-b.Top) < epsilon &&
+		math.Abs(a.Width-b.Width) < epsilon && math.Abs(a.Height-b.Height) < epsilon
+}
+
+func TestEmbedChunks(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider EmbeddingProvider
+		chunks   []ChunkRecord
+		wantErr  string
+	}{
+		{name: "no chunks", provider: NewHashingEmbedder(EmbeddingDimensions)},
+		{name: "embedded", provider: NewHashingEmbedder(EmbeddingDimensions), chunks: []ChunkRecord{{Text: "invoice total"}, {Text: "shipping address"}}},
+		{name: "wrong dimensions", provider: NewHashingEmbedder(8), chunks: []ChunkRecord{{Text: "invoice"}}, wantErr: "dimensions"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := EmbedChunks(tt.provider, tt.chunks)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("EmbedChunks() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("EmbedChunks() error = %v", err)
+			}
+			for i, chunk := range tt.chunks {
+				if len(chunk.Embedding) != EmbeddingDimensions || chunk.EmbeddingModel != tt.provider.Name() {
+					t.Errorf("chunk %d has %d dimensions from %q", i, len(chunk.Embedding), chunk.EmbeddingModel)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Name of the file the comprehend processor writes the page records of a document to
+const ComprehendOutputFileName = "comprehend-output.json"
+
+// Key of the comprehend output of a document: <documentId>/<documentName>/comprehend-output.json
+func ComprehendOutputKey(documentId, documentName string) string {
+	return fmt.Sprintf("%s/%s/%s", documentId, documentName, ComprehendOutputFileName)
+}
+
+// Split a comprehend output key into its documentId and documentName
+func ParseComprehendOutputKey(key string) (string, string, error) {
+	path := strings.TrimSuffix(key, "/"+ComprehendOutputFileName)
+	documentId, documentName, found := strings.Cut(path, "/")
+	if path == key || !found || documentId == "" || documentName == "" {
+		return "", "", fmt.Errorf("%s is not a comprehend output key", key)
+	}
+	return documentId, documentName, nil
+}
+
+// Parse the page records of a comprehend output file
+func ParseComprehendOutput(content []byte) ([]PageRecord, error) {
+	pages := []PageRecord{}
+	err := json.Unmarshal(content, &pages)
+	if err != nil {
+		return nil, err
+	}
+	for i := range pages {
+		pages[i].RecordType = RecordTypePage
+	}
+	return pages, nil
+}
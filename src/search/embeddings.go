@@ -0,0 +1,193 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sagemakerruntime"
+	"github.com/dreamspider42/document-processing-pipeline/src/awshelper"
+)
+
+const (
+	EmbeddingProviderHashing   = "hashing"
+	EmbeddingProviderSageMaker = "sagemaker"
+
+	// Dimension of the k-NN field in the index template. Providers must produce vectors of this size.
+	EmbeddingDimensions = 384
+)
+
+// Computes embedding vectors for text. Implementations return one vector of Dimensions() values per text, in order.
+type EmbeddingProvider interface {
+	Name() string
+	Dimensions() int
+	Embed(texts []string) ([][]float32, error)
+}
+
+// Deterministic local embedder hashing word unigrams and bigrams into a fixed size vector.
+// It needs no model, so it suits tests and local runs, but only matches shared vocabulary.
+type HashingEmbedder struct {
+	dimensions int
+}
+
+// Embedder backed by a SageMaker endpoint hosting a sentence embedding model.
+// The endpoint receives {"inputs": [...]} and answers with a list of vectors, or {"embeddings": [...]}.
+type SageMakerEmbedder struct {
+	Client       *sagemakerruntime.SageMakerRuntime
+	EndpointName string
+	BatchSize    int
+	dimensions   int
+}
+
+// Create a new instance of the hashing embedder
+func NewHashingEmbedder(dimensions int) *HashingEmbedder {
+	return &HashingEmbedder{dimensions: dimensions}
+}
+
+// Create a new instance of the SageMaker embedder
+func NewSageMakerEmbedder(endpointName string, dimensions int) *SageMakerEmbedder {
+	return &SageMakerEmbedder{
+		Client:       sagemakerruntime.New(awshelper.NewAWSSession()),
+		EndpointName: endpointName,
+		BatchSize:    16,
+		dimensions:   dimensions,
+	}
+}
+
+// Create the embedding provider of the given kind: sagemaker or hashing. An empty kind configures no provider,
+// nil, which disables vector and hybrid search rather than silently embedding with the hashing embedder.
+func NewEmbeddingProvider(kind, endpointName string) (EmbeddingProvider, error) {
+	switch kind {
+	case "":
+		return nil, nil
+	case EmbeddingProviderHashing:
+		return NewHashingEmbedder(EmbeddingDimensions), nil
+	case EmbeddingProviderSageMaker:
+		if endpointName == "" {
+			return nil, fmt.Errorf("the %s embedding provider needs an endpoint name", kind)
+		}
+		return NewSageMakerEmbedder(endpointName, EmbeddingDimensions), nil
+	}
+	return nil, fmt.Errorf("unknown embedding provider %q", kind)
+}
+
+func (e *HashingEmbedder) Name() string {
+	return fmt.Sprintf("%s-%d", EmbeddingProviderHashing, e.dimensions)
+}
+
+func (e *HashingEmbedder) Dimensions() int {
+	return e.dimensions
+}
+
+func (e *HashingEmbedder) Embed(texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vector := make([]float32, e.dimensions)
+		tokens := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+		for j, token := range tokens {
+			e.add(vector, token)
+			if j > 0 {
+				e.add(vector, tokens[j-1]+" "+token)
+			}
+		}
+		vectors[i] = normalize(vector)
+	}
+	return vectors, nil
+}
+
+// Add a feature to its hashed bucket, the sign taken from another hash bit so collisions tend to cancel out
+func (e *HashingEmbedder) add(vector []float32, feature string) {
+	h := fnv.New64a()
+	h.Write([]byte(feature))
+	sum := h.Sum64()
+	if sum>>63 == 1 {
+		vector[sum%uint64(e.dimensions)] -= 1
+	} else {
+		vector[sum%uint64(e.dimensions)] += 1
+	}
+}
+
+func (e *SageMakerEmbedder) Name() string {
+	return EmbeddingProviderSageMaker + ":" + e.EndpointName
+}
+
+func (e *SageMakerEmbedder) Dimensions() int {
+	return e.dimensions
+}
+
+func (e *SageMakerEmbedder) Embed(texts []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += e.BatchSize {
+		end := start + e.BatchSize
+		if end > len(texts) {
+			end = len(texts)
+		}
+
+		body, err := json.Marshal(map[string]interface{}{"inputs": texts[start:end]})
+		if err != nil {
+			return nil, err
+		}
+		res, err := e.Client.InvokeEndpoint(&sagemakerruntime.InvokeEndpointInput{
+			EndpointName: aws.String(e.EndpointName),
+			ContentType:  aws.String("application/json"),
+			Accept:       aws.String("application/json"),
+			Body:         body,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("invoke embedding endpoint %s: %v", e.EndpointName, err)
+		}
+
+		batch, err := parseEmbeddingResponse(res.Body)
+		if err != nil {
+			return nil, fmt.Errorf("embedding endpoint %s: %v", e.EndpointName, err)
+		}
+		if len(batch) != end-start {
+			return nil, fmt.Errorf("embedding endpoint %s returned %d vectors for %d texts", e.EndpointName, len(batch), end-start)
+		}
+		for _, vector := range batch {
+			if len(vector) != e.dimensions {
+				return nil, fmt.Errorf("embedding endpoint %s returned %d dimensions, expected %d", e.EndpointName, len(vector), e.dimensions)
+			}
+			vectors = append(vectors, normalize(vector))
+		}
+	}
+	return vectors, nil
+}
+
+// Accept both a bare list of vectors and an object wrapping them
+func parseEmbeddingResponse(body []byte) ([][]float32, error) {
+	vectors := [][]float32{}
+	if err := json.Unmarshal(body, &vectors); err == nil {
+		return vectors, nil
+	}
+
+	wrapped := struct {
+		Embeddings [][]float32 `json:"embeddings"`
+	}{}
+	if err := json.Unmarshal(body, &wrapped); err != nil {
+		return nil, fmt.Errorf("unexpected response: %v", err)
+	}
+	return wrapped.Embeddings, nil
+}
+
+// Scale a vector to unit length so cosine similarity and dot product agree
+func normalize(vector []float32) []float32 {
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v) * float64(v)
+	}
+	if norm == 0 {
+		return vector
+	}
+	norm = math.Sqrt(norm)
+	for i := range vector {
+		vector[i] = float32(float64(vector[i]) / norm)
+	}
+	return vector
+}
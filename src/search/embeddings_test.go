@@ -0,0 +1,101 @@
+package search
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestNewEmbeddingProvider(t *testing.T) {
+	tests := []struct {
+		kind     string
+		endpoint string
+		wantName string
+		wantErr  string
+	}{
+		{kind: ""},
+		{kind: EmbeddingProviderHashing, wantName: "hashing-384"},
+		{kind: EmbeddingProviderSageMaker, wantErr: "needs an endpoint name"},
+		{kind: "openai", wantErr: "unknown embedding provider"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			provider, err := NewEmbeddingProvider(tt.kind, tt.endpoint)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("NewEmbeddingProvider(%q) error = %v, want one containing %q", tt.kind, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewEmbeddingProvider(%q) error = %v", tt.kind, err)
+			}
+			if tt.wantName == "" {
+				if provider != nil {
+					t.Errorf("provider %s, want none", provider.Name())
+				}
+				return
+			}
+			if provider.Name() != tt.wantName {
+				t.Errorf("provider name = %q, want %q", provider.Name(), tt.wantName)
+			}
+		})
+	}
+}
+
+func cosine(a, b []float32) float64 {
+	var dot float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+	}
+	return dot
+}
+
+func TestHashingEmbedder(t *testing.T) {
+	embedder := NewHashingEmbedder(EmbeddingDimensions)
+	vectors, err := embedder.Embed([]string{
+		"Invoice total due",
+		"invoice TOTAL, due!",
+		"The invoice total is due",
+		"Patient discharge summary",
+		"",
+	})
+	if err != nil {
+		t.Fatalf("Embed() error = %v", err)
+	}
+	if len(vectors) != 5 {
+		t.Fatalf("got %d vectors, want 5", len(vectors))
+	}
+
+	tests := []struct {
+		name string
+		a, b int
+		min  float64
+		max  float64
+	}{
+		{name: "same words ignoring case and punctuation", a: 0, b: 1, min: 0.999, max: 1.001},
+		{name: "shared vocabulary", a: 0, b: 2, min: 0.3, max: 0.999},
+		{name: "no shared vocabulary", a: 0, b: 3, min: -0.3, max: 0.3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			similarity := cosine(vectors[tt.a], vectors[tt.b])
+			if similarity < tt.min || similarity > tt.max {
+				t.Errorf("similarity = %f, want between %f and %f", similarity, tt.min, tt.max)
+			}
+		})
+	}
+
+	for i, vector := range vectors[:4] {
+		if len(vector) != EmbeddingDimensions {
+			t.Errorf("vector %d has %d dimensions", i, len(vector))
+		}
+		if norm := math.Sqrt(cosine(vector, vector)); math.Abs(norm-1) > 1e-5 {
+			t.Errorf("vector %d has norm %f, want 1", i, norm)
+		}
+	}
+	if norm := cosine(vectors[4], vectors[4]); norm != 0 {
+		t.Errorf("empty text has norm %f, want 0", norm)
+	}
+}
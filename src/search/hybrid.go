@@ -0,0 +1,100 @@
+package search
+
+import (
+	"fmt"
+	"sort"
+
+	"golang.org/x/exp/slices"
+)
+
+// The keyword and vector searches of a backend, combined by runSearch according to the query mode
+type modeSearcher interface {
+	keywordSearch(q Query) (*Results, error)
+	vectorSearch(q Query, vector []float32) (*Results, error)
+}
+
+// Run a validated query in its mode. Vector and hybrid searches embed the query text with the provider,
+// which must be the one the chunks were embedded with.
+func runSearch(backend modeSearcher, provider EmbeddingProvider, q Query) (*Results, error) {
+	if q.Mode == SearchModeKeyword || q.Mode == "" {
+		return backend.keywordSearch(q)
+	}
+	if provider == nil {
+		return nil, fmt.Errorf("%s search needs an embedding provider", q.Mode)
+	}
+
+	vectors, err := provider.Embed([]string{q.Text})
+	if err != nil {
+		return nil, fmt.Errorf("embed query: %v", err)
+	}
+	if q.Mode == SearchModeVector {
+		return backend.vectorSearch(q, vectors[0])
+	}
+
+	// Fuse the top from+size documents of both searches, then cut the requested page
+	window := q
+	window.From = 0
+	window.Size = q.From + q.Size
+	keyword, err := backend.keywordSearch(window)
+	if err != nil {
+		return nil, err
+	}
+	vector, err := backend.vectorSearch(window, vectors[0])
+	if err != nil {
+		return nil, err
+	}
+	return FuseResults(keyword, vector, q.From, q.Size), nil
+}
+
+// Merge keyword and vector results with reciprocal rank fusion: a document scores the sum of 1/(60+rank)
+// over the result lists it appears in, so agreement between both searches ranks first.
+// Facets and highlights come from the keyword results, citations from the vector results.
+func FuseResults(keyword, vector *Results, from, size int) *Results {
+	fused := map[string]*Hit{}
+	order := []string{}
+	for _, results := range []*Results{keyword, vector} {
+		for rank, hit := range results.Hits {
+			score := 1 / float64(rrfRankConstant+rank+1)
+			existing, ok := fused[hit.DocumentId]
+			if !ok {
+				first := hit
+				first.Score = score
+				fused[hit.DocumentId] = &first
+				order = append(order, hit.DocumentId)
+				continue
+			}
+
+			existing.Score += score
+			for _, page := range hit.Pages {
+				if !slices.Contains(existing.Pages, page) {
+					existing.Pages = append(existing.Pages, page)
+				}
+			}
+			if existing.Highlights == nil {
+				existing.Highlights = hit.Highlights
+			}
+			existing.Citations = append(existing.Citations, hit.Citations...)
+		}
+	}
+
+	hits := make([]Hit, 0, len(order))
+	for _, documentId := range order {
+		hit := fused[documentId]
+		slices.Sort(hit.Pages)
+		hits = append(hits, *hit)
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+
+	results := &Results{Total: keyword.Total, Facets: keyword.Facets, Hits: []Hit{}}
+	if vector.Total > results.Total {
+		results.Total = vector.Total
+	}
+	if from < len(hits) {
+		end := from + size
+		if end > len(hits) {
+			end = len(hits)
+		}
+		results.Hits = hits[from:end]
+	}
+	return results
+}
@@ -0,0 +1,201 @@
+package search
+
+import (
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+func hits(documentIds ...string) *Results {
+	results := &Results{Total: len(documentIds), Hits: []Hit{}}
+	for _, documentId := range documentIds {
+		results.Hits = append(results.Hits, Hit{DocumentId: documentId})
+	}
+	return results
+}
+
+func documentIds(results *Results) []string {
+	ids := []string{}
+	for _, hit := range results.Hits {
+		ids = append(ids, hit.DocumentId)
+	}
+	return ids
+}
+
+func TestFuseResults(t *testing.T) {
+	tests := []struct {
+		name      string
+		keyword   *Results
+		vector    *Results
+		from      int
+		size      int
+		want      []string
+		wantTotal int
+	}{
+		{
+			name:      "documents in both lists rank first",
+			keyword:   hits("a", "b", "c"),
+			vector:    hits("c", "d", "b"),
+			size:      10,
+			want:      []string{"c", "b", "a", "d"},
+			wantTotal: 3,
+		},
+		{
+			name:      "ties keep the keyword order",
+			keyword:   hits("a", "b"),
+			vector:    hits("c", "d"),
+			size:      10,
+			want:      []string{"a", "c", "b", "d"},
+			wantTotal: 2,
+		},
+		{
+			name:      "page of the fused ranking",
+			keyword:   hits("a", "b", "c"),
+			vector:    hits("c", "d", "b", "e"),
+			from:      1,
+			size:      2,
+			want:      []string{"b", "a"},
+			wantTotal: 4,
+		},
+		{
+			name:      "page past the end",
+			keyword:   hits("a"),
+			vector:    hits("a"),
+			from:      5,
+			size:      2,
+			want:      []string{},
+			wantTotal: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := FuseResults(tt.keyword, tt.vector, tt.from, tt.size)
+			if got := documentIds(results); !slices.Equal(got, tt.want) {
+				t.Errorf("FuseResults() = %v, want %v", got, tt.want)
+			}
+			if results.Total != tt.wantTotal {
+				t.Errorf("total = %d, want %d", results.Total, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestFuseResultsMergesHits(t *testing.T) {
+	keyword := &Results{Total: 1, Hits: []Hit{{DocumentId: "a", Pages: []int{3, 1}, Highlights: map[string][]string{"text": {"<em>a</em>"}}}}}
+	vector := &Results{Total: 1, Hits: []Hit{{DocumentId: "a", Pages: []int{2, 3}, Citations: []Citation{{Page: 2, Chunk: 1}}}}}
+
+	results := FuseResults(keyword, vector, 0, 10)
+	if len(results.Hits) != 1 {
+		t.Fatalf("got %d hits, want 1", len(results.Hits))
+	}
+	hit := results.Hits[0]
+	if !slices.Equal(hit.Pages, []int{1, 2, 3}) {
+		t.Errorf("pages = %v, want [1 2 3]", hit.Pages)
+	}
+	if len(hit.Highlights["text"]) != 1 || len(hit.Citations) != 1 {
+		t.Errorf("highlights %v and citations %v not merged", hit.Highlights, hit.Citations)
+	}
+	if want := 2.0 / (rrfRankConstant + 1); hit.Score != want {
+		t.Errorf("score = %f, want %f", hit.Score, want)
+	}
+}
+
+// A search backend ranking the documents whose chunk is similar to the query vector, and documents by a fixed
+// keyword order
+type fakeBackend struct {
+	keywordOrder []string
+	chunks       map[string][]float32
+	vectorCalled bool
+}
+
+func (b *fakeBackend) keywordSearch(q Query) (*Results, error) {
+	return hits(b.keywordOrder...), nil
+}
+
+func (b *fakeBackend) vectorSearch(q Query, vector []float32) (*Results, error) {
+	b.vectorCalled = true
+	results := &Results{Hits: []Hit{}}
+	for documentId, embedding := range b.chunks {
+		if similarity := cosine(vector, embedding); similarity > 0.2 {
+			results.Hits = append(results.Hits, Hit{DocumentId: documentId, Score: similarity})
+		}
+	}
+	results.Total = len(results.Hits)
+	sortHits(results.Hits)
+	return results, nil
+}
+
+func TestRunSearch(t *testing.T) {
+	embedder := NewHashingEmbedder(EmbeddingDimensions)
+	texts := map[string]string{
+		"invoice":   "invoice total amount due",
+		"discharge": "patient discharge summary",
+		"contract":  "contract termination clause",
+	}
+	chunks := map[string][]float32{}
+	for documentId, text := range texts {
+		vectors, err := embedder.Embed([]string{text})
+		if err != nil {
+			t.Fatalf("Embed() error = %v", err)
+		}
+		chunks[documentId] = vectors[0]
+	}
+
+	tests := []struct {
+		name       string
+		query      Query
+		provider   EmbeddingProvider
+		want       []string
+		wantVector bool
+		wantErr    bool
+	}{
+		{
+			name:     "keyword",
+			query:    Query{Text: "invoice", Mode: SearchModeKeyword, Size: 10},
+			provider: embedder,
+			want:     []string{"contract", "discharge"},
+		},
+		{
+			name:       "vector matches the similar chunk",
+			query:      Query{Text: "invoice amount due", Mode: SearchModeVector, Size: 10},
+			provider:   embedder,
+			want:       []string{"invoice"},
+			wantVector: true,
+		},
+		{
+			name:       "hybrid ranks agreement first",
+			query:      Query{Text: "patient discharge", Mode: SearchModeHybrid, Size: 10},
+			provider:   embedder,
+			want:       []string{"discharge", "contract"},
+			wantVector: true,
+		},
+		{
+			name:    "vector without a provider",
+			query:   Query{Text: "invoice", Mode: SearchModeVector, Size: 10},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &fakeBackend{keywordOrder: []string{"contract", "discharge"}, chunks: chunks}
+			results, err := runSearch(backend, tt.provider, tt.query)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("runSearch() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("runSearch() error = %v", err)
+			}
+			if got := documentIds(results); !slices.Equal(got, tt.want) {
+				t.Errorf("runSearch() = %v, want %v", got, tt.want)
+			}
+			if backend.vectorCalled != tt.wantVector {
+				t.Errorf("vector search called = %v, want %v", backend.vectorCalled, tt.wantVector)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package search
+
+import "github.com/dreamspider42/document-processing-pipeline/src/awshelper"
+
+// Bump when the settings or mappings below change, then run the index migration
+const IndexTemplateVersion = 3
+
+// Index template for the document and page records, with explicit mappings so nothing relies on dynamic mapping
+func IndexTemplate(name string) awshelper.IndexTemplate {
+	textWithKeyword := map[string]interface{}{
+		"type":     "text",
+		"analyzer": "document_text",
+		"fields": map[string]interface{}{
+			"keyword": map[string]interface{}{"type": "keyword", "ignore_above": 256, "normalizer": "lowercase_keyword"},
+		},
+	}
+
+	return awshelper.IndexTemplate{
+		Name:    name,
+		Version: IndexTemplateVersion,
+		Settings: map[string]interface{}{
+			// Enables the k-NN plugin for the chunk embeddings
+			"index": map[string]interface{}{"knn": true},
+			"analysis": map[string]interface{}{
+				"analyzer": map[string]interface{}{
+					"document_text": map[string]interface{}{
+						"type":      "custom",
+						"tokenizer": "standard",
+						"filter":    []string{"lowercase", "asciifolding", "english_stop", "english_stemmer"},
+					},
+				},
+				"filter": map[string]interface{}{
+					"english_stop":    map[string]interface{}{"type": "stop", "stopwords": "_english_"},
+					"english_stemmer": map[string]interface{}{"type": "stemmer", "language": "light_english"},
+				},
+				"normalizer": map[string]interface{}{
+					"lowercase_keyword": map[string]interface{}{"type": "custom", "filter": []string{"lowercase", "asciifolding"}},
+				},
+			},
+		},
+		Mappings: map[string]interface{}{
+			// Unknown fields are kept in _source but never mapped
+			"dynamic": false,
+			"dynamic_templates": []map[string]interface{}{
+				{
+					// Entities is keyed by Comprehend entity type, e.g. Entities.PERSON
+					"entities": map[string]interface{}{
+						"path_match":         "Entities.*",
+						"match_mapping_type": "string",
+						"mapping":            textWithKeyword,
+					},
+				},
+			},
+			"properties": map[string]interface{}{
+				"recordType":     map[string]interface{}{"type": "keyword"},
+				"documentClass":  map[string]interface{}{"type": "keyword"},
+				"owner":          map[string]interface{}{"type": "keyword"},
+				"writer":         map[string]interface{}{"type": "keyword"},
+				"registeredAt":   map[string]interface{}{"type": "date"},
+				"indexedAt":      map[string]interface{}{"type": "date"},
+				"documentId":     map[string]interface{}{"type": "keyword"},
+				"documentName":   textWithKeyword,
+				"bucketName":     map[string]interface{}{"type": "keyword"},
+				"objectName":     map[string]interface{}{"type": "keyword"},
+				"pageCount":      map[string]interface{}{"type": "integer"},
+				"page":           map[string]interface{}{"type": "integer"},
+				"KeyPhrases":     textWithKeyword,
+				"Entities":       map[string]interface{}{"type": "object", "dynamic": true},
+				"text":           map[string]interface{}{"type": "text", "analyzer": "document_text"},
+				"table":          map[string]interface{}{"type": "text", "analyzer": "document_text"},
+				"forms":          map[string]interface{}{"type": "text", "analyzer": "document_text"},
+				"chunk":          map[string]interface{}{"type": "integer"},
+				"boundingBox":    map[string]interface{}{"type": "object", "enabled": false},
+				"embeddingModel": map[string]interface{}{"type": "keyword"},
+				"embedding": map[string]interface{}{
+					"type":      "knn_vector",
+					"dimension": EmbeddingDimensions,
+					"method": map[string]interface{}{
+						"name":       "hnsw",
+						"space_type": "cosinesimil",
+						"engine":     "nmslib",
+					},
+				},
+			},
+		},
+	}
+}
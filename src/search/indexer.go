@@ -0,0 +1,113 @@
+package search
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/dreamspider42/document-processing-pipeline/src/awshelper"
+)
+
+const (
+	BackendOpenSearch = "opensearch"
+	BackendLocal      = "local"
+)
+
+// SearchIndexer is a search backend the pipeline indexes into and the search API queries
+type SearchIndexer interface {
+	// Index the parent record and page records of a document
+	IndexDocument(document DocumentRecord, pages []PageRecord) (*awshelper.BulkResult, error)
+	// Replace the embedded chunks of a document with the given ones, stamped with its record metadata
+	IndexChunks(documentId string, recordMetadata RecordMetadata, chunks []ChunkRecord) (*awshelper.BulkResult, error)
+	// Delete every record of a document
+	DeleteDocument(documentId string) error
+	// Run a validated query. Vector and hybrid queries are embedded with the provider.
+	Search(q Query, provider EmbeddingProvider) (*Results, error)
+}
+
+// Searcher is the read side of a search backend, as the search API uses it
+type Searcher interface {
+	// Run a validated query. Vector and hybrid queries are embedded with the provider.
+	Search(q Query, provider EmbeddingProvider) (*Results, error)
+}
+
+// BackendConfig selects and configures the search backend
+type BackendConfig struct {
+	Backend  string
+	LocalDir string
+	ES       awshelper.ESConfig
+
+	// Where the OpenSearch backend parks records that fail to index, nil to not park them
+	DeadLetter *awshelper.ESDeadLetter
+}
+
+// Read the backend settings from the environment: SEARCH_BACKEND (opensearch by default, or local),
+// SEARCH_LOCAL_DIR for the local backend, and the OpenSearch connection settings of awshelper.ESConfigFromEnv.
+func BackendConfigFromEnv() (BackendConfig, error) {
+	esConfig, err := awshelper.ESConfigFromEnv()
+	if err != nil {
+		return BackendConfig{}, err
+	}
+
+	cfg := BackendConfig{
+		Backend:  os.Getenv("SEARCH_BACKEND"),
+		LocalDir: os.Getenv("SEARCH_LOCAL_DIR"),
+		ES:       esConfig,
+	}
+	if cfg.Backend == "" {
+		cfg.Backend = BackendOpenSearch
+	}
+	if cfg.LocalDir == "" {
+		cfg.LocalDir = filepath.Join(os.TempDir(), "document-search-index")
+	}
+	return cfg, nil
+}
+
+// Whether the backend has what it needs to be opened. An OpenSearch backend without an endpoint is not configured.
+func (cfg BackendConfig) Configured() bool {
+	return cfg.Backend != BackendOpenSearch || cfg.ES.Endpoint != ""
+}
+
+// Name of the index records are written to, as recorded in the lineage of index writes
+func (cfg BackendConfig) Target() string {
+	if cfg.Backend == BackendLocal {
+		return BackendLocal + ":" + cfg.LocalDir
+	}
+	return BackendOpenSearch + ":" + cfg.ES.Index
+}
+
+// Open the configured backend. OpenSearch connections are health checked and the index template applied.
+func OpenIndexer(cfg BackendConfig) (SearchIndexer, error) {
+	switch cfg.Backend {
+	case BackendLocal:
+		return OpenLocalIndex(cfg.LocalDir)
+	case BackendOpenSearch:
+		es, err := awshelper.ConnectESHelper(cfg.ES)
+		if err != nil {
+			return nil, err
+		}
+		err = es.ManageIndex(IndexTemplate(cfg.ES.Index))
+		if err != nil {
+			return nil, err
+		}
+		es.DeadLetter = cfg.DeadLetter
+		return NewOpenSearchIndexer(es), nil
+	}
+	return nil, fmt.Errorf("unknown search backend %q", cfg.Backend)
+}
+
+// Open the configured backend for searching only. OpenSearch connections are health checked; unlike OpenIndexer,
+// the index template and indices are left as they are, and searches read through the read alias.
+func OpenSearcher(cfg BackendConfig) (Searcher, error) {
+	switch cfg.Backend {
+	case BackendLocal:
+		return OpenLocalIndex(cfg.LocalDir)
+	case BackendOpenSearch:
+		es, err := awshelper.ConnectESHelper(cfg.ES)
+		if err != nil {
+			return nil, err
+		}
+		return NewOpenSearchIndexer(es), nil
+	}
+	return nil, fmt.Errorf("unknown search backend %q", cfg.Backend)
+}
@@ -0,0 +1,583 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/dreamspider42/document-processing-pipeline/src/awshelper"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	localDocumentSuffix = ".document.json"
+	localChunksSuffix   = ".chunks.json"
+
+	// BM25 parameters, the OpenSearch defaults
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+var localStopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "but": true, "by": true,
+	"for": true, "if": true, "in": true, "into": true, "is": true, "it": true, "no": true, "not": true, "of": true,
+	"on": true, "or": true, "such": true, "that": true, "the": true, "their": true, "then": true, "there": true,
+	"these": true, "they": true, "this": true, "to": true, "was": true, "will": true, "with": true,
+}
+
+// The records of a document as stored on disk. Pages and chunks live in separate files
+// so the comprehend and embeddings stages never overwrite each other's records.
+type localDocument struct {
+	Document *DocumentRecord `json:"document,omitempty"`
+	Pages    []PageRecord    `json:"pages,omitempty"`
+	Chunks   []ChunkRecord   `json:"chunks,omitempty"`
+}
+
+// LocalIndex is an embedded full-text index kept as JSON files in a local directory, for running the pipeline
+// without OpenSearch. Pages are ranked with BM25 over an in-memory inverted index and chunks by brute force
+// cosine similarity. Files written by other processes are picked up before every search.
+type LocalIndex struct {
+	dir string
+
+	mu        sync.Mutex
+	modTimes  map[string]time.Time
+	documents map[string]*localDocument
+	postings  map[string]map[string]int
+	lengths   map[string]int
+	pages     map[string]*PageRecord
+}
+
+// Open the local index in a directory, creating the directory if needed
+func OpenLocalIndex(dir string) (*LocalIndex, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("create local search index %s: %v", dir, err)
+	}
+
+	l := &LocalIndex{
+		dir:       dir,
+		modTimes:  map[string]time.Time{},
+		documents: map[string]*localDocument{},
+		postings:  map[string]map[string]int{},
+		lengths:   map[string]int{},
+		pages:     map[string]*PageRecord{},
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l, l.refresh()
+}
+
+func (l *LocalIndex) IndexDocument(document DocumentRecord, pages []PageRecord) (*awshelper.BulkResult, error) {
+	document.RecordType = RecordTypeDocument
+	stored := make([]PageRecord, len(pages))
+	for i, page := range pages {
+		page.RecordType = RecordTypePage
+		page.RecordMetadata = document.RecordMetadata
+		stored[i] = page
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.write(document.DocumentId, localDocumentSuffix, localDocument{Document: &document, Pages: stored})
+	if err != nil {
+		return nil, err
+	}
+	return &awshelper.BulkResult{Indexed: len(stored) + 1}, l.reload(document.DocumentId)
+}
+
+func (l *LocalIndex) IndexChunks(documentId string, recordMetadata RecordMetadata, chunks []ChunkRecord) (*awshelper.BulkResult, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(chunks) == 0 {
+		err := os.Remove(l.path(documentId, localChunksSuffix))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		return &awshelper.BulkResult{}, l.reload(documentId)
+	}
+
+	stored := make([]ChunkRecord, len(chunks))
+	for i, chunk := range chunks {
+		chunk.RecordType = RecordTypeChunk
+		chunk.RecordMetadata = recordMetadata
+		stored[i] = chunk
+	}
+	err := l.write(documentId, localChunksSuffix, localDocument{Chunks: stored})
+	if err != nil {
+		return nil, err
+	}
+	return &awshelper.BulkResult{Indexed: len(stored)}, l.reload(documentId)
+}
+
+func (l *LocalIndex) DeleteDocument(documentId string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, suffix := range []string{localDocumentSuffix, localChunksSuffix} {
+		err := os.Remove(l.path(documentId, suffix))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return l.reload(documentId)
+}
+
+func (l *LocalIndex) Search(q Query, provider EmbeddingProvider) (*Results, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.refresh()
+	if err != nil {
+		return nil, err
+	}
+	return runSearch(l, provider, q)
+}
+
+func (l *LocalIndex) path(documentId, suffix string) string {
+	return filepath.Join(l.dir, url.PathEscape(documentId)+suffix)
+}
+
+// Write a file atomically so concurrent readers never see it half written
+func (l *LocalIndex) write(documentId, suffix string, content localDocument) error {
+	payload, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	path := l.path(documentId, suffix)
+	tmp, err := os.CreateTemp(l.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(payload)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Reload the documents whose files changed since the last refresh. Callers hold l.mu.
+func (l *LocalIndex) refresh() error {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	changed := map[string]bool{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, localDocumentSuffix) && !strings.HasSuffix(name, localChunksSuffix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		seen[name] = true
+		if modTime, ok := l.modTimes[name]; !ok || !modTime.Equal(info.ModTime()) {
+			l.modTimes[name] = info.ModTime()
+			changed[l.documentIdOf(name)] = true
+		}
+	}
+	for name := range l.modTimes {
+		if !seen[name] {
+			delete(l.modTimes, name)
+			changed[l.documentIdOf(name)] = true
+		}
+	}
+
+	for documentId := range changed {
+		err = l.load(documentId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reload a document this process just wrote or deleted, whatever the file timestamps say. Callers hold l.mu.
+func (l *LocalIndex) reload(documentId string) error {
+	for _, suffix := range []string{localDocumentSuffix, localChunksSuffix} {
+		path := l.path(documentId, suffix)
+		if info, err := os.Stat(path); err == nil {
+			l.modTimes[filepath.Base(path)] = info.ModTime()
+		} else {
+			delete(l.modTimes, filepath.Base(path))
+		}
+	}
+	return l.load(documentId)
+}
+
+func (l *LocalIndex) documentIdOf(name string) string {
+	escaped := strings.TrimSuffix(strings.TrimSuffix(name, localDocumentSuffix), localChunksSuffix)
+	documentId, err := url.PathUnescape(escaped)
+	if err != nil {
+		return escaped
+	}
+	return documentId
+}
+
+// Replace the in-memory records of a document with what is on disk
+func (l *LocalIndex) load(documentId string) error {
+	l.unindex(documentId)
+
+	document := &localDocument{}
+	found := false
+	for _, suffix := range []string{localDocumentSuffix, localChunksSuffix} {
+		payload, err := os.ReadFile(l.path(documentId, suffix))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		part := localDocument{}
+		err = json.Unmarshal(payload, &part)
+		if err != nil {
+			return fmt.Errorf("corrupt local search index file %s: %v", l.path(documentId, suffix), err)
+		}
+		found = true
+		if suffix == localDocumentSuffix {
+			document.Document = part.Document
+			document.Pages = part.Pages
+		} else {
+			document.Chunks = part.Chunks
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	l.documents[documentId] = document
+	for i := range document.Pages {
+		page := &document.Pages[i]
+		recordId := PageRecordId(page.DocumentId, page.Page)
+		terms := pageTerms(page)
+		l.pages[recordId] = page
+		l.lengths[recordId] = len(terms)
+		for _, term := range terms {
+			if l.postings[term] == nil {
+				l.postings[term] = map[string]int{}
+			}
+			l.postings[term][recordId]++
+		}
+	}
+	return nil
+}
+
+// Drop a document's pages from the inverted index
+func (l *LocalIndex) unindex(documentId string) {
+	document, ok := l.documents[documentId]
+	if !ok {
+		return
+	}
+	for _, page := range document.Pages {
+		recordId := PageRecordId(page.DocumentId, page.Page)
+		for _, term := range pageTerms(&page) {
+			delete(l.postings[term], recordId)
+			if len(l.postings[term]) == 0 {
+				delete(l.postings, term)
+			}
+		}
+		delete(l.pages, recordId)
+		delete(l.lengths, recordId)
+	}
+	delete(l.documents, documentId)
+}
+
+// Lowercased, folded and stop word filtered tokens, close to the document_text analyzer without stemming
+func tokenize(text string) []string {
+	tokens := []string{}
+	for _, token := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		if !localStopWords[token] {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// Searchable terms of a page across the fields the keyword query matches, key phrases boosted by repetition
+func pageTerms(page *PageRecord) []string {
+	terms := tokenize(page.Text)
+	for _, keyPhrase := range page.KeyPhrases {
+		phrase := tokenize(keyPhrase)
+		terms = append(terms, phrase...)
+		terms = append(terms, phrase...)
+	}
+	for _, value := range page.Entities {
+		terms = append(terms, tokenize(value)...)
+	}
+	for _, rows := range [][][]string{page.Table, page.Forms} {
+		for _, row := range rows {
+			terms = append(terms, tokenize(strings.Join(row, " "))...)
+		}
+	}
+	return terms
+}
+
+// Whether a record passes the permission and metadata filters of a query
+func matchesFilters(q Query, recordMetadata RecordMetadata, entities map[string][]string) bool {
+	if !q.Principal.CanAccess(recordMetadata.Owner, recordMetadata.Writer) {
+		return false
+	}
+	for _, entity := range q.Entities {
+		values := entities[entity.Type]
+		if len(values) == 0 {
+			return false
+		}
+		if entity.Value != "" && !slices.ContainsFunc(values, func(v string) bool { return strings.EqualFold(v, entity.Value) }) {
+			return false
+		}
+	}
+	if len(q.DocumentClasses) > 0 && !slices.Contains(q.DocumentClasses, recordMetadata.DocumentClass) {
+		return false
+	}
+	if q.RegisteredAfter != nil || q.RegisteredBefore != nil {
+		registeredAt, err := time.Parse(time.RFC3339, recordMetadata.RegisteredAt)
+		if err != nil {
+			return false
+		}
+		if q.RegisteredAfter != nil && registeredAt.Before(*q.RegisteredAfter) {
+			return false
+		}
+		if q.RegisteredBefore != nil && registeredAt.After(*q.RegisteredBefore) {
+			return false
+		}
+	}
+	return true
+}
+
+// Entities of a page or chunk, as lists like on the document record. Chunks use their document's entities.
+func (l *LocalIndex) entitiesOf(documentId string, page *PageRecord) map[string][]string {
+	if page != nil {
+		entities := map[string][]string{}
+		for entityType, value := range page.Entities {
+			entities[entityType] = []string{value}
+		}
+		return entities
+	}
+	if document := l.documents[documentId]; document != nil && document.Document != nil {
+		return document.Document.Entities
+	}
+	return nil
+}
+
+type scoredPage struct {
+	page  *PageRecord
+	score float64
+}
+
+func (l *LocalIndex) keywordSearch(q Query) (*Results, error) {
+	terms := tokenize(q.Text)
+
+	// Score the candidate pages with BM25, or match every page without query text
+	scores := map[string]float64{}
+	if q.Text == "" {
+		for recordId := range l.pages {
+			scores[recordId] = 1
+		}
+	} else {
+		averageLength := 0.0
+		for _, length := range l.lengths {
+			averageLength += float64(length)
+		}
+		if len(l.lengths) > 0 {
+			averageLength /= float64(len(l.lengths))
+		}
+		count := float64(len(l.pages))
+		for _, term := range terms {
+			postings := l.postings[term]
+			idf := math.Log(1 + (count-float64(len(postings))+0.5)/(float64(len(postings))+0.5))
+			for recordId, frequency := range postings {
+				tf := float64(frequency)
+				norm := 1 - bm25B + bm25B*float64(l.lengths[recordId])/averageLength
+				scores[recordId] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+			}
+		}
+	}
+
+	// Collapse the matching pages into their documents
+	byDocument := map[string][]scoredPage{}
+	for recordId, score := range scores {
+		page := l.pages[recordId]
+		if !matchesFilters(q, page.RecordMetadata, l.entitiesOf(page.DocumentId, page)) {
+			continue
+		}
+		byDocument[page.DocumentId] = append(byDocument[page.DocumentId], scoredPage{page: page, score: score})
+	}
+
+	hits := []Hit{}
+	for documentId, pages := range byDocument {
+		sort.Slice(pages, func(i, j int) bool { return pages[i].score > pages[j].score })
+		hit := Hit{DocumentId: documentId, Score: pages[0].score, Pages: []int{}}
+		for i, page := range pages {
+			if i == 10 {
+				break
+			}
+			hit.Pages = append(hit.Pages, page.page.Page)
+		}
+		slices.Sort(hit.Pages)
+		if q.Highlight && q.Text != "" {
+			if fragments := highlight(pages[0].page.Text, terms); len(fragments) > 0 {
+				hit.Highlights = map[string][]string{"text": fragments}
+			}
+		}
+		hits = append(hits, hit)
+	}
+
+	results := &Results{Total: len(hits), Hits: pageOf(sortHits(hits), q.From, q.Size)}
+	for _, facet := range q.Facets {
+		if results.Facets == nil {
+			results.Facets = map[string][]FacetBucket{}
+		}
+		results.Facets[facet] = facetBuckets(facet, byDocument)
+	}
+	return results, nil
+}
+
+func (l *LocalIndex) vectorSearch(q Query, vector []float32) (*Results, error) {
+	if len(vector) != EmbeddingDimensions {
+		return nil, fmt.Errorf("query vector has %d dimensions, the index expects %d", len(vector), EmbeddingDimensions)
+	}
+
+	hits := []Hit{}
+	for documentId, document := range l.documents {
+		citations := []Citation{}
+		for _, chunk := range document.Chunks {
+			if len(chunk.Embedding) != len(vector) || !matchesFilters(q, chunk.RecordMetadata, l.entitiesOf(documentId, nil)) {
+				continue
+			}
+			var cosine float64
+			for i := range vector {
+				cosine += float64(vector[i]) * float64(chunk.Embedding[i])
+			}
+			// Same scale as the cosinesimil space of the k-NN plugin
+			citations = append(citations, Citation{
+				Page:        chunk.Page,
+				Chunk:       chunk.Chunk,
+				Text:        chunk.Text,
+				BoundingBox: chunk.BoundingBox,
+				Score:       1 / (2 - cosine),
+			})
+		}
+		if len(citations) == 0 {
+			continue
+		}
+
+		sort.Slice(citations, func(i, j int) bool { return citations[i].Score > citations[j].Score })
+		if len(citations) > 3 {
+			citations = citations[:3]
+		}
+		hit := Hit{DocumentId: documentId, Score: citations[0].Score, Pages: []int{}, Citations: citations}
+		for _, citation := range citations {
+			if !slices.Contains(hit.Pages, citation.Page) {
+				hit.Pages = append(hit.Pages, citation.Page)
+			}
+		}
+		slices.Sort(hit.Pages)
+		hits = append(hits, hit)
+	}
+
+	return &Results{Total: len(hits), Hits: pageOf(sortHits(hits), q.From, q.Size)}, nil
+}
+
+// Order hits by score, breaking ties on the document id so pages are stable
+func sortHits(hits []Hit) []Hit {
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].DocumentId < hits[j].DocumentId
+	})
+	return hits
+}
+
+func pageOf(hits []Hit, from, size int) []Hit {
+	if from >= len(hits) {
+		return []Hit{}
+	}
+	end := from + size
+	if end > len(hits) {
+		end = len(hits)
+	}
+	return hits[from:end]
+}
+
+// Count the matching documents of each facet value, keeping the top 10 like the terms aggregation
+func facetBuckets(facet string, byDocument map[string][]scoredPage) []FacetBucket {
+	counts := map[string]int{}
+	for _, pages := range byDocument {
+		values := map[string]bool{}
+		for _, scored := range pages {
+			page := scored.page
+			switch {
+			case facet == "documentClass":
+				values[page.DocumentClass] = page.DocumentClass != ""
+			case facet == "owner":
+				values[page.Owner] = page.Owner != ""
+			case facet == "KeyPhrases":
+				for _, keyPhrase := range page.KeyPhrases {
+					values[strings.ToLower(keyPhrase)] = true
+				}
+			case strings.HasPrefix(facet, "entity:"):
+				if value, ok := page.Entities[strings.TrimPrefix(facet, "entity:")]; ok {
+					values[strings.ToLower(value)] = true
+				}
+			}
+		}
+		for value, ok := range values {
+			if ok {
+				counts[value]++
+			}
+		}
+	}
+
+	buckets := []FacetBucket{}
+	for value, documents := range counts {
+		buckets = append(buckets, FacetBucket{Value: value, Documents: documents})
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		if buckets[i].Documents != buckets[j].Documents {
+			return buckets[i].Documents > buckets[j].Documents
+		}
+		return buckets[i].Value < buckets[j].Value
+	})
+	if len(buckets) > 10 {
+		buckets = buckets[:10]
+	}
+	return buckets
+}
+
+// Up to three lines of text containing query terms, with the terms wrapped in <em> like OpenSearch highlights
+func highlight(text string, terms []string) []string {
+	fragments := []string{}
+	for _, line := range strings.Split(text, "\n") {
+		matched := false
+		words := strings.Fields(line)
+		for i, word := range words {
+			tokens := tokenize(word)
+			if len(tokens) > 0 && slices.Contains(terms, tokens[0]) {
+				words[i] = "<em>" + word + "</em>"
+				matched = true
+			}
+		}
+		if matched {
+			fragments = append(fragments, strings.Join(words, " "))
+			if len(fragments) == 3 {
+				break
+			}
+		}
+	}
+	return fragments
+}
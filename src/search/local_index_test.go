@@ -0,0 +1,67 @@
+package search
+
+import (
+	"testing"
+)
+
+func TestLocalIndexChunks(t *testing.T) {
+	embedder := NewHashingEmbedder(EmbeddingDimensions)
+	chunk := func(documentId string, text string) ChunkRecord {
+		c := ChunkRecord{DocumentId: documentId, Page: 1, Chunk: 1, Text: text}
+		vectors, _ := embedder.Embed([]string{text})
+		c.Embedding = vectors[0]
+		return c
+	}
+	query := Query{Text: "invoice total", Mode: SearchModeVector, Size: 10, Principal: Principal{Admin: true}}
+
+	tests := []struct {
+		name string
+		// Applied to a document first indexed with one chunk
+		update func(l *LocalIndex) error
+		want   int
+	}{
+		{
+			name:   "indexed",
+			update: func(l *LocalIndex) error { return nil },
+			want:   1,
+		},
+		{
+			name: "re-embedded without chunks",
+			update: func(l *LocalIndex) error {
+				_, err := l.IndexChunks("doc", RecordMetadata{}, []ChunkRecord{})
+				return err
+			},
+			want: 0,
+		},
+		{
+			name:   "deleted",
+			update: func(l *LocalIndex) error { return l.DeleteDocument("doc") },
+			want:   0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := OpenLocalIndex(t.TempDir())
+			if err != nil {
+				t.Fatalf("OpenLocalIndex() error = %v", err)
+			}
+			_, err = l.IndexChunks("doc", RecordMetadata{}, []ChunkRecord{chunk("doc", "invoice total due")})
+			if err != nil {
+				t.Fatalf("IndexChunks() error = %v", err)
+			}
+			err = tt.update(l)
+			if err != nil {
+				t.Fatalf("update error = %v", err)
+			}
+
+			results, err := l.Search(query, embedder)
+			if err != nil {
+				t.Fatalf("Search() error = %v", err)
+			}
+			if len(results.Hits) != tt.want {
+				t.Errorf("got %d hits, want %d", len(results.Hits), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package search
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/dreamspider42/document-processing-pipeline/src/awshelper"
+)
+
+// SearchIndexer backed by an OpenSearch cluster
+type OpenSearchIndexer struct {
+	ES *awshelper.ESHelper
+}
+
+// Create a new instance of the OpenSearch indexer
+func NewOpenSearchIndexer(es *awshelper.ESHelper) *OpenSearchIndexer {
+	return &OpenSearchIndexer{ES: es}
+}
+
+func (o *OpenSearchIndexer) IndexDocument(document DocumentRecord, pages []PageRecord) (*awshelper.BulkResult, error) {
+	items, err := BulkItems(document, pages)
+	if err != nil {
+		return nil, err
+	}
+	return o.ES.PostBulk(items)
+}
+
+func (o *OpenSearchIndexer) IndexChunks(documentId string, recordMetadata RecordMetadata, chunks []ChunkRecord) (*awshelper.BulkResult, error) {
+	items, err := ChunkBulkItems(recordMetadata, chunks)
+	if err != nil {
+		return nil, err
+	}
+	result := &awshelper.BulkResult{}
+	if len(items) > 0 {
+		result, err = o.ES.PostBulk(items)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	// Drop the chunks of an earlier embedding that the new one no longer has
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.DocumentID
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{"bool": map[string]interface{}{
+			"filter": []interface{}{
+				map[string]interface{}{"term": map[string]interface{}{"documentId": documentId}},
+				map[string]interface{}{"term": map[string]interface{}{"recordType": RecordTypeChunk}},
+			},
+			"must_not": []interface{}{
+				map[string]interface{}{"ids": map[string]interface{}{"values": ids}},
+			},
+		}},
+	})
+	if err != nil {
+		return result, err
+	}
+	deleted, err := o.ES.DeleteByQuery(body)
+	if err != nil {
+		return result, err
+	}
+	if deleted > 0 {
+		log.Printf("Deleted %d stale chunks of document %s \n", deleted, documentId)
+	}
+	return result, nil
+}
+
+func (o *OpenSearchIndexer) DeleteDocument(documentId string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{"term": map[string]interface{}{"documentId": documentId}},
+	})
+	if err != nil {
+		return err
+	}
+	deleted, err := o.ES.DeleteByQuery(body)
+	if err != nil {
+		return err
+	}
+	log.Printf("Deleted %d search records of document %s \n", deleted, documentId)
+	return nil
+}
+
+func (o *OpenSearchIndexer) Search(q Query, provider EmbeddingProvider) (*Results, error) {
+	return runSearch(o, provider, q)
+}
+
+func (o *OpenSearchIndexer) keywordSearch(q Query) (*Results, error) {
+	body, err := OpenSearchQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	response, err := o.ES.Search(body)
+	if err != nil {
+		return nil, err
+	}
+	return ParseOpenSearchResults(response)
+}
+
+func (o *OpenSearchIndexer) vectorSearch(q Query, vector []float32) (*Results, error) {
+	var documentIds []string
+	if len(q.Entities) > 0 {
+		body, err := EntityDocumentsQuery(q)
+		if err != nil {
+			return nil, err
+		}
+		response, err := o.ES.Search(body)
+		if err != nil {
+			return nil, err
+		}
+		documents, err := ParseOpenSearchResults(response)
+		if err != nil {
+			return nil, err
+		}
+		if len(documents.Hits) == 0 {
+			return &Results{Hits: []Hit{}}, nil
+		}
+		if len(documents.Hits) == maxEntityDocuments {
+			log.Printf("Vector search limited to the first %d documents matching its entity filters \n", maxEntityDocuments)
+		}
+		documentIds = make([]string, len(documents.Hits))
+		for i, document := range documents.Hits {
+			documentIds[i] = document.DocumentId
+		}
+	}
+
+	body, err := VectorQuery(q, vector, documentIds)
+	if err != nil {
+		return nil, err
+	}
+	response, err := o.ES.Search(body)
+	if err != nil {
+		return nil, err
+	}
+	return ParseOpenSearchResults(response)
+}
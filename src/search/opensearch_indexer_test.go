@@ -0,0 +1,298 @@
+package search
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dreamspider42/document-processing-pipeline/src/awshelper"
+	"golang.org/x/exp/slices"
+)
+
+// OpenSearch cluster keeping its records in memory, answering bulk requests and the searches this package builds
+type fakeCluster struct {
+	mu      sync.Mutex
+	records map[string]map[string]interface{}
+}
+
+func (c *fakeCluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var response interface{}
+	switch {
+	case r.URL.Path == "/":
+		// The client checks the cluster before its first request
+		response = map[string]interface{}{"version": map[string]interface{}{"number": "2.11.0", "distribution": "opensearch"}}
+	case strings.HasSuffix(r.URL.Path, "/_bulk"):
+		response = c.bulk(r)
+	case strings.HasSuffix(r.URL.Path, "/_delete_by_query"):
+		response = map[string]interface{}{"deleted": 0}
+	case strings.HasSuffix(r.URL.Path, "/_search"):
+		response = c.search(r)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (c *fakeCluster) bulk(r *http.Request) interface{} {
+	items := []interface{}{}
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	for scanner.Scan() {
+		action := map[string]struct {
+			Id string `json:"_id"`
+		}{}
+		json.Unmarshal(scanner.Bytes(), &action)
+		scanner.Scan()
+		record := map[string]interface{}{}
+		json.Unmarshal(scanner.Bytes(), &record)
+		c.records[action["index"].Id] = record
+		items = append(items, map[string]interface{}{"index": map[string]interface{}{"_id": action["index"].Id, "status": 201, "result": "created"}})
+	}
+	return map[string]interface{}{"errors": false, "items": items}
+}
+
+func (c *fakeCluster) search(r *http.Request) interface{} {
+	request := struct {
+		From  int `json:"from"`
+		Size  int `json:"size"`
+		Query struct {
+			Bool struct {
+				Must   map[string]map[string]struct{ Vector []float64 } `json:"must"`
+				Filter []map[string]interface{}                         `json:"filter"`
+			} `json:"bool"`
+		} `json:"query"`
+	}{}
+	json.NewDecoder(r.Body).Decode(&request)
+
+	type scored struct {
+		record map[string]interface{}
+		score  float64
+	}
+	byDocument := map[string][]scored{}
+	documents := []string{}
+	for _, record := range c.records {
+		if slices.IndexFunc(request.Query.Bool.Filter, func(filter map[string]interface{}) bool { return !matches(filter, record) }) != -1 {
+			continue
+		}
+		score := 1.0
+		if knn, ok := request.Query.Bool.Must["knn"]; ok {
+			embedding, _ := record["embedding"].([]interface{})
+			if len(embedding) != len(knn["embedding"].Vector) {
+				continue
+			}
+			cosine := 0.0
+			for i, value := range embedding {
+				cosine += value.(float64) * knn["embedding"].Vector[i]
+			}
+			score = 1 / (2 - cosine)
+		}
+		documentId := record["documentId"].(string)
+		if _, ok := byDocument[documentId]; !ok {
+			documents = append(documents, documentId)
+		}
+		byDocument[documentId] = append(byDocument[documentId], scored{record: record, score: score})
+	}
+
+	// Collapse into documents, the closest chunks as inner hits
+	hits := []map[string]interface{}{}
+	for _, documentId := range documents {
+		records := byDocument[documentId]
+		sort.Slice(records, func(i, j int) bool { return records[i].score > records[j].score })
+		inner := []interface{}{}
+		for i, record := range records {
+			if i == 3 {
+				break
+			}
+			inner = append(inner, map[string]interface{}{"_score": record.score, "_source": record.record})
+		}
+		hits = append(hits, map[string]interface{}{
+			"documentId": documentId,
+			"_score":     records[0].score,
+			"_source":    map[string]interface{}{"documentId": documentId},
+			"inner_hits": map[string]interface{}{"chunks": map[string]interface{}{"hits": map[string]interface{}{"hits": inner}}},
+		})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i]["_score"] != hits[j]["_score"] {
+			return hits[i]["_score"].(float64) > hits[j]["_score"].(float64)
+		}
+		return hits[i]["documentId"].(string) < hits[j]["documentId"].(string)
+	})
+	total := len(hits)
+	end := request.From + request.Size
+	if end > len(hits) {
+		end = len(hits)
+	}
+	if request.From < end {
+		hits = hits[request.From:end]
+	} else {
+		hits = nil
+	}
+	return map[string]interface{}{
+		"hits":         map[string]interface{}{"hits": hits},
+		"aggregations": map[string]interface{}{"documents": map[string]interface{}{"value": total}},
+	}
+}
+
+// Whether a record passes a filter of the query DSL subset queryFilters builds
+func matches(filter map[string]interface{}, record map[string]interface{}) bool {
+	for kind, clause := range filter {
+		arguments := clause.(map[string]interface{})
+		switch kind {
+		case "match_all":
+			return true
+		case "exists":
+			return len(fieldValues(record, arguments["field"].(string))) > 0
+		case "bool":
+			for _, should := range asList(arguments["should"]) {
+				if matches(should, record) {
+					return true
+				}
+			}
+			for _, mustNot := range asList(arguments["must_not"]) {
+				if matches(mustNot, record) {
+					return false
+				}
+			}
+			return arguments["should"] == nil
+		}
+		for field, want := range arguments {
+			values := fieldValues(record, field)
+			switch kind {
+			case "term":
+				return slices.Contains(values, fmt.Sprint(want))
+			case "terms":
+				for _, value := range want.([]interface{}) {
+					if slices.Contains(values, fmt.Sprint(value)) {
+						return true
+					}
+				}
+				return false
+			case "match":
+				return slices.ContainsFunc(values, func(v string) bool { return strings.EqualFold(v, fmt.Sprint(want)) })
+			}
+		}
+	}
+	panic(fmt.Sprintf("unsupported filter %v", filter))
+}
+
+func asList(value interface{}) []map[string]interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return []map[string]interface{}{v}
+	case []interface{}:
+		list := []map[string]interface{}{}
+		for _, item := range v {
+			list = append(list, item.(map[string]interface{}))
+		}
+		return list
+	}
+	return nil
+}
+
+// String values of a record field, keyword subfields read from their field
+func fieldValues(record map[string]interface{}, field string) []string {
+	var value interface{} = record
+	for _, name := range strings.Split(strings.TrimSuffix(field, ".keyword"), ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[name]
+	}
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := []string{}
+		for _, item := range v {
+			values = append(values, fmt.Sprint(item))
+		}
+		return values
+	}
+	return nil
+}
+
+func TestVectorSearchEntityFilters(t *testing.T) {
+	embedder := NewHashingEmbedder(EmbeddingDimensions)
+	server := httptest.NewServer(&fakeCluster{records: map[string]map[string]interface{}{}})
+	defer server.Close()
+	es, err := awshelper.NewESHelperWithConfig(awshelper.ESConfig{Endpoint: server.URL, Index: "documents", AuthMode: awshelper.ESAuthNone})
+	if err != nil {
+		t.Fatalf("NewESHelperWithConfig() error = %v", err)
+	}
+	local, err := OpenLocalIndex(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenLocalIndex() error = %v", err)
+	}
+	backends := map[string]SearchIndexer{"local": local, "opensearch": NewOpenSearchIndexer(es)}
+
+	documents := []struct {
+		documentId string
+		text       string
+		entities   map[string]string
+	}{
+		{documentId: "doc-1", text: "invoice total due", entities: map[string]string{"ORGANIZATION": "Acme"}},
+		{documentId: "doc-2", text: "invoice total paid", entities: map[string]string{"PERSON": "Jane"}},
+	}
+	for name, backend := range backends {
+		for _, d := range documents {
+			pages := []PageRecord{{DocumentId: d.documentId, Page: 1, Text: d.text, Entities: d.entities}}
+			_, err := backend.IndexDocument(NewDocumentRecord(d.documentId, d.documentId, "bucket", d.documentId, pages), pages)
+			if err != nil {
+				t.Fatalf("%s IndexDocument() error = %v", name, err)
+			}
+			chunks := []ChunkRecord{{DocumentId: d.documentId, Page: 1, Chunk: 1, Text: d.text}}
+			err = EmbedChunks(embedder, chunks)
+			if err != nil {
+				t.Fatalf("EmbedChunks() error = %v", err)
+			}
+			_, err = backend.IndexChunks(d.documentId, RecordMetadata{}, chunks)
+			if err != nil {
+				t.Fatalf("%s IndexChunks() error = %v", name, err)
+			}
+		}
+	}
+
+	tests := []struct {
+		name     string
+		entities []EntityFilter
+		want     []string
+	}{
+		{name: "no entity filter", want: []string{"doc-1", "doc-2"}},
+		{name: "entity type", entities: []EntityFilter{{Type: "ORGANIZATION"}}, want: []string{"doc-1"}},
+		{name: "entity value", entities: []EntityFilter{{Type: "PERSON", Value: "jane"}}, want: []string{"doc-2"}},
+		{name: "entity value of no document", entities: []EntityFilter{{Type: "PERSON", Value: "Bob"}}, want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := Query{Text: "invoice total", Mode: SearchModeVector, Entities: tt.entities, Principal: Principal{Admin: true}}
+			err := q.Validate()
+			if err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			for name, backend := range backends {
+				results, err := backend.Search(q, embedder)
+				if err != nil {
+					t.Fatalf("%s Search() error = %v", name, err)
+				}
+				got := documentIds(results)
+				slices.Sort(got)
+				if !slices.Equal(got, tt.want) {
+					t.Errorf("%s search found %v, want %v", name, got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,397 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dreamspider42/document-processing-pipeline/src/datastores"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	DefaultPageSize = 10
+	MaxPageSize     = 100
+
+	SearchModeKeyword = "keyword"
+	SearchModeVector  = "vector"
+	SearchModeHybrid  = "hybrid"
+
+	// Rank constant of reciprocal rank fusion, dampening the weight of the top ranks
+	rrfRankConstant = 60
+
+	// Most documents an entity filtered vector search considers, the default result window of an index
+	maxEntityDocuments = 10000
+)
+
+var entityTypePattern = regexp.MustCompile(`^[A-Z_]+$`)
+
+// Filters a search to documents mentioning an entity type, and optionally a specific value of it
+type EntityFilter struct {
+	Type  string `json:"type"`
+	Value string `json:"value,omitempty"`
+}
+
+// The caller a search runs on behalf of. Admins see every document, everyone else sees the documents
+// they wrote and the documents of the owners they belong to.
+type Principal struct {
+	Id     string   `json:"id"`
+	Owners []string `json:"owners"`
+	Admin  bool     `json:"admin"`
+}
+
+// Represents a document search
+type Query struct {
+	Text             string         `json:"text"`
+	Mode             string         `json:"mode"`
+	Entities         []EntityFilter `json:"entities"`
+	DocumentClasses  []string       `json:"documentClasses"`
+	RegisteredAfter  *time.Time     `json:"registeredAfter"`
+	RegisteredBefore *time.Time     `json:"registeredBefore"`
+	Facets           []string       `json:"facets"`
+	Highlight        bool           `json:"highlight"`
+	From             int            `json:"from"`
+	Size             int            `json:"size"`
+	Principal        Principal      `json:"-"`
+}
+
+// Represents a matching document
+type Hit struct {
+	DocumentId string                           `json:"documentId"`
+	Score      float64                          `json:"score"`
+	Pages      []int                            `json:"pages"`
+	Highlights map[string][]string              `json:"highlights,omitempty"`
+	Citations  []Citation                       `json:"citations,omitempty"`
+	Registry   *datastores.DocumentRegistryItem `json:"registry,omitempty"`
+}
+
+// Represents one value of a facet and the number of matching documents having it
+type FacetBucket struct {
+	Value     string `json:"value"`
+	Documents int    `json:"documents"`
+}
+
+// Represents the results of a document search
+type Results struct {
+	Total int   `json:"total"`
+	Hits  []Hit `json:"hits"`
+	// Set when hits were dropped after the search, so the total and facets may still count documents that other
+	// pages drop too
+	TotalApproximate bool                     `json:"totalApproximate,omitempty"`
+	Facets           map[string][]FacetBucket `json:"facets,omitempty"`
+}
+
+// Whether the principal may read a document with the given owner and writer
+func (p Principal) CanAccess(owner, writer string) bool {
+	if p.Admin {
+		return true
+	}
+	if p.Id != "" && p.Id == writer {
+		return true
+	}
+	return owner != "" && slices.Contains(p.Owners, owner)
+}
+
+// Check the query and fill in defaults
+func (q *Query) Validate() error {
+	if q.Size <= 0 {
+		q.Size = DefaultPageSize
+	}
+	if q.Size > MaxPageSize {
+		return fmt.Errorf("size cannot exceed %d", MaxPageSize)
+	}
+	if q.From < 0 {
+		return fmt.Errorf("from cannot be negative")
+	}
+	switch q.Mode {
+	case "":
+		q.Mode = SearchModeKeyword
+	case SearchModeKeyword:
+	case SearchModeVector, SearchModeHybrid:
+		if q.Text == "" {
+			return fmt.Errorf("%s search needs query text", q.Mode)
+		}
+	default:
+		return fmt.Errorf("unsupported search mode %q", q.Mode)
+	}
+	for _, entity := range q.Entities {
+		if !entityTypePattern.MatchString(entity.Type) {
+			return fmt.Errorf("invalid entity type %q", entity.Type)
+		}
+	}
+	for _, facet := range q.Facets {
+		if _, err := facetField(facet); err != nil {
+			return err
+		}
+	}
+	if q.RegisteredAfter != nil && q.RegisteredBefore != nil && q.RegisteredBefore.Before(*q.RegisteredAfter) {
+		return fmt.Errorf("registeredBefore is earlier than registeredAfter")
+	}
+	return nil
+}
+
+// Field a facet aggregates on: documentClass, owner, KeyPhrases or entity:<TYPE>
+func facetField(facet string) (string, error) {
+	switch facet {
+	case "documentClass", "owner":
+		return facet, nil
+	case "KeyPhrases":
+		return "KeyPhrases.keyword", nil
+	}
+	if entityType := strings.TrimPrefix(facet, "entity:"); entityType != facet && entityTypePattern.MatchString(entityType) {
+		return "Entities." + entityType + ".keyword", nil
+	}
+	return "", fmt.Errorf("unsupported facet %q", facet)
+}
+
+// Filter restricting results to the documents the principal may read, nil for admins
+func permissionFilter(p Principal) map[string]interface{} {
+	if p.Admin {
+		return nil
+	}
+	should := []map[string]interface{}{}
+	if p.Id != "" {
+		should = append(should, map[string]interface{}{"term": map[string]interface{}{"writer": p.Id}})
+	}
+	if len(p.Owners) > 0 {
+		should = append(should, map[string]interface{}{"terms": map[string]interface{}{"owner": p.Owners}})
+	}
+	if len(should) == 0 {
+		// Nobody to match: no results
+		return map[string]interface{}{"bool": map[string]interface{}{"must_not": map[string]interface{}{"match_all": map[string]interface{}{}}}}
+	}
+	return map[string]interface{}{"bool": map[string]interface{}{"should": should, "minimum_should_match": 1}}
+}
+
+// Filters shared by keyword and vector searches, restricted to one record type. Chunks carry no entities, so
+// their entity filters are applied through the document records, see EntityDocumentsQuery.
+func queryFilters(q Query, recordType string) []map[string]interface{} {
+	filters := []map[string]interface{}{
+		{"term": map[string]interface{}{"recordType": recordType}},
+	}
+	if permission := permissionFilter(q.Principal); permission != nil {
+		filters = append(filters, permission)
+	}
+	for _, entity := range q.Entities {
+		if recordType == RecordTypeChunk {
+			break
+		}
+		if entity.Value == "" {
+			filters = append(filters, map[string]interface{}{"exists": map[string]interface{}{"field": "Entities." + entity.Type}})
+		} else {
+			filters = append(filters, map[string]interface{}{"match": map[string]interface{}{"Entities." + entity.Type + ".keyword": entity.Value}})
+		}
+	}
+	if len(q.DocumentClasses) > 0 {
+		filters = append(filters, map[string]interface{}{"terms": map[string]interface{}{"documentClass": q.DocumentClasses}})
+	}
+	if q.RegisteredAfter != nil || q.RegisteredBefore != nil {
+		dateRange := map[string]interface{}{}
+		if q.RegisteredAfter != nil {
+			dateRange["gte"] = q.RegisteredAfter.UTC().Format(time.RFC3339)
+		}
+		if q.RegisteredBefore != nil {
+			dateRange["lte"] = q.RegisteredBefore.UTC().Format(time.RFC3339)
+		}
+		filters = append(filters, map[string]interface{}{"range": map[string]interface{}{"registeredAt": dateRange}})
+	}
+	return filters
+}
+
+// Build the OpenSearch request body of a validated keyword query. Pages are matched and collapsed into their documents.
+func OpenSearchQuery(q Query) ([]byte, error) {
+	filters := queryFilters(q, RecordTypePage)
+
+	must := map[string]interface{}{"match_all": map[string]interface{}{}}
+	if q.Text != "" {
+		must = map[string]interface{}{
+			"multi_match": map[string]interface{}{
+				"query":  q.Text,
+				"fields": []string{"text", "KeyPhrases^2", "Entities.*", "table", "forms"},
+			},
+		}
+	}
+
+	documentCount := map[string]interface{}{"cardinality": map[string]interface{}{"field": "documentId"}}
+	aggs := map[string]interface{}{"documents": documentCount}
+	for _, facet := range q.Facets {
+		field, err := facetField(facet)
+		if err != nil {
+			return nil, err
+		}
+		aggs["facet:"+facet] = map[string]interface{}{
+			"terms": map[string]interface{}{"field": field, "size": 10},
+			"aggs":  map[string]interface{}{"documents": documentCount},
+		}
+	}
+
+	body := map[string]interface{}{
+		"from":    q.From,
+		"size":    q.Size,
+		"_source": []string{"documentId"},
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{"must": must, "filter": filters},
+		},
+		"collapse": map[string]interface{}{
+			"field": "documentId",
+			"inner_hits": map[string]interface{}{
+				"name":    "pages",
+				"size":    10,
+				"_source": []string{"page"},
+			},
+		},
+		"aggs": aggs,
+	}
+	if q.Highlight && q.Text != "" {
+		body["highlight"] = map[string]interface{}{
+			"fields": map[string]interface{}{"text": map[string]interface{}{}, "KeyPhrases": map[string]interface{}{}},
+		}
+	}
+
+	return json.Marshal(body)
+}
+
+// Build the OpenSearch request body listing the documents whose records pass the filters of a validated query,
+// entity filters included
+func EntityDocumentsQuery(q Query) ([]byte, error) {
+	body := map[string]interface{}{
+		"size":    maxEntityDocuments,
+		"_source": []string{"documentId"},
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{"filter": queryFilters(q, RecordTypeDocument)},
+		},
+	}
+	return json.Marshal(body)
+}
+
+// Build the OpenSearch k-NN request body of a validated query, matching chunk embeddings against the embedded query text.
+// The closest chunks of each document are returned as citations. Unless documentIds is nil, only the chunks of those
+// documents match, which is how the entity filters listed by EntityDocumentsQuery apply.
+func VectorQuery(q Query, vector []float32, documentIds []string) ([]byte, error) {
+	if len(vector) != EmbeddingDimensions {
+		return nil, fmt.Errorf("query vector has %d dimensions, the index expects %d", len(vector), EmbeddingDimensions)
+	}
+
+	// Several chunks usually match per document, so ask for more neighbours than documents
+	k := (q.From + q.Size) * 5
+	if k > 1000 {
+		k = 1000
+	}
+
+	filters := queryFilters(q, RecordTypeChunk)
+	if documentIds != nil {
+		filters = append(filters, map[string]interface{}{"terms": map[string]interface{}{"documentId": documentIds}})
+	}
+
+	body := map[string]interface{}{
+		"from":    q.From,
+		"size":    q.Size,
+		"_source": []string{"documentId"},
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must": map[string]interface{}{
+					"knn": map[string]interface{}{
+						"embedding": map[string]interface{}{"vector": vector, "k": k},
+					},
+				},
+				"filter": filters,
+			},
+		},
+		"collapse": map[string]interface{}{
+			"field": "documentId",
+			"inner_hits": map[string]interface{}{
+				"name":    "chunks",
+				"size":    3,
+				"_source": []string{"page", "chunk", "text", "boundingBox"},
+			},
+		},
+		"aggs": map[string]interface{}{
+			"documents": map[string]interface{}{"cardinality": map[string]interface{}{"field": "documentId"}},
+		},
+	}
+	return json.Marshal(body)
+}
+
+// Parse the OpenSearch response of a query built by OpenSearchQuery or VectorQuery
+func ParseOpenSearchResults(response []byte) (*Results, error) {
+	parsed := struct {
+		Hits struct {
+			Hits []struct {
+				Score  float64             `json:"_score"`
+				Source PageRecord          `json:"_source"`
+				Marks  map[string][]string `json:"highlight"`
+				Inner  map[string]struct {
+					Hits struct {
+						Hits []struct {
+							Score  float64     `json:"_score"`
+							Source ChunkRecord `json:"_source"`
+						} `json:"hits"`
+					} `json:"hits"`
+				} `json:"inner_hits"`
+			} `json:"hits"`
+		} `json:"hits"`
+		Aggregations map[string]json.RawMessage `json:"aggregations"`
+	}{}
+	err := json.Unmarshal(response, &parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	results := &Results{Hits: []Hit{}}
+	for _, h := range parsed.Hits.Hits {
+		hit := Hit{DocumentId: h.Source.DocumentId, Score: h.Score, Pages: []int{}, Highlights: h.Marks}
+		for _, page := range h.Inner["pages"].Hits.Hits {
+			hit.Pages = append(hit.Pages, page.Source.Page)
+		}
+		for _, chunk := range h.Inner["chunks"].Hits.Hits {
+			hit.Citations = append(hit.Citations, Citation{
+				Page:        chunk.Source.Page,
+				Chunk:       chunk.Source.Chunk,
+				Text:        chunk.Source.Text,
+				BoundingBox: chunk.Source.BoundingBox,
+				Score:       chunk.Score,
+			})
+			if !slices.Contains(hit.Pages, chunk.Source.Page) {
+				hit.Pages = append(hit.Pages, chunk.Source.Page)
+			}
+		}
+		slices.Sort(hit.Pages)
+		results.Hits = append(results.Hits, hit)
+	}
+
+	for name, raw := range parsed.Aggregations {
+		if name == "documents" {
+			count := struct {
+				Value int `json:"value"`
+			}{}
+			if err := json.Unmarshal(raw, &count); err != nil {
+				return nil, err
+			}
+			results.Total = count.Value
+			continue
+		}
+
+		terms := struct {
+			Buckets []struct {
+				Key       string `json:"key"`
+				Documents struct {
+					Value int `json:"value"`
+				} `json:"documents"`
+			} `json:"buckets"`
+		}{}
+		if err := json.Unmarshal(raw, &terms); err != nil {
+			return nil, err
+		}
+		if results.Facets == nil {
+			results.Facets = map[string][]FacetBucket{}
+		}
+		facet := strings.TrimPrefix(name, "facet:")
+		results.Facets[facet] = []FacetBucket{}
+		for _, bucket := range terms.Buckets {
+			results.Facets[facet] = append(results.Facets[facet], FacetBucket{Value: bucket.Key, Documents: bucket.Documents.Value})
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,129 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dreamspider42/document-processing-pipeline/src/awshelper"
+	"github.com/dreamspider42/document-processing-pipeline/src/datastores"
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
+	"golang.org/x/exp/slices"
+)
+
+const (
+	RecordTypeDocument = "document"
+	RecordTypePage     = "page"
+)
+
+// Registry metadata copied onto every record so searches can filter and authorize without a join
+type RecordMetadata struct {
+	DocumentClass string `json:"documentClass,omitempty"`
+	Owner         string `json:"owner,omitempty"`
+	Writer        string `json:"writer,omitempty"`
+	RegisteredAt  string `json:"registeredAt,omitempty"`
+	IndexedAt     string `json:"indexedAt,omitempty"`
+}
+
+// Represents the parent search record of a document
+type DocumentRecord struct {
+	RecordMetadata
+	RecordType   string              `json:"recordType"`
+	DocumentId   string              `json:"documentId"`
+	DocumentName string              `json:"documentName"`
+	BucketName   string              `json:"bucketName"`
+	ObjectName   string              `json:"objectName"`
+	PageCount    int                 `json:"pageCount"`
+	KeyPhrases   []string            `json:"KeyPhrases"`
+	Entities     map[string][]string `json:"Entities"`
+}
+
+// Represents the search record of a single page of a document
+type PageRecord struct {
+	RecordMetadata
+	RecordType string            `json:"recordType"`
+	DocumentId string            `json:"documentId"`
+	Page       int               `json:"page"`
+	KeyPhrases []string          `json:"KeyPhrases"`
+	Entities   map[string]string `json:"Entities"`
+	Text       string            `json:"text"`
+	Table      [][]string        `json:"table"`
+	Forms      [][]string        `json:"forms"`
+}
+
+// Stable search record id of a page: documentId#page
+func PageRecordId(documentId string, page int) string {
+	return fmt.Sprintf("%s#%d", documentId, page)
+}
+
+// Build the parent record of a document from its page records
+func NewDocumentRecord(documentId, documentName, bucketName, objectName string, pages []PageRecord) DocumentRecord {
+	record := DocumentRecord{
+		RecordType:   RecordTypeDocument,
+		DocumentId:   documentId,
+		DocumentName: documentName,
+		BucketName:   bucketName,
+		ObjectName:   objectName,
+		PageCount:    len(pages),
+		KeyPhrases:   []string{},
+		Entities:     map[string][]string{},
+	}
+
+	for _, page := range pages {
+		for _, keyPhrase := range page.KeyPhrases {
+			if !slices.Contains(record.KeyPhrases, keyPhrase) {
+				record.KeyPhrases = append(record.KeyPhrases, keyPhrase)
+			}
+		}
+		for entityType, text := range page.Entities {
+			if !slices.Contains(record.Entities[entityType], text) {
+				record.Entities[entityType] = append(record.Entities[entityType], text)
+			}
+		}
+	}
+	return record
+}
+
+// Build the record metadata of a document from its registry record, which may be nil for unregistered documents
+func NewRecordMetadata(registryItem *datastores.DocumentRegistryItem, indexedAt time.Time) RecordMetadata {
+	recordMetadata := RecordMetadata{IndexedAt: indexedAt.UTC().Format(time.RFC3339)}
+	if registryItem == nil {
+		return recordMetadata
+	}
+
+	if class, ok := registryItem.DocumentMetadata["class"].(string); ok {
+		recordMetadata.DocumentClass = class
+	}
+	if owner, ok := registryItem.DocumentMetadata["owner"].(string); ok {
+		recordMetadata.Owner = owner
+	}
+	if writer, ok := registryItem.PrincipalIAMWriter["principalId"].(string); ok {
+		recordMetadata.Writer = writer
+	}
+	if registeredAt, err := metadata.ParseTimestamp(registryItem.Timestamp); err == nil {
+		recordMetadata.RegisteredAt = registeredAt.UTC().Format(time.RFC3339)
+	}
+	return recordMetadata
+}
+
+// Convert a document and its pages into the items of a single bulk session
+func BulkItems(document DocumentRecord, pages []PageRecord) ([]awshelper.BulkItem, error) {
+	items := make([]awshelper.BulkItem, 0, len(pages)+1)
+
+	body, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	items = append(items, awshelper.BulkItem{DocumentID: document.DocumentId, Body: body})
+
+	for _, page := range pages {
+		page.RecordType = RecordTypePage
+		page.RecordMetadata = document.RecordMetadata
+		body, err := json.Marshal(page)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, awshelper.BulkItem{DocumentID: PageRecordId(page.DocumentId, page.Page), Body: body})
+	}
+	return items, nil
+}
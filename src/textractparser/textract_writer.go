@@ -37,6 +37,11 @@ func NewOutputGenerator (s3 *awshelper.S3Helper, response *textract.AnalyzeDocum
 	}
 }
 
+// Key of the full Textract response, the output comprehend and the embeddings read
+func (o *OutputGenerator) FullResponsePath() string {
+	return fmt.Sprintf("%s/fullresponse.json", o.OutputPath)
+}
+
 func (o *OutputGenerator) OutputText(page *Page, p int, noWrite bool) (string, error) {
 	text := page.Text
 
@@ -182,7 +187,7 @@ func (o *OutputGenerator) WriteTextractOutputs(taggingStr *string) error {
 	log.Println("Serialized Response:", responseJsonPayload)
 
 	// Write the whole output for it to then be used for comprehend
-	opath := fmt.Sprintf("%s/fullresponse.json", o.OutputPath)
+	opath := o.FullResponsePath()
 	log.Println("Total Pages in Document: ", len(o.Document.Pages))
 	err = o.s3.WriteToS3(responseJsonPayload, o.BucketName, opath, taggingStr)
 	if(err != nil) {
@@ -0,0 +1,183 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dreamspider42/document-processing-pipeline/src/datastores"
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
+	"golang.org/x/exp/slices"
+)
+
+// Endpoints the notifier reads, as the WebhookEndpointStore provides them
+type endpointStore interface {
+	ListEndpoints(tenantId string) ([]datastores.WebhookEndpointItem, error)
+	GetEndpoint(tenantId string, endpointId string) (*datastores.WebhookEndpointItem, error)
+}
+
+// Delivery log the notifier keeps, as the WebhookDeliveryStore provides it
+type deliveryStore interface {
+	GetDelivery(deliveryId string) (*datastores.WebhookDeliveryItem, error)
+	ClaimDelivery(item datastores.WebhookDeliveryItem, retryableStatus string) (bool, error)
+	PutDelivery(item datastores.WebhookDeliveryItem) error
+	ListDocumentDeliveries(documentId string) ([]datastores.WebhookDeliveryItem, error)
+}
+
+// Notifier sends the webhooks of pipeline status changes to the endpoints of a tenant and logs each delivery
+type Notifier struct {
+	endpoints  endpointStore
+	deliveries deliveryStore
+	dispatcher *Dispatcher
+}
+
+// Create a new Notifier
+func NewNotifier(endpoints *datastores.WebhookEndpointStore, deliveries *datastores.WebhookDeliveryStore, dispatcher *Dispatcher) *Notifier {
+	return &Notifier{endpoints: endpoints, deliveries: deliveries, dispatcher: dispatcher}
+}
+
+// Send an operations event to the enabled endpoints of the tenant subscribed to its status. Each delivery is
+// first claimed in the log as PENDING, which only succeeds for new and FAILED deliveries, so events delivered or
+// being delivered by another invocation are not sent again. Deliveries that still fail after their retries are
+// logged as FAILED for redelivery; only store errors are returned.
+func (n *Notifier) Notify(tenantId string, event *metadata.OperationsEvent) error {
+	eventType := EventTypeOf(event.Stage, event.Status)
+	if eventType == "" {
+		return nil
+	}
+
+	endpoints, err := n.endpoints.ListEndpoints(tenantId)
+	if err != nil {
+		return err
+	}
+	for _, endpoint := range endpoints {
+		if !endpoint.Enabled || (len(endpoint.Statuses) > 0 && !slices.Contains(endpoint.Statuses, event.Status)) {
+			continue
+		}
+
+		deliveryId := DeliveryIdOf(endpoint.EndpointId, event)
+		delivery, err := n.deliveries.GetDelivery(deliveryId)
+		if err != nil {
+			return err
+		}
+		if delivery != nil && delivery.Status == DeliverySucceeded {
+			log.Printf("Webhook %s was already delivered to %s \n", deliveryId, endpoint.EndpointId)
+			continue
+		}
+		if delivery == nil {
+			delivery, err = newDelivery(deliveryId, tenantId, endpoint.EndpointId, eventType, event)
+			if err != nil {
+				return err
+			}
+		} else {
+			delivery.Status = DeliveryPending
+			delivery.UpdatedAt = metadata.FormatTimestamp(time.Now())
+		}
+
+		claimed, err := n.deliveries.ClaimDelivery(*delivery, DeliveryFailed)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			log.Printf("Webhook %s to %s was claimed by another sender \n", deliveryId, endpoint.EndpointId)
+			continue
+		}
+
+		err = n.send(endpoint, delivery)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Send a logged delivery again, whatever its status
+func (n *Notifier) Redeliver(deliveryId string) (*datastores.WebhookDeliveryItem, error) {
+	delivery, err := n.deliveries.GetDelivery(deliveryId)
+	if err != nil {
+		return nil, err
+	}
+	if delivery == nil {
+		return nil, fmt.Errorf("no webhook delivery %s", deliveryId)
+	}
+	endpoint, err := n.endpoints.GetEndpoint(delivery.TenantId, delivery.EndpointId)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint == nil {
+		return nil, fmt.Errorf("endpoint %s of tenant %s is no longer registered", delivery.EndpointId, delivery.TenantId)
+	}
+	return delivery, n.send(*endpoint, delivery)
+}
+
+// Send the failed deliveries of a document again
+func (n *Notifier) RedeliverFailed(documentId string) ([]datastores.WebhookDeliveryItem, error) {
+	deliveries, err := n.deliveries.ListDocumentDeliveries(documentId)
+	if err != nil {
+		return nil, err
+	}
+	redelivered := []datastores.WebhookDeliveryItem{}
+	for _, delivery := range deliveries {
+		if delivery.Status != DeliveryFailed {
+			continue
+		}
+		item, err := n.Redeliver(delivery.DeliveryId)
+		if err != nil {
+			return redelivered, err
+		}
+		redelivered = append(redelivered, *item)
+	}
+	return redelivered, nil
+}
+
+// Post a delivery to its endpoint and log the outcome
+func (n *Notifier) send(endpoint datastores.WebhookEndpointItem, delivery *datastores.WebhookDeliveryItem) error {
+	result := n.dispatcher.Deliver(endpoint.Url, endpoint.Secret, delivery.DeliveryId, delivery.EventType, []byte(delivery.Payload))
+
+	delivery.Attempts += result.Attempts
+	delivery.ResponseStatus = result.ResponseStatus
+	delivery.ResponseBody = result.ResponseBody
+	delivery.UpdatedAt = metadata.FormatTimestamp(time.Now())
+	if result.Succeeded() {
+		delivery.Status = DeliverySucceeded
+		delivery.LastError = ""
+	} else {
+		delivery.Status = DeliveryFailed
+		delivery.LastError = result.Err.Error()
+		log.Printf("Webhook %s to %s failed after %d attempts: %v \n", delivery.DeliveryId, endpoint.Url, result.Attempts, result.Err)
+	}
+	return n.deliveries.PutDelivery(*delivery)
+}
+
+// New pending delivery of an operations event to an endpoint
+func newDelivery(deliveryId string, tenantId string, endpointId string, eventType string, event *metadata.OperationsEvent) (*datastores.WebhookDeliveryItem, error) {
+	payload, err := json.Marshal(Payload{
+		Id:         deliveryId,
+		Type:       eventType,
+		TenantId:   tenantId,
+		DocumentId: event.DocumentId,
+		BucketName: event.BucketName,
+		ObjectName: event.ObjectName,
+		Stage:      event.Stage,
+		Status:     event.Status,
+		Message:    event.Message,
+		Timestamp:  event.Timestamp,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling webhook payload: %v", err)
+	}
+
+	now := metadata.FormatTimestamp(time.Now())
+	return &datastores.WebhookDeliveryItem{
+		DeliveryId: deliveryId,
+		TenantId:   tenantId,
+		EndpointId: endpointId,
+		DocumentId: event.DocumentId,
+		EventType:  eventType,
+		Payload:    string(payload),
+		Status:     DeliveryPending,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}, nil
+}
@@ -0,0 +1,238 @@
+package webhooks
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dreamspider42/document-processing-pipeline/src/datastores"
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
+)
+
+// Endpoint answering each request with the next scripted status, then with 200
+type scriptedEndpoint struct {
+	statuses []int
+	requests int
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func (e *scriptedEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	e.bodies = append(e.bodies, body)
+	e.headers = append(e.headers, r.Header)
+	status := http.StatusOK
+	if e.requests < len(e.statuses) {
+		status = e.statuses[e.requests]
+	}
+	e.requests++
+	w.WriteHeader(status)
+}
+
+func TestDispatcherDeliver(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantAttempts int
+		wantStatus   int
+		wantOk       bool
+	}{
+		{name: "accepted", wantAttempts: 1, wantStatus: http.StatusOK, wantOk: true},
+		{name: "server errors retried", statuses: []int{http.StatusInternalServerError, http.StatusServiceUnavailable}, wantAttempts: 3, wantStatus: http.StatusOK, wantOk: true},
+		{name: "throttling retried", statuses: []int{http.StatusTooManyRequests}, wantAttempts: 2, wantStatus: http.StatusOK, wantOk: true},
+		{name: "client error not retried", statuses: []int{http.StatusBadRequest}, wantAttempts: 1, wantStatus: http.StatusBadRequest},
+		{name: "gone not retried", statuses: []int{http.StatusGone}, wantAttempts: 1, wantStatus: http.StatusGone},
+		{name: "attempts exhausted", statuses: []int{500, 502, 503, 504, 500}, wantAttempts: 4, wantStatus: http.StatusGatewayTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoint := &scriptedEndpoint{statuses: tt.statuses}
+			server := httptest.NewServer(endpoint)
+			defer server.Close()
+			dispatcher := NewDispatcher()
+			dispatcher.Backoff = 0
+
+			body := []byte(`{"type":"document.succeeded"}`)
+			result := dispatcher.Deliver(server.URL, "secret", "delivery", EventDocumentSucceeded, body)
+
+			if result.Attempts != tt.wantAttempts || endpoint.requests != tt.wantAttempts {
+				t.Errorf("%d attempts and %d requests, want %d", result.Attempts, endpoint.requests, tt.wantAttempts)
+			}
+			if result.ResponseStatus != tt.wantStatus || result.Succeeded() != tt.wantOk {
+				t.Errorf("responded %d, succeeded %v, want %d and %v", result.ResponseStatus, result.Succeeded(), tt.wantStatus, tt.wantOk)
+			}
+			for i, header := range endpoint.headers {
+				err := Verify("secret", header.Get(SignatureHeader), endpoint.bodies[i], time.Minute, time.Now())
+				if err != nil {
+					t.Errorf("attempt %d signature: %v", i+1, err)
+				}
+				if header.Get(DeliveryHeader) != "delivery" || header.Get(EventHeader) != EventDocumentSucceeded {
+					t.Errorf("attempt %d headers %v", i+1, header)
+				}
+			}
+		})
+	}
+}
+
+// Endpoint store of a single tenant
+type fakeEndpointStore struct {
+	endpoints []datastores.WebhookEndpointItem
+}
+
+func (s *fakeEndpointStore) ListEndpoints(tenantId string) ([]datastores.WebhookEndpointItem, error) {
+	return s.endpoints, nil
+}
+
+func (s *fakeEndpointStore) GetEndpoint(tenantId string, endpointId string) (*datastores.WebhookEndpointItem, error) {
+	for _, endpoint := range s.endpoints {
+		if endpoint.EndpointId == endpointId {
+			return &endpoint, nil
+		}
+	}
+	return nil, nil
+}
+
+// Delivery log keeping its records in memory, claiming deliveries on the same condition as the DynamoDB store
+type fakeDeliveryStore struct {
+	deliveries map[string]datastores.WebhookDeliveryItem
+}
+
+func (s *fakeDeliveryStore) GetDelivery(deliveryId string) (*datastores.WebhookDeliveryItem, error) {
+	delivery, ok := s.deliveries[deliveryId]
+	if !ok {
+		return nil, nil
+	}
+	return &delivery, nil
+}
+
+func (s *fakeDeliveryStore) ClaimDelivery(item datastores.WebhookDeliveryItem, retryableStatus string) (bool, error) {
+	if existing, ok := s.deliveries[item.DeliveryId]; ok && existing.Status != retryableStatus {
+		return false, nil
+	}
+	s.deliveries[item.DeliveryId] = item
+	return true, nil
+}
+
+func (s *fakeDeliveryStore) PutDelivery(item datastores.WebhookDeliveryItem) error {
+	s.deliveries[item.DeliveryId] = item
+	return nil
+}
+
+func (s *fakeDeliveryStore) ListDocumentDeliveries(documentId string) ([]datastores.WebhookDeliveryItem, error) {
+	items := []datastores.WebhookDeliveryItem{}
+	for _, delivery := range s.deliveries {
+		if delivery.DocumentId == documentId {
+			items = append(items, delivery)
+		}
+	}
+	return items, nil
+}
+
+func TestNotifierNotify(t *testing.T) {
+	event := &metadata.OperationsEvent{
+		EventHeader: metadata.EventHeader{DocumentId: "doc", Timestamp: "2024-05-01T12:00:00Z"},
+		Stage:       metadata.StageSyncProcessEmbeddings,
+		Status:      metadata.StatusSucceeded,
+	}
+	deliveryId := DeliveryIdOf("endpoint", event)
+
+	tests := []struct {
+		name string
+		// Delivery of the event logged before, if any
+		logged       *datastores.WebhookDeliveryItem
+		statuses     []int
+		endpoint     datastores.WebhookEndpointItem
+		wantRequests int
+		wantStatus   string
+		wantAttempts int
+	}{
+		{
+			name:         "new delivery",
+			endpoint:     datastores.WebhookEndpointItem{EndpointId: "endpoint", Enabled: true},
+			wantRequests: 1,
+			wantStatus:   DeliverySucceeded,
+			wantAttempts: 1,
+		},
+		{
+			name:         "failed delivery sent again",
+			logged:       &datastores.WebhookDeliveryItem{DeliveryId: deliveryId, DocumentId: "doc", Status: DeliveryFailed, Attempts: 4},
+			endpoint:     datastores.WebhookEndpointItem{EndpointId: "endpoint", Enabled: true},
+			wantRequests: 1,
+			wantStatus:   DeliverySucceeded,
+			wantAttempts: 5,
+		},
+		{
+			name:         "already delivered",
+			logged:       &datastores.WebhookDeliveryItem{DeliveryId: deliveryId, DocumentId: "doc", Status: DeliverySucceeded, Attempts: 1},
+			endpoint:     datastores.WebhookEndpointItem{EndpointId: "endpoint", Enabled: true},
+			wantStatus:   DeliverySucceeded,
+			wantAttempts: 1,
+		},
+		{
+			name:         "claimed by another sender",
+			logged:       &datastores.WebhookDeliveryItem{DeliveryId: deliveryId, DocumentId: "doc", Status: DeliveryPending},
+			endpoint:     datastores.WebhookEndpointItem{EndpointId: "endpoint", Enabled: true},
+			wantStatus:   DeliveryPending,
+			wantAttempts: 0,
+		},
+		{
+			name:         "client error logged as failed",
+			statuses:     []int{http.StatusUnauthorized},
+			endpoint:     datastores.WebhookEndpointItem{EndpointId: "endpoint", Enabled: true},
+			wantRequests: 1,
+			wantStatus:   DeliveryFailed,
+			wantAttempts: 1,
+		},
+		{
+			name:     "disabled endpoint",
+			endpoint: datastores.WebhookEndpointItem{EndpointId: "endpoint"},
+		},
+		{
+			name:     "status not subscribed",
+			endpoint: datastores.WebhookEndpointItem{EndpointId: "endpoint", Enabled: true, Statuses: []string{metadata.StatusFailed}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoint := &scriptedEndpoint{statuses: tt.statuses}
+			server := httptest.NewServer(endpoint)
+			defer server.Close()
+			tt.endpoint.Url = server.URL
+			tt.endpoint.Secret = "secret"
+			deliveries := &fakeDeliveryStore{deliveries: map[string]datastores.WebhookDeliveryItem{}}
+			if tt.logged != nil {
+				deliveries.deliveries[deliveryId] = *tt.logged
+			}
+			dispatcher := NewDispatcher()
+			dispatcher.Backoff = 0
+			notifier := &Notifier{
+				endpoints:  &fakeEndpointStore{endpoints: []datastores.WebhookEndpointItem{tt.endpoint}},
+				deliveries: deliveries,
+				dispatcher: dispatcher,
+			}
+
+			err := notifier.Notify("tenant", event)
+			if err != nil {
+				t.Fatalf("Notify() error = %v", err)
+			}
+
+			if endpoint.requests != tt.wantRequests {
+				t.Errorf("endpoint received %d requests, want %d", endpoint.requests, tt.wantRequests)
+			}
+			delivery, ok := deliveries.deliveries[deliveryId]
+			if tt.wantStatus == "" {
+				if ok {
+					t.Errorf("delivery logged as %s, want none", delivery.Status)
+				}
+				return
+			}
+			if delivery.Status != tt.wantStatus || delivery.Attempts != tt.wantAttempts {
+				t.Errorf("delivery %s after %d attempts, want %s after %d", delivery.Status, delivery.Attempts, tt.wantStatus, tt.wantAttempts)
+			}
+		})
+	}
+}
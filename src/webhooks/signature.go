@@ -0,0 +1,62 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signature of a webhook body sent at the given time, as set in the SignatureHeader: t=<unix seconds>,v1=<hex>.
+// The HMAC-SHA256 of the endpoint secret covers "<unix seconds>.<body>", so a captured request cannot be replayed
+// later with a new timestamp.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	unix := strconv.FormatInt(timestamp.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", unix, signature(secret, unix, body))
+}
+
+// Check the SignatureHeader of a received webhook against its body, rejecting signatures older than the tolerance
+func Verify(secret string, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var unix string
+	signatures := []string{}
+	for _, part := range strings.Split(header, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			continue
+		}
+		switch key {
+		case "t":
+			unix = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+
+	seconds, err := strconv.ParseInt(unix, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return fmt.Errorf("malformed webhook signature %q", header)
+	}
+	age := now.Sub(time.Unix(seconds, 0))
+	if age > tolerance || age < -tolerance {
+		return fmt.Errorf("webhook signature timestamp %s is outside the %s tolerance", time.Unix(seconds, 0).UTC().Format(time.RFC3339), tolerance)
+	}
+
+	expected := signature(secret, unix, body)
+	for _, s := range signatures {
+		if hmac.Equal([]byte(s), []byte(expected)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("webhook signature does not match")
+}
+
+func signature(secret string, unix string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unix))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,160 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
+	"github.com/google/uuid"
+)
+
+// Headers of a webhook request
+const (
+	SignatureHeader = "X-Pipeline-Signature"
+	DeliveryHeader  = "X-Pipeline-Delivery"
+	EventHeader     = "X-Pipeline-Event"
+)
+
+// Webhook event types
+const (
+	EventDocumentSucceeded = "document.succeeded"
+	EventDocumentFailed    = "document.failed"
+)
+
+// Delivery statuses
+const (
+	DeliveryPending   = "PENDING"
+	DeliverySucceeded = "SUCCEEDED"
+	DeliveryFailed    = "FAILED"
+)
+
+const (
+	defaultMaxAttempts = 4
+	defaultBackoff     = time.Second
+	defaultTimeout     = 10 * time.Second
+	// Response bodies kept in the delivery log
+	maxResponseBytes = 1024
+)
+
+// Payload is the JSON body of a webhook
+type Payload struct {
+	Id         string `json:"id"`
+	Type       string `json:"type"`
+	TenantId   string `json:"tenantId"`
+	DocumentId string `json:"documentId"`
+	BucketName string `json:"bucketName"`
+	ObjectName string `json:"objectName"`
+	Stage      string `json:"stage"`
+	Status     string `json:"status"`
+	Message    string `json:"message,omitempty"`
+	Timestamp  string `json:"timestamp"`
+}
+
+// Webhook event type of a pipeline state, empty for states that are not sent. Documents succeed when they reach the
+// terminal state of the pipeline and fail when any stage fails.
+func EventTypeOf(stage string, status string) string {
+	if status == metadata.StatusFailed {
+		return EventDocumentFailed
+	}
+	if metadata.Pipeline.Terminal(metadata.StageState{Stage: stage, Status: status}) {
+		return EventDocumentSucceeded
+	}
+	return ""
+}
+
+// Delivery id of an operations event to an endpoint. Redelivered events get the same id, so they are only sent once.
+func DeliveryIdOf(endpointId string, event *metadata.OperationsEvent) string {
+	name := fmt.Sprintf("%s|%s|%s|%s|%s", endpointId, event.DocumentId, event.Stage, event.Status, event.Timestamp)
+	return uuid.NewSHA1(metadata.DocumentIdNamespace, []byte(name)).String()
+}
+
+// New random endpoint secret
+func NewSecret() (string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(secret), nil
+}
+
+// Result of a delivery: the attempts made and the outcome of the last one
+type Result struct {
+	Attempts       int
+	ResponseStatus int
+	ResponseBody   string
+	Err            error
+}
+
+// Whether the endpoint accepted the webhook
+func (r Result) Succeeded() bool {
+	return r.Err == nil
+}
+
+// Dispatcher sends signed webhooks, retrying failed attempts with exponential backoff
+type Dispatcher struct {
+	Client      *http.Client
+	MaxAttempts int
+	// Wait before the second attempt, doubled for each further attempt
+	Backoff time.Duration
+}
+
+// Create a dispatcher with the default timeout and retries
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		Client:      &http.Client{Timeout: defaultTimeout},
+		MaxAttempts: defaultMaxAttempts,
+		Backoff:     defaultBackoff,
+	}
+}
+
+// Post a webhook body to an endpoint. Network errors, 429 and 5xx responses are retried; other responses
+// outside 2xx fail the delivery at once.
+func (d *Dispatcher) Deliver(url string, secret string, deliveryId string, eventType string, body []byte) Result {
+	result := Result{}
+	for result.Attempts < d.MaxAttempts {
+		if result.Attempts > 0 {
+			time.Sleep(d.Backoff << (result.Attempts - 1))
+		}
+		result.Attempts++
+
+		retryable := false
+		result.ResponseStatus, result.ResponseBody, retryable, result.Err = d.attempt(url, secret, deliveryId, eventType, body)
+		if result.Err == nil || !retryable {
+			break
+		}
+		log.Printf("Webhook %s to %s failed on attempt %d: %v \n", deliveryId, url, result.Attempts, result.Err)
+	}
+	return result
+}
+
+// Send a single attempt, returning the response and whether a failure may be retried
+func (d *Dispatcher) attempt(url string, secret string, deliveryId string, eventType string, body []byte) (int, string, bool, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(secret, time.Now(), body))
+	req.Header.Set(DeliveryHeader, deliveryId)
+	req.Header.Set(EventHeader, eventType)
+
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return 0, "", true, err
+	}
+	defer res.Body.Close()
+	responseBody, _ := io.ReadAll(io.LimitReader(res.Body, maxResponseBytes))
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return res.StatusCode, string(responseBody), false, nil
+	}
+	retryable := res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+	return res.StatusCode, string(responseBody), retryable, fmt.Errorf("endpoint responded %s", res.Status)
+}
@@ -0,0 +1,72 @@
+package webhooks
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dreamspider42/document-processing-pipeline/src/metadata"
+)
+
+func TestEventTypeOf(t *testing.T) {
+	tests := []struct {
+		stage  string
+		status string
+		want   string
+	}{
+		{metadata.StageSyncProcessEmbeddings, metadata.StatusSucceeded, EventDocumentSucceeded},
+		{metadata.StageSyncProcessComprehend, metadata.StatusSucceeded, ""},
+		{metadata.StageDocumentClassifier, metadata.StatusSucceeded, ""},
+		{metadata.StageDocumentClassifier, metadata.StatusFailed, EventDocumentFailed},
+		{metadata.StageSyncProcessEmbeddings, metadata.StatusFailed, EventDocumentFailed},
+		{metadata.StageSyncProcessEmbeddings, metadata.StatusInProgress, ""},
+		{"UNKNOWN_STAGE", metadata.StatusSucceeded, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.stage+"/"+tt.status, func(t *testing.T) {
+			if got := EventTypeOf(tt.stage, tt.status); got != tt.want {
+				t.Errorf("EventTypeOf(%s, %s) = %q, want %q", tt.stage, tt.status, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	sent := time.Unix(1700000000, 0)
+	body := []byte(`{"type":"document.succeeded"}`)
+	header := Sign("secret", sent, body)
+
+	tests := []struct {
+		name    string
+		secret  string
+		header  string
+		body    []byte
+		now     time.Time
+		wantErr string
+	}{
+		{name: "valid", secret: "secret", header: header, body: body, now: sent.Add(time.Minute)},
+		{name: "clock behind the sender", secret: "secret", header: header, body: body, now: sent.Add(-time.Minute)},
+		{name: "valid among several signatures", secret: "secret", header: header + ",v1=00ff", body: body, now: sent},
+		{name: "wrong secret", secret: "other", header: header, body: body, now: sent, wantErr: "does not match"},
+		{name: "tampered body", secret: "secret", header: header, body: []byte(`{"type":"document.failed"}`), now: sent, wantErr: "does not match"},
+		{name: "replayed late", secret: "secret", header: header, body: body, now: sent.Add(10 * time.Minute), wantErr: "tolerance"},
+		{name: "replayed with a new timestamp", secret: "secret", header: strings.Replace(header, "t=1700000000", "t=1700000500", 1), body: body, now: sent.Add(500 * time.Second), wantErr: "does not match"},
+		{name: "no timestamp", secret: "secret", header: header[strings.Index(header, ",")+1:], body: body, now: sent, wantErr: "malformed"},
+		{name: "no signature", secret: "secret", header: "t=1700000000", body: body, now: sent, wantErr: "malformed"},
+		{name: "empty header", secret: "secret", header: "", body: body, now: sent, wantErr: "malformed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.secret, tt.header, tt.body, 5*time.Minute, tt.now)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Verify() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Verify() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}